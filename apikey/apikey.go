@@ -0,0 +1,270 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package apikey
+
+import (
+    "citron-repo/model"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    //api key文件默认保存在备份目录下
+    DEFAULT_FILE = ".citron/apikeys.json"
+
+    //key格式：ck-<id>-<secret>
+    KEY_PREFIX  = "ck"
+    ID_SIZE     = 8
+    SECRET_SIZE = 32
+
+    //最后使用时间的持久化间隔，避免每次请求都写文件
+    LAST_USED_INTERVAL = time.Minute
+)
+
+var (
+    ErrInvalidKey  = errors.New("invalid api key")
+    ErrKeyExpired  = errors.New("api key expired")
+    ErrKeyNotFound = errors.New("api key not found")
+)
+
+type KeyMgr struct {
+    //持久化文件路径，为空时只保存在内存中
+    path string
+    lock sync.Mutex
+    keys map[string]*model.ApiKey
+}
+
+//创建只保存在内存中的api key管理器
+func New() *KeyMgr {
+    return &KeyMgr{
+        keys: map[string]*model.ApiKey{},
+    }
+}
+
+//从文件加载api key，文件不存在时创建空的管理器
+func Open(path string) (*KeyMgr, error) {
+    ret := New()
+    ret.path = path
+
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return ret, nil
+        }
+        return nil, err
+    }
+
+    var keys []*model.ApiKey
+    if err := json.Unmarshal(data, &keys); err != nil {
+        return nil, err
+    }
+    for _, k := range keys {
+        ret.keys[k.ID] = k
+    }
+    return ret, nil
+}
+
+func random(size int) (string, error) {
+    b := make([]byte, size)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(b), nil
+}
+
+func hash(key string) string {
+    sum := sha256.Sum256([]byte(key))
+    return hex.EncodeToString(sum[:])
+}
+
+//解析key中的id
+func parse(key string) (string, bool) {
+    parts := strings.Split(key, "-")
+    if len(parts) != 3 || parts[0] != KEY_PREFIX || len(parts[1]) != 2*ID_SIZE {
+        return "", false
+    }
+    return parts[1], true
+}
+
+//是否为api key格式
+func IsApiKey(key string) bool {
+    _, ok := parse(key)
+    return ok
+}
+
+//创建api key，返回的完整key只能获得一次，服务端只保存hash
+func (m *KeyMgr) Create(user string, info model.ApiKeyInfo) (model.ApiKeyResult, error) {
+    id, err := random(ID_SIZE)
+    if err != nil {
+        return model.ApiKeyResult{}, err
+    }
+    secret, err := random(SECRET_SIZE)
+    if err != nil {
+        return model.ApiKeyResult{}, err
+    }
+    key := KEY_PREFIX + "-" + id + "-" + secret
+
+    now := time.Now()
+    k := &model.ApiKey{
+        ID:         id,
+        User:       user,
+        Name:       info.Name,
+        Hash:       hash(key),
+        Scopes:     info.Scopes,
+        Path:       info.Path,
+        CreateTime: now,
+    }
+    if info.ExpireDays > 0 {
+        k.ExpireTime = now.Add(time.Duration(info.ExpireDays) * 24 * time.Hour)
+    }
+
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    m.keys[id] = k
+    if err := m.save(); err != nil {
+        delete(m.keys, id)
+        return model.ApiKeyResult{}, err
+    }
+    return model.ApiKeyResult{Key: key, ApiKey: strip(k)}, nil
+}
+
+//校验key，成功时更新最后使用时间
+func (m *KeyMgr) Verify(key string) (model.ApiKey, error) {
+    id, ok := parse(key)
+    if !ok {
+        return model.ApiKey{}, ErrInvalidKey
+    }
+
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    k, ok := m.keys[id]
+    if !ok || subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hash(key))) != 1 {
+        return model.ApiKey{}, ErrInvalidKey
+    }
+    now := time.Now()
+    if !k.ExpireTime.IsZero() && !now.Before(k.ExpireTime) {
+        return model.ApiKey{}, ErrKeyExpired
+    }
+
+    persist := now.Sub(k.LastUsed) >= LAST_USED_INTERVAL
+    k.LastUsed = now
+    if persist {
+        //最后使用时间保存失败不影响校验结果
+        m.save()
+    }
+    return strip(k), nil
+}
+
+//列出用户的所有api key
+func (m *KeyMgr) List(user string) []model.ApiKey {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    ret := []model.ApiKey{}
+    for _, k := range m.keys {
+        if k.User == user {
+            ret = append(ret, strip(k))
+        }
+    }
+    sort.Slice(ret, func(i, j int) bool {
+        return ret[i].CreateTime.Before(ret[j].CreateTime)
+    })
+    return ret
+}
+
+//吊销用户的api key
+func (m *KeyMgr) Revoke(user, id string) error {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    k, ok := m.keys[id]
+    if !ok || k.User != user {
+        return ErrKeyNotFound
+    }
+    delete(m.keys, id)
+    if err := m.save(); err != nil {
+        m.keys[id] = k
+        return err
+    }
+    return nil
+}
+
+//吊销用户所有的api key
+func (m *KeyMgr) RevokeUser(user string) error {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    removed := map[string]*model.ApiKey{}
+    for id, k := range m.keys {
+        if k.User == user {
+            removed[id] = k
+            delete(m.keys, id)
+        }
+    }
+    if err := m.save(); err != nil {
+        for id, k := range removed {
+            m.keys[id] = k
+        }
+        return err
+    }
+    return nil
+}
+
+//保存最后使用时间
+func (m *KeyMgr) Close() error {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    return m.save()
+}
+
+//持久化api key，先写临时文件再替换，必须在持有锁时调用
+func (m *KeyMgr) save() error {
+    if m.path == "" {
+        return nil
+    }
+
+    keys := make([]*model.ApiKey, 0, len(m.keys))
+    for _, k := range m.keys {
+        keys = append(keys, k)
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        return keys[i].ID < keys[j].ID
+    })
+    data, err := json.MarshalIndent(keys, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    if err := os.MkdirAll(filepath.Dir(m.path), 0700); err != nil {
+        return err
+    }
+    tmp := m.path + ".tmp"
+    if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+        return err
+    }
+    return os.Rename(tmp, m.path)
+}
+
+func strip(k *model.ApiKey) model.ApiKey {
+    ret := *k
+    ret.Hash = ""
+    return ret
+}
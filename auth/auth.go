@@ -0,0 +1,136 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package auth
+
+import (
+    "citron-repo/model"
+    "errors"
+    "path"
+    "strings"
+)
+
+const (
+    //浏览目录及文件元数据
+    ActionList = 1 << iota
+    //下载文件内容
+    ActionRead
+    //上传文件
+    ActionWrite
+    //删除文件
+    ActionDelete
+    //修改服务配置
+    ActionConfig
+    //管理用户
+    ActionUser
+)
+
+var ErrPermissionDenied = errors.New("permission denied")
+
+//token scope对应的操作
+var scopeAction = map[string]int{
+    "list":   ActionList,
+    "read":   ActionRead,
+    "write":  ActionWrite,
+    "delete": ActionDelete,
+    "config": ActionConfig,
+    "user":   ActionUser,
+}
+
+var rolePermission = map[string]int{
+    model.RoleAdmin:       ActionList | ActionRead | ActionWrite | ActionDelete | ActionConfig | ActionUser,
+    model.RoleWriter:      ActionList | ActionRead | ActionWrite,
+    model.RoleRestoreOnly: ActionList | ActionRead,
+    model.RoleReader:      ActionList,
+}
+
+func ValidRole(role string) bool {
+    _, ok := rolePermission[role]
+    return ok
+}
+
+func ValidScope(scope string) bool {
+    _, ok := scopeAction[scope]
+    return ok
+}
+
+//scope对应的操作，无效的scope返回0
+func ScopeAction(scope string) int {
+    return scopeAction[scope]
+}
+
+//检查token的scope及绑定路径是否允许在路径p上执行action，scopes为空时不限制操作，bind为空时不限制路径
+func CheckScope(scopes []string, bind string, action int, p string) error {
+    if len(scopes) > 0 {
+        allowed := 0
+        for _, scope := range scopes {
+            allowed |= scopeAction[scope]
+        }
+        if allowed&action != action {
+            return ErrPermissionDenied
+        }
+    }
+    if bind != "" && !HasPrefix(path.Clean("/"+p), path.Clean("/"+bind)) {
+        return ErrPermissionDenied
+    }
+    return nil
+}
+
+//用户的备份根目录
+func Home(username string) string {
+    return "/" + username
+}
+
+//将请求中的路径转换为仓库内的绝对路径：
+//以"/"开头的路径为仓库绝对路径，否则为相对于用户备份根目录的路径且不能超出根目录
+func Resolve(username string, rel ...string) string {
+    p := path.Join(rel...)
+    if strings.HasPrefix(p, "/") {
+        return path.Clean(p)
+    }
+    return path.Join(Home(username), path.Clean("/"+p))
+}
+
+//p是否为prefix或其子路径
+func HasPrefix(p, prefix string) bool {
+    if prefix == "/" {
+        return true
+    }
+    return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+//获得用户在路径p上的角色：用户在自己的备份根目录下为u.Role，授权路径中匹配最长的优先
+func RoleOf(u model.User, p string) string {
+    if u.Role == model.RoleAdmin {
+        return model.RoleAdmin
+    }
+
+    role := ""
+    matched := -1
+    if home := Home(u.Username); HasPrefix(p, home) {
+        role = u.Role
+        matched = len(home)
+    }
+    for _, perm := range u.Permissions {
+        prefix := path.Clean("/" + perm.Path)
+        if HasPrefix(p, prefix) && len(prefix) >= matched {
+            role = perm.Role
+            matched = len(prefix)
+        }
+    }
+    return role
+}
+
+//检查用户是否有权限在路径p上执行action
+func Check(u model.User, action int, p string) error {
+    if u.Disabled {
+        return ErrPermissionDenied
+    }
+    if rolePermission[RoleOf(u, path.Clean("/"+p))]&action != action {
+        return ErrPermissionDenied
+    }
+    return nil
+}
@@ -0,0 +1,206 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package client
+
+import (
+    "github.com/fsnotify/fsnotify"
+    "github.com/xfali/goutils/log"
+    "path"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+const (
+    DEFAULT_DEBOUNCE = 2 * time.Second
+    //持续变化时最多延迟的倍数，避免一直不上传
+    MAX_DEBOUNCE_FACTOR = 10
+)
+
+//监控的本地目录及对应的仓库路径
+type WatchDir struct {
+    Dir  string `yaml:"dir"`
+    Dest string `yaml:"dest"`
+}
+
+//持续备份：监控目录变化，合并短时间内的多次变化后上传变化的文件
+type Agent struct {
+    backup   *Backup
+    state    *State
+    dirs     []WatchDir
+    debounce time.Duration
+    watcher  *fsnotify.Watcher
+}
+
+type AgentOpt func(a *Agent)
+
+//变化后等待d没有新的变化再上传
+func SetDebounce(d time.Duration) AgentOpt {
+    return func(a *Agent) {
+        a.debounce = d
+    }
+}
+
+func NewAgent(backup *Backup, state *State, dirs []WatchDir, opts ...AgentOpt) *Agent {
+    ret := &Agent{
+        backup:   backup,
+        state:    state,
+        dirs:     dirs,
+        debounce: DEFAULT_DEBOUNCE,
+    }
+    for i := range opts {
+        opts[i](ret)
+    }
+    return ret
+}
+
+//同步所有目录后开始监控，直到stop关闭
+func (a *Agent) Run(stop <-chan struct{}) error {
+    watcher, err := fsnotify.NewWatcher()
+    if err != nil {
+        return err
+    }
+    defer watcher.Close()
+    a.watcher = watcher
+
+    //先同步停止期间的变化
+    for i := range a.dirs {
+        a.sync(i)
+    }
+
+    pending := map[int]bool{}
+    var first time.Time
+    timer := time.NewTimer(a.debounce)
+    timer.Stop()
+    for {
+        select {
+        case <-stop:
+            return nil
+        case ev, ok := <-watcher.Events:
+            if !ok {
+                return nil
+            }
+            i := a.dirOf(ev.Name)
+            if i < 0 {
+                continue
+            }
+            if len(pending) == 0 {
+                first = time.Now()
+            }
+            pending[i] = true
+            if time.Since(first) < MAX_DEBOUNCE_FACTOR*a.debounce {
+                if !timer.Stop() {
+                    select {
+                    case <-timer.C:
+                    default:
+                    }
+                }
+                timer.Reset(a.debounce)
+            }
+        case err, ok := <-watcher.Errors:
+            if !ok {
+                return nil
+            }
+            log.Warn("watch error: %v", err)
+        case <-timer.C:
+            for i := range pending {
+                a.sync(i)
+            }
+            pending = map[int]bool{}
+        }
+    }
+}
+
+//同步一个目录，返回同步结果
+func (a *Agent) Sync(dir WatchDir) (BackupSummary, error) {
+    summary := BackupSummary{}
+    locals, failed, err := Scan(dir.Dir, a.backup.exclude)
+    if err != nil {
+        return summary, err
+    }
+    for _, e := range failed {
+        e.Info.FilePath = path.Join(dir.Dest, e.Info.FilePath)
+        a.backup.finish(&summary, e)
+    }
+
+    //第一次同步时以仓库中的记录为准，避免重复上传
+    if !a.state.Has(dir.Dest) {
+        remotes, err := ListAll(a.backup.repo, dir.Dest)
+        if err != nil {
+            return summary, err
+        }
+        for rel, info := range remotes {
+            if !info.IsDir {
+                a.state.Set(path.Join(dir.Dest, rel), newFileState(info))
+            }
+        }
+    }
+
+    if a.watcher != nil {
+        if err := a.watcher.Add(dir.Dir); err != nil {
+            return summary, err
+        }
+    }
+    keep := map[string]bool{}
+    for _, info := range locals {
+        local := filepath.Join(dir.Dir, filepath.FromSlash(info.FilePath))
+        if info.IsDir {
+            if a.watcher != nil {
+                if err := a.watcher.Add(local); err != nil {
+                    log.Warn("watch %s failed: %v", local, err)
+                }
+            }
+            continue
+        }
+        info.FilePath = path.Join(dir.Dest, info.FilePath)
+        info.Parent = path.Dir(info.FilePath)
+        keep[info.FilePath] = true
+        if fs, ok := a.state.Get(info.FilePath); ok && fs.Same(info) {
+            summary.add(BackupEntry{Info: info, Action: BackupSkipped})
+            continue
+        }
+
+        action := BackupChanged
+        if _, ok := a.state.Get(info.FilePath); !ok {
+            action = BackupAdded
+        }
+        ret, err := a.backup.Upload(local, info)
+        if err != nil {
+            a.backup.finish(&summary, BackupEntry{Info: info, Action: BackupFailed, Err: err})
+            continue
+        }
+        //以实际上传时的状态为准
+        a.state.Set(info.FilePath, newFileState(ret))
+        a.backup.finish(&summary, BackupEntry{Info: ret, Action: action})
+    }
+    //本地已删除的文件不再记录，仓库中的版本保留
+    a.state.Prune(dir.Dest, keep)
+    return summary, a.state.Save()
+}
+
+func (a *Agent) sync(i int) {
+    dir := a.dirs[i]
+    s, err := a.Sync(dir)
+    if err != nil {
+        log.Error("sync %s failed: %v", dir.Dir, err)
+        return
+    }
+    if s.Added+s.Changed+s.Failed > 0 {
+        log.Info("sync %s: added %d, changed %d, failed %d", dir.Dir, s.Added, s.Changed, s.Failed)
+    }
+}
+
+//事件所属的监控目录
+func (a *Agent) dirOf(name string) int {
+    for i, d := range a.dirs {
+        rel, err := filepath.Rel(d.Dir, name)
+        if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+            return i
+        }
+    }
+    return -1
+}
@@ -0,0 +1,419 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package client
+
+import (
+    "citron-repo/model"
+    "citron-repo/protocol"
+    "io"
+    "net/http"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+)
+
+//备份结果
+const (
+    BackupAdded   = "added"
+    BackupChanged = "changed"
+    BackupSkipped = "skipped"
+    BackupFailed  = "failed"
+)
+
+//单个文件的备份结果，Info.FilePath为仓库路径
+type BackupEntry struct {
+    Info   model.FileInfo
+    Action string
+    Err    error
+}
+
+type BackupSummary struct {
+    Added   int
+    Changed int
+    Skipped int
+    Failed  int
+    //上传的字节数
+    Bytes   int64
+    Entries []BackupEntry
+    //创建快照时为提交后的快照，有文件失败时不提交快照
+    Snapshot model.Snapshot
+}
+
+func (s *BackupSummary) add(e BackupEntry) {
+    switch e.Action {
+    case BackupAdded:
+        s.Added++
+        s.Bytes += e.Info.Size
+    case BackupChanged:
+        s.Changed++
+        s.Bytes += e.Info.Size
+    case BackupSkipped:
+        s.Skipped++
+    case BackupFailed:
+        s.Failed++
+    }
+    s.Entries = append(s.Entries, e)
+}
+
+type Backup struct {
+    repo Repo
+    //比较checksum而不是大小及修改时间判断文件是否变化
+    checksum bool
+    progress func(info model.FileInfo) io.Writer
+    callback func(e BackupEntry)
+    //全局忽略规则
+    exclude *Ignore
+    //只比较不上传
+    dryRun bool
+    //不为空时备份完成后提交快照
+    snapshot *model.SnapshotRequest
+}
+
+type BackupOpt func(b *Backup)
+
+//使用checksum判断文件是否变化，需要读取所有本地文件
+func SetCompareChecksum(checksum bool) BackupOpt {
+    return func(b *Backup) {
+        b.checksum = checksum
+    }
+}
+
+//上传文件时将已上传的内容写入progress返回的writer，返回nil时不记录进度
+func SetProgress(progress func(info model.FileInfo) io.Writer) BackupOpt {
+    return func(b *Backup) {
+        b.progress = progress
+    }
+}
+
+//每个文件处理完成后回调
+func SetCallback(callback func(e BackupEntry)) BackupOpt {
+    return func(b *Backup) {
+        b.callback = callback
+    }
+}
+
+//全局忽略规则，语法同.gitignore，相对于备份目录
+func SetExclude(patterns []string) BackupOpt {
+    return func(b *Backup) {
+        b.exclude = NewIgnore("", patterns)
+    }
+}
+
+//只比较本地文件与仓库记录，不上传，结果中的Action为将要执行的操作
+func SetDryRun(dryRun bool) BackupOpt {
+    return func(b *Backup) {
+        b.dryRun = dryRun
+    }
+}
+
+//备份时创建快照，包含目录下所有文件（上传的及未变化的）当前的版本
+func SetSnapshot(from string, tags ...string) BackupOpt {
+    return func(b *Backup) {
+        b.snapshot = &model.SnapshotRequest{From: from, Tags: tags}
+    }
+}
+
+func NewBackup(repo Repo, opts ...BackupOpt) *Backup {
+    ret := &Backup{repo: repo}
+    for i := range opts {
+        opts[i](ret)
+    }
+    return ret
+}
+
+//遍历本地目录，返回所有文件及目录的信息，FilePath为相对于dir的路径（使用/分隔），不包含符号链接等特殊文件
+//global为全局忽略规则，各级目录下的.citronignore优先于全局规则，忽略的目录不再遍历
+func Scan(dir string, global *Ignore) ([]model.FileInfo, []BackupEntry, error) {
+    var infos []model.FileInfo
+    var failed []BackupEntry
+    //各目录生效的规则
+    ignores := map[string]Ignores{}
+    err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+        rel, _ := filepath.Rel(dir, p)
+        rel = filepath.ToSlash(rel)
+        if err != nil {
+            if rel == "." {
+                return err
+            }
+            failed = append(failed, BackupEntry{Info: model.FileInfo{FilePath: rel}, Action: BackupFailed, Err: err})
+            return nil
+        }
+        if rel == "." {
+            return loadIgnores(ignores, rel, p, Ignores{global})
+        }
+        parent := ignores[path.Dir(rel)]
+        if parent.Ignored(rel, fi.IsDir()) {
+            if fi.IsDir() {
+                return filepath.SkipDir
+            }
+            return nil
+        }
+        if fi.IsDir() {
+            if err := loadIgnores(ignores, rel, p, parent); err != nil {
+                failed = append(failed, BackupEntry{Info: model.FileInfo{FilePath: rel}, Action: BackupFailed, Err: err})
+                return filepath.SkipDir
+            }
+        } else if !fi.Mode().IsRegular() {
+            return nil
+        }
+        infos = append(infos, localInfo(rel, fi))
+        return nil
+    })
+    return infos, failed, err
+}
+
+//读取目录下的规则文件，与父目录的规则合并
+func loadIgnores(ignores map[string]Ignores, rel, dir string, parent Ignores) error {
+    base := rel
+    if base == "." {
+        base = ""
+    }
+    ig, err := LoadIgnore(base, filepath.Join(dir, IGNORE_FILE))
+    if err != nil {
+        return err
+    }
+    if ig == nil {
+        ignores[rel] = parent
+        return nil
+    }
+    igs := make(Ignores, 0, len(parent)+1)
+    ignores[rel] = append(append(igs, parent...), ig)
+    return nil
+}
+
+func localInfo(rel string, fi os.FileInfo) model.FileInfo {
+    info := model.FileInfo{
+        FileName: path.Base(rel),
+        FilePath: rel,
+        Parent:   path.Dir(rel),
+        IsDir:    fi.IsDir(),
+        ModTime:  fi.ModTime(),
+        Mode:     uint32(fi.Mode().Perm()),
+    }
+    if !fi.IsDir() {
+        info.Size = fi.Size()
+    }
+    return info
+}
+
+//递归列出仓库目录下的所有文件，key为相对于p的路径，目录不存在时返回空
+func ListAll(repo Repo, p string) (map[string]model.FileInfo, error) {
+    ret := map[string]model.FileInfo{}
+    root, err := repo.Stat(p)
+    if err != nil {
+        if IsNotFound(err) {
+            return ret, nil
+        }
+        return nil, err
+    }
+    if !root.IsDir {
+        return ret, nil
+    }
+    var list func(dir, rel string) error
+    list = func(dir, rel string) error {
+        children, err := repo.List(dir)
+        if err != nil {
+            return err
+        }
+        for _, c := range children {
+            r := path.Join(rel, c.FileName)
+            ret[r] = c
+            if c.IsDir {
+                if err := list(c.FilePath, r); err != nil {
+                    return err
+                }
+            }
+        }
+        return nil
+    }
+    return ret, list(root.FilePath, "")
+}
+
+//仓库中不存在该路径
+func IsNotFound(err error) bool {
+    switch e := err.(type) {
+    case *RestError:
+        return e.Status == http.StatusNotFound
+    case *protocol.Error:
+        return e.Status == protocol.StatusNotFound
+    }
+    return false
+}
+
+//备份本地目录到仓库路径dest，只上传新增或变化的文件
+func (b *Backup) Dir(dir, dest string) (BackupSummary, error) {
+    summary := BackupSummary{}
+    locals, failed, err := Scan(dir, b.exclude)
+    if err != nil {
+        return summary, err
+    }
+    for _, e := range failed {
+        e.Info.FilePath = path.Join(dest, e.Info.FilePath)
+        b.finish(&summary, e)
+    }
+    remotes, err := ListAll(b.repo, dest)
+    if err != nil {
+        return summary, err
+    }
+
+    var snap *snapshotWriter
+    if b.snapshot != nil && !b.dryRun {
+        req := *b.snapshot
+        req.Path = dest
+        s, err := b.repo.BeginSnapshot(req)
+        if err != nil {
+            return summary, err
+        }
+        snap = &snapshotWriter{repo: b.repo, snapshot: s}
+    }
+
+    sort.Slice(locals, func(i, j int) bool {
+        return locals[i].FilePath < locals[j].FilePath
+    })
+    for _, info := range locals {
+        if info.IsDir {
+            continue
+        }
+        local := filepath.Join(dir, filepath.FromSlash(info.FilePath))
+        remote, exists := remotes[info.FilePath]
+        info.FilePath = path.Join(dest, info.FilePath)
+        info.Parent = path.Dir(info.FilePath)
+        e := b.backupFile(local, info, remote, exists)
+        b.finish(&summary, e)
+        if snap != nil && e.Action != BackupFailed {
+            if err := snap.add(e.Info); err != nil {
+                return summary, err
+            }
+        }
+    }
+    if snap == nil {
+        return summary, nil
+    }
+    //快照必须包含所有文件
+    if summary.Failed > 0 {
+        return summary, b.repo.DeleteSnapshot(snap.snapshot.ID)
+    }
+    summary.Snapshot, err = snap.commit()
+    return summary, err
+}
+
+//每次添加到快照的请求大小上限（估算），需小于服务端的命令大小限制
+const SNAPSHOT_BATCH_SIZE = 32 * 1024
+
+//分批向快照添加文件
+type snapshotWriter struct {
+    repo     Repo
+    snapshot model.Snapshot
+    files    []model.FileRequest
+    size     int
+}
+
+func (w *snapshotWriter) add(info model.FileInfo) error {
+    w.files = append(w.files, model.FileRequest{Path: info.FilePath, Version: info.Version})
+    //json字段名及分隔符
+    w.size += len(info.FilePath) + len(info.Version) + 32
+    if w.size < SNAPSHOT_BATCH_SIZE {
+        return nil
+    }
+    return w.flush()
+}
+
+func (w *snapshotWriter) flush() error {
+    if len(w.files) == 0 {
+        return nil
+    }
+    _, err := w.repo.AddSnapshot(w.snapshot.ID, w.files)
+    w.files, w.size = w.files[:0], 0
+    return err
+}
+
+func (w *snapshotWriter) commit() (model.Snapshot, error) {
+    if err := w.flush(); err != nil {
+        return w.snapshot, err
+    }
+    return w.repo.CommitSnapshot(w.snapshot.ID)
+}
+
+func (b *Backup) finish(summary *BackupSummary, e BackupEntry) {
+    summary.add(e)
+    if b.callback != nil {
+        b.callback(e)
+    }
+}
+
+func (b *Backup) backupFile(local string, info, remote model.FileInfo, exists bool) BackupEntry {
+    action := BackupAdded
+    if exists {
+        if remote.IsDir {
+            return BackupEntry{Info: info, Action: BackupFailed, Err: os.ErrExist}
+        }
+        changed, err := b.changed(local, &info, remote)
+        if err != nil {
+            return BackupEntry{Info: info, Action: BackupFailed, Err: err}
+        }
+        if !changed {
+            return BackupEntry{Info: remote, Action: BackupSkipped}
+        }
+        action = BackupChanged
+    }
+    if b.dryRun {
+        return BackupEntry{Info: info, Action: action}
+    }
+
+    ret, err := b.Upload(local, info)
+    if err != nil {
+        return BackupEntry{Info: info, Action: BackupFailed, Err: err}
+    }
+    return BackupEntry{Info: ret, Action: action}
+}
+
+//比较本地文件与仓库记录，使用checksum比较时计算的checksum保存在info中
+func (b *Backup) changed(local string, info *model.FileInfo, remote model.FileInfo) (bool, error) {
+    if info.Size != remote.Size {
+        return true, nil
+    }
+    if b.checksum && remote.Checksum != "" {
+        f, err := os.Open(local)
+        if err != nil {
+            return false, err
+        }
+        defer f.Close()
+        sum, err := Checksum(f)
+        if err != nil {
+            return false, err
+        }
+        info.Checksum = sum
+        return sum != remote.Checksum || info.Mode != remote.Mode, nil
+    }
+    return !info.ModTime.Equal(remote.ModTime) || info.Mode != remote.Mode, nil
+}
+
+//上传单个本地文件，info.FilePath为仓库路径
+func (b *Backup) Upload(local string, info model.FileInfo) (model.FileInfo, error) {
+    f, err := os.Open(local)
+    if err != nil {
+        return info, err
+    }
+    defer f.Close()
+    //以打开后的状态为准
+    fi, err := f.Stat()
+    if err != nil {
+        return info, err
+    }
+    info.Size = fi.Size()
+    info.ModTime = fi.ModTime()
+    info.Mode = uint32(fi.Mode().Perm())
+
+    var r io.Reader = f
+    if b.progress != nil {
+        if w := b.progress(info); w != nil {
+            r = io.TeeReader(f, w)
+        }
+    }
+    return b.repo.Upload(info, info.Size, r)
+}
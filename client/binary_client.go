@@ -7,12 +7,16 @@
 package client
 
 import (
+    "bytes"
     "citron-repo/ioutil"
+    "citron-repo/model"
     "citron-repo/protocol"
     "encoding/binary"
+    "encoding/json"
     "errors"
     "github.com/xfali/goutils/log"
     "io"
+    "net"
 )
 
 const (
@@ -20,12 +24,17 @@ const (
     Version         = 0x01
     ReadBufferSize  = 32 * 1024
     WriteBufferSize = 32 * 1024
+    //错误响应body最大长度
+    MaxErrorSize = 4 * 1024
 )
 
 type BinaryClient struct {
     sendBuffer []byte
     recvBuffer []byte
     client     *TcpClient
+    //协商的请求body压缩算法及最小压缩长度
+    codec   int16
+    minSize int64
 }
 
 func NewBinaryClient(addr string) *BinaryClient {
@@ -57,10 +66,20 @@ func ReadResponseHeader(resp *protocol.ResponseHeader, r io.Reader) error {
 }
 
 func (c *BinaryClient) Send(length int64, body io.Reader) (err error) {
+    return c.SendCommand(protocol.DebugCommandID, length, body)
+}
+
+func (c *BinaryClient) SendCommand(cmd int16, length int64, body io.Reader) (err error) {
+    compress := c.codec != protocol.CodecNone && body != nil && length > 0 && length >= c.minSize
+    reserve := cmd
+    if compress {
+        reserve = protocol.JoinReserve(cmd, c.codec)
+    }
     w := &ioutil.ByteWrapper{B: c.sendBuffer}
     err = binary.Write(w, binary.BigEndian, protocol.RequestHeader{
         MagicCode: MagicCode,
         Version:   Version,
+        Reserve:   reserve,
         Length:    length,
     })
     if err != nil {
@@ -70,6 +89,9 @@ func (c *BinaryClient) Send(length int64, body io.Reader) (err error) {
     if err != nil {
         return err
     }
+    if compress {
+        return protocol.WriteBlocks(c.client.conn, c.codec, body, length)
+    }
     if body != nil {
         _, err = c.client.Send(body)
         if err != nil {
@@ -107,5 +129,234 @@ func (c *BinaryClient) Receive() (body io.Reader, err error) {
         return
     }
 
-    return io.LimitReader(c.client.conn, header.Length), nil
+    status, codec := protocol.SplitReserve(header.Reserve)
+    if protocol.CodecName(codec) == "" {
+        return nil, protocol.ErrCodec
+    }
+    body = io.LimitReader(c.client.conn, header.Length)
+    if codec != protocol.CodecNone {
+        body = protocol.NewBlockReader(c.client.conn, codec, header.Length)
+    }
+    if status != protocol.StatusOK {
+        msg := bytes.NewBuffer(nil)
+        _, err = ioutil.CopyN(msg, body, MaxErrorSize)
+        if err != nil {
+            return nil, err
+        }
+        return nil, protocol.NewError(status, msg.String())
+    }
+    return body, nil
+}
+
+//与服务端协商body压缩算法，codecs按优先级排序，服务端不支持协商时返回protocol.Error，此时不压缩
+func (c *BinaryClient) Negotiate(codecs []string) error {
+    ret := protocol.Negotiation{}
+    if err := c.sendJson(protocol.NegotiateCommandID, protocol.Negotiation{Codecs: codecs}); err != nil {
+        return err
+    }
+    if err := c.receiveJson(&ret); err != nil {
+        return err
+    }
+    codec, err := protocol.ParseCodec(ret.Codec)
+    if err != nil {
+        return err
+    }
+    c.codec, c.minSize = codec, ret.MinSize
+    return nil
+}
+
+//协商的压缩算法，未协商或不压缩时为none
+func (c *BinaryClient) Codec() string {
+    return protocol.CodecName(c.codec)
+}
+
+//使用用户名密码认证连接
+func (c *BinaryClient) Login(username, password string) error {
+    return c.auth(model.AuthInfo{Username: username, Password: password})
+}
+
+//使用REST /login获得的登录token认证连接
+func (c *BinaryClient) LoginWithToken(token string) error {
+    return c.auth(model.AuthInfo{Token: token})
+}
+
+//使用api key认证连接
+func (c *BinaryClient) LoginWithApiKey(key string) error {
+    return c.auth(model.AuthInfo{ApiKey: key})
+}
+
+func (c *BinaryClient) auth(info model.AuthInfo) error {
+    data, err := json.Marshal(info)
+    if err != nil {
+        return err
+    }
+    err = c.SendCommand(protocol.AuthCommandID, int64(len(data)), bytes.NewReader(data))
+    if err != nil {
+        return err
+    }
+    r, err := c.Receive()
+    if err != nil {
+        return err
+    }
+    _, err = ioutil.Copy(bytes.NewBuffer(nil), r)
+    return err
+}
+
+//连接服务端，连接失败时返回错误
+func Dial(addr string) (*BinaryClient, error) {
+    conn, err := net.Dial("tcp", addr)
+    if err != nil {
+        return nil, err
+    }
+    return &BinaryClient{
+        sendBuffer: make([]byte, WriteBufferSize),
+        recvBuffer: make([]byte, ReadBufferSize),
+        client:     &TcpClient{conn: conn},
+    }, nil
+}
+
+func (c *BinaryClient) Upload(info model.FileInfo, size int64, r io.Reader) (model.FileInfo, error) {
+    meta, err := protocol.EncodeMeta(info)
+    if err != nil {
+        return model.FileInfo{}, err
+    }
+    err = c.SendCommand(protocol.UploadCommandID, int64(len(meta))+size, io.MultiReader(bytes.NewReader(meta), io.LimitReader(r, size)))
+    if err != nil {
+        return model.FileInfo{}, err
+    }
+    ret := model.FileInfo{}
+    return ret, c.receiveJson(&ret)
+}
+
+func (c *BinaryClient) Download(req model.FileRequest, w io.Writer) (model.FileInfo, error) {
+    info := model.FileInfo{}
+    if err := c.sendJson(protocol.DownloadCommandID, req); err != nil {
+        return info, err
+    }
+    body, err := c.Receive()
+    if err != nil {
+        return info, err
+    }
+    if _, err := protocol.DecodeMeta(body, &info); err != nil {
+        return info, err
+    }
+    cw := newChecksumWriter(w)
+    if _, err := ioutil.Copy(cw, body); err != nil {
+        return info, err
+    }
+    return info, cw.Check(info)
+}
+
+func (c *BinaryClient) Stat(path string) (model.FileInfo, error) {
+    ret := model.FileInfo{}
+    return ret, c.request(protocol.StatCommandID, path, &ret)
+}
+
+func (c *BinaryClient) List(path string) ([]model.FileInfo, error) {
+    var ret []model.FileInfo
+    return ret, c.request(protocol.ListCommandID, path, &ret)
+}
+
+func (c *BinaryClient) Versions(path string) ([]model.FileInfo, error) {
+    var ret []model.FileInfo
+    return ret, c.request(protocol.VersionsCommandID, path, &ret)
+}
+
+func (c *BinaryClient) Prune(req model.PruneRequest) (model.PruneResult, error) {
+    ret := model.PruneResult{}
+    if err := c.sendJson(protocol.PruneCommandID, req); err != nil {
+        return ret, err
+    }
+    return ret, c.receiveJson(&ret)
+}
+
+func (c *BinaryClient) Delete(path string) (model.TrashResult, error) {
+    ret := model.TrashResult{}
+    return ret, c.request(protocol.DeleteCommandID, path, &ret)
+}
+
+func (c *BinaryClient) Trash(path string) ([]model.TrashEntry, error) {
+    var ret []model.TrashEntry
+    return ret, c.request(protocol.TrashListCommandID, path, &ret)
+}
+
+func (c *BinaryClient) RestoreTrash(path string) (model.TrashResult, error) {
+    ret := model.TrashResult{}
+    return ret, c.request(protocol.TrashRestoreCommandID, path, &ret)
+}
+
+func (c *BinaryClient) PurgeTrash(path string) (model.TrashResult, error) {
+    ret := model.TrashResult{}
+    return ret, c.request(protocol.TrashPurgeCommandID, path, &ret)
+}
+
+func (c *BinaryClient) BeginSnapshot(req model.SnapshotRequest) (model.Snapshot, error) {
+    ret := model.Snapshot{}
+    return ret, c.snapshotRequest(protocol.SnapshotBeginCommandID, req, &ret)
+}
+
+func (c *BinaryClient) AddSnapshot(id string, files []model.FileRequest) (model.Snapshot, error) {
+    ret := model.Snapshot{}
+    return ret, c.snapshotRequest(protocol.SnapshotAddCommandID, model.SnapshotRequest{ID: id, Files: files}, &ret)
+}
+
+func (c *BinaryClient) CommitSnapshot(id string) (model.Snapshot, error) {
+    ret := model.Snapshot{}
+    return ret, c.snapshotRequest(protocol.SnapshotCommitCommandID, model.SnapshotRequest{ID: id}, &ret)
+}
+
+func (c *BinaryClient) Snapshots(path string) ([]model.Snapshot, error) {
+    var ret []model.Snapshot
+    return ret, c.snapshotRequest(protocol.SnapshotListCommandID, model.SnapshotRequest{Path: path}, &ret)
+}
+
+func (c *BinaryClient) Snapshot(id string) (model.Snapshot, error) {
+    ret := model.Snapshot{}
+    return ret, c.snapshotRequest(protocol.SnapshotShowCommandID, model.SnapshotRequest{ID: id}, &ret)
+}
+
+func (c *BinaryClient) RestoreSnapshot(id, target string) (model.RestoreResult, error) {
+    ret := model.RestoreResult{}
+    return ret, c.snapshotRequest(protocol.SnapshotRestoreCommandID, model.SnapshotRequest{ID: id, Target: target}, &ret)
+}
+
+func (c *BinaryClient) DeleteSnapshot(id string) error {
+    return c.snapshotRequest(protocol.SnapshotDeleteCommandID, model.SnapshotRequest{ID: id}, nil)
+}
+
+func (c *BinaryClient) snapshotRequest(cmd int16, req model.SnapshotRequest, v interface{}) error {
+    if err := c.sendJson(cmd, req); err != nil {
+        return err
+    }
+    return c.receiveJson(v)
+}
+
+func (c *BinaryClient) request(cmd int16, path string, v interface{}) error {
+    if err := c.sendJson(cmd, model.FileRequest{Path: path}); err != nil {
+        return err
+    }
+    return c.receiveJson(v)
+}
+
+func (c *BinaryClient) sendJson(cmd int16, v interface{}) error {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    return c.SendCommand(cmd, int64(len(data)), bytes.NewReader(data))
+}
+
+func (c *BinaryClient) receiveJson(v interface{}) error {
+    body, err := c.Receive()
+    if err != nil {
+        return err
+    }
+    buf := bytes.NewBuffer(nil)
+    if _, err := ioutil.Copy(buf, body); err != nil {
+        return err
+    }
+    if v == nil || buf.Len() == 0 {
+        return nil
+    }
+    return json.Unmarshal(buf.Bytes(), v)
 }
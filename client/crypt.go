@@ -0,0 +1,489 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package client
+
+import (
+    "bytes"
+    "citron-repo/model"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "golang.org/x/crypto/chacha20poly1305"
+    "golang.org/x/crypto/hkdf"
+    "golang.org/x/crypto/scrypt"
+    "hash"
+    "io"
+    "path"
+    "strings"
+)
+
+//客户端加密算法
+const (
+    CIPHER_AES_GCM = "aes-256-gcm"
+    CIPHER_XCHACHA = "xchacha20-poly1305"
+
+    KDF_SCRYPT = "scrypt"
+
+    //密钥参数在仓库中的保存路径（相对于用户的备份根目录），文件名不加密
+    CRYPT_KEY_FILE = ".citron-key"
+    //每块明文的大小，每块单独认证
+    CRYPT_CHUNK_SIZE = 64 * 1024
+)
+
+const (
+    scryptN = 1 << 15
+    scryptR = 8
+    scryptP = 1
+
+    //文件头：magic + 随机salt（用于派生文件密钥）
+    cryptMagic      = "CTE1"
+    cryptSaltSize   = 24
+    cryptHeaderSize = len(cryptMagic) + cryptSaltSize
+    //每块的认证标签长度
+    cryptOverhead = 16
+    //加密后的文件名长度上限
+    cryptMaxName = 255
+    //最短的nonce（AES-GCM）
+    cryptMinNonce = 12
+)
+
+var (
+    ErrPassphrase  = errors.New("wrong passphrase")
+    ErrDecrypt     = errors.New("decrypt failed")
+    ErrCipher      = errors.New("unknown cipher")
+    ErrNameTooLong = errors.New("encrypted name too long")
+    ErrNoKey       = errors.New("encryption key not found")
+    ErrKeyExists   = errors.New("encryption key already exists")
+    //仓库中没有密钥参数，但已有加密的文件，新的密钥无法解密这些文件
+    ErrKeyLost = errors.New("encryption key not found but encrypted files exist")
+)
+
+//保存在仓库中的密钥参数，不包含密钥本身
+type KeyParams struct {
+    Cipher string `json:"cipher"`
+    KDF    string `json:"kdf"`
+    N      int    `json:"n"`
+    R      int    `json:"r"`
+    P      int    `json:"p"`
+    Salt   []byte `json:"salt"`
+    //由口令派生，用于校验口令是否正确
+    Check []byte `json:"check"`
+}
+
+//由口令派生的密钥：内容密钥用于派生每个文件的密钥，文件名使用确定性加密（相同的名字加密结果相同）
+type CryptKey struct {
+    algo    string
+    content []byte
+    nameIV  []byte
+    name    cipher.AEAD
+}
+
+//使用新的随机salt派生密钥，algo为空时使用AES-GCM
+func NewKey(passphrase, algo string) (*CryptKey, KeyParams, error) {
+    if algo == "" {
+        algo = CIPHER_AES_GCM
+    }
+    params := KeyParams{Cipher: algo, KDF: KDF_SCRYPT, N: scryptN, R: scryptR, P: scryptP, Salt: make([]byte, 32)}
+    if _, err := rand.Read(params.Salt); err != nil {
+        return nil, params, err
+    }
+    key, check, err := deriveKey(passphrase, params)
+    params.Check = check
+    return key, params, err
+}
+
+//使用保存的参数派生密钥并校验口令
+func OpenKey(passphrase string, params KeyParams) (*CryptKey, error) {
+    key, check, err := deriveKey(passphrase, params)
+    if err != nil {
+        return nil, err
+    }
+    if !hmac.Equal(check, params.Check) {
+        return nil, ErrPassphrase
+    }
+    return key, nil
+}
+
+//读取仓库中的密钥参数并派生密钥，仓库中没有密钥参数时返回ErrNoKey（已有加密的文件时返回ErrKeyLost）
+//repo为未加密的仓库客户端
+func LoadKey(repo Repo, passphrase string) (*CryptKey, error) {
+    buf := bytes.NewBuffer(nil)
+    _, err := repo.Download(model.FileRequest{Path: CRYPT_KEY_FILE}, buf)
+    if err == nil {
+        params := KeyParams{}
+        if err := json.Unmarshal(buf.Bytes(), &params); err != nil {
+            return nil, err
+        }
+        return OpenKey(passphrase, params)
+    }
+    if !IsNotFound(err) {
+        return nil, err
+    }
+    if err := checkNoEncrypted(repo); err != nil {
+        return nil, err
+    }
+    return nil, ErrNoKey
+}
+
+//使用algo创建密钥参数并上传，仓库中已有密钥参数时返回ErrKeyExists，已有加密的文件时返回ErrKeyLost
+//repo为未加密的仓库客户端
+func InitKey(repo Repo, passphrase, algo string) (*CryptKey, error) {
+    _, err := repo.Stat(CRYPT_KEY_FILE)
+    if err == nil {
+        return nil, ErrKeyExists
+    }
+    if !IsNotFound(err) {
+        return nil, err
+    }
+    if err := checkNoEncrypted(repo); err != nil {
+        return nil, err
+    }
+
+    key, params, err := NewKey(passphrase, algo)
+    if err != nil {
+        return nil, err
+    }
+    data, err := json.Marshal(params)
+    if err != nil {
+        return nil, err
+    }
+    if _, err := repo.Upload(model.FileInfo{FilePath: CRYPT_KEY_FILE, Mode: 0600}, int64(len(data)), bytes.NewReader(data)); err != nil {
+        return nil, err
+    }
+    return key, nil
+}
+
+//备份根目录中有加密的文件名时返回ErrKeyLost
+func checkNoEncrypted(repo Repo) error {
+    infos, err := repo.List("")
+    if err != nil {
+        if IsNotFound(err) {
+            return nil
+        }
+        return err
+    }
+    for _, info := range infos {
+        if info.FileName != CRYPT_KEY_FILE && isEncryptedName(info.FileName) {
+            return ErrKeyLost
+        }
+    }
+    return nil
+}
+
+//名字可以按加密后的格式解码：nonce + 密文 + 认证标签
+func isEncryptedName(name string) bool {
+    data, err := base64.RawURLEncoding.DecodeString(name)
+    return err == nil && len(data) > cryptMinNonce+cryptOverhead
+}
+
+func deriveKey(passphrase string, params KeyParams) (*CryptKey, []byte, error) {
+    if params.KDF != KDF_SCRYPT {
+        return nil, nil, fmt.Errorf("unknown kdf %s", params.KDF)
+    }
+    master, err := scrypt.Key([]byte(passphrase), params.Salt, params.N, params.R, params.P, 32)
+    if err != nil {
+        return nil, nil, err
+    }
+    sub := func(info string) []byte {
+        ret := make([]byte, 32)
+        io.ReadFull(hkdf.New(sha256.New, master, nil, []byte(info)), ret)
+        return ret
+    }
+    name, err := newAEAD(params.Cipher, sub("citron name"))
+    if err != nil {
+        return nil, nil, err
+    }
+    key := &CryptKey{
+        algo:    params.Cipher,
+        content: sub("citron content"),
+        nameIV:  sub("citron name iv"),
+        name:    name,
+    }
+    return key, sub("citron check"), nil
+}
+
+func newAEAD(algo string, key []byte) (cipher.AEAD, error) {
+    switch algo {
+    case CIPHER_AES_GCM:
+        block, err := aes.NewCipher(key)
+        if err != nil {
+            return nil, err
+        }
+        return cipher.NewGCM(block)
+    case CIPHER_XCHACHA:
+        return chacha20poly1305.NewX(key)
+    }
+    return nil, ErrCipher
+}
+
+//nonce由名字的HMAC生成，相同的名字加密结果相同，以便按路径查找
+func (k *CryptKey) encryptName(name string) (string, error) {
+    mac := hmac.New(sha256.New, k.nameIV)
+    mac.Write([]byte(name))
+    nonce := mac.Sum(nil)[:k.name.NonceSize()]
+    data := k.name.Seal(append([]byte{}, nonce...), nonce, []byte(name), nil)
+    ret := base64.RawURLEncoding.EncodeToString(data)
+    if len(ret) > cryptMaxName {
+        return "", ErrNameTooLong
+    }
+    return ret, nil
+}
+
+func (k *CryptKey) decryptName(name string) (string, error) {
+    data, err := base64.RawURLEncoding.DecodeString(name)
+    ns := k.name.NonceSize()
+    if err != nil || len(data) < ns+cryptOverhead {
+        return "", ErrDecrypt
+    }
+    plain, err := k.name.Open(nil, data[:ns], data[ns:], nil)
+    if err != nil {
+        return "", ErrDecrypt
+    }
+    return string(plain), nil
+}
+
+//相对路径的每一级都加密，绝对路径的第一级（用户的备份根目录）不加密
+func (k *CryptKey) encryptPath(p string) (string, error) {
+    return k.convertPath(p, k.encryptName)
+}
+
+func (k *CryptKey) decryptPath(p string) (string, error) {
+    return k.convertPath(p, k.decryptName)
+}
+
+func (k *CryptKey) convertPath(p string, f func(string) (string, error)) (string, error) {
+    abs := strings.HasPrefix(p, "/")
+    clean := strings.TrimPrefix(path.Clean("/"+p), "/")
+    if clean == "" {
+        return p, nil
+    }
+    parts := strings.Split(clean, "/")
+    start := 0
+    if abs {
+        start = 1
+    }
+    for i := start; i < len(parts); i++ {
+        name, err := f(parts[i])
+        if err != nil {
+            return p, err
+        }
+        parts[i] = name
+    }
+    ret := strings.Join(parts, "/")
+    if abs {
+        ret = "/" + ret
+    }
+    return ret, nil
+}
+
+//每个文件使用由文件头中的salt派生的密钥
+func (k *CryptKey) fileAEAD(salt []byte) (cipher.AEAD, error) {
+    mac := hmac.New(sha256.New, k.content)
+    mac.Write(salt)
+    return newAEAD(k.algo, mac.Sum(nil))
+}
+
+//第i块的nonce：块序号（大端）+ 是否为最后一块，防止块被重排或截断
+func chunkNonce(nonce []byte, i uint64, last bool) {
+    for j := range nonce {
+        nonce[j] = 0
+    }
+    binary.BigEndian.PutUint64(nonce[len(nonce)-9:], i)
+    if last {
+        nonce[len(nonce)-1] = 1
+    }
+}
+
+//加密后的大小：文件头 + 每块的认证标签 + 明文，空文件也有一个块
+func EncryptedSize(size int64) int64 {
+    chunks := (size + CRYPT_CHUNK_SIZE - 1) / CRYPT_CHUNK_SIZE
+    if chunks == 0 {
+        chunks = 1
+    }
+    return int64(cryptHeaderSize) + chunks*cryptOverhead + size
+}
+
+//由加密后的大小计算明文大小，大小不合法时返回-1
+func DecryptedSize(size int64) int64 {
+    size -= int64(cryptHeaderSize)
+    if size < cryptOverhead {
+        return -1
+    }
+    chunks := (size + CRYPT_CHUNK_SIZE + cryptOverhead - 1) / (CRYPT_CHUNK_SIZE + cryptOverhead)
+    return size - chunks*cryptOverhead
+}
+
+//读取size字节明文，输出文件头及加密后的块，同时计算明文的checksum
+type encryptReader struct {
+    r      io.Reader
+    aead   cipher.AEAD
+    header []byte
+    nonce  []byte
+    left   int64
+    index  uint64
+    done   bool
+    plain  []byte
+    out    []byte
+    buf    []byte
+    hash   hash.Hash
+}
+
+func (k *CryptKey) newEncryptReader(r io.Reader, size int64) (*encryptReader, error) {
+    header := make([]byte, cryptHeaderSize)
+    copy(header, cryptMagic)
+    if _, err := rand.Read(header[len(cryptMagic):]); err != nil {
+        return nil, err
+    }
+    aead, err := k.fileAEAD(header[len(cryptMagic):])
+    if err != nil {
+        return nil, err
+    }
+    return &encryptReader{
+        r:      r,
+        aead:   aead,
+        header: header,
+        nonce:  make([]byte, aead.NonceSize()),
+        left:   size,
+        plain:  make([]byte, CRYPT_CHUNK_SIZE),
+        out:    make([]byte, 0, CRYPT_CHUNK_SIZE+cryptOverhead),
+        buf:    header,
+        hash:   sha256.New(),
+    }, nil
+}
+
+func (e *encryptReader) Read(p []byte) (int, error) {
+    if len(e.buf) == 0 {
+        if e.done {
+            return 0, io.EOF
+        }
+        if err := e.next(); err != nil {
+            return 0, err
+        }
+    }
+    n := copy(p, e.buf)
+    e.buf = e.buf[n:]
+    return n, nil
+}
+
+func (e *encryptReader) next() error {
+    n := int64(CRYPT_CHUNK_SIZE)
+    if n > e.left {
+        n = e.left
+    }
+    plain := e.plain[:n]
+    if _, err := io.ReadFull(e.r, plain); err != nil {
+        if err == io.EOF {
+            err = io.ErrUnexpectedEOF
+        }
+        return err
+    }
+    e.hash.Write(plain)
+    e.left -= n
+    e.done = e.left == 0
+    chunkNonce(e.nonce, e.index, e.done)
+    e.index++
+    e.buf = e.aead.Seal(e.out[:0], e.nonce, plain, e.header)
+    return nil
+}
+
+//明文的checksum
+func (e *encryptReader) Sum() string {
+    return hex.EncodeToString(e.hash.Sum(nil))
+}
+
+//接收加密后的数据，解密后写入w，最后一块在Close时处理
+//解密或写入出错后丢弃剩余数据（保证连接可以继续使用），错误在Close时返回
+type decryptWriter struct {
+    key    *CryptKey
+    w      io.Writer
+    err    error
+    aead   cipher.AEAD
+    header []byte
+    nonce  []byte
+    index  uint64
+    buf    []byte
+    plain  []byte
+    hash   hash.Hash
+}
+
+func (k *CryptKey) newDecryptWriter(w io.Writer) *decryptWriter {
+    return &decryptWriter{key: k, w: w, plain: make([]byte, 0, CRYPT_CHUNK_SIZE), hash: sha256.New()}
+}
+
+func (d *decryptWriter) Write(p []byte) (int, error) {
+    if d.err == nil {
+        d.err = d.write(p)
+    }
+    return len(p), nil
+}
+
+func (d *decryptWriter) write(p []byte) error {
+    d.buf = append(d.buf, p...)
+    off := 0
+    if d.aead == nil {
+        if len(d.buf) < cryptHeaderSize {
+            return nil
+        }
+        if string(d.buf[:len(cryptMagic)]) != cryptMagic {
+            return ErrDecrypt
+        }
+        d.header = append([]byte{}, d.buf[:cryptHeaderSize]...)
+        aead, err := d.key.fileAEAD(d.header[len(cryptMagic):])
+        if err != nil {
+            return err
+        }
+        d.aead, d.nonce, off = aead, make([]byte, aead.NonceSize()), cryptHeaderSize
+    }
+    //无法确定是否为最后一块时保留
+    chunk := CRYPT_CHUNK_SIZE + cryptOverhead
+    for len(d.buf)-off > chunk {
+        if err := d.open(d.buf[off:off+chunk], false); err != nil {
+            return err
+        }
+        off += chunk
+    }
+    d.buf = d.buf[:copy(d.buf, d.buf[off:])]
+    return nil
+}
+
+func (d *decryptWriter) open(data []byte, last bool) error {
+    chunkNonce(d.nonce, d.index, last)
+    d.index++
+    plain, err := d.aead.Open(d.plain[:0], d.nonce, data, d.header)
+    if err != nil {
+        return ErrDecrypt
+    }
+    d.hash.Write(plain)
+    _, err = d.w.Write(plain)
+    return err
+}
+
+//解密最后一块，数据被截断时返回ErrDecrypt
+func (d *decryptWriter) Close() error {
+    if d.err != nil {
+        return d.err
+    }
+    if d.aead == nil || len(d.buf) < cryptOverhead {
+        return ErrDecrypt
+    }
+    err := d.open(d.buf, true)
+    d.buf = d.buf[:0]
+    return err
+}
+
+//明文的checksum
+func (d *decryptWriter) Sum() string {
+    return hex.EncodeToString(d.hash.Sum(nil))
+}
@@ -0,0 +1,319 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package client
+
+import (
+    "citron-repo/model"
+    "errors"
+    "io"
+    "path"
+)
+
+var ErrDiffNotSupported = errors.New("diff is only available over rest")
+
+//客户端加密的仓库：文件内容及路径在上传前加密，下载时解密，服务端只保存密文，快照清单中也只有加密后的路径
+//文件大小、修改时间、权限及目录层级不加密；服务端记录的是密文的checksum，Stat及List返回的checksum为空
+type CryptRepo struct {
+    repo Repo
+    key  *CryptKey
+}
+
+func NewCryptRepo(repo Repo, key *CryptKey) *CryptRepo {
+    return &CryptRepo{repo: repo, key: key}
+}
+
+//返回的Checksum为明文的checksum
+func (c *CryptRepo) Upload(info model.FileInfo, size int64, r io.Reader) (model.FileInfo, error) {
+    p, err := c.key.encryptPath(info.FilePath)
+    if err != nil {
+        return info, err
+    }
+    er, err := c.key.newEncryptReader(r, size)
+    if err != nil {
+        return info, err
+    }
+    enc := info
+    enc.FilePath, enc.FileName, enc.Parent = p, path.Base(p), path.Dir(p)
+    //服务端校验的是密文，不上传明文的checksum
+    enc.Checksum, enc.ChecksumType = "", ""
+    ret, err := c.repo.Upload(enc, EncryptedSize(size), er)
+    if err != nil {
+        return info, err
+    }
+    ret, err = c.decryptInfo(ret)
+    ret.Checksum = er.Sum()
+    return ret, err
+}
+
+//解密后写入w，内容被篡改或截断时返回ErrDecrypt，返回的Checksum为明文的checksum
+func (c *CryptRepo) Download(req model.FileRequest, w io.Writer) (model.FileInfo, error) {
+    p, err := c.key.encryptPath(req.Path)
+    if err != nil {
+        return model.FileInfo{}, err
+    }
+    dw := c.key.newDecryptWriter(w)
+    info, err := c.repo.Download(model.FileRequest{Path: p, Version: req.Version}, dw)
+    //密文被篡改时服务端的checksum也不匹配，优先返回解密错误
+    if cerr := dw.Close(); cerr != nil && (err == nil || err == ErrChecksum) {
+        err = cerr
+    }
+    if err != nil {
+        return info, err
+    }
+    info, err = c.decryptInfo(info)
+    info.Checksum = dw.Sum()
+    return info, err
+}
+
+func (c *CryptRepo) Stat(p string) (model.FileInfo, error) {
+    enc, err := c.key.encryptPath(p)
+    if err != nil {
+        return model.FileInfo{}, err
+    }
+    info, err := c.repo.Stat(enc)
+    if err != nil {
+        return info, err
+    }
+    return c.decryptInfo(info)
+}
+
+//不是使用该密钥加密的文件（如密钥参数文件）不返回
+func (c *CryptRepo) List(p string) ([]model.FileInfo, error) {
+    enc, err := c.key.encryptPath(p)
+    if err != nil {
+        return nil, err
+    }
+    infos, err := c.repo.List(enc)
+    if err != nil {
+        return nil, err
+    }
+    ret := make([]model.FileInfo, 0, len(infos))
+    for _, info := range infos {
+        if info, err := c.decryptInfo(info); err == nil {
+            ret = append(ret, info)
+        }
+    }
+    return ret, nil
+}
+
+func (c *CryptRepo) Versions(p string) ([]model.FileInfo, error) {
+    enc, err := c.key.encryptPath(p)
+    if err != nil {
+        return nil, err
+    }
+    infos, err := c.repo.Versions(enc)
+    if err != nil {
+        return nil, err
+    }
+    for i := range infos {
+        if infos[i], err = c.decryptInfo(infos[i]); err != nil {
+            return nil, err
+        }
+    }
+    return infos, nil
+}
+
+func (c *CryptRepo) Prune(req model.PruneRequest) (model.PruneResult, error) {
+    p, err := c.key.encryptPath(req.Path)
+    if err != nil {
+        return model.PruneResult{}, err
+    }
+    req.Path = p
+    return c.repo.Prune(req)
+}
+
+func (c *CryptRepo) Delete(p string) (model.TrashResult, error) {
+    enc, err := c.key.encryptPath(p)
+    if err != nil {
+        return model.TrashResult{}, err
+    }
+    return c.trashResult(c.repo.Delete(enc))
+}
+
+//不是使用该密钥加密的文件不返回
+func (c *CryptRepo) Trash(p string) ([]model.TrashEntry, error) {
+    enc, err := c.key.encryptPath(p)
+    if err != nil {
+        return nil, err
+    }
+    entries, err := c.repo.Trash(enc)
+    if err != nil {
+        return nil, err
+    }
+    ret := make([]model.TrashEntry, 0, len(entries))
+    for _, e := range entries {
+        if e.Path, err = c.key.decryptPath(e.Path); err != nil {
+            continue
+        }
+        if e.Size = DecryptedSize(e.Size); e.Size >= 0 {
+            ret = append(ret, e)
+        }
+    }
+    return ret, nil
+}
+
+func (c *CryptRepo) RestoreTrash(p string) (model.TrashResult, error) {
+    enc, err := c.key.encryptPath(p)
+    if err != nil {
+        return model.TrashResult{}, err
+    }
+    return c.trashResult(c.repo.RestoreTrash(enc))
+}
+
+func (c *CryptRepo) PurgeTrash(p string) (model.TrashResult, error) {
+    enc, err := c.key.encryptPath(p)
+    if err != nil {
+        return model.TrashResult{}, err
+    }
+    return c.trashResult(c.repo.PurgeTrash(enc))
+}
+
+func (c *CryptRepo) BeginSnapshot(req model.SnapshotRequest) (model.Snapshot, error) {
+    p, err := c.key.encryptPath(req.Path)
+    if err != nil {
+        return model.Snapshot{}, err
+    }
+    files, err := c.encryptFiles(req.Files)
+    if err != nil {
+        return model.Snapshot{}, err
+    }
+    req.Path, req.Files = p, files
+    return c.snapshot(c.repo.BeginSnapshot(req))
+}
+
+func (c *CryptRepo) AddSnapshot(id string, files []model.FileRequest) (model.Snapshot, error) {
+    files, err := c.encryptFiles(files)
+    if err != nil {
+        return model.Snapshot{}, err
+    }
+    return c.snapshot(c.repo.AddSnapshot(id, files))
+}
+
+func (c *CryptRepo) CommitSnapshot(id string) (model.Snapshot, error) {
+    return c.snapshot(c.repo.CommitSnapshot(id))
+}
+
+func (c *CryptRepo) Snapshots(p string) ([]model.Snapshot, error) {
+    enc, err := c.key.encryptPath(p)
+    if err != nil {
+        return nil, err
+    }
+    snaps, err := c.repo.Snapshots(enc)
+    if err != nil {
+        return nil, err
+    }
+    for i := range snaps {
+        if snaps[i], err = c.snapshot(snaps[i], nil); err != nil {
+            return nil, err
+        }
+    }
+    return snaps, nil
+}
+
+func (c *CryptRepo) Snapshot(id string) (model.Snapshot, error) {
+    return c.snapshot(c.repo.Snapshot(id))
+}
+
+func (c *CryptRepo) RestoreSnapshot(id, target string) (model.RestoreResult, error) {
+    enc, err := c.key.encryptPath(target)
+    if err != nil {
+        return model.RestoreResult{}, err
+    }
+    return c.repo.RestoreSnapshot(id, enc)
+}
+
+func (c *CryptRepo) DeleteSnapshot(id string) error {
+    return c.repo.DeleteSnapshot(id)
+}
+
+//比较快照，使用RestClient时可用；密文每次上传都不同，重新上传的文件总是显示checksum变化
+func (c *CryptRepo) DiffSnapshot(from, to string, f func(e model.DiffEntry) error) error {
+    rc, ok := c.repo.(*RestClient)
+    if !ok {
+        return ErrDiffNotSupported
+    }
+    return rc.DiffSnapshot(from, to, func(e model.DiffEntry) error {
+        var err error
+        if e.Path, err = c.key.decryptPath(e.Path); err != nil {
+            return err
+        }
+        if e.OldPath != "" {
+            if e.OldPath, err = c.key.decryptPath(e.OldPath); err != nil {
+                return err
+            }
+        }
+        for _, info := range []*model.FileInfo{e.Old, e.New} {
+            if info == nil {
+                continue
+            }
+            if *info, err = c.decryptInfo(*info); err != nil {
+                return err
+            }
+        }
+        return f(e)
+    })
+}
+
+func (c *CryptRepo) Close() error {
+    return c.repo.Close()
+}
+
+//解密路径，文件大小转换为明文大小
+func (c *CryptRepo) decryptInfo(info model.FileInfo) (model.FileInfo, error) {
+    p, err := c.key.decryptPath(info.FilePath)
+    if err != nil {
+        return info, err
+    }
+    if p != info.FilePath {
+        info.FilePath, info.FileName, info.Parent = p, path.Base(p), path.Dir(p)
+    }
+    if info.IsDir {
+        return info, nil
+    }
+    if info.Size = DecryptedSize(info.Size); info.Size < 0 {
+        return info, ErrDecrypt
+    }
+    info.Checksum, info.ChecksumType = "", ""
+    return info, nil
+}
+
+//解密恢复失败的路径，Bytes为加密后的大小
+func (c *CryptRepo) trashResult(ret model.TrashResult, err error) (model.TrashResult, error) {
+    for i, p := range ret.Failed {
+        if dec, derr := c.key.decryptPath(p); derr == nil {
+            ret.Failed[i] = dec
+        }
+    }
+    return ret, err
+}
+
+func (c *CryptRepo) encryptFiles(files []model.FileRequest) ([]model.FileRequest, error) {
+    ret := make([]model.FileRequest, len(files))
+    for i, f := range files {
+        p, err := c.key.encryptPath(f.Path)
+        if err != nil {
+            return nil, err
+        }
+        ret[i] = model.FileRequest{Path: p, Version: f.Version}
+    }
+    return ret, nil
+}
+
+func (c *CryptRepo) snapshot(snap model.Snapshot, err error) (model.Snapshot, error) {
+    if err != nil {
+        return snap, err
+    }
+    if snap.Path, err = c.key.decryptPath(snap.Path); err != nil {
+        return snap, err
+    }
+    for i := range snap.Entries {
+        if snap.Entries[i], err = c.decryptInfo(snap.Entries[i]); err != nil {
+            return snap, err
+        }
+    }
+    return snap, nil
+}
@@ -0,0 +1,193 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package client
+
+import (
+    "bufio"
+    "io"
+    "os"
+    "regexp"
+    "strings"
+)
+
+//目录下的忽略规则文件，语法同.gitignore
+const IGNORE_FILE = ".citronignore"
+
+//单条规则
+type pattern struct {
+    re *regexp.Regexp
+    //以!开头，重新包含已忽略的文件
+    negate bool
+    //以/结尾，只匹配目录
+    dirOnly bool
+}
+
+//一组规则，base为规则所在目录（相对于备份目录），规则只作用于base下的路径
+type Ignore struct {
+    base     string
+    patterns []pattern
+}
+
+//解析规则，忽略空行及#开头的注释
+func NewIgnore(base string, lines []string) *Ignore {
+    ret := &Ignore{base: strings.Trim(base, "/")}
+    if ret.base == "." {
+        ret.base = ""
+    }
+    for _, l := range lines {
+        if p, ok := parsePattern(l); ok {
+            ret.patterns = append(ret.patterns, p)
+        }
+    }
+    return ret
+}
+
+//读取规则文件
+func ReadIgnore(base string, r io.Reader) (*Ignore, error) {
+    var lines []string
+    s := bufio.NewScanner(r)
+    for s.Scan() {
+        lines = append(lines, s.Text())
+    }
+    if err := s.Err(); err != nil {
+        return nil, err
+    }
+    return NewIgnore(base, lines), nil
+}
+
+//读取目录下的规则文件，文件不存在时返回nil
+func LoadIgnore(base, file string) (*Ignore, error) {
+    f, err := os.Open(file)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, nil
+        }
+        return nil, err
+    }
+    defer f.Close()
+    return ReadIgnore(base, f)
+}
+
+func parsePattern(line string) (pattern, bool) {
+    p := pattern{}
+    //行尾未转义的空格忽略
+    line = strings.TrimRight(line, "\r")
+    for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+        line = line[:len(line)-1]
+    }
+    if line == "" || strings.HasPrefix(line, "#") {
+        return p, false
+    }
+    if strings.HasPrefix(line, "!") {
+        p.negate = true
+        line = line[1:]
+    } else if strings.HasPrefix(line, "\\") && len(line) > 1 && (line[1] == '!' || line[1] == '#') {
+        line = line[1:]
+    }
+    if strings.HasSuffix(line, "/") {
+        p.dirOnly = true
+        line = strings.TrimRight(line, "/")
+    }
+    if line == "" {
+        return p, false
+    }
+    //包含/的规则相对于规则所在目录，否则匹配任意层级的文件名
+    anchored := strings.Contains(line, "/")
+    line = strings.TrimPrefix(line, "/")
+    expr := globRegexp(line)
+    if anchored {
+        expr = "^" + expr + "$"
+    } else {
+        expr = "^(.*/)?" + expr + "$"
+    }
+    re, err := regexp.Compile(expr)
+    if err != nil {
+        return p, false
+    }
+    p.re = re
+    return p, true
+}
+
+//将glob转换为正则表达式，支持*、?、[...]及**
+func globRegexp(glob string) string {
+    b := strings.Builder{}
+    for i := 0; i < len(glob); i++ {
+        c := glob[i]
+        switch c {
+        case '*':
+            if i+1 < len(glob) && glob[i+1] == '*' {
+                i++
+                if i+1 < len(glob) && glob[i+1] == '/' {
+                    // **/ 匹配零或多级目录
+                    i++
+                    b.WriteString("(.*/)?")
+                } else {
+                    b.WriteString(".*")
+                }
+            } else {
+                b.WriteString("[^/]*")
+            }
+        case '?':
+            b.WriteString("[^/]")
+        case '[':
+            end := strings.IndexByte(glob[i+1:], ']')
+            if end < 0 {
+                b.WriteString("\\[")
+                continue
+            }
+            class := glob[i+1 : i+1+end]
+            if strings.HasPrefix(class, "!") {
+                class = "^" + class[1:]
+            }
+            b.WriteString("[" + strings.Replace(class, "\\", "\\\\", -1) + "]")
+            i += end + 1
+        case '\\':
+            if i+1 < len(glob) {
+                i++
+                b.WriteString(regexp.QuoteMeta(string(glob[i])))
+            }
+        default:
+            b.WriteString(regexp.QuoteMeta(string(c)))
+        }
+    }
+    return b.String()
+}
+
+//匹配路径（相对于备份目录，使用/分隔），返回是否有规则匹配及是否忽略，最后一条匹配的规则生效
+func (ig *Ignore) Match(rel string, isDir bool) (matched bool, ignored bool) {
+    if ig == nil {
+        return false, false
+    }
+    if ig.base != "" {
+        if !strings.HasPrefix(rel, ig.base+"/") {
+            return false, false
+        }
+        rel = rel[len(ig.base)+1:]
+    }
+    for i := len(ig.patterns) - 1; i >= 0; i-- {
+        p := ig.patterns[i]
+        if p.dirOnly && !isDir {
+            continue
+        }
+        if p.re.MatchString(rel) {
+            return true, !p.negate
+        }
+    }
+    return false, false
+}
+
+//多组规则，后加入的规则优先（子目录的规则优先于父目录，目录规则优先于全局规则）
+type Ignores []*Ignore
+
+func (igs Ignores) Ignored(rel string, isDir bool) bool {
+    for i := len(igs) - 1; i >= 0; i-- {
+        if matched, ignored := igs[i].Match(rel, isDir); matched {
+            return ignored
+        }
+    }
+    return false
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package client
+
+import (
+    "citron-repo/model"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "hash"
+    "io"
+    "strings"
+)
+
+var ErrChecksum = errors.New("checksum mismatch")
+
+//仓库客户端，RestClient及BinaryClient实现相同的接口
+//path为相对路径时相对于用户的备份根目录
+type Repo interface {
+    //上传文件，info.FilePath为仓库路径，info.Checksum不为空时服务端校验内容
+    Upload(info model.FileInfo, size int64, r io.Reader) (model.FileInfo, error)
+    //下载文件内容写入w，并校验内容与服务端记录的checksum是否一致
+    Download(req model.FileRequest, w io.Writer) (model.FileInfo, error)
+    Stat(path string) (model.FileInfo, error)
+    List(path string) ([]model.FileInfo, error)
+    Versions(path string) ([]model.FileInfo, error)
+    //按保留策略删除文件或目录下的历史版本
+    Prune(req model.PruneRequest) (model.PruneResult, error)
+    //删除文件或目录，删除的文件移动到回收站，可以恢复，超过保留时间后自动删除
+    Delete(path string) (model.TrashResult, error)
+    //列出回收站中path下的文件
+    Trash(path string) ([]model.TrashEntry, error)
+    RestoreTrash(path string) (model.TrashResult, error)
+    //立即删除回收站中path下的文件
+    PurgeTrash(path string) (model.TrashResult, error)
+
+    //创建快照，上传文件后通过AddSnapshot添加到快照，最后提交
+    BeginSnapshot(req model.SnapshotRequest) (model.Snapshot, error)
+    AddSnapshot(id string, files []model.FileRequest) (model.Snapshot, error)
+    CommitSnapshot(id string) (model.Snapshot, error)
+    //列出path下的快照（不包含文件清单）
+    Snapshots(path string) ([]model.Snapshot, error)
+    //快照信息及文件清单
+    Snapshot(id string) (model.Snapshot, error)
+    //将快照中的文件恢复为仓库中的最新版本，target为空时恢复到原路径
+    RestoreSnapshot(id, target string) (model.RestoreResult, error)
+    DeleteSnapshot(id string) error
+
+    Close() error
+}
+
+//写入时计算sha256
+type checksumWriter struct {
+    w    io.Writer
+    hash hash.Hash
+}
+
+func newChecksumWriter(w io.Writer) *checksumWriter {
+    return &checksumWriter{w: w, hash: sha256.New()}
+}
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+    n, err := c.w.Write(p)
+    c.hash.Write(p[:n])
+    return n, err
+}
+
+func (c *checksumWriter) Sum() string {
+    return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+//服务端没有记录checksum时不校验
+func (c *checksumWriter) Check(info model.FileInfo) error {
+    if info.Checksum != "" && !strings.EqualFold(info.Checksum, c.Sum()) {
+        return ErrChecksum
+    }
+    return nil
+}
+
+//计算sha256
+func Checksum(r io.Reader) (string, error) {
+    h := sha256.New()
+    if _, err := io.Copy(h, r); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,380 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package client
+
+import (
+    "bytes"
+    "citron-repo/model"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "mime/multipart"
+    "net/http"
+    "net/url"
+    "path"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const (
+    HeaderToken        = "CITRON-TOKEN"
+    HeaderApiKey       = "CITRON-API-KEY"
+    HeaderFileToken    = "CITRON-FILE-TOKEN"
+    HeaderRel          = "CITRON-REL"
+    HeaderFilename     = "CITRON-FILENAME"
+    HeaderModTime      = "CITRON-MODTIME"
+    HeaderMode         = "CITRON-MODE"
+    HeaderChecksum     = "CITRON-CHECKSUM"
+    HeaderVersion      = "CITRON-VERSION"
+    HeaderRefreshToken = "CITRON-REFRESH-TOKEN"
+)
+
+//REST接口返回的错误
+type RestError struct {
+    Status int
+    Code   string
+    Msg    string
+}
+
+func (e *RestError) Error() string {
+    return fmt.Sprintf("http %d, code %s: %s", e.Status, e.Code, e.Msg)
+}
+
+type result struct {
+    Code string          `json:"code"`
+    Msg  string          `json:"message"`
+    Data json.RawMessage `json:"data"`
+}
+
+type RestClient struct {
+    url    string
+    client *http.Client
+    token  string
+    apiKey string
+}
+
+//url如http://127.0.0.1:8080
+func NewRestClient(url string) *RestClient {
+    return &RestClient{
+        url:    strings.TrimRight(url, "/"),
+        client: &http.Client{},
+    }
+}
+
+//使用登录token认证
+func (c *RestClient) SetToken(token string) {
+    c.token = token
+}
+
+//使用api key认证
+func (c *RestClient) SetApiKey(key string) {
+    c.apiKey = key
+}
+
+func (c *RestClient) Close() error {
+    return nil
+}
+
+//登录并使用获得的登录token认证之后的请求
+func (c *RestClient) Login(info model.LoginInfo) (model.LoginResult, error) {
+    ret := model.LoginResult{}
+    err := c.doJson(http.MethodPost, "/login", info, &ret)
+    if err == nil {
+        c.token = ret.Token
+    }
+    return ret, err
+}
+
+//使用刷新token获得新的登录token
+func (c *RestClient) Refresh(refreshToken string) (model.LoginResult, error) {
+    ret := model.LoginResult{}
+    err := c.doJson(http.MethodPost, "/token/refresh", model.RefreshInfo{RefreshToken: refreshToken}, &ret)
+    if err == nil {
+        c.token = ret.Token
+    }
+    return ret, err
+}
+
+func (c *RestClient) Upload(info model.FileInfo, size int64, r io.Reader) (model.FileInfo, error) {
+    ret := model.FileInfo{}
+    req, err := c.newRequest(http.MethodPost, "/meta", nil)
+    if err != nil {
+        return ret, err
+    }
+    req.Header.Set(HeaderRel, path.Dir(info.FilePath))
+    req.Header.Set(HeaderFilename, path.Base(info.FilePath))
+    fileToken := ""
+    if err := c.do(req, &fileToken); err != nil {
+        return ret, err
+    }
+
+    //multipart body边生成边发送
+    pr, pw := io.Pipe()
+    mw := multipart.NewWriter(pw)
+    go func() {
+        part, err := mw.CreateFormFile("file", path.Base(info.FilePath))
+        if err == nil {
+            _, err = io.CopyN(part, r, size)
+        }
+        if err == nil {
+            err = mw.Close()
+        }
+        pw.CloseWithError(err)
+    }()
+
+    req, err = c.newRequest(http.MethodPost, "/file", pr)
+    if err != nil {
+        pr.Close()
+        return ret, err
+    }
+    req.Header.Set("Content-Type", mw.FormDataContentType())
+    req.Header.Set(HeaderFileToken, fileToken)
+    if !info.ModTime.IsZero() {
+        req.Header.Set(HeaderModTime, info.ModTime.Format(time.RFC3339Nano))
+    }
+    if info.Mode != 0 {
+        req.Header.Set(HeaderMode, strconv.FormatUint(uint64(info.Mode), 8))
+    }
+    if info.Checksum != "" {
+        req.Header.Set(HeaderChecksum, info.Checksum)
+    }
+    err = c.do(req, &ret)
+    pr.Close()
+    return ret, err
+}
+
+func (c *RestClient) Download(freq model.FileRequest, w io.Writer) (model.FileInfo, error) {
+    info := model.FileInfo{FilePath: freq.Path}
+    req, err := c.newRequest(http.MethodGet, "/file?"+query(freq), nil)
+    if err != nil {
+        return info, err
+    }
+    resp, err := c.client.Do(req)
+    if err != nil {
+        return info, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return info, readError(resp)
+    }
+
+    info.FileName = resp.Header.Get(HeaderFilename)
+    info.Checksum = resp.Header.Get(HeaderChecksum)
+    info.Version = resp.Header.Get(HeaderVersion)
+    info.Size = resp.ContentLength
+    info.ModTime, _ = time.Parse(time.RFC3339Nano, resp.Header.Get(HeaderModTime))
+    if mode, err := strconv.ParseUint(resp.Header.Get(HeaderMode), 8, 32); err == nil {
+        info.Mode = uint32(mode)
+    }
+    cw := newChecksumWriter(w)
+    if _, err := io.Copy(cw, resp.Body); err != nil {
+        return info, err
+    }
+    return info, cw.Check(info)
+}
+
+func (c *RestClient) Stat(p string) (model.FileInfo, error) {
+    ret := model.FileInfo{}
+    return ret, c.doJson(http.MethodGet, "/stat?"+query(model.FileRequest{Path: p}), nil, &ret)
+}
+
+func (c *RestClient) List(p string) ([]model.FileInfo, error) {
+    var ret []model.FileInfo
+    return ret, c.doJson(http.MethodGet, "/list?"+query(model.FileRequest{Path: p}), nil, &ret)
+}
+
+func (c *RestClient) Versions(p string) ([]model.FileInfo, error) {
+    var ret []model.FileInfo
+    return ret, c.doJson(http.MethodGet, "/versions?"+query(model.FileRequest{Path: p}), nil, &ret)
+}
+
+func (c *RestClient) Prune(req model.PruneRequest) (model.PruneResult, error) {
+    ret := model.PruneResult{}
+    v := url.Values{}
+    v.Set("path", req.Path)
+    if req.Retention.Versions > 0 {
+        v.Set("versions", strconv.Itoa(req.Retention.Versions))
+    }
+    if req.Retention.MaxAge > 0 {
+        v.Set("maxAge", req.Retention.MaxAge.String())
+    }
+    return ret, c.doJson(http.MethodDelete, "/versions?"+v.Encode(), nil, &ret)
+}
+
+func (c *RestClient) Delete(p string) (model.TrashResult, error) {
+    ret := model.TrashResult{}
+    return ret, c.doJson(http.MethodDelete, "/file?"+query(model.FileRequest{Path: p}), nil, &ret)
+}
+
+func (c *RestClient) Trash(p string) ([]model.TrashEntry, error) {
+    var ret []model.TrashEntry
+    return ret, c.doJson(http.MethodGet, "/trash?"+query(model.FileRequest{Path: p}), nil, &ret)
+}
+
+func (c *RestClient) RestoreTrash(p string) (model.TrashResult, error) {
+    ret := model.TrashResult{}
+    return ret, c.doJson(http.MethodPost, "/trash/restore?"+query(model.FileRequest{Path: p}), nil, &ret)
+}
+
+func (c *RestClient) PurgeTrash(p string) (model.TrashResult, error) {
+    ret := model.TrashResult{}
+    return ret, c.doJson(http.MethodDelete, "/trash?"+query(model.FileRequest{Path: p}), nil, &ret)
+}
+
+func (c *RestClient) BeginSnapshot(req model.SnapshotRequest) (model.Snapshot, error) {
+    ret := model.Snapshot{}
+    return ret, c.doJson(http.MethodPost, "/snapshot", req, &ret)
+}
+
+func (c *RestClient) AddSnapshot(id string, files []model.FileRequest) (model.Snapshot, error) {
+    ret := model.Snapshot{}
+    return ret, c.doJson(http.MethodPost, "/snapshot/"+url.PathEscape(id)+"/files", model.SnapshotRequest{Files: files}, &ret)
+}
+
+func (c *RestClient) CommitSnapshot(id string) (model.Snapshot, error) {
+    ret := model.Snapshot{}
+    return ret, c.doJson(http.MethodPost, "/snapshot/"+url.PathEscape(id)+"/commit", nil, &ret)
+}
+
+func (c *RestClient) Snapshots(p string) ([]model.Snapshot, error) {
+    var ret []model.Snapshot
+    return ret, c.doJson(http.MethodGet, "/snapshot?"+query(model.FileRequest{Path: p}), nil, &ret)
+}
+
+func (c *RestClient) Snapshot(id string) (model.Snapshot, error) {
+    ret := model.Snapshot{}
+    return ret, c.doJson(http.MethodGet, "/snapshot/"+url.PathEscape(id), nil, &ret)
+}
+
+func (c *RestClient) RestoreSnapshot(id, target string) (model.RestoreResult, error) {
+    ret := model.RestoreResult{}
+    return ret, c.doJson(http.MethodPost, "/snapshot/"+url.PathEscape(id)+"/restore", model.SnapshotRequest{Target: target}, &ret)
+}
+
+func (c *RestClient) DeleteSnapshot(id string) error {
+    return c.doJson(http.MethodDelete, "/snapshot/"+url.PathEscape(id), nil, nil)
+}
+
+//所有副本的复制状态，需要配置管理权限
+func (c *RestClient) Replicas() ([]model.ReplicaStatus, error) {
+    var ret []model.ReplicaStatus
+    return ret, c.doJson(http.MethodGet, "/replication", nil, &ret)
+}
+
+//比较仓库与副本name中的文件
+func (c *RestClient) VerifyReplica(name string) (model.ReplicaVerify, error) {
+    ret := model.ReplicaVerify{}
+    return ret, c.doJson(http.MethodPost, "/replication/"+url.PathEscape(name)+"/verify", nil, &ret)
+}
+
+//比较两个快照，to为空时与快照根目录当前的文件比较，逐项回调差异
+func (c *RestClient) DiffSnapshot(from, to string, f func(e model.DiffEntry) error) error {
+    v := url.Values{}
+    if to != "" {
+        v.Set("to", to)
+    }
+    req, err := c.newRequest(http.MethodGet, "/snapshot/"+url.PathEscape(from)+"/diff?"+v.Encode(), nil)
+    if err != nil {
+        return err
+    }
+    resp, err := c.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return readError(resp)
+    }
+
+    dec := json.NewDecoder(resp.Body)
+    for {
+        e := model.DiffEntry{}
+        if err := dec.Decode(&e); err != nil {
+            if err == io.EOF {
+                return nil
+            }
+            return err
+        }
+        if e.Type == model.DiffError {
+            return errors.New(e.Error)
+        }
+        if err := f(e); err != nil {
+            return err
+        }
+    }
+}
+
+func query(req model.FileRequest) string {
+    v := url.Values{}
+    v.Set("path", req.Path)
+    if req.Version != "" {
+        v.Set("version", req.Version)
+    }
+    return v.Encode()
+}
+
+func (c *RestClient) newRequest(method, uri string, body io.Reader) (*http.Request, error) {
+    req, err := http.NewRequest(method, c.url+uri, body)
+    if err != nil {
+        return nil, err
+    }
+    if c.token != "" {
+        req.Header.Set(HeaderToken, c.token)
+    }
+    if c.apiKey != "" {
+        req.Header.Set(HeaderApiKey, c.apiKey)
+    }
+    return req, nil
+}
+
+func (c *RestClient) doJson(method, uri string, body interface{}, v interface{}) error {
+    var r io.Reader
+    if body != nil {
+        data, err := json.Marshal(body)
+        if err != nil {
+            return err
+        }
+        r = bytes.NewReader(data)
+    }
+    req, err := c.newRequest(method, uri, r)
+    if err != nil {
+        return err
+    }
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    return c.do(req, v)
+}
+
+//发送请求并将响应的data解析到v
+func (c *RestClient) do(req *http.Request, v interface{}) error {
+    resp, err := c.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return readError(resp)
+    }
+
+    ret := result{}
+    if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+        return err
+    }
+    if v == nil || len(ret.Data) == 0 {
+        return nil
+    }
+    return json.Unmarshal(ret.Data, v)
+}
+
+func readError(resp *http.Response) error {
+    ret := result{}
+    json.NewDecoder(io.LimitReader(resp.Body, MaxErrorSize)).Decode(&ret)
+    return &RestError{Status: resp.StatusCode, Code: ret.Code, Msg: ret.Msg}
+}
@@ -0,0 +1,506 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package client
+
+import (
+    "citron-repo/model"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+)
+
+//本地已存在同名文件时的处理方式
+const (
+    //保留本地文件
+    OVERWRITE_SKIP = "skip"
+    //替换本地文件
+    OVERWRITE_ALWAYS = "overwrite"
+    //保留本地文件，恢复的文件重命名为name.restored[-n].ext
+    OVERWRITE_RENAME = "rename"
+)
+
+//恢复结果
+const (
+    RestoreRestored = "restored"
+    RestoreRenamed  = "renamed"
+    RestoreSkipped  = "skipped"
+    RestoreFailed   = "failed"
+)
+
+//默认并行下载数
+const DEFAULT_PARALLEL = 4
+
+var (
+    ErrOverwritePolicy = errors.New("unknown overwrite policy")
+    ErrVersionNotFound = errors.New("version not found")
+    ErrNoChecksum      = errors.New("no checksum on server")
+)
+
+//单个文件或目录的恢复结果，Info为仓库中的信息，Local为本地路径
+type RestoreEntry struct {
+    Info   model.FileInfo
+    Local  string
+    Action string
+    Err    error
+}
+
+type RestoreSummary struct {
+    Restored int
+    Renamed  int
+    Skipped  int
+    Failed   int
+    //恢复的目录数
+    Dirs int
+    //下载的字节数
+    Bytes   int64
+    Entries []RestoreEntry
+}
+
+func (s *RestoreSummary) add(e RestoreEntry) {
+    switch e.Action {
+    case RestoreRestored:
+        if e.Info.IsDir {
+            s.Dirs++
+        } else {
+            s.Restored++
+            s.Bytes += e.Info.Size
+        }
+    case RestoreRenamed:
+        s.Renamed++
+        s.Bytes += e.Info.Size
+    case RestoreSkipped:
+        s.Skipped++
+    case RestoreFailed:
+        s.Failed++
+    }
+    s.Entries = append(s.Entries, e)
+}
+
+type Restore struct {
+    repo Repo
+    //并行下载时为每个下载协程创建连接，为空时共用repo
+    connect   func() (Repo, error)
+    overwrite string
+    include   *Ignore
+    parallel  int
+    verify    bool
+    progress  func(info model.FileInfo) io.Writer
+    callback  func(e RestoreEntry)
+}
+
+type RestoreOpt func(r *Restore)
+
+//本地已存在同名文件时的处理方式，默认为OVERWRITE_ALWAYS
+//本地文件大小及修改时间与仓库记录相同时视为已恢复，总是跳过
+func SetOverwrite(policy string) RestoreOpt {
+    return func(r *Restore) {
+        r.overwrite = policy
+    }
+}
+
+//只恢复匹配的文件，语法同.citronignore（如docs/、/src/a.txt、*.go、!*.tmp），匹配目录时恢复目录下的所有文件
+func SetInclude(patterns []string) RestoreOpt {
+    return func(r *Restore) {
+        if len(patterns) > 0 {
+            r.include = NewIgnore("", patterns)
+        }
+    }
+}
+
+//并行下载数，connect为空时共用同一个连接，BinaryClient不支持并发，此时只使用一个下载协程
+func SetParallel(n int, connect func() (Repo, error)) RestoreOpt {
+    return func(r *Restore) {
+        r.parallel = n
+        r.connect = connect
+    }
+}
+
+//下载完成后重新读取本地文件并与仓库记录的checksum比较
+func SetVerify(verify bool) RestoreOpt {
+    return func(r *Restore) {
+        r.verify = verify
+    }
+}
+
+//下载文件时将已下载的内容写入progress返回的writer，返回nil时不记录进度，并行下载时会被并发调用
+func SetRestoreProgress(progress func(info model.FileInfo) io.Writer) RestoreOpt {
+    return func(r *Restore) {
+        r.progress = progress
+    }
+}
+
+//每个文件或目录处理完成后回调
+func SetRestoreCallback(callback func(e RestoreEntry)) RestoreOpt {
+    return func(r *Restore) {
+        r.callback = callback
+    }
+}
+
+func NewRestore(repo Repo, opts ...RestoreOpt) *Restore {
+    ret := &Restore{repo: repo, overwrite: OVERWRITE_ALWAYS, parallel: DEFAULT_PARALLEL}
+    for i := range opts {
+        opts[i](ret)
+    }
+    return ret
+}
+
+//待恢复的文件或目录，rel为用于匹配的相对路径
+type restoreItem struct {
+    rel   string
+    local string
+    info  model.FileInfo
+}
+
+//将仓库目录（包括空目录）恢复到本地目录target，src为文件时恢复到target（target为已存在的目录时恢复到该目录下）
+func (r *Restore) Dir(src, target string) (RestoreSummary, error) {
+    root, err := r.repo.Stat(src)
+    if err != nil {
+        return RestoreSummary{}, err
+    }
+    if !root.IsDir {
+        return r.file(root, target)
+    }
+    remotes, err := ListAll(r.repo, src)
+    if err != nil {
+        return RestoreSummary{}, err
+    }
+    items := make([]restoreItem, 0, len(remotes))
+    for rel, info := range remotes {
+        items = append(items, restoreItem{rel: rel, local: filepath.Join(target, filepath.FromSlash(rel)), info: info})
+    }
+    return r.restore(items, target)
+}
+
+//恢复文件的指定版本，req.Version为空时恢复最新版本
+func (r *Restore) File(req model.FileRequest, target string) (RestoreSummary, error) {
+    info, err := r.repo.Stat(req.Path)
+    if err != nil {
+        return RestoreSummary{}, err
+    }
+    if info.IsDir {
+        return RestoreSummary{}, fmt.Errorf("%s is a directory", req.Path)
+    }
+    if req.Version != "" && req.Version != info.Version {
+        versions, err := r.repo.Versions(req.Path)
+        if err != nil {
+            return RestoreSummary{}, err
+        }
+        found := false
+        for _, v := range versions {
+            if v.Version == req.Version {
+                info, found = v, true
+                break
+            }
+        }
+        if !found {
+            return RestoreSummary{}, ErrVersionNotFound
+        }
+    }
+    return r.file(info, target)
+}
+
+func (r *Restore) file(info model.FileInfo, target string) (RestoreSummary, error) {
+    local := target
+    if fi, err := os.Stat(target); err == nil && fi.IsDir() {
+        local = filepath.Join(target, info.FileName)
+    }
+    return r.restore([]restoreItem{{rel: info.FileName, local: local, info: info}}, "")
+}
+
+//将快照中的文件恢复到本地目录target，快照只记录文件，不恢复空目录
+func (r *Restore) Snapshot(id, target string) (RestoreSummary, error) {
+    snap, err := r.repo.Snapshot(id)
+    if err != nil {
+        return RestoreSummary{}, err
+    }
+    root := strings.TrimSuffix(snap.Path, "/")
+    items := make([]restoreItem, 0, len(snap.Entries))
+    for _, info := range snap.Entries {
+        if !strings.HasPrefix(info.FilePath, root+"/") {
+            continue
+        }
+        rel := info.FilePath[len(root)+1:]
+        items = append(items, restoreItem{rel: rel, local: filepath.Join(target, filepath.FromSlash(rel)), info: info})
+    }
+    return r.restore(items, target)
+}
+
+//先并行下载文件，再创建目录并设置目录的权限及修改时间（写入文件会改变目录的修改时间）
+func (r *Restore) restore(items []restoreItem, target string) (RestoreSummary, error) {
+    summary := RestoreSummary{}
+    switch r.overwrite {
+    case OVERWRITE_SKIP, OVERWRITE_ALWAYS, OVERWRITE_RENAME:
+    default:
+        return summary, ErrOverwritePolicy
+    }
+    if target != "" {
+        if err := os.MkdirAll(target, 0755); err != nil {
+            return summary, err
+        }
+    }
+
+    var files, dirs []restoreItem
+    //包含待恢复文件的目录
+    parents := map[string]bool{}
+    for _, it := range items {
+        if it.info.IsDir {
+            dirs = append(dirs, it)
+            continue
+        }
+        if !r.included(it.rel, false) {
+            continue
+        }
+        files = append(files, it)
+        for p := path.Dir(it.rel); p != "."; p = path.Dir(p) {
+            parents[p] = true
+        }
+    }
+    sort.Slice(files, func(i, j int) bool {
+        return files[i].rel < files[j].rel
+    })
+
+    repos, closeRepos, err := r.repos()
+    if err != nil {
+        return summary, err
+    }
+    r.download(repos, files, func(e RestoreEntry) {
+        r.finish(&summary, e)
+    })
+    closeRepos()
+
+    //子目录先于父目录处理
+    sort.Slice(dirs, func(i, j int) bool {
+        return dirs[i].rel > dirs[j].rel
+    })
+    for _, it := range dirs {
+        if parents[it.rel] || r.included(it.rel, true) {
+            r.finish(&summary, restoreDir(it))
+        }
+    }
+    return summary, nil
+}
+
+//匹配路径及其上级目录，最近的匹配生效
+func (r *Restore) included(rel string, isDir bool) bool {
+    if r.include == nil {
+        return true
+    }
+    for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+        if matched, ok := r.include.Match(p, p != rel || isDir); matched {
+            return ok
+        }
+    }
+    return false
+}
+
+//每个下载协程使用的连接，第一个为r.repo，返回的函数关闭新建的连接
+func (r *Restore) repos() ([]Repo, func(), error) {
+    ret := []Repo{r.repo}
+    closeAll := func() {
+        for _, repo := range ret[1:] {
+            repo.Close()
+        }
+    }
+    for i := 1; i < r.parallel; i++ {
+        if r.connect != nil {
+            repo, err := r.connect()
+            if err != nil {
+                closeAll()
+                return nil, nil, err
+            }
+            ret = append(ret, repo)
+            continue
+        }
+        if !concurrent(r.repo) {
+            break
+        }
+        ret = append(ret, r.repo)
+    }
+    if r.connect == nil {
+        return ret, func() {}, nil
+    }
+    return ret, closeAll, nil
+}
+
+//RestClient可以并发使用
+func concurrent(repo Repo) bool {
+    switch c := repo.(type) {
+    case *RestClient:
+        return true
+    case *CryptRepo:
+        return concurrent(c.repo)
+    }
+    return false
+}
+
+func (r *Restore) download(repos []Repo, files []restoreItem, f func(e RestoreEntry)) {
+    ch := make(chan restoreItem)
+    results := make(chan RestoreEntry)
+    wait := sync.WaitGroup{}
+    for _, repo := range repos {
+        wait.Add(1)
+        go func(repo Repo) {
+            defer wait.Done()
+            for it := range ch {
+                results <- r.restoreFile(repo, it)
+            }
+        }(repo)
+    }
+    go func() {
+        for _, it := range files {
+            ch <- it
+        }
+        close(ch)
+        wait.Wait()
+        close(results)
+    }()
+    for e := range results {
+        f(e)
+    }
+}
+
+func (r *Restore) finish(summary *RestoreSummary, e RestoreEntry) {
+    summary.add(e)
+    if r.callback != nil {
+        r.callback(e)
+    }
+}
+
+func (r *Restore) restoreFile(repo Repo, it restoreItem) RestoreEntry {
+    e := RestoreEntry{Info: it.info, Local: it.local, Action: RestoreRestored}
+    fi, err := os.Lstat(it.local)
+    if err == nil {
+        if fi.IsDir() {
+            e.Action, e.Err = RestoreFailed, os.ErrExist
+            return e
+        }
+        if fi.Size() == it.info.Size && fi.ModTime().Equal(it.info.ModTime) {
+            e.Action = RestoreSkipped
+            return e
+        }
+        switch r.overwrite {
+        case OVERWRITE_SKIP:
+            e.Action = RestoreSkipped
+            return e
+        case OVERWRITE_RENAME:
+            e.Action, e.Local = RestoreRenamed, renamed(it.local)
+        }
+    } else if !os.IsNotExist(err) {
+        e.Action, e.Err = RestoreFailed, err
+        return e
+    }
+
+    info, err := r.downloadFile(repo, it.info, e.Local)
+    if err != nil {
+        e.Action, e.Err = RestoreFailed, err
+        return e
+    }
+    e.Info = info
+    return e
+}
+
+//下载到临时文件，设置权限及修改时间并校验后替换目标文件
+func (r *Restore) downloadFile(repo Repo, info model.FileInfo, local string) (model.FileInfo, error) {
+    if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+        return info, err
+    }
+    f, err := ioutil.TempFile(filepath.Dir(local), ".citron-restore")
+    if err != nil {
+        return info, err
+    }
+    var w io.Writer = f
+    if r.progress != nil {
+        if pw := r.progress(info); pw != nil {
+            w = io.MultiWriter(f, pw)
+        }
+    }
+    ret, err := repo.Download(model.FileRequest{Path: info.FilePath, Version: info.Version}, w)
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+    if err == nil {
+        err = setAttr(f.Name(), ret)
+    }
+    if err == nil && r.verify {
+        err = verifyFile(f.Name(), ret)
+    }
+    if err == nil {
+        err = os.Rename(f.Name(), local)
+    }
+    if err != nil {
+        os.Remove(f.Name())
+    }
+    return ret, err
+}
+
+func restoreDir(it restoreItem) RestoreEntry {
+    e := RestoreEntry{Info: it.info, Local: it.local, Action: RestoreRestored}
+    if err := os.MkdirAll(it.local, 0755); err != nil {
+        e.Action, e.Err = RestoreFailed, err
+        return e
+    }
+    if err := setAttr(it.local, it.info); err != nil {
+        e.Action, e.Err = RestoreFailed, err
+    }
+    return e
+}
+
+//仓库没有记录权限时文件使用0644，目录保持不变
+func setAttr(local string, info model.FileInfo) error {
+    mode := os.FileMode(info.Mode).Perm()
+    if mode == 0 && !info.IsDir {
+        mode = 0644
+    }
+    if mode != 0 {
+        if err := os.Chmod(local, mode); err != nil {
+            return err
+        }
+    }
+    if info.ModTime.IsZero() {
+        return nil
+    }
+    return os.Chtimes(local, info.ModTime, info.ModTime)
+}
+
+func verifyFile(local string, info model.FileInfo) error {
+    if info.Checksum == "" {
+        return ErrNoChecksum
+    }
+    f, err := os.Open(local)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    sum, err := Checksum(f)
+    if err != nil {
+        return err
+    }
+    if !strings.EqualFold(sum, info.Checksum) {
+        return ErrChecksum
+    }
+    return nil
+}
+
+//a.txt重命名为a.restored.txt，已存在时为a.restored-1.txt，依此类推
+func renamed(local string) string {
+    ext := filepath.Ext(local)
+    base := strings.TrimSuffix(local, ext)
+    p := base + ".restored" + ext
+    for i := 1; ; i++ {
+        if _, err := os.Lstat(p); os.IsNotExist(err) {
+            return p
+        }
+        p = fmt.Sprintf("%s.restored-%d%s", base, i, ext)
+    }
+}
@@ -0,0 +1,127 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package client
+
+import (
+    "citron-repo/model"
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+//已备份文件的本地状态
+type FileState struct {
+    Size     int64     `json:"size"`
+    ModTime  time.Time `json:"modTime"`
+    Mode     uint32    `json:"mode"`
+    Checksum string    `json:"checksum,omitempty"`
+}
+
+func newFileState(info model.FileInfo) FileState {
+    return FileState{
+        Size:     info.Size,
+        ModTime:  info.ModTime,
+        Mode:     info.Mode,
+        Checksum: info.Checksum,
+    }
+}
+
+//本地文件与记录的状态是否一致
+func (s FileState) Same(info model.FileInfo) bool {
+    return s.Size == info.Size && s.ModTime.Equal(info.ModTime) && s.Mode == info.Mode
+}
+
+//本地状态库，key为仓库路径，用于agent停止期间的变化检测
+type State struct {
+    path  string
+    Files map[string]FileState `json:"files"`
+    dirty bool
+}
+
+//读取状态库，文件不存在时返回空状态
+func LoadState(path string) (*State, error) {
+    s := &State{path: path, Files: map[string]FileState{}}
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return s, nil
+        }
+        return nil, err
+    }
+    if err := json.Unmarshal(data, s); err != nil {
+        return nil, err
+    }
+    if s.Files == nil {
+        s.Files = map[string]FileState{}
+    }
+    return s, nil
+}
+
+func (s *State) Get(p string) (FileState, bool) {
+    fs, ok := s.Files[p]
+    return fs, ok
+}
+
+func (s *State) Set(p string, fs FileState) {
+    s.Files[p] = fs
+    s.dirty = true
+}
+
+func (s *State) Delete(p string) {
+    if _, ok := s.Files[p]; ok {
+        delete(s.Files, p)
+        s.dirty = true
+    }
+}
+
+//是否有dir下的记录
+func (s *State) Has(dir string) bool {
+    prefix := strings.TrimSuffix(dir, "/") + "/"
+    for p := range s.Files {
+        if strings.HasPrefix(p, prefix) {
+            return true
+        }
+    }
+    return false
+}
+
+//删除dir下不在keep中的记录
+func (s *State) Prune(dir string, keep map[string]bool) {
+    prefix := strings.TrimSuffix(dir, "/") + "/"
+    for p := range s.Files {
+        if strings.HasPrefix(p, prefix) && !keep[p] {
+            s.Delete(p)
+        }
+    }
+}
+
+//有修改时保存，先写临时文件再替换，避免中断时损坏
+func (s *State) Save() error {
+    if !s.dirty || s.path == "" {
+        return nil
+    }
+    data, err := json.Marshal(s)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+        return err
+    }
+    tmp := s.path + ".tmp"
+    if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+        os.Remove(tmp)
+        return err
+    }
+    if err := os.Rename(tmp, s.path); err != nil {
+        return err
+    }
+    s.dirty = false
+    return nil
+}
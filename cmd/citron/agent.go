@@ -0,0 +1,125 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package main
+
+import (
+    "citron-repo/client"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "os/signal"
+    "path/filepath"
+    "strings"
+    "syscall"
+    "time"
+)
+
+func initAgent(fs *flag.FlagSet, o *options) runner {
+    debounce := fs.Duration("debounce", client.DEFAULT_DEBOUNCE, "wait for no more changes before upload")
+    state := fs.String("state", "", "state database path, default ~/"+STATE_FILE)
+    exclude := stringList{}
+    fs.Var(&exclude, "exclude", "exclude pattern (.citronignore syntax), can be repeated")
+    return func(args []string) error {
+        conf, err := loadConfig()
+        if err != nil {
+            return err
+        }
+        dirs := conf.Watch
+        if len(args) > 0 {
+            dirs = nil
+            for _, arg := range args {
+                dirs = append(dirs, parseWatchDir(arg))
+            }
+        }
+        if len(dirs) == 0 {
+            return errUsage
+        }
+        for i := range dirs {
+            if dirs[i], err = resolveWatchDir(dirs[i]); err != nil {
+                return err
+            }
+        }
+        if *state == "" {
+            *state = conf.State
+        }
+        if *state == "" {
+            *state = homePath("CITRON_STATE", STATE_FILE)
+        }
+        return agent(o, dirs, *state, *debounce, append(conf.Exclude, exclude...))
+    }
+}
+
+//dir[:dest]
+func parseWatchDir(arg string) client.WatchDir {
+    if i := strings.LastIndex(arg, ":"); i > 1 {
+        return client.WatchDir{Dir: arg[:i], Dest: arg[i+1:]}
+    }
+    return client.WatchDir{Dir: arg}
+}
+
+//使用绝对路径，未指定仓库路径时使用目录名
+func resolveWatchDir(w client.WatchDir) (client.WatchDir, error) {
+    dir, err := filepath.Abs(w.Dir)
+    if err != nil {
+        return w, err
+    }
+    fi, err := os.Stat(dir)
+    if err != nil {
+        return w, err
+    }
+    if !fi.IsDir() {
+        return w, fmt.Errorf("%s is not a directory", dir)
+    }
+    w.Dir = dir
+    if w.Dest == "" {
+        w.Dest = filepath.Base(dir)
+    }
+    return w, nil
+}
+
+func agent(o *options, dirs []client.WatchDir, statePath string, debounce time.Duration, exclude []string) error {
+    if debounce <= 0 {
+        return errors.New("debounce must be positive")
+    }
+    state, err := client.LoadState(statePath)
+    if err != nil {
+        return err
+    }
+    if o.apiKey == "" {
+        fmt.Fprintln(os.Stderr, "warning: login token expires, use an api key (-k) for a long running agent")
+    }
+    repo, err := o.connect()
+    if err != nil {
+        return err
+    }
+    defer repo.Close()
+
+    b := client.NewBackup(repo,
+        client.SetExclude(exclude),
+        client.SetCallback(func(e client.BackupEntry) {
+            if e.Err != nil {
+                fmt.Fprintf(os.Stderr, "%s: %v\n", e.Info.FilePath, e.Err)
+            } else if !o.quiet {
+                fmt.Fprintf(os.Stderr, "%s %s %s\n", e.Action, e.Info.FilePath, formatSize(e.Info.Size))
+            }
+        }))
+
+    stop := make(chan struct{})
+    ch := make(chan os.Signal, 1)
+    signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+    defer signal.Stop(ch)
+    go func() {
+        <-ch
+        close(stop)
+    }()
+
+    for _, d := range dirs {
+        fmt.Fprintf(os.Stderr, "watching %s -> %s\n", d.Dir, d.Dest)
+    }
+    return client.NewAgent(b, state, dirs, client.SetDebounce(debounce)).Run(stop)
+}
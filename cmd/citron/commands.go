@@ -0,0 +1,422 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package main
+
+import (
+    "bufio"
+    "citron-repo/client"
+    "citron-repo/model"
+    "flag"
+    "fmt"
+    "golang.org/x/crypto/ssh/terminal"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+//批量操作结果
+type summary struct {
+    files  int
+    bytes  int64
+    failed int
+}
+
+func (s *summary) add(size int64, err error) {
+    if err != nil {
+        s.failed++
+        return
+    }
+    s.files++
+    s.bytes += size
+}
+
+func (s *summary) result(action string) error {
+    fmt.Printf("%s %d files (%s), %d failed\n", action, s.files, formatSize(s.bytes), s.failed)
+    if s.failed > 0 {
+        return fmt.Errorf("%d files failed", s.failed)
+    }
+    return nil
+}
+
+func initLogin(fs *flag.FlagSet, o *options) runner {
+    username := fs.String("u", os.Getenv("USER"), "username")
+    password := fs.String("p", "", "password, read from terminal if empty")
+    return func(args []string) error {
+        if len(args) > 0 {
+            return errUsage
+        }
+        return login(o, *username, *password)
+    }
+}
+
+func login(o *options, username, password string) error {
+    if password == "" {
+        p, err := readPassword("password: ")
+        if err != nil {
+            return err
+        }
+        password = p
+    }
+
+    o.resolve(credentials{})
+    rc := client.NewRestClient(o.server)
+    ret, err := rc.Login(model.LoginInfo{Username: username, Password: password})
+    if err != nil {
+        return err
+    }
+    c := credentials{
+        Server:       o.server,
+        Binary:       o.binary,
+        Username:     username,
+        Token:        ret.Token,
+        ExpireAt:     ret.ExpireAt,
+        RefreshToken: ret.RefreshToken,
+    }
+    if err := saveCredentials(c); err != nil {
+        return err
+    }
+    fmt.Printf("login %s as %s\n", o.server, username)
+    return nil
+}
+
+func readPassword(prompt string) (string, error) {
+    fmt.Fprint(os.Stderr, prompt)
+    if terminal.IsTerminal(int(os.Stdin.Fd())) {
+        p, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+        fmt.Fprintln(os.Stderr)
+        return string(p), err
+    }
+    line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+    if err != nil && line == "" {
+        return "", err
+    }
+    return strings.TrimRight(line, "\r\n"), nil
+}
+
+func initBackup(fs *flag.FlagSet, o *options) runner {
+    checksum := fs.Bool("checksum", false, "compare checksum instead of size and modification time")
+    dryRun := fs.Bool("n", false, "dry run, list files to upload without uploading")
+    snapshot := fs.Bool("snapshot", false, "create a snapshot of the backup")
+    exclude, tags := stringList{}, stringList{}
+    fs.Var(&exclude, "exclude", "exclude pattern (.citronignore syntax), can be repeated")
+    fs.Var(&tags, "tag", "snapshot tag, can be repeated")
+    return func(args []string) error {
+        if len(args) < 1 || len(args) > 2 {
+            return errUsage
+        }
+        conf, err := loadConfig()
+        if err != nil {
+            return err
+        }
+        opts := []client.BackupOpt{
+            client.SetCompareChecksum(*checksum),
+            client.SetDryRun(*dryRun),
+            client.SetExclude(append(conf.Exclude, exclude...)),
+        }
+        if *snapshot {
+            host, _ := os.Hostname()
+            opts = append(opts, client.SetSnapshot(host, tags...))
+        }
+        if *dryRun {
+            return backup(o, args, append(opts, client.SetCallback(printDryRun))...)
+        }
+        return backup(o, args, opts...)
+    }
+}
+
+func printDryRun(e client.BackupEntry) {
+    switch e.Action {
+    case client.BackupAdded, client.BackupChanged:
+        fmt.Printf("%-8s %s %s\n", e.Action, e.Info.FilePath, formatSize(e.Info.Size))
+    case client.BackupFailed:
+        fmt.Fprintf(os.Stderr, "%s: %v\n", e.Info.FilePath, e.Err)
+    }
+}
+
+func backup(o *options, args []string, opts ...client.BackupOpt) error {
+    dir, err := filepath.Abs(args[0])
+    if err != nil {
+        return err
+    }
+    dest := filepath.Base(dir)
+    if len(args) == 2 {
+        dest = args[1]
+    }
+
+    repo, err := o.connect()
+    if err != nil {
+        return err
+    }
+    defer repo.Close()
+
+    var current *progress
+    b := client.NewBackup(repo, append([]client.BackupOpt{
+        client.SetProgress(func(info model.FileInfo) io.Writer {
+            current = newProgress(info.FilePath, info.Size, o.quiet)
+            return current
+        }),
+        client.SetCallback(func(e client.BackupEntry) {
+            if current != nil {
+                current.finish(e.Err)
+                current = nil
+            } else if e.Err != nil {
+                fmt.Fprintf(os.Stderr, "%s: %v\n", e.Info.FilePath, e.Err)
+            }
+        }),
+    }, opts...)...)
+    s, err := b.Dir(dir, dest)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("added %d, changed %d, skipped %d files (%s), %d failed\n",
+        s.Added, s.Changed, s.Skipped, formatSize(s.Bytes), s.Failed)
+    if s.Snapshot.ID != "" {
+        fmt.Printf("snapshot %s\n", s.Snapshot.ID)
+    }
+    if s.Failed > 0 {
+        return fmt.Errorf("%d files failed", s.Failed)
+    }
+    return nil
+}
+
+//列出目录下的所有文件（递归）
+func walkRemote(repo client.Repo, info model.FileInfo, f func(info model.FileInfo) error) error {
+    if !info.IsDir {
+        return f(info)
+    }
+    children, err := repo.List(info.FilePath)
+    if err != nil {
+        return err
+    }
+    for _, c := range children {
+        if err := walkRemote(repo, c, f); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+//文件相对于root的本地路径
+func localPath(root model.FileInfo, info model.FileInfo, dest string) string {
+    if !root.IsDir {
+        return dest
+    }
+    rel := strings.TrimPrefix(info.FilePath, root.FilePath)
+    return filepath.Join(dest, filepath.FromSlash(rel))
+}
+
+func initRestore(fs *flag.FlagSet, o *options) runner {
+    version := fs.String("version", "", "version of the file, default latest")
+    snapshot := fs.String("snapshot", "", "restore files of the snapshot, only dest is needed")
+    overwrite := fs.String("overwrite", client.OVERWRITE_ALWAYS, "existing local files: skip, overwrite or rename")
+    parallel := fs.Int("parallel", client.DEFAULT_PARALLEL, "parallel downloads")
+    verify := fs.Bool("verify", false, "verify checksum of restored files")
+    include := stringList{}
+    fs.Var(&include, "include", "restore matched files only (.citronignore syntax), can be repeated")
+    return func(args []string) error {
+        if *snapshot != "" && len(args) != 1 || *snapshot == "" && len(args) != 2 {
+            return errUsage
+        }
+        repo, err := o.connect()
+        if err != nil {
+            return err
+        }
+        defer repo.Close()
+
+        r := newRestore(o, repo, *parallel,
+            client.SetOverwrite(*overwrite),
+            client.SetInclude(include),
+            client.SetVerify(*verify))
+        var s client.RestoreSummary
+        switch {
+        case *snapshot != "":
+            s, err = r.Snapshot(*snapshot, args[0])
+        case *version != "":
+            s, err = r.File(model.FileRequest{Path: args[0], Version: *version}, args[1])
+        default:
+            s, err = r.Dir(args[0], args[1])
+        }
+        if err != nil {
+            return err
+        }
+        fmt.Printf("restored %d, renamed %d, skipped %d files (%s), %d failed\n",
+            s.Restored, s.Renamed, s.Skipped, formatSize(s.Bytes), s.Failed)
+        if s.Failed > 0 {
+            return fmt.Errorf("%d files failed", s.Failed)
+        }
+        return nil
+    }
+}
+
+//单个下载时显示进度，并行下载时每个文件完成后输出一行
+func newRestore(o *options, repo client.Repo, parallel int, opts ...client.RestoreOpt) *client.Restore {
+    connect := func() (client.Repo, error) {
+        return o.connect()
+    }
+    if parallel > 1 {
+        return client.NewRestore(repo, append([]client.RestoreOpt{
+            client.SetParallel(parallel, connect),
+            client.SetRestoreCallback(func(e client.RestoreEntry) {
+                if e.Err != nil {
+                    fmt.Fprintf(os.Stderr, "%s: %v\n", e.Info.FilePath, e.Err)
+                } else if !o.quiet && !e.Info.IsDir {
+                    fmt.Fprintf(os.Stderr, "%-8s %s\n", e.Action, e.Local)
+                }
+            }),
+        }, opts...)...)
+    }
+
+    var current *progress
+    return client.NewRestore(repo, append([]client.RestoreOpt{
+        client.SetParallel(1, nil),
+        client.SetRestoreProgress(func(info model.FileInfo) io.Writer {
+            current = newProgress(info.FilePath, info.Size, o.quiet)
+            return current
+        }),
+        client.SetRestoreCallback(func(e client.RestoreEntry) {
+            if current != nil {
+                current.finish(e.Err)
+                current = nil
+            } else if e.Err != nil {
+                fmt.Fprintf(os.Stderr, "%s: %v\n", e.Info.FilePath, e.Err)
+            }
+        }),
+    }, opts...)...)
+}
+
+func runList(o *options, args []string) error {
+    if len(args) > 1 {
+        return errUsage
+    }
+    p := ""
+    if len(args) == 1 {
+        p = args[0]
+    }
+
+    repo, err := o.connect()
+    if err != nil {
+        return err
+    }
+    defer repo.Close()
+
+    info, err := repo.Stat(p)
+    if err != nil {
+        return err
+    }
+    infos := []model.FileInfo{info}
+    if info.IsDir {
+        infos, err = repo.List(p)
+        if err != nil {
+            return err
+        }
+    }
+    for _, i := range infos {
+        printInfo(i)
+    }
+    return nil
+}
+
+func printInfo(info model.FileInfo) {
+    if info.IsDir {
+        fmt.Printf("d %10s  %s  %s/\n", "-", info.ModTime.Format("2006-01-02 15:04:05"), info.FileName)
+        return
+    }
+    fmt.Printf("- %10s  %s  %s\n", formatSize(info.Size), info.ModTime.Format("2006-01-02 15:04:05"), info.FileName)
+}
+
+func runVersions(o *options, args []string) error {
+    if len(args) != 1 {
+        return errUsage
+    }
+    repo, err := o.connect()
+    if err != nil {
+        return err
+    }
+    defer repo.Close()
+
+    infos, err := repo.Versions(args[0])
+    if err != nil {
+        return err
+    }
+    for _, i := range infos {
+        sum := i.Checksum
+        if len(sum) > 12 {
+            sum = sum[:12]
+        }
+        if i.State == model.FileDeleted {
+            sum = "deleted"
+        }
+        fmt.Printf("%s  %10s  %s  %s\n", i.Version, formatSize(i.Size), i.CreateTime.Format("2006-01-02 15:04:05"), sum)
+    }
+    return nil
+}
+
+//不指定本地路径时下载文件并校验服务端记录的checksum，否则比较本地文件与服务端的checksum
+//加密仓库的服务端只有密文的checksum，比较本地文件时下载并解密以计算明文的checksum
+func runVerify(o *options, args []string) error {
+    if len(args) < 1 || len(args) > 2 {
+        return errUsage
+    }
+    repo, err := o.connect()
+    if err != nil {
+        return err
+    }
+    defer repo.Close()
+
+    root, err := repo.Stat(args[0])
+    if err != nil {
+        return err
+    }
+
+    s := summary{}
+    err = walkRemote(repo, root, func(info model.FileInfo) error {
+        var err error
+        if len(args) == 2 {
+            err = verifyLocal(repo, info, localPath(root, info, args[1]))
+        } else {
+            _, err = repo.Download(model.FileRequest{Path: info.FilePath}, ioutil.Discard)
+        }
+        if err != nil {
+            fmt.Printf("FAILED %s: %v\n", info.FilePath, err)
+        } else {
+            fmt.Printf("OK     %s\n", info.FilePath)
+        }
+        s.add(info.Size, err)
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    return s.result("verified")
+}
+
+func verifyLocal(repo client.Repo, info model.FileInfo, local string) error {
+    if info.Checksum == "" {
+        var err error
+        if info, err = repo.Download(model.FileRequest{Path: info.FilePath, Version: info.Version}, ioutil.Discard); err != nil {
+            return err
+        }
+    }
+    if info.Checksum == "" {
+        return fmt.Errorf("no checksum on server")
+    }
+    f, err := os.Open(local)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    sum, err := client.Checksum(f)
+    if err != nil {
+        return err
+    }
+    if sum != info.Checksum {
+        return client.ErrChecksum
+    }
+    return nil
+}
@@ -0,0 +1,146 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package main
+
+import (
+    "citron-repo/client"
+    "citron-repo/schedule"
+    "citron-repo/schedule/cron"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "os/signal"
+    "sync"
+    "syscall"
+    "time"
+)
+
+func initSchedule(fs *flag.FlagSet, o *options) runner {
+    name := fs.String("run", "", "run the job now and exit")
+    return func(args []string) error {
+        if len(args) > 0 {
+            return errUsage
+        }
+        conf, err := loadConfig()
+        if err != nil {
+            return err
+        }
+        if len(conf.Jobs) == 0 {
+            return errors.New("no jobs in config")
+        }
+        history, err := schedule.LoadHistory(conf.historyPath())
+        if err != nil {
+            return err
+        }
+
+        //多个任务同时执行时避免同时刷新token
+        lock := sync.Mutex{}
+        connect := func() (client.Repo, error) {
+            lock.Lock()
+            defer lock.Unlock()
+            return o.connect()
+        }
+        s, err := schedule.New(conf.Jobs, connect, history,
+            schedule.SetExclude(conf.Exclude),
+            schedule.SetCallback(func(job string, e client.BackupEntry) {
+                if e.Err != nil {
+                    fmt.Fprintf(os.Stderr, "[%s] %s: %v\n", job, e.Info.FilePath, e.Err)
+                } else if !o.quiet && e.Action != client.BackupSkipped {
+                    fmt.Fprintf(os.Stderr, "[%s] %s %s %s\n", job, e.Action, e.Info.FilePath, formatSize(e.Info.Size))
+                }
+            }))
+        if err != nil {
+            return err
+        }
+
+        if *name != "" {
+            run, err := s.RunJob(*name)
+            printRun(run)
+            return err
+        }
+
+        stop := make(chan struct{})
+        ch := make(chan os.Signal, 1)
+        signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+        defer signal.Stop(ch)
+        go func() {
+            <-ch
+            close(stop)
+        }()
+        now := time.Now()
+        for _, j := range conf.Jobs {
+            next, _ := s.Next(j.Name, now)
+            fmt.Fprintf(os.Stderr, "job %s: %s -> %s, next run %s\n", j.Name, j.Dir, j.Dest, formatTime(next))
+        }
+        s.Run(stop)
+        return nil
+    }
+}
+
+func runStatus(o *options, args []string) error {
+    if len(args) > 1 {
+        return errUsage
+    }
+    conf, err := loadConfig()
+    if err != nil {
+        return err
+    }
+    history, err := schedule.LoadHistory(conf.historyPath())
+    if err != nil {
+        return err
+    }
+    //指定任务时输出所有执行记录
+    if len(args) == 1 {
+        runs := history.Get(args[0])
+        if len(runs) == 0 {
+            return fmt.Errorf("no history of job %s", args[0])
+        }
+        for _, run := range runs {
+            printRun(run)
+        }
+        return nil
+    }
+
+    now := time.Now()
+    for _, j := range conf.Jobs {
+        next := "-"
+        if sch, err := cron.Parse(j.Schedule); err == nil {
+            next = formatTime(sch.Next(now))
+        }
+        fmt.Printf("%s\t%s\tnext %s\n", j.Name, j.Schedule, next)
+        if run, ok := history.Last(j.Name); ok {
+            printRun(run)
+        } else {
+            fmt.Println("    never run")
+        }
+    }
+    return nil
+}
+
+func printRun(run schedule.Run) {
+    if run.Start.IsZero() {
+        return
+    }
+    duration := "-"
+    if !run.End.IsZero() {
+        duration = run.End.Sub(run.Start).Round(time.Millisecond).String()
+    }
+    fmt.Printf("    %s %-8s %s added %d, changed %d, skipped %d, failed %d, %s, pruned %d",
+        formatTime(run.Start), run.Status, duration, run.Added, run.Changed, run.Skipped, run.Failed, formatSize(run.Bytes), run.Pruned)
+    if run.Error != "" {
+        fmt.Printf(": %s", run.Error)
+    }
+    fmt.Println()
+}
+
+func formatTime(t time.Time) string {
+    if t.IsZero() {
+        return "-"
+    }
+    return t.Local().Format("2006-01-02 15:04:05")
+}
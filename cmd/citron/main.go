@@ -0,0 +1,108 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package main
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "github.com/xfali/goutils/log"
+    "os"
+)
+
+const (
+    EXIT_OK = 0
+    //命令执行失败（部分文件失败也视为失败）
+    EXIT_FAILED = 1
+    //参数错误
+    EXIT_USAGE = 2
+)
+
+var errUsage = errors.New("usage error")
+
+//命令执行函数，args为参数解析后剩余的参数
+type runner func(args []string) error
+
+type command struct {
+    name  string
+    usage string
+    //注册命令的参数，返回执行函数
+    init func(fs *flag.FlagSet, o *options) runner
+}
+
+var commands = []command{
+    {"login", "login [-u username] [-p password]", initLogin},
+    {"backup", "backup [-checksum] [-n] [-snapshot [-tag t]] [-exclude pattern] <dir> [dest]", initBackup},
+    {"agent", "agent [-debounce d] [-state file] [-exclude pattern] [dir[:dest]...]", initAgent},
+    {"schedule", "schedule [-run job]", initSchedule},
+    {"status", "status [job]", simple(runStatus)},
+    {"snapshot", "snapshot <ls [path] | show <id> | diff <id> [id] | restore <id> [target] | rm <id>>", simple(runSnapshot)},
+    {"restore", "restore [-version v | -snapshot id] [-overwrite policy] [-include pattern] [-parallel n] [-verify] [path] <dest>", initRestore},
+    {"ls", "ls [path]", simple(runList)},
+    {"verify", "verify <path> [local]", simple(runVerify)},
+    {"versions", "versions <path>", simple(runVersions)},
+    {"rm", "rm <path>", simple(runRm)},
+    {"trash", "trash <ls [path] | restore <path> | purge [path]>", simple(runTrash)},
+    {"replica", "replica <status | verify <name>>", simple(runReplica)},
+}
+
+//没有额外参数的命令
+func simple(f func(o *options, args []string) error) func(fs *flag.FlagSet, o *options) runner {
+    return func(fs *flag.FlagSet, o *options) runner {
+        return func(args []string) error {
+            return f(o, args)
+        }
+    }
+}
+
+func usage() {
+    fmt.Fprintln(os.Stderr, "usage: citron <command> [options] [args]")
+    fmt.Fprintln(os.Stderr, "commands:")
+    for _, c := range commands {
+        fmt.Fprintf(os.Stderr, "    %s\n", c.usage)
+    }
+    fmt.Fprintln(os.Stderr, "run 'citron <command> -h' for options")
+}
+
+func main() {
+    log.Level = log.WARN
+    os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+    if len(args) == 0 {
+        usage()
+        return EXIT_USAGE
+    }
+    for _, c := range commands {
+        if c.name != args[0] {
+            continue
+        }
+        fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+        o := newOptions(fs)
+        r := c.init(fs, o)
+        fs.Usage = func() {
+            fmt.Fprintf(os.Stderr, "usage: citron %s\n", c.usage)
+            fs.PrintDefaults()
+        }
+        if err := fs.Parse(args[1:]); err != nil {
+            return EXIT_USAGE
+        }
+        err := r(fs.Args())
+        if err == errUsage {
+            fs.Usage()
+            return EXIT_USAGE
+        }
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "citron %s: %v\n", c.name, err)
+            return EXIT_FAILED
+        }
+        return EXIT_OK
+    }
+    usage()
+    return EXIT_USAGE
+}
@@ -0,0 +1,274 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package main
+
+import (
+    "citron-repo/client"
+    "citron-repo/protocol"
+    "citron-repo/schedule"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "gopkg.in/yaml.v2"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+const (
+    PROTO_REST   = "rest"
+    PROTO_BINARY = "binary"
+
+    DEFAULT_SERVER = "http://127.0.0.1:8080"
+    DEFAULT_BINARY = "127.0.0.1:20001"
+    //登录信息保存路径（相对于用户目录）
+    CREDENTIALS_FILE = ".citron/credentials.json"
+    //客户端配置路径（相对于用户目录）
+    CONFIG_FILE = ".citron/config.yaml"
+    //agent状态库路径（相对于用户目录）
+    STATE_FILE = ".citron/agent-state.json"
+    //任务执行历史路径（相对于用户目录）
+    HISTORY_FILE = ".citron/jobs-history.json"
+)
+
+//客户端配置
+type clientConfig struct {
+    //备份时的全局忽略规则，语法同.gitignore
+    Exclude []string `yaml:"exclude"`
+    //agent监控的目录
+    Watch []client.WatchDir `yaml:"watch"`
+    //agent状态库路径，默认为用户目录下的STATE_FILE
+    State string `yaml:"state"`
+    //定时备份任务
+    Jobs []schedule.Job `yaml:"jobs"`
+    //任务执行历史路径，默认为用户目录下的HISTORY_FILE
+    History string `yaml:"history"`
+}
+
+//login保存的登录信息
+type credentials struct {
+    Server       string    `json:"server"`
+    Binary       string    `json:"binary"`
+    Username     string    `json:"username"`
+    Token        string    `json:"token"`
+    ExpireAt     time.Time `json:"expireAt"`
+    RefreshToken string    `json:"refreshToken"`
+}
+
+type options struct {
+    server string
+    binary string
+    proto  string
+    apiKey string
+    quiet  bool
+    //客户端加密，key在第一次连接时由口令派生
+    encrypt bool
+    cipher  string
+    //仓库中没有密钥时创建
+    initKey bool
+    key     *client.CryptKey
+}
+
+func newOptions(fs *flag.FlagSet) *options {
+    o := &options{}
+    fs.StringVar(&o.server, "s", os.Getenv("CITRON_SERVER"), "REST server url, default "+DEFAULT_SERVER)
+    fs.StringVar(&o.binary, "b", os.Getenv("CITRON_BINARY"), "binary server address, default "+DEFAULT_BINARY)
+    fs.StringVar(&o.proto, "proto", PROTO_REST, "protocol: rest or binary")
+    fs.StringVar(&o.apiKey, "k", os.Getenv("CITRON_API_KEY"), "api key, use it instead of login token")
+    fs.BoolVar(&o.quiet, "q", false, "no progress output")
+    fs.BoolVar(&o.encrypt, "e", os.Getenv("CITRON_PASSPHRASE") != "", "encrypt file contents and names with a passphrase (CITRON_PASSPHRASE or read from terminal)")
+    fs.StringVar(&o.cipher, "cipher", client.CIPHER_AES_GCM, "cipher of a new encryption key: "+client.CIPHER_AES_GCM+" or "+client.CIPHER_XCHACHA)
+    fs.BoolVar(&o.initKey, "init-key", false, "create the encryption key if the repository has none")
+    return o
+}
+
+//env不为空时使用env指定的路径，否则为用户目录下的file
+func homePath(env, file string) string {
+    if p := os.Getenv(env); p != "" {
+        return p
+    }
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return file
+    }
+    return filepath.Join(home, file)
+}
+
+func credentialsPath() string {
+    return homePath("CITRON_CREDENTIALS", CREDENTIALS_FILE)
+}
+
+func (c clientConfig) historyPath() string {
+    if c.History != "" {
+        return c.History
+    }
+    return homePath("CITRON_HISTORY", HISTORY_FILE)
+}
+
+func loadConfig() (clientConfig, error) {
+    c := clientConfig{}
+    data, err := ioutil.ReadFile(homePath("CITRON_CONFIG", CONFIG_FILE))
+    if err != nil {
+        if os.IsNotExist(err) {
+            return c, nil
+        }
+        return c, err
+    }
+    return c, yaml.UnmarshalStrict(data, &c)
+}
+
+func loadCredentials() (credentials, error) {
+    c := credentials{}
+    data, err := ioutil.ReadFile(credentialsPath())
+    if err != nil {
+        if os.IsNotExist(err) {
+            return c, nil
+        }
+        return c, err
+    }
+    return c, json.Unmarshal(data, &c)
+}
+
+func saveCredentials(c credentials) error {
+    p := credentialsPath()
+    if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+        return err
+    }
+    data, err := json.MarshalIndent(c, "", "  ")
+    if err != nil {
+        return err
+    }
+    return ioutil.WriteFile(p, data, 0600)
+}
+
+//命令行参数优先，其次为login保存的地址
+func (o *options) resolve(c credentials) {
+    if o.server == "" {
+        o.server = c.Server
+    }
+    if o.server == "" {
+        o.server = DEFAULT_SERVER
+    }
+    if o.binary == "" {
+        o.binary = c.Binary
+    }
+    if o.binary == "" {
+        o.binary = DEFAULT_BINARY
+    }
+}
+
+//连接仓库，启用加密时返回加密的仓库客户端
+func (o *options) connect() (client.Repo, error) {
+    repo, err := o.dial()
+    if err != nil || !o.encrypt {
+        return repo, err
+    }
+    if o.key == nil {
+        passphrase := os.Getenv("CITRON_PASSPHRASE")
+        if passphrase == "" {
+            if passphrase, err = readPassword("passphrase: "); err != nil {
+                repo.Close()
+                return nil, err
+            }
+        }
+        o.key, err = client.LoadKey(repo, passphrase)
+        if err == client.ErrNoKey {
+            if !o.initKey {
+                err = errors.New("encryption key not found, run with -init-key to create one")
+            } else {
+                o.key, err = client.InitKey(repo, passphrase, o.cipher)
+            }
+        }
+        if err != nil {
+            repo.Close()
+            return nil, err
+        }
+    }
+    return client.NewCryptRepo(repo, o.key), nil
+}
+
+//使用api key或login保存的登录token认证，token过期时使用刷新token更新
+func (o *options) dial() (client.Repo, error) {
+    c, err := loadCredentials()
+    if err != nil {
+        return nil, err
+    }
+    o.resolve(c)
+
+    token := ""
+    if o.apiKey == "" {
+        if c.Token == "" {
+            return nil, errors.New("not logged in, run 'citron login' or set CITRON_API_KEY")
+        }
+        token = c.Token
+        if !c.ExpireAt.IsZero() && time.Now().After(c.ExpireAt.Add(-time.Minute)) {
+            token, err = refresh(c, o.server)
+            if err != nil {
+                return nil, fmt.Errorf("login expired, run 'citron login': %v", err)
+            }
+        }
+    }
+
+    switch o.proto {
+    case PROTO_REST:
+        rc := client.NewRestClient(o.server)
+        rc.SetToken(token)
+        rc.SetApiKey(o.apiKey)
+        return rc, nil
+    case PROTO_BINARY:
+        bc, err := client.Dial(o.binary)
+        if err != nil {
+            return nil, err
+        }
+        //旧版本服务端不支持协商，不压缩
+        if err := bc.Negotiate(protocol.DefaultCodecs); err != nil {
+            if _, ok := err.(*protocol.Error); !ok {
+                bc.Close()
+                return nil, err
+            }
+        }
+        if o.apiKey != "" {
+            err = bc.LoginWithApiKey(o.apiKey)
+        } else {
+            err = bc.LoginWithToken(token)
+        }
+        if err != nil {
+            bc.Close()
+            return nil, err
+        }
+        return bc, nil
+    }
+    return nil, fmt.Errorf("unknown protocol %s", o.proto)
+}
+
+func refresh(c credentials, server string) (string, error) {
+    if c.RefreshToken == "" {
+        return "", errors.New("no refresh token")
+    }
+    rc := client.NewRestClient(server)
+    ret, err := rc.Refresh(c.RefreshToken)
+    if err != nil {
+        return "", err
+    }
+    c.Token, c.ExpireAt, c.RefreshToken = ret.Token, ret.ExpireAt, ret.RefreshToken
+    return c.Token, saveCredentials(c)
+}
+
+//可重复指定的参数
+type stringList []string
+
+func (l *stringList) String() string {
+    return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+    *l = append(*l, s)
+    return nil
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package main
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "time"
+)
+
+const PROGRESS_INTERVAL = 200 * time.Millisecond
+
+//传输进度，输出到stderr
+type progress struct {
+    name  string
+    total int64
+    done  int64
+    quiet bool
+    last  time.Time
+}
+
+func newProgress(name string, total int64, quiet bool) *progress {
+    return &progress{name: name, total: total, quiet: quiet}
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+    p.done += int64(len(b))
+    if !p.quiet && time.Since(p.last) >= PROGRESS_INTERVAL {
+        p.last = time.Now()
+        p.print()
+    }
+    return len(b), nil
+}
+
+func (p *progress) print() {
+    percent := int64(100)
+    if p.total > 0 {
+        percent = p.done * 100 / p.total
+    }
+    fmt.Fprintf(os.Stderr, "\r%s %3d%% %s/%s", p.name, percent, formatSize(p.done), formatSize(p.total))
+}
+
+//传输结束，err不为空时输出错误
+func (p *progress) finish(err error) {
+    if p.quiet {
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "%s: %v\n", p.name, err)
+        }
+        return
+    }
+    p.print()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, " failed: %v\n", err)
+        return
+    }
+    fmt.Fprintln(os.Stderr, " done")
+}
+
+//读取时更新进度
+func (p *progress) reader(r io.Reader) io.Reader {
+    return io.TeeReader(r, p)
+}
+
+func formatSize(n int64) string {
+    units := []string{"B", "KB", "MB", "GB", "TB"}
+    f := float64(n)
+    i := 0
+    for f >= 1024 && i < len(units)-1 {
+        f /= 1024
+        i++
+    }
+    if i == 0 {
+        return fmt.Sprintf("%d%s", n, units[i])
+    }
+    return fmt.Sprintf("%.1f%s", f, units[i])
+}
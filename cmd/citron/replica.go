@@ -0,0 +1,89 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package main
+
+import (
+    "citron-repo/client"
+    "citron-repo/model"
+    "fmt"
+    "time"
+)
+
+func runReplica(o *options, args []string) error {
+    if len(args) == 0 {
+        return errUsage
+    }
+    op, args := args[0], args[1:]
+    switch op {
+    case "status":
+        if len(args) != 0 {
+            return errUsage
+        }
+    case "verify":
+        if len(args) != 1 {
+            return errUsage
+        }
+    default:
+        return errUsage
+    }
+
+    //复制状态只能通过rest查询
+    o.proto = PROTO_REST
+    repo, err := o.dial()
+    if err != nil {
+        return err
+    }
+    defer repo.Close()
+    rc := repo.(*client.RestClient)
+
+    if op == "status" {
+        replicas, err := rc.Replicas()
+        if err != nil {
+            return err
+        }
+        for _, r := range replicas {
+            printReplica(r)
+        }
+        return nil
+    }
+
+    ret, err := rc.VerifyReplica(args[0])
+    if err != nil {
+        return err
+    }
+    for _, p := range ret.Missing {
+        fmt.Printf("missing     %s\n", p)
+    }
+    for _, p := range ret.Mismatched {
+        fmt.Printf("mismatched  %s\n", p)
+    }
+    for _, p := range ret.Extra {
+        fmt.Printf("extra       %s\n", p)
+    }
+    fmt.Printf("%s: %d files, %d matched, %d skipped, %d missing, %d mismatched, %d extra\n", ret.Name, ret.Files,
+        ret.Matched, ret.Skipped, len(ret.Missing), len(ret.Mismatched), len(ret.Extra))
+    if !ret.Ok() {
+        return fmt.Errorf("replica %s differs from the repo", ret.Name)
+    }
+    return nil
+}
+
+func printReplica(r model.ReplicaStatus) {
+    state := "connected"
+    if !r.Connected {
+        state = "disconnected"
+    }
+    last := "never"
+    if !r.LastSync.IsZero() {
+        last = r.LastSync.Format("2006-01-02 15:04:05")
+    }
+    fmt.Printf("%s  %s  %s  pending %d  lag %s  synced %d files (%s)  last sync %s\n", r.Name, r.Addr, state, r.Pending,
+        time.Duration(r.Lag).Round(time.Second), r.Files, formatSize(r.Bytes), last)
+    if r.LastError != "" {
+        fmt.Printf("    error: %s\n", r.LastError)
+    }
+}
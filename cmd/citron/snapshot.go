@@ -0,0 +1,118 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package main
+
+import (
+    "citron-repo/client"
+    "citron-repo/model"
+    "fmt"
+    "strings"
+)
+
+func runSnapshot(o *options, args []string) error {
+    if len(args) == 0 {
+        return errUsage
+    }
+    op, args := args[0], args[1:]
+    switch op {
+    case "ls":
+        if len(args) > 1 {
+            return errUsage
+        }
+    case "show", "rm":
+        if len(args) != 1 {
+            return errUsage
+        }
+    case "restore", "diff":
+        if len(args) < 1 || len(args) > 2 {
+            return errUsage
+        }
+    default:
+        return errUsage
+    }
+
+    repo, err := o.connect()
+    if err != nil {
+        return err
+    }
+    defer repo.Close()
+
+    switch op {
+    case "ls":
+        p := ""
+        if len(args) == 1 {
+            p = args[0]
+        }
+        snaps, err := repo.Snapshots(p)
+        if err != nil {
+            return err
+        }
+        for _, snap := range snaps {
+            printSnapshot(snap)
+        }
+    case "show":
+        snap, err := repo.Snapshot(args[0])
+        if err != nil {
+            return err
+        }
+        printSnapshot(snap)
+        for _, e := range snap.Entries {
+            fmt.Printf("    %s  %10s  %s  %s\n", e.Version, formatSize(e.Size), e.ModTime.Format("2006-01-02 15:04:05"), e.FilePath)
+        }
+    case "restore":
+        target := ""
+        if len(args) == 2 {
+            target = args[1]
+        }
+        ret, err := repo.RestoreSnapshot(args[0], target)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("restored %d files (%s)\n", ret.Files, formatSize(ret.Bytes))
+    case "rm":
+        return repo.DeleteSnapshot(args[0])
+    case "diff":
+        rc, ok := repo.(differ)
+        if !ok {
+            return client.ErrDiffNotSupported
+        }
+        to := ""
+        if len(args) == 2 {
+            to = args[1]
+        }
+        return rc.DiffSnapshot(args[0], to, printDiff)
+    }
+    return nil
+}
+
+//RestClient及CryptRepo支持比较快照
+type differ interface {
+    DiffSnapshot(from, to string, f func(e model.DiffEntry) error) error
+}
+
+func printDiff(e model.DiffEntry) error {
+    switch e.Type {
+    case model.DiffAdded:
+        fmt.Printf("+ %s\n", e.Path)
+    case model.DiffRemoved:
+        fmt.Printf("- %s\n", e.Path)
+    case model.DiffModified:
+        fmt.Printf("M %s (%s)\n", e.Path, strings.Join(e.Changes, ","))
+    case model.DiffRenamed:
+        fmt.Printf("R %s -> %s\n", e.OldPath, e.Path)
+    }
+    return nil
+}
+
+func printSnapshot(snap model.Snapshot) {
+    state := "committed"
+    if snap.State == model.SnapshotPending {
+        state = "pending"
+    }
+    fmt.Printf("%s  %-9s  %s  %s  %d files (%s)  from %s  [%s]\n", snap.ID, state, snap.CreateTime.Format("2006-01-02 15:04:05"),
+        snap.Path, snap.Files, formatSize(snap.Size), snap.From, strings.Join(snap.Tags, ","))
+}
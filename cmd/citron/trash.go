@@ -0,0 +1,95 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package main
+
+import (
+    "citron-repo/model"
+    "fmt"
+)
+
+//删除的文件移动到回收站，可以通过trash restore恢复
+func runRm(o *options, args []string) error {
+    if len(args) != 1 {
+        return errUsage
+    }
+    repo, err := o.connect()
+    if err != nil {
+        return err
+    }
+    defer repo.Close()
+
+    ret, err := repo.Delete(args[0])
+    if err != nil {
+        return err
+    }
+    fmt.Printf("moved %d files (%s) to trash\n", ret.Files, formatSize(ret.Bytes))
+    return nil
+}
+
+func runTrash(o *options, args []string) error {
+    if len(args) == 0 {
+        return errUsage
+    }
+    op, args := args[0], args[1:]
+    switch op {
+    case "ls", "purge":
+        if len(args) > 1 {
+            return errUsage
+        }
+    case "restore":
+        if len(args) != 1 {
+            return errUsage
+        }
+    default:
+        return errUsage
+    }
+    p := ""
+    if len(args) == 1 {
+        p = args[0]
+    }
+
+    repo, err := o.connect()
+    if err != nil {
+        return err
+    }
+    defer repo.Close()
+
+    switch op {
+    case "ls":
+        entries, err := repo.Trash(p)
+        if err != nil {
+            return err
+        }
+        for _, e := range entries {
+            fmt.Printf("%10s  deleted %s  purge %s  %d versions  %s\n", formatSize(e.Size), e.DeleteTime.Format("2006-01-02 15:04:05"),
+                e.PurgeTime.Format("2006-01-02 15:04:05"), e.Versions, e.Path)
+        }
+    case "restore":
+        ret, err := repo.RestoreTrash(p)
+        if err != nil {
+            return err
+        }
+        printTrashResult("restored", ret)
+        if len(ret.Failed) > 0 {
+            return fmt.Errorf("%d files not restored", len(ret.Failed))
+        }
+    case "purge":
+        ret, err := repo.PurgeTrash(p)
+        if err != nil {
+            return err
+        }
+        printTrashResult("purged", ret)
+    }
+    return nil
+}
+
+func printTrashResult(action string, ret model.TrashResult) {
+    for _, p := range ret.Failed {
+        fmt.Printf("failed  %s\n", p)
+    }
+    fmt.Printf("%s %d files (%s)\n", action, ret.Files, formatSize(ret.Bytes))
+}
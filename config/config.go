@@ -0,0 +1,327 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package config
+
+import (
+    "citron-repo/auth"
+    "citron-repo/model"
+    "citron-repo/protocol"
+    "citron-repo/schedule/cron"
+    "citron-repo/storage"
+    "citron-repo/token"
+    "citron-repo/user"
+    "fmt"
+    "gopkg.in/yaml.v2"
+    "io/ioutil"
+    "net"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+const (
+    DEFAULT_FILE = "citron.yaml"
+
+    DEFAULT_BACKUP_DIR     = "./backup"
+    DEFAULT_HTTP_PORT      = 8080
+    DEFAULT_BINARY_PORT    = 20001
+    DEFAULT_BUF_SIZE       = 32 * 1024
+    DEFAULT_TIMEOUT        = 15 * time.Second
+    DEFAULT_IDLE_TIMEOUT   = time.Minute
+    DEFAULT_LOGIN_EXPIRE   = 3 * time.Hour
+    DEFAULT_REFRESH_EXPIRE = 7 * 24 * time.Hour
+    DEFAULT_FILE_EXPIRE    = 15 * time.Second
+    DEFAULT_TRASH_RETAIN   = 30 * 24 * time.Hour
+
+    MAX_BUF_SIZE = 16 * 1024 * 1024
+)
+
+//配置校验失败，包含所有不合法的配置项
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+    return "invalid config: " + strings.Join(e, "; ")
+}
+
+func Default() model.Config {
+    conf := model.Config{BackupDir: DEFAULT_BACKUP_DIR}
+    SetDefaults(&conf)
+    return conf
+}
+
+//为未设置（零值）的配置项填充默认值，BackupDir除外
+func SetDefaults(conf *model.Config) {
+    setInt(&conf.Http.Port, DEFAULT_HTTP_PORT)
+    setDuration(&conf.Http.ReadHeaderTimeout, DEFAULT_TIMEOUT)
+    setDuration(&conf.Http.IdleTimeout, DEFAULT_IDLE_TIMEOUT)
+
+    setInt(&conf.Binary.Port, DEFAULT_BINARY_PORT)
+    setInt(&conf.Binary.ReadBufSize, DEFAULT_BUF_SIZE)
+    setInt(&conf.Binary.WriteBufSize, DEFAULT_BUF_SIZE)
+    setDuration(&conf.Binary.ReadTimeout, DEFAULT_TIMEOUT)
+    setDuration(&conf.Binary.WriteTimeout, DEFAULT_TIMEOUT)
+    if conf.Binary.Compression == nil {
+        conf.Binary.Compression = append([]string(nil), protocol.DefaultCodecs...)
+    }
+    if conf.Binary.CompressMinSize == 0 {
+        conf.Binary.CompressMinSize = protocol.DefaultCompressMinSize
+    }
+
+    setDuration(&conf.Token.LoginExpire, DEFAULT_LOGIN_EXPIRE)
+    setDuration(&conf.Token.RefreshExpire, DEFAULT_REFRESH_EXPIRE)
+    setDuration(&conf.Token.FileExpire, DEFAULT_FILE_EXPIRE)
+
+    setDuration(&conf.Trash.Retention, DEFAULT_TRASH_RETAIN)
+}
+
+func setInt(v *int, def int) {
+    if *v == 0 {
+        *v = def
+    }
+}
+
+func setDuration(v *model.Duration, def time.Duration) {
+    if *v == 0 {
+        *v = model.Duration(def)
+    }
+}
+
+//读取配置文件（文件不存在时使用默认配置），不应用环境变量
+func ReadFile(path string) (model.Config, error) {
+    conf := Default()
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return conf, nil
+        }
+        return conf, err
+    }
+    if err := yaml.UnmarshalStrict(data, &conf); err != nil {
+        return conf, fmt.Errorf("parse config %s failed: %v", path, err)
+    }
+    SetDefaults(&conf)
+    return conf, nil
+}
+
+//依次加载默认配置、配置文件、环境变量，并校验
+func Load(path string) (model.Config, error) {
+    conf, err := ReadFile(path)
+    if err != nil {
+        return conf, err
+    }
+    if err := ApplyEnv(&conf); err != nil {
+        return conf, err
+    }
+    return conf, Validate(conf)
+}
+
+//写入临时文件后rename，保证配置文件不会只写入一部分
+func Save(path string, conf model.Config) error {
+    data, err := yaml.Marshal(conf)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return err
+    }
+    tmp := path + ".tmp"
+    f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+    if err != nil {
+        return err
+    }
+    _, err = f.Write(data)
+    if err == nil {
+        err = f.Sync()
+    }
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        os.Remove(tmp)
+        return err
+    }
+    return os.Rename(tmp, path)
+}
+
+func Validate(conf model.Config) error {
+    var errs ValidationError
+    add := func(format string, args ...interface{}) {
+        errs = append(errs, fmt.Sprintf(format, args...))
+    }
+
+    if conf.BackupDir == "" {
+        add("backupDir: must not be empty")
+    }
+    if conf.Username != "" {
+        if !user.ValidUsername(conf.Username) {
+            add("username: invalid username %q", conf.Username)
+        }
+        if len(conf.Password) < user.MIN_PASSWORD {
+            add("password: must be at least %d characters", user.MIN_PASSWORD)
+        }
+    }
+
+    checkPort := func(name string, port int) {
+        if port <= 0 || port > 65535 {
+            add("%s: must be between 1 and 65535, got %d", name, port)
+        }
+    }
+    checkPort("http.port", conf.Http.Port)
+    checkPort("binary.port", conf.Binary.Port)
+    if conf.Http.Port == conf.Binary.Port {
+        add("binary.port: must differ from http.port (%d)", conf.Http.Port)
+    }
+
+    checkPositive := func(name string, d model.Duration) {
+        if d <= 0 {
+            add("%s: must be positive, got %s", name, d)
+        }
+    }
+    //http请求的读写超时包括body（上传、下载），0为不限制
+    checkNonNegative := func(name string, d model.Duration) {
+        if d < 0 {
+            add("%s: must not be negative, got %s", name, d)
+        }
+    }
+    checkNonNegative("http.readTimeout", conf.Http.ReadTimeout)
+    checkNonNegative("http.writeTimeout", conf.Http.WriteTimeout)
+    checkPositive("http.readHeaderTimeout", conf.Http.ReadHeaderTimeout)
+    checkPositive("http.idleTimeout", conf.Http.IdleTimeout)
+    checkPositive("binary.readTimeout", conf.Binary.ReadTimeout)
+    checkPositive("binary.writeTimeout", conf.Binary.WriteTimeout)
+
+    checkBuf := func(name string, size int) {
+        if size < 1024 || size > MAX_BUF_SIZE {
+            add("%s: must be between 1024 and %d, got %d", name, MAX_BUF_SIZE, size)
+        }
+    }
+    checkBuf("binary.readBufSize", conf.Binary.ReadBufSize)
+    checkBuf("binary.writeBufSize", conf.Binary.WriteBufSize)
+    for i, name := range conf.Binary.Compression {
+        if _, err := protocol.ParseCodec(name); err != nil {
+            add("binary.compression[%d]: unknown codec %q", i, name)
+        }
+    }
+    if conf.Binary.CompressMinSize < 0 {
+        add("binary.compressMinSize: must not be negative")
+    }
+
+    checkPositive("token.loginExpire", conf.Token.LoginExpire)
+    checkPositive("token.refreshExpire", conf.Token.RefreshExpire)
+    checkPositive("token.fileExpire", conf.Token.FileExpire)
+    if conf.Token.RefreshExpire < conf.Token.LoginExpire {
+        add("token.refreshExpire: must not be shorter than token.loginExpire")
+    }
+    keyIds := map[string]bool{}
+    for i, k := range conf.Token.Keys {
+        if k.ID == "" {
+            add("token.keys[%d].id: must not be empty", i)
+        } else if keyIds[k.ID] {
+            add("token.keys[%d].id: duplicate id %q", i, k.ID)
+        }
+        keyIds[k.ID] = true
+        if len(k.Secret) < token.MIN_KEY_SIZE {
+            add("token.keys[%d].secret: must be at least %d bytes", i, token.MIN_KEY_SIZE)
+        }
+    }
+
+    if conf.Limit.MaxUploadSize < 0 {
+        add("limit.maxUploadSize: must not be negative")
+    }
+    if conf.Limit.MaxConnections < 0 {
+        add("limit.maxConnections: must not be negative")
+    }
+
+    if !storage.ValidCodec(conf.Compression.Codec) {
+        add("compression.codec: unknown codec %q", conf.Compression.Codec)
+    }
+    if conf.Compression.MinSize < 0 {
+        add("compression.minSize: must not be negative")
+    }
+    for i, r := range conf.Compression.Rules {
+        if _, err := storage.Clean(r.Prefix); err != nil || r.Prefix == "" {
+            add("compression.rules[%d].prefix: invalid path %q", i, r.Prefix)
+        }
+        if !storage.ValidCodec(r.Codec) {
+            add("compression.rules[%d].codec: unknown codec %q", i, r.Codec)
+        }
+    }
+
+    masterIds := map[string]bool{}
+    for i, k := range conf.Encryption.Keys {
+        if k.ID == "" {
+            add("encryption.keys[%d].id: must not be empty", i)
+        } else if masterIds[k.ID] {
+            add("encryption.keys[%d].id: duplicate id %q", i, k.ID)
+        }
+        masterIds[k.ID] = true
+        if (k.Secret == "") == (k.File == "") {
+            add("encryption.keys[%d]: exactly one of secret and file must be set", i)
+        } else if _, err := storage.LoadMasterKey(k); err != nil {
+            add("encryption.keys[%d]: %v", i, err)
+        }
+    }
+
+    if conf.Scrub.Schedule != "" {
+        if _, err := cron.Parse(conf.Scrub.Schedule); err != nil {
+            add("scrub.schedule: %v", err)
+        }
+    }
+    if conf.Scrub.RateLimit < 0 {
+        add("scrub.rateLimit: must not be negative")
+    }
+
+    replicas := map[string]bool{}
+    for i, r := range conf.Replication.Replicas {
+        //名称用于保存复制进度的文件名
+        if r.Name == "" || r.Name != filepath.Base(r.Name) || strings.HasPrefix(r.Name, ".") {
+            add("replication.replicas[%d].name: invalid name %q", i, r.Name)
+        } else if replicas[r.Name] {
+            add("replication.replicas[%d].name: duplicate name %q", i, r.Name)
+        }
+        replicas[r.Name] = true
+        if _, _, err := net.SplitHostPort(r.Addr); err != nil {
+            add("replication.replicas[%d].addr: %v", i, err)
+        }
+        if r.ApiKey == "" && (r.Username == "" || r.Password == "") {
+            add("replication.replicas[%d]: apiKey or username and password is required", i)
+        }
+    }
+    if conf.Replication.MaxRetryInterval < 0 {
+        add("replication.maxRetryInterval: must not be negative")
+    }
+    if conf.Trash.Retention < 0 {
+        add("trash.retention: must not be negative")
+    }
+
+    names := map[string]bool{}
+    for i, u := range conf.Users {
+        if !user.ValidUsername(u.Username) {
+            add("users[%d].username: invalid username %q", i, u.Username)
+        } else if names[u.Username] || u.Username == conf.Username {
+            add("users[%d].username: duplicate user %q", i, u.Username)
+        }
+        names[u.Username] = true
+        if len(u.Password) < user.MIN_PASSWORD {
+            add("users[%d].password: must be at least %d characters", i, user.MIN_PASSWORD)
+        }
+        if u.Role != "" && !auth.ValidRole(u.Role) {
+            add("users[%d].role: unknown role %q", i, u.Role)
+        }
+        for j, p := range u.Permissions {
+            if !auth.ValidRole(p.Role) || p.Role == model.RoleAdmin {
+                add("users[%d].permissions[%d].role: invalid role %q", i, j, p.Role)
+            }
+        }
+    }
+
+    if len(errs) > 0 {
+        return errs
+    }
+    return nil
+}
@@ -0,0 +1,147 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package config
+
+import (
+    "citron-repo/model"
+    "fmt"
+    "os"
+    "reflect"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+)
+
+const ENV_PREFIX = "CITRON"
+
+var durationType = reflect.TypeOf(model.Duration(0))
+
+//使用环境变量覆盖配置，变量名为CITRON_加上yaml路径的大写下划线形式
+//如http.port对应CITRON_HTTP_PORT，token.loginExpire对应CITRON_TOKEN_LOGIN_EXPIRE
+//字符串数组以逗号分隔；token.keys可用CITRON_TOKEN_KEYS=id:secret,id2:secret2设置
+func ApplyEnv(conf *model.Config) error {
+    if v, ok := os.LookupEnv(ENV_PREFIX + "_TOKEN_KEYS"); ok {
+        keys, err := parseKeys(v)
+        if err != nil {
+            return fmt.Errorf("%s_TOKEN_KEYS: %v", ENV_PREFIX, err)
+        }
+        conf.Token.Keys = keys
+    }
+    v := reflect.ValueOf(conf).Elem()
+    return walkEnv(v.Type(), nil, ENV_PREFIX, func(key string, index []int) error {
+        s, ok := os.LookupEnv(key)
+        if !ok {
+            return nil
+        }
+        if err := setValue(v.FieldByIndex(index), s); err != nil {
+            return fmt.Errorf("%s: %v", key, err)
+        }
+        return nil
+    })
+}
+
+//将conf中被环境变量覆盖的配置项恢复为file中的值，避免环境变量被写入配置文件
+func RestoreEnv(conf *model.Config, file model.Config) {
+    if _, ok := os.LookupEnv(ENV_PREFIX + "_TOKEN_KEYS"); ok {
+        conf.Token.Keys = file.Token.Keys
+    }
+    dst := reflect.ValueOf(conf).Elem()
+    src := reflect.ValueOf(file)
+    walkEnv(dst.Type(), nil, ENV_PREFIX, func(key string, index []int) error {
+        if _, ok := os.LookupEnv(key); ok {
+            dst.FieldByIndex(index).Set(src.FieldByIndex(index))
+        }
+        return nil
+    })
+}
+
+//遍历所有可以通过环境变量设置的字段，key为对应的环境变量名，index为字段位置
+func walkEnv(t reflect.Type, parent []int, prefix string, f func(key string, index []int) error) error {
+    for i := 0; i < t.NumField(); i++ {
+        field := t.Field(i)
+        name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+        if name == "" || name == "-" {
+            continue
+        }
+        key := prefix + "_" + envName(name)
+        index := append(append([]int(nil), parent...), i)
+        if field.Type.Kind() == reflect.Struct {
+            if err := walkEnv(field.Type, index, key, f); err != nil {
+                return err
+            }
+            continue
+        }
+        //结构体数组（如users）不支持环境变量
+        if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+            continue
+        }
+        if err := f(key, index); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func setValue(v reflect.Value, s string) error {
+    if v.Type() == durationType {
+        d, err := time.ParseDuration(s)
+        if err != nil {
+            return err
+        }
+        v.SetInt(int64(d))
+        return nil
+    }
+    switch v.Kind() {
+    case reflect.String:
+        v.SetString(s)
+    case reflect.Int, reflect.Int64:
+        i, err := strconv.ParseInt(s, 10, 64)
+        if err != nil {
+            return err
+        }
+        v.SetInt(i)
+    case reflect.Bool:
+        b, err := strconv.ParseBool(s)
+        if err != nil {
+            return err
+        }
+        v.SetBool(b)
+    case reflect.Slice:
+        if v.Type().Elem().Kind() != reflect.String {
+            return fmt.Errorf("not supported")
+        }
+        v.Set(reflect.ValueOf(strings.Split(s, ",")))
+    default:
+        return fmt.Errorf("not supported")
+    }
+    return nil
+}
+
+func parseKeys(s string) ([]model.TokenKey, error) {
+    var keys []model.TokenKey
+    for _, kv := range strings.Split(s, ",") {
+        i := strings.Index(kv, ":")
+        if i <= 0 {
+            return nil, fmt.Errorf("expect id:secret")
+        }
+        keys = append(keys, model.TokenKey{ID: kv[:i], Secret: kv[i+1:]})
+    }
+    return keys, nil
+}
+
+//backupDir -> BACKUP_DIR
+func envName(name string) string {
+    buf := strings.Builder{}
+    for i, r := range name {
+        if unicode.IsUpper(r) && i > 0 {
+            buf.WriteByte('_')
+        }
+        buf.WriteRune(unicode.ToUpper(r))
+    }
+    return buf.String()
+}
@@ -0,0 +1,187 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package config
+
+import (
+    "citron-repo/model"
+    "github.com/xfali/goutils/log"
+    "os"
+    "os/signal"
+    "sync"
+    "syscall"
+)
+
+//配置变更回调，old为变更前的配置
+type Listener func(old, new model.Config)
+
+//管理当前生效的配置，Update/Reload时校验、持久化后整体替换并通知监听者
+type Manager struct {
+    path string
+    //配置文件中的内容（未应用环境变量），持久化时使用，避免将环境变量中的密钥写入文件
+    file model.Config
+    conf model.Config
+    lock sync.RWMutex
+    //串行执行Update及Reload（包括通知），监听者收到通知的顺序与配置替换的顺序一致
+    updateLock sync.Mutex
+
+    listenerLock sync.Mutex
+    listeners    []Listener
+}
+
+//内存中的配置，Update不会持久化
+func NewManager(conf model.Config) *Manager {
+    SetDefaults(&conf)
+    return &Manager{file: conf, conf: conf}
+}
+
+//从配置文件加载，文件不存在时使用默认配置
+func Open(path string) (*Manager, error) {
+    file, conf, err := load(path)
+    if err != nil {
+        return nil, err
+    }
+    return &Manager{path: path, file: file, conf: conf}, nil
+}
+
+func load(path string) (model.Config, model.Config, error) {
+    file, err := ReadFile(path)
+    if err != nil {
+        return file, file, err
+    }
+    conf := clone(file)
+    if err := ApplyEnv(&conf); err != nil {
+        return file, conf, err
+    }
+    return file, conf, Validate(conf)
+}
+
+func (m *Manager) Path() string {
+    return m.path
+}
+
+//获得当前配置的副本
+func (m *Manager) Get() model.Config {
+    m.lock.RLock()
+    defer m.lock.RUnlock()
+
+    return clone(m.conf)
+}
+
+func (m *Manager) OnChange(l Listener) {
+    m.listenerLock.Lock()
+    defer m.listenerLock.Unlock()
+
+    m.listeners = append(m.listeners, l)
+}
+
+//校验并持久化新配置后替换当前配置，环境变量仍然优先生效
+//conf通常由Get()修改而来，被环境变量覆盖的配置项不会写入配置文件
+//监听者中不能调用Update或Reload
+func (m *Manager) Update(conf model.Config) error {
+    m.updateLock.Lock()
+    defer m.updateLock.Unlock()
+
+    SetDefaults(&conf)
+    m.lock.RLock()
+    file := clone(conf)
+    RestoreEnv(&file, m.file)
+    m.lock.RUnlock()
+    if err := ApplyEnv(&conf); err != nil {
+        return err
+    }
+    if err := Validate(conf); err != nil {
+        return err
+    }
+
+    m.lock.Lock()
+    if m.path != "" {
+        if err := Save(m.path, file); err != nil {
+            m.lock.Unlock()
+            return err
+        }
+    }
+    old := m.conf
+    m.file, m.conf = file, conf
+    m.lock.Unlock()
+
+    m.notify(old, conf)
+    return nil
+}
+
+//重新读取配置文件，校验失败时保留当前配置
+func (m *Manager) Reload() error {
+    if m.path == "" {
+        return nil
+    }
+    m.updateLock.Lock()
+    defer m.updateLock.Unlock()
+
+    file, conf, err := load(m.path)
+    if err != nil {
+        return err
+    }
+
+    m.lock.Lock()
+    old := m.conf
+    m.file, m.conf = file, conf
+    m.lock.Unlock()
+
+    m.notify(old, conf)
+    return nil
+}
+
+//收到SIGHUP时重新加载配置，调用返回的函数停止监听
+func (m *Manager) WatchSignal() func() {
+    c := make(chan os.Signal, 1)
+    done := make(chan struct{})
+    signal.Notify(c, syscall.SIGHUP)
+    go func() {
+        for {
+            select {
+            case <-c:
+                if err := m.Reload(); err != nil {
+                    log.Error("reload config %s failed, keep current config: %v", m.path, err)
+                } else {
+                    log.Info("config %s reloaded", m.path)
+                }
+            case <-done:
+                return
+            }
+        }
+    }()
+    return func() {
+        signal.Stop(c)
+        close(done)
+    }
+}
+
+func (m *Manager) notify(old, new model.Config) {
+    m.listenerLock.Lock()
+    listeners := make([]Listener, len(m.listeners))
+    copy(listeners, m.listeners)
+    m.listenerLock.Unlock()
+
+    for _, l := range listeners {
+        l(clone(old), clone(new))
+    }
+}
+
+func clone(conf model.Config) model.Config {
+    ret := conf
+    ret.Token.Keys = append([]model.TokenKey(nil), conf.Token.Keys...)
+    ret.Encryption.Keys = append([]model.MasterKey(nil), conf.Encryption.Keys...)
+    ret.Replication.Replicas = append([]model.ReplicaConfig(nil), conf.Replication.Replicas...)
+    ret.Users = make([]model.UserInfo, len(conf.Users))
+    for i, u := range conf.Users {
+        u.Permissions = append([]model.Permission(nil), u.Permissions...)
+        ret.Users[i] = u
+    }
+    if conf.Users == nil {
+        ret.Users = nil
+    }
+    return ret
+}
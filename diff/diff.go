@@ -0,0 +1,130 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package diff
+
+import (
+    "citron-repo/model"
+    "sort"
+    "strings"
+)
+
+//变化的属性
+const (
+    CHANGE_CHECKSUM = "checksum"
+    CHANGE_SIZE     = "size"
+    CHANGE_MODTIME  = "modTime"
+    CHANGE_MODE     = "mode"
+)
+
+type entry struct {
+    rel  string
+    info model.FileInfo
+}
+
+//比较两个文件清单，oldRoot、newRoot为清单的根目录，按相对路径比较。
+//修改的文件按路径顺序依次回调，新增及删除的文件在最后回调（内容相同的新增及删除合并为重命名）
+func Diff(oldRoot string, old []model.FileInfo, newRoot string, new []model.FileInfo, f func(e model.DiffEntry) error) error {
+    olds, news := relative(oldRoot, old), relative(newRoot, new)
+
+    var added, removed []entry
+    i, j := 0, 0
+    for i < len(olds) || j < len(news) {
+        switch {
+        case j >= len(news) || (i < len(olds) && olds[i].rel < news[j].rel):
+            removed = append(removed, olds[i])
+            i++
+        case i >= len(olds) || news[j].rel < olds[i].rel:
+            added = append(added, news[j])
+            j++
+        default:
+            if changes := Compare(olds[i].info, news[j].info); len(changes) > 0 {
+                o, n := olds[i].info, news[j].info
+                if err := f(model.DiffEntry{Type: model.DiffModified, Path: news[j].rel, Changes: changes, Old: &o, New: &n}); err != nil {
+                    return err
+                }
+            }
+            i++
+            j++
+        }
+    }
+
+    //按checksum匹配重命名，每个删除的文件最多匹配一个新增的文件
+    renamed := map[string][]int{}
+    for k, e := range removed {
+        if e.info.Checksum != "" {
+            renamed[e.info.Checksum] = append(renamed[e.info.Checksum], k)
+        }
+    }
+    matched := make([]bool, len(removed))
+    for _, e := range added {
+        n := e.info
+        candidates := renamed[n.Checksum]
+        if n.Checksum == "" || len(candidates) == 0 {
+            if err := f(model.DiffEntry{Type: model.DiffAdded, Path: e.rel, New: &n}); err != nil {
+                return err
+            }
+            continue
+        }
+        k := candidates[0]
+        renamed[n.Checksum] = candidates[1:]
+        matched[k] = true
+        o := removed[k].info
+        var changes []string
+        for _, c := range Compare(o, n) {
+            if c != CHANGE_CHECKSUM && c != CHANGE_SIZE {
+                changes = append(changes, c)
+            }
+        }
+        if err := f(model.DiffEntry{Type: model.DiffRenamed, Path: e.rel, OldPath: removed[k].rel, Changes: changes, Old: &o, New: &n}); err != nil {
+            return err
+        }
+    }
+    for k, e := range removed {
+        if matched[k] {
+            continue
+        }
+        o := e.info
+        if err := f(model.DiffEntry{Type: model.DiffRemoved, Path: e.rel, Old: &o}); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+//比较同一文件的两个版本，返回变化的属性，checksum为空时不比较
+func Compare(old, new model.FileInfo) []string {
+    var changes []string
+    if old.Checksum != "" && new.Checksum != "" && !strings.EqualFold(old.Checksum, new.Checksum) {
+        changes = append(changes, CHANGE_CHECKSUM)
+    }
+    if old.Size != new.Size {
+        changes = append(changes, CHANGE_SIZE)
+    }
+    if !old.ModTime.Equal(new.ModTime) {
+        changes = append(changes, CHANGE_MODTIME)
+    }
+    if old.Mode != new.Mode {
+        changes = append(changes, CHANGE_MODE)
+    }
+    return changes
+}
+
+//转换为相对于root的路径并排序，忽略目录及不在root下的文件
+func relative(root string, infos []model.FileInfo) []entry {
+    root = strings.TrimSuffix(root, "/")
+    ret := make([]entry, 0, len(infos))
+    for _, info := range infos {
+        if info.IsDir || !strings.HasPrefix(info.FilePath, root+"/") {
+            continue
+        }
+        ret = append(ret, entry{rel: info.FilePath[len(root)+1:], info: info})
+    }
+    sort.Slice(ret, func(i, j int) bool {
+        return ret[i].rel < ret[j].rel
+    })
+    return ret
+}
@@ -12,14 +12,43 @@ var (
     OK         = model.OK
 
     ConfigError  = model.Result{Code: "801", Msg: "config failed"}
+    ConfigSaveFailed = model.Result{Code: "802", Msg: "save config failed"}
+    KeyRotateFailed  = model.Result{Code: "803", Msg: "rotate master keys failed"}
 
     LoginError = model.Result{Code: "1001", Msg: "login failed"}
     AuthError  = model.Result{Code: "1002", Msg: "login auth failed"}
+    PermissionDenied = model.Result{Code: "1003", Msg: "permission denied"}
+    TokenError       = model.Result{Code: "1004", Msg: "create token failed"}
+
+    UserParamError = model.Result{Code: "1101", Msg: "user param error, check username and password(at least 6 characters)"}
+    UserExists     = model.Result{Code: "1102", Msg: "user already exists"}
+    UserNotFound   = model.Result{Code: "1103", Msg: "user not found"}
+    UserSaveFailed = model.Result{Code: "1104", Msg: "save user failed"}
+
+    ApiKeyParamError = model.Result{Code: "1201", Msg: "api key param error"}
+    ApiKeyNotFound   = model.Result{Code: "1202", Msg: "api key not found"}
+    ApiKeyError      = model.Result{Code: "1203", Msg: "save api key failed"}
 
     FilenamNotFound  = model.Result{Code: "2001", Msg: "file name not found, add it to header: CITRON-FILENAME"}
     FileUploadFailed  = model.Result{Code: "3001", Msg: "file upload failed"}
     FileTokenMissing  = model.Result{Code: "3002", Msg: "file token missing, add it to header: CITRON-FILE-TOKEN"}
     FileTokenError  = model.Result{Code: "3003", Msg: "file token error"}
+    FileTooLarge  = model.Result{Code: "3004", Msg: "file too large"}
+    FileNotFound  = model.Result{Code: "3005", Msg: "file not found"}
+    FileChecksumError  = model.Result{Code: "3006", Msg: "file checksum mismatch"}
+    FileParamError  = model.Result{Code: "3007", Msg: "file param error, check path"}
+    FileReadFailed  = model.Result{Code: "3008", Msg: "file read failed"}
+    FileDeleteFailed  = model.Result{Code: "3009", Msg: "file delete failed"}
+
+    SnapshotNotFound  = model.Result{Code: "3101", Msg: "snapshot not found"}
+    SnapshotCommitted = model.Result{Code: "3102", Msg: "snapshot already committed"}
+    SnapshotParamError = model.Result{Code: "3103", Msg: "snapshot param error"}
+
+    ScrubRunning = model.Result{Code: "3201", Msg: "scrub already running"}
+    ScrubFailed  = model.Result{Code: "3202", Msg: "scrub failed"}
+
+    ReplicaNotFound     = model.Result{Code: "3301", Msg: "replica not found"}
+    ReplicaVerifyFailed = model.Result{Code: "3302", Msg: "verify replica failed"}
 
     PackageNotReady  = model.Result{Code: "5001", Msg: "package not ready"}
 )
@@ -27,3 +56,9 @@ var (
 func Ok(data interface{}) model.Result {
     return model.Ok(data)
 }
+
+//返回附带详细错误信息的result
+func WithMsg(result model.Result, msg string) model.Result {
+    result.Msg = result.Msg + ": " + msg
+    return result
+}
@@ -3,7 +3,11 @@ module citron-repo
 go 1.12
 
 require (
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/gin-gonic/gin v1.4.0
+	github.com/klauspost/compress v1.10.3
 	github.com/xfali/go-web-starter v0.0.2
 	github.com/xfali/goutils v0.0.3
+	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
+	gopkg.in/yaml.v2 v2.2.2
 )
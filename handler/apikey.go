@@ -0,0 +1,113 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package handler
+
+import (
+    "citron-repo/apikey"
+    "citron-repo/auth"
+    "citron-repo/errcode"
+    "citron-repo/model"
+    "citron-repo/token"
+    "github.com/gin-gonic/gin"
+    "github.com/xfali/goutils/log"
+    "net/http"
+)
+
+//api key只能由登录token管理，不能使用api key创建或吊销api key
+func requireLoginToken(ctx *gin.Context) bool {
+    if CurrentClaims(ctx).Type != token.LoginToken {
+        ctx.JSON(http.StatusForbidden, errcode.PermissionDenied)
+        return false
+    }
+    return true
+}
+
+func (rest *restfulApi) ListApiKey(ctx *gin.Context) {
+    ctx.JSON(http.StatusOK, errcode.Ok(rest.keyMgr.List(CurrentUser(ctx).Username)))
+}
+
+//创建当前用户的api key，返回的key只显示一次
+//body: {"name": "", "scopes": ["list", "write"], "path": "", "expireDays": 0}
+func (rest *restfulApi) CreateApiKey(ctx *gin.Context) {
+    if !requireLoginToken(ctx) {
+        return
+    }
+
+    info := model.ApiKeyInfo{}
+    err := ctx.Bind(&info)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, errcode.ApiKeyParamError)
+        return
+    }
+    for _, scope := range info.Scopes {
+        if !auth.ValidScope(scope) {
+            ctx.JSON(http.StatusBadRequest, errcode.ApiKeyParamError)
+            return
+        }
+    }
+
+    u := CurrentUser(ctx)
+    if info.Path != "" {
+        info.Path = auth.Resolve(u.Username, info.Path)
+    }
+    //api key的范围不能超过当前登录token
+    claims := CurrentClaims(ctx)
+    if claims.Path != "" {
+        if info.Path == "" {
+            info.Path = claims.Path
+        } else if !auth.HasPrefix(info.Path, claims.Path) {
+            ctx.JSON(http.StatusForbidden, errcode.PermissionDenied)
+            return
+        }
+    }
+    if len(info.Scopes) == 0 {
+        info.Scopes = claims.Scopes
+    }
+    for _, scope := range info.Scopes {
+        if auth.CheckScope(claims.Scopes, "", auth.ScopeAction(scope), "/") != nil {
+            ctx.JSON(http.StatusForbidden, errcode.PermissionDenied)
+            return
+        }
+    }
+
+    ret, err := rest.keyMgr.Create(u.Username, info)
+    if err != nil {
+        log.Error("create api key failed: %v", err)
+        ctx.JSON(http.StatusInternalServerError, errcode.ApiKeyError)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.Ok(ret))
+}
+
+func (rest *restfulApi) RevokeApiKey(ctx *gin.Context) {
+    if !requireLoginToken(ctx) {
+        return
+    }
+    rest.revokeApiKey(ctx, CurrentUser(ctx).Username, ctx.Param("id"))
+}
+
+func (rest *restfulApi) ListUserApiKey(ctx *gin.Context) {
+    ctx.JSON(http.StatusOK, errcode.Ok(rest.keyMgr.List(ctx.Param("username"))))
+}
+
+func (rest *restfulApi) RevokeUserApiKey(ctx *gin.Context) {
+    rest.revokeApiKey(ctx, ctx.Param("username"), ctx.Param("id"))
+}
+
+func (rest *restfulApi) revokeApiKey(ctx *gin.Context, username, id string) {
+    err := rest.keyMgr.Revoke(username, id)
+    if err == apikey.ErrKeyNotFound {
+        ctx.JSON(http.StatusNotFound, errcode.ApiKeyNotFound)
+        return
+    }
+    if err != nil {
+        log.Error("revoke api key failed: %v", err)
+        ctx.JSON(http.StatusInternalServerError, errcode.ApiKeyError)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.OK)
+}
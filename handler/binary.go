@@ -0,0 +1,451 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package handler
+
+import (
+    "bytes"
+    "citron-repo/auth"
+    "citron-repo/errcode"
+    "citron-repo/model"
+    "citron-repo/protocol"
+    "citron-repo/storage"
+    "citron-repo/transport"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "github.com/xfali/goutils/log"
+    "io"
+    "time"
+)
+
+var errBinaryAuth = errors.New("username, password or token not match")
+
+type binaryAuthenticator struct {
+    rest *restfulApi
+}
+
+//二进制协议认证，与REST共用用户及token
+func (rest *restfulApi) BinaryAuthenticator() transport.Authenticator {
+    return &binaryAuthenticator{rest: rest}
+}
+
+func (a *binaryAuthenticator) Authenticate(data []byte) (*transport.Identity, error) {
+    info := model.AuthInfo{}
+    if err := json.Unmarshal(data, &info); err != nil {
+        return nil, err
+    }
+
+    if info.Token != "" || info.ApiKey != "" {
+        claims, ok := a.rest.verify(info.Token, info.ApiKey)
+        if !ok {
+            return nil, errBinaryAuth
+        }
+        u, ok := a.rest.userMgr.Get(claims.User)
+        if !ok || u.Disabled {
+            return nil, errBinaryAuth
+        }
+        return &transport.Identity{
+            Username:   u.Username,
+            Scopes:     claims.Scopes,
+            Path:       claims.Path,
+            Credential: model.AuthInfo{Token: info.Token, ApiKey: info.ApiKey},
+        }, nil
+    }
+
+    u, err := a.rest.userMgr.Authenticate(info.Username, info.Password)
+    if err != nil {
+        return nil, errBinaryAuth
+    }
+    return &transport.Identity{Username: u.Username}, nil
+}
+
+const (
+    //非上传请求body最大长度
+    MAX_COMMAND_SIZE = 64 * 1024
+    //调试命令（echo）body最大长度
+    MAX_DEBUG_SIZE = 4 * 1024 * 1024
+)
+
+//二进制协议命令处理，每个连接一个
+type binaryHandler struct {
+    rest *restfulApi
+    ctx  transport.ConnContext
+
+    header protocol.RequestHeader
+    buf    bytes.Buffer
+    //上传文件
+    writer *storage.Writer
+    //处理请求过程中出现的错误，接收完body后返回给客户端
+    err error
+}
+
+//二进制协议命令处理，与REST共用用户权限及存储
+func (rest *restfulApi) BinaryHandlerFactory() transport.RequestHandlerFactory {
+    return func(ctx transport.ConnContext) transport.RequestHandler {
+        return &binaryHandler{rest: rest, ctx: ctx}
+    }
+}
+
+func (h *binaryHandler) Begin(header protocol.RequestHeader) {
+    h.header = header
+    switch header.Reserve {
+    case protocol.DebugCommandID:
+        if header.Length > MAX_DEBUG_SIZE {
+            h.err = protocol.NewError(protocol.StatusTooLarge, "request too large")
+        }
+    case protocol.UploadCommandID:
+        maxSize := h.rest.config().Limit.MaxUploadSize
+        if maxSize > 0 && header.Length > maxSize+protocol.MetaLengthSize+protocol.MaxMetaSize {
+            h.err = protocol.NewError(protocol.StatusTooLarge, errcode.FileTooLarge.Msg)
+        }
+    case protocol.DownloadCommandID, protocol.StatCommandID, protocol.ListCommandID, protocol.VersionsCommandID,
+        protocol.PruneCommandID, protocol.SnapshotBeginCommandID, protocol.SnapshotAddCommandID,
+        protocol.SnapshotCommitCommandID, protocol.SnapshotListCommandID, protocol.SnapshotShowCommandID,
+        protocol.SnapshotRestoreCommandID, protocol.SnapshotDeleteCommandID, protocol.DeleteCommandID,
+        protocol.TrashListCommandID, protocol.TrashRestoreCommandID, protocol.TrashPurgeCommandID:
+        if header.Length > MAX_COMMAND_SIZE {
+            h.err = protocol.NewError(protocol.StatusTooLarge, "request too large")
+        }
+    default:
+        h.err = protocol.NewError(protocol.StatusBadRequest, fmt.Sprintf("unknown command %d", header.Reserve))
+    }
+}
+
+func (h *binaryHandler) Write(p []byte) (int, error) {
+    //出错后丢弃剩余的body
+    if h.err != nil {
+        return len(p), nil
+    }
+    if h.header.Reserve != protocol.UploadCommandID {
+        return h.buf.Write(p)
+    }
+
+    n := len(p)
+    if h.writer == nil {
+        h.buf.Write(p)
+        p = h.startUpload()
+        if h.err != nil || h.writer == nil {
+            return n, nil
+        }
+    }
+    if len(p) > 0 {
+        if _, err := h.writer.Write(p); err != nil {
+            log.Error("write upload file failed: %v", err)
+            h.err = protocol.NewError(protocol.StatusError, errcode.FileUploadFailed.Msg)
+        }
+    }
+    return n, nil
+}
+
+//meta接收完成后创建文件，返回meta之后的文件内容
+func (h *binaryHandler) startUpload() []byte {
+    data := h.buf.Bytes()
+    if len(data) < protocol.MetaLengthSize {
+        return nil
+    }
+    size := int(binary.BigEndian.Uint32(data))
+    if size > protocol.MaxMetaSize {
+        h.err = protocol.NewError(protocol.StatusBadRequest, protocol.ErrMetaTooLarge.Error())
+        return nil
+    }
+    if len(data) < protocol.MetaLengthSize+size {
+        return nil
+    }
+
+    info := model.FileInfo{}
+    if err := json.Unmarshal(data[protocol.MetaLengthSize:protocol.MetaLengthSize+size], &info); err != nil {
+        h.err = protocol.NewError(protocol.StatusBadRequest, err.Error())
+        return nil
+    }
+    rest := data[protocol.MetaLengthSize+size:]
+    maxSize := h.rest.config().Limit.MaxUploadSize
+    if maxSize > 0 && h.header.Length-int64(protocol.MetaLengthSize+size) > maxSize {
+        h.err = protocol.NewError(protocol.StatusTooLarge, errcode.FileTooLarge.Msg)
+        return nil
+    }
+
+    p, err := h.checkPath(info.FilePath, auth.ActionWrite)
+    if err != nil {
+        h.err = err
+        return nil
+    }
+    info.FilePath = p
+    w, err := h.rest.storage.Create(info)
+    if err != nil {
+        h.err = fileError(err)
+        return nil
+    }
+    h.writer = w
+    return rest
+}
+
+func (h *binaryHandler) Reset() {
+    h.header = protocol.RequestHeader{}
+    h.buf.Reset()
+    if h.writer != nil {
+        h.writer.Abort()
+        h.writer = nil
+    }
+    h.err = nil
+}
+
+func (h *binaryHandler) OnePackage(w transport.PackageWriter) error {
+    if h.err != nil {
+        return h.err
+    }
+    switch h.header.Reserve {
+    case protocol.DebugCommandID:
+        return w(int64(h.buf.Len()), &h.buf)
+    case protocol.UploadCommandID:
+        return h.upload(w)
+    case protocol.DownloadCommandID:
+        return h.download(w)
+    case protocol.StatCommandID:
+        return h.query(w, auth.ActionList, func(p string) (interface{}, error) {
+            return h.rest.storage.Stat(p)
+        })
+    case protocol.ListCommandID:
+        return h.query(w, auth.ActionList, func(p string) (interface{}, error) {
+            return h.rest.storage.List(p)
+        })
+    case protocol.VersionsCommandID:
+        return h.query(w, auth.ActionList, func(p string) (interface{}, error) {
+            return h.rest.storage.Versions(p)
+        })
+    case protocol.PruneCommandID:
+        return h.prune(w)
+    case protocol.DeleteCommandID:
+        return h.query(w, auth.ActionDelete, func(p string) (interface{}, error) {
+            return h.rest.storage.Delete(p)
+        })
+    case protocol.TrashListCommandID:
+        return h.query(w, auth.ActionList, func(p string) (interface{}, error) {
+            return h.rest.trash(p)
+        })
+    case protocol.TrashRestoreCommandID:
+        return h.query(w, auth.ActionWrite, func(p string) (interface{}, error) {
+            return h.rest.storage.Restore(p)
+        })
+    case protocol.TrashPurgeCommandID:
+        return h.query(w, auth.ActionDelete, func(p string) (interface{}, error) {
+            return h.rest.storage.PurgeTrash(p, time.Now())
+        })
+    case protocol.SnapshotBeginCommandID, protocol.SnapshotAddCommandID, protocol.SnapshotCommitCommandID,
+        protocol.SnapshotListCommandID, protocol.SnapshotShowCommandID, protocol.SnapshotRestoreCommandID,
+        protocol.SnapshotDeleteCommandID:
+        return h.snapshot(w)
+    }
+    return protocol.NewError(protocol.StatusBadRequest, "unknown command")
+}
+
+func (h *binaryHandler) upload(w transport.PackageWriter) error {
+    if h.writer == nil {
+        return protocol.NewError(protocol.StatusBadRequest, "upload meta missing")
+    }
+    writer := h.writer
+    h.writer = nil
+    info, err := writer.Commit()
+    if err != nil {
+        log.Error("save file failed: %v", err)
+        return fileError(err)
+    }
+    return writeJson(w, info)
+}
+
+func (h *binaryHandler) download(w transport.PackageWriter) error {
+    req := model.FileRequest{}
+    if err := json.Unmarshal(h.buf.Bytes(), &req); err != nil {
+        return protocol.NewError(protocol.StatusBadRequest, err.Error())
+    }
+    p, err := h.checkPath(req.Path, auth.ActionRead)
+    if err != nil {
+        return err
+    }
+    r, info, err := h.rest.storage.Open(p, req.Version)
+    if err != nil {
+        return fileError(err)
+    }
+    defer r.Close()
+
+    meta, err := protocol.EncodeMeta(info)
+    if err != nil {
+        return protocol.NewError(protocol.StatusError, err.Error())
+    }
+    return w(int64(len(meta))+info.Size, io.MultiReader(bytes.NewReader(meta), r))
+}
+
+func (h *binaryHandler) prune(w transport.PackageWriter) error {
+    req := model.PruneRequest{}
+    if err := json.Unmarshal(h.buf.Bytes(), &req); err != nil {
+        return protocol.NewError(protocol.StatusBadRequest, err.Error())
+    }
+    if req.Retention.Versions < 0 || req.Retention.MaxAge < 0 {
+        return protocol.NewError(protocol.StatusBadRequest, "invalid retention")
+    }
+    p, err := h.checkPath(req.Path, auth.ActionDelete)
+    if err != nil {
+        return err
+    }
+    ret, err := h.rest.storage.Prune(p, req.Retention, time.Now())
+    if err != nil {
+        return fileError(err)
+    }
+    return writeJson(w, ret)
+}
+
+func (h *binaryHandler) snapshot(w transport.PackageWriter) error {
+    req := model.SnapshotRequest{}
+    if err := json.Unmarshal(h.buf.Bytes(), &req); err != nil {
+        return protocol.NewError(protocol.StatusBadRequest, err.Error())
+    }
+    st := h.rest.storage
+    switch h.header.Reserve {
+    case protocol.SnapshotBeginCommandID:
+        p, err := h.checkPath(req.Path, auth.ActionWrite)
+        if err != nil {
+            return err
+        }
+        snap, err := st.BeginSnapshot(model.Snapshot{
+            Path:  p,
+            From:  req.From,
+            Tags:  req.Tags,
+            Owner: h.ctx.Identity().Username,
+        })
+        if err != nil {
+            return fileError(err)
+        }
+        return writeJson(w, snap)
+    case protocol.SnapshotListCommandID:
+        return h.query(w, auth.ActionList, func(p string) (interface{}, error) {
+            return st.Snapshots(p)
+        })
+    }
+
+    //其他操作检查在快照根目录上的权限
+    actions := map[int16]int{
+        protocol.SnapshotAddCommandID:     auth.ActionWrite,
+        protocol.SnapshotCommitCommandID:  auth.ActionWrite,
+        protocol.SnapshotShowCommandID:    auth.ActionList,
+        protocol.SnapshotRestoreCommandID: auth.ActionRead,
+        protocol.SnapshotDeleteCommandID:  auth.ActionDelete,
+    }
+    if h.ctx.Identity() == nil {
+        return protocol.NewError(protocol.StatusAuthRequired, "authentication required")
+    }
+    snap, err := st.Snapshot(req.ID)
+    if err != nil {
+        return fileError(err)
+    }
+    if _, err := h.checkPath(snap.Path, actions[h.header.Reserve]); err != nil {
+        return err
+    }
+
+    var ret interface{}
+    switch h.header.Reserve {
+    case protocol.SnapshotAddCommandID:
+        for i := range req.Files {
+            p, err := h.checkPath(req.Files[i].Path, auth.ActionRead)
+            if err != nil {
+                return err
+            }
+            req.Files[i].Path = p
+        }
+        ret, err = st.AddSnapshot(snap.ID, req.Files)
+    case protocol.SnapshotCommitCommandID:
+        ret, err = st.CommitSnapshot(snap.ID)
+    case protocol.SnapshotShowCommandID:
+        ret = snap
+    case protocol.SnapshotRestoreCommandID:
+        target := snap.Path
+        if req.Target != "" {
+            target = req.Target
+        }
+        if target, err = h.checkPath(target, auth.ActionWrite); err != nil {
+            return err
+        }
+        ret, err = st.RestoreSnapshot(snap.ID, target)
+    case protocol.SnapshotDeleteCommandID:
+        if err := st.DeleteSnapshot(snap.ID); err != nil {
+            return fileError(err)
+        }
+        return w(0, bytes.NewReader(nil))
+    }
+    if err != nil {
+        return fileError(err)
+    }
+    return writeJson(w, ret)
+}
+
+func (h *binaryHandler) query(w transport.PackageWriter, action int, f func(p string) (interface{}, error)) error {
+    req := model.FileRequest{}
+    if err := json.Unmarshal(h.buf.Bytes(), &req); err != nil {
+        return protocol.NewError(protocol.StatusBadRequest, err.Error())
+    }
+    p, err := h.checkPath(req.Path, action)
+    if err != nil {
+        return err
+    }
+
+    ret, err := f(p)
+    if err != nil {
+        return fileError(err)
+    }
+    return writeJson(w, ret)
+}
+
+//解析请求路径并检查连接身份的权限
+func (h *binaryHandler) checkPath(p string, action int) (string, error) {
+    identity := h.ctx.Identity()
+    if identity == nil {
+        return "", protocol.NewError(protocol.StatusAuthRequired, "authentication required")
+    }
+    //token或api key被吊销、过期后立即失效
+    if info, ok := identity.Credential.(model.AuthInfo); ok {
+        claims, ok := h.rest.verify(info.Token, info.ApiKey)
+        if !ok || claims.User != identity.Username {
+            return "", protocol.NewError(protocol.StatusAuthFailed, errBinaryAuth.Error())
+        }
+    }
+    //用户被删除或禁用后立即失效
+    u, ok := h.rest.userMgr.Get(identity.Username)
+    if !ok || u.Disabled {
+        return "", protocol.NewError(protocol.StatusAuthFailed, errBinaryAuth.Error())
+    }
+
+    p, err := storage.Clean(auth.Resolve(u.Username, p))
+    if err != nil {
+        return "", fileError(err)
+    }
+    if err := auth.Check(u, action, p); err != nil {
+        return "", protocol.NewError(protocol.StatusPermissionDenied, err.Error())
+    }
+    if err := auth.CheckScope(identity.Scopes, identity.Path, action, p); err != nil {
+        return "", protocol.NewError(protocol.StatusPermissionDenied, err.Error())
+    }
+    return p, nil
+}
+
+func fileError(err error) error {
+    switch err {
+    case storage.ErrNotFound:
+        return protocol.NewError(protocol.StatusNotFound, err.Error())
+    case storage.ErrInvalidPath, storage.ErrIsDir, storage.ErrChecksum, storage.ErrSnapshotCommitted:
+        return protocol.NewError(protocol.StatusBadRequest, err.Error())
+    case storage.ErrSnapshotNotFound:
+        return protocol.NewError(protocol.StatusNotFound, err.Error())
+    }
+    return protocol.NewError(protocol.StatusError, err.Error())
+}
+
+func writeJson(w transport.PackageWriter, v interface{}) error {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return protocol.NewError(protocol.StatusError, err.Error())
+    }
+    return w(int64(len(data)), bytes.NewReader(data))
+}
@@ -0,0 +1,143 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package handler
+
+import (
+    "citron-repo/config"
+    "citron-repo/errcode"
+    "citron-repo/model"
+    "citron-repo/storage"
+    "github.com/gin-gonic/gin"
+    "github.com/xfali/goutils/log"
+    "net/http"
+)
+
+//返回当前配置，密码及密钥不返回
+func (rest *restfulApi) GetConfig(ctx *gin.Context) {
+    conf := rest.config()
+    conf.Password = ""
+    for i := range conf.Token.Keys {
+        conf.Token.Keys[i].Secret = ""
+    }
+    for i := range conf.Encryption.Keys {
+        conf.Encryption.Keys[i].Secret = ""
+    }
+    for i := range conf.Replication.Replicas {
+        conf.Replication.Replicas[i].ApiKey = ""
+        conf.Replication.Replicas[i].Password = ""
+    }
+    for i := range conf.Users {
+        conf.Users[i].Password = ""
+    }
+    ctx.JSON(http.StatusOK, errcode.Ok(conf))
+}
+
+//body中未包含的配置项保持不变，密码及密钥为空时保留原值（id/用户名相同）
+//校验通过后写入配置文件并替换当前配置
+func (rest *restfulApi) Config(ctx *gin.Context) {
+    old := rest.config()
+    conf := old
+    conf.Token.Keys = nil
+    conf.Encryption.Keys = nil
+    conf.Replication.Replicas = nil
+    conf.Users = nil
+    err := ctx.Bind(&conf)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, errcode.ConfigError)
+        return
+    }
+    keepSecrets(old, &conf)
+
+    err = rest.configMgr.Update(conf)
+    if err != nil {
+        if verr, ok := err.(config.ValidationError); ok {
+            ctx.JSON(http.StatusBadRequest, errcode.WithMsg(errcode.ConfigError, verr.Error()))
+            return
+        }
+        log.Error("save config failed: %v", err)
+        ctx.JSON(http.StatusInternalServerError, errcode.ConfigSaveFailed)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.OK)
+}
+
+//使用当前主密钥重新加密所有数据密钥，更换主密钥后调用，完成后可以删除旧的主密钥
+func (rest *restfulApi) RotateKeys(ctx *gin.Context) {
+    ret, err := rest.storage.RotateKeys()
+    if err != nil {
+        if err == storage.ErrNoMasterKey {
+            ctx.JSON(http.StatusBadRequest, errcode.WithMsg(errcode.ConfigError, err.Error()))
+            return
+        }
+        log.Error("rotate master keys failed: %v", err)
+        ctx.JSON(http.StatusInternalServerError, errcode.KeyRotateFailed)
+        return
+    }
+    if len(ret.Failed) > 0 {
+        log.Warn("master keys of %d files not found: %v", len(ret.Failed), ret.Failed)
+    }
+    ctx.JSON(http.StatusOK, errcode.Ok(ret))
+}
+
+func keepSecrets(old model.Config, conf *model.Config) {
+    if conf.Password == "" && conf.Username == old.Username {
+        conf.Password = old.Password
+    }
+
+    if conf.Token.Keys == nil {
+        conf.Token.Keys = old.Token.Keys
+    }
+    secrets := map[string]string{}
+    for _, k := range old.Token.Keys {
+        secrets[k.ID] = k.Secret
+    }
+    for i, k := range conf.Token.Keys {
+        if k.Secret == "" {
+            conf.Token.Keys[i].Secret = secrets[k.ID]
+        }
+    }
+
+    if conf.Encryption.Keys == nil {
+        conf.Encryption.Keys = old.Encryption.Keys
+    }
+    masters := map[string]string{}
+    for _, k := range old.Encryption.Keys {
+        masters[k.ID] = k.Secret
+    }
+    for i, k := range conf.Encryption.Keys {
+        if k.Secret == "" && k.File == "" {
+            conf.Encryption.Keys[i].Secret = masters[k.ID]
+        }
+    }
+
+    if conf.Replication.Replicas == nil {
+        conf.Replication.Replicas = old.Replication.Replicas
+    }
+    replicas := map[string]model.ReplicaConfig{}
+    for _, r := range old.Replication.Replicas {
+        replicas[r.Name] = r
+    }
+    for i, r := range conf.Replication.Replicas {
+        if r.ApiKey == "" && r.Password == "" {
+            conf.Replication.Replicas[i].ApiKey = replicas[r.Name].ApiKey
+            conf.Replication.Replicas[i].Password = replicas[r.Name].Password
+        }
+    }
+
+    if conf.Users == nil {
+        conf.Users = old.Users
+    }
+    passwords := map[string]string{}
+    for _, u := range old.Users {
+        passwords[u.Username] = u.Password
+    }
+    for i, u := range conf.Users {
+        if u.Password == "" {
+            conf.Users[i].Password = passwords[u.Username]
+        }
+    }
+}
@@ -0,0 +1,153 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package handler
+
+import (
+    "citron-repo/auth"
+    "citron-repo/errcode"
+    "citron-repo/model"
+    "citron-repo/storage"
+    "github.com/gin-gonic/gin"
+    "github.com/xfali/goutils/log"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+//解析query中的path并检查权限，path为相对路径时相对于用户的备份根目录
+func (rest *restfulApi) queryPath(ctx *gin.Context, action int) (string, bool) {
+    return rest.checkPath(ctx, action, ctx.Query("path"))
+}
+
+//解析路径并检查权限，失败时写入响应
+func (rest *restfulApi) checkPath(ctx *gin.Context, action int, p string) (string, bool) {
+    p, err := storage.Clean(auth.Resolve(CurrentUser(ctx).Username, p))
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, errcode.FileParamError)
+        return "", false
+    }
+    if Check(ctx, action, p) != nil {
+        ctx.JSON(http.StatusForbidden, errcode.PermissionDenied)
+        return "", false
+    }
+    return p, true
+}
+
+func writeFileError(ctx *gin.Context, err error) {
+    switch err {
+    case storage.ErrNotFound:
+        ctx.JSON(http.StatusNotFound, errcode.FileNotFound)
+    case storage.ErrInvalidPath, storage.ErrIsDir:
+        ctx.JSON(http.StatusBadRequest, errcode.FileParamError)
+    case storage.ErrChecksum:
+        ctx.JSON(http.StatusBadRequest, errcode.FileChecksumError)
+    case storage.ErrSnapshotNotFound:
+        ctx.JSON(http.StatusNotFound, errcode.SnapshotNotFound)
+    case storage.ErrSnapshotCommitted:
+        ctx.JSON(http.StatusConflict, errcode.SnapshotCommitted)
+    default:
+        ctx.JSON(http.StatusInternalServerError, errcode.FileReadFailed)
+    }
+}
+
+//query: path, version（可选，默认最新版本）
+//响应header包含CITRON-CHECKSUM、CITRON-VERSION、CITRON-MODTIME
+func (rest *restfulApi) Download(ctx *gin.Context) {
+    p, ok := rest.queryPath(ctx, auth.ActionRead)
+    if !ok {
+        return
+    }
+    r, info, err := rest.storage.Open(p, ctx.Query("version"))
+    if err != nil {
+        writeFileError(ctx, err)
+        return
+    }
+    defer r.Close()
+
+    ctx.DataFromReader(http.StatusOK, info.Size, "application/octet-stream", r, map[string]string{
+        CITRON_CHECKSUM: info.Checksum,
+        CITRON_VERSION:  info.Version,
+        CITRON_MODTIME:  info.ModTime.Format(time.RFC3339Nano),
+        CITRON_FILENAME: info.FileName,
+        CITRON_MODE:     strconv.FormatUint(uint64(info.Mode), 8),
+    })
+}
+
+//query: path
+func (rest *restfulApi) Stat(ctx *gin.Context) {
+    p, ok := rest.queryPath(ctx, auth.ActionList)
+    if !ok {
+        return
+    }
+    info, err := rest.storage.Stat(p)
+    if err != nil {
+        writeFileError(ctx, err)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.Ok(info))
+}
+
+//query: path
+func (rest *restfulApi) List(ctx *gin.Context) {
+    p, ok := rest.queryPath(ctx, auth.ActionList)
+    if !ok {
+        return
+    }
+    infos, err := rest.storage.List(p)
+    if err != nil {
+        log.Debug("list %s failed: %v", p, err)
+        writeFileError(ctx, err)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.Ok(infos))
+}
+
+//query: path
+func (rest *restfulApi) Versions(ctx *gin.Context) {
+    p, ok := rest.queryPath(ctx, auth.ActionList)
+    if !ok {
+        return
+    }
+    infos, err := rest.storage.Versions(p)
+    if err != nil {
+        writeFileError(ctx, err)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.Ok(infos))
+}
+
+//query: path, versions（保留的版本数）, maxAge（如720h）
+func (rest *restfulApi) Prune(ctx *gin.Context) {
+    p, ok := rest.queryPath(ctx, auth.ActionDelete)
+    if !ok {
+        return
+    }
+    r := model.Retention{}
+    if v := ctx.Query("versions"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 0 {
+            ctx.JSON(http.StatusBadRequest, errcode.FileParamError)
+            return
+        }
+        r.Versions = n
+    }
+    if v := ctx.Query("maxAge"); v != "" {
+        d, err := time.ParseDuration(v)
+        if err != nil || d < 0 {
+            ctx.JSON(http.StatusBadRequest, errcode.FileParamError)
+            return
+        }
+        r.MaxAge = model.Duration(d)
+    }
+    ret, err := rest.storage.Prune(p, r, time.Now())
+    if err != nil {
+        log.Warn("prune %s failed: %v", p, err)
+        writeFileError(ctx, err)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.Ok(ret))
+}
@@ -0,0 +1,35 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package handler
+
+import (
+    "citron-repo/errcode"
+    "citron-repo/replica"
+    "github.com/gin-gonic/gin"
+    "github.com/xfali/goutils/log"
+    "net/http"
+)
+
+//所有副本的复制状态（未复制的记录数、延迟、最近的错误）
+func (rest *restfulApi) ReplicaStatus(ctx *gin.Context) {
+    ctx.JSON(http.StatusOK, errcode.Ok(rest.replicator.Status()))
+}
+
+//比较所有文件的最新版本与副本中的内容，文件较多时需要较长时间
+func (rest *restfulApi) VerifyReplica(ctx *gin.Context) {
+    ret, err := rest.replicator.Verify(ctx.Param("name"))
+    if err != nil {
+        if err == replica.ErrReplicaNotFound {
+            ctx.JSON(http.StatusNotFound, errcode.ReplicaNotFound)
+            return
+        }
+        log.Warn("verify replica %s failed: %v", ctx.Param("name"), err)
+        ctx.JSON(http.StatusInternalServerError, errcode.WithMsg(errcode.ReplicaVerifyFailed, err.Error()))
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.Ok(ret))
+}
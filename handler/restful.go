@@ -7,50 +7,231 @@
 package handler
 
 import (
+    "citron-repo/apikey"
+    "citron-repo/auth"
+    "citron-repo/config"
     "citron-repo/errcode"
     "citron-repo/model"
+    "citron-repo/replica"
+    "citron-repo/storage"
     "citron-repo/token"
+    "citron-repo/user"
     "github.com/gin-gonic/gin"
     "github.com/xfali/goutils/log"
-    "io"
     "net/http"
-    "os"
     "path/filepath"
+    "reflect"
+    "strconv"
+    "sync"
     "time"
 )
 
 const (
-    LOGIN_EXPIRE_TIME = 3 * time.Hour
-    FILE_EXPIRE_TIME  = 15 * time.Second
+    CITRON_TOKEN         = "CITRON-TOKEN"
+    CITRON_API_KEY       = "CITRON-API-KEY"
+    CITRON_REFRESH_TOKEN = "CITRON-REFRESH-TOKEN"
+    CITRON_FILE_TOKEN    = "CITRON-FILE-TOKEN"
+    CITRON_REL           = "CITRON-REL"
+    CITRON_FILENAME      = "CITRON-FILENAME"
+    //上传时可选，文件修改时间（RFC3339）
+    CITRON_MODTIME = "CITRON-MODTIME"
+    //上传时可选，文件权限（8进制，如0644）
+    CITRON_MODE = "CITRON-MODE"
+    //上传时可选，文件sha256，服务端校验内容；下载时返回
+    CITRON_CHECKSUM = "CITRON-CHECKSUM"
+    CITRON_VERSION  = "CITRON-VERSION"
 
-    CITRON_TOKEN      = "CITRON-TOKEN"
-    CITRON_FILE_TOKEN = "CITRON-FILE-TOKEN"
-    CITRON_REL        = "CITRON-REL"
-    CITRON_FILENAME   = "CITRON-FILENAME"
+    MULTIPART_OVERHEAD = 64 * 1024
+
+    //context中保存当前登录用户的key
+    CONTEXT_USER = "citron.user"
+    //context中保存当前登录token信息的key
+    CONTEXT_CLAIMS = "citron.claims"
 )
 
 type restfulApi struct {
-    conf     model.Config
-    tokenMgr *token.TokenMgr
+    configMgr *config.Manager
+    tokenMgr  *token.TokenMgr
+    userMgr   *user.UserMgr
+    keyMgr    *apikey.KeyMgr
+    storage   *storage.Storage
+    scrubber  *scrubber
+    //复制到副本
+    replicator *replica.Replicator
+    //定期清理回收站
+    purgeStop chan struct{}
+    purgeWait sync.WaitGroup
+}
+
+type RestOpt func(rest *restfulApi)
+
+//设置后忽略NewRestful的conf参数
+func SetConfigMgr(configMgr *config.Manager) RestOpt {
+    return func(rest *restfulApi) {
+        rest.configMgr = configMgr
+    }
+}
+
+func SetTokenMgr(tokenMgr *token.TokenMgr) RestOpt {
+    return func(rest *restfulApi) {
+        rest.tokenMgr = tokenMgr
+    }
+}
+
+func SetUserMgr(userMgr *user.UserMgr) RestOpt {
+    return func(rest *restfulApi) {
+        rest.userMgr = userMgr
+    }
 }
 
-func NewRestful(conf model.Config) *restfulApi {
-    ret := &restfulApi{
-        conf:     conf,
-        tokenMgr: token.New(),
+func SetApiKeyMgr(keyMgr *apikey.KeyMgr) RestOpt {
+    return func(rest *restfulApi) {
+        rest.keyMgr = keyMgr
+    }
+}
+
+//默认使用配置中的BackupDir
+func SetStorage(storage *storage.Storage) RestOpt {
+    return func(rest *restfulApi) {
+        rest.storage = storage
+    }
+}
+
+func NewRestful(conf model.Config, opts ...RestOpt) *restfulApi {
+    ret := &restfulApi{}
+    for i := range opts {
+        opts[i](ret)
+    }
+    if ret.configMgr == nil {
+        ret.configMgr = config.NewManager(conf)
+    }
+    if ret.tokenMgr == nil {
+        ret.tokenMgr = token.New()
+    }
+    if ret.userMgr == nil {
+        ret.userMgr = user.New()
+    }
+    if ret.keyMgr == nil {
+        ret.keyMgr = apikey.New()
+    }
+    if ret.storage == nil {
+        ret.storage = storage.New(ret.configMgr.Get().BackupDir)
+    }
+    ret.scrubber = newScrubber()
+    ret.replicator = replica.New(ret.storage)
+    if err := ret.replicator.Update(ret.configMgr.Get().Replication); err != nil {
+        log.Error("start replication failed: %v", err)
     }
+    ret.initUsers(ret.configMgr.Get())
+    ret.storage.SetCompression(ret.configMgr.Get().Compression)
+    if err := ret.storage.SetEncryption(ret.configMgr.Get().Encryption); err != nil {
+        log.Error("storage encryption disabled, uploads are rejected: %v", err)
+    }
+    ret.configMgr.OnChange(func(old, new model.Config) {
+        ret.initUsers(new)
+        ret.storage.SetCompression(new.Compression)
+        if err := ret.storage.SetEncryption(new.Encryption); err != nil {
+            log.Error("storage encryption disabled, uploads are rejected: %v", err)
+        }
+        if !reflect.DeepEqual(old.Replication, new.Replication) {
+            if err := ret.replicator.Update(new.Replication); err != nil {
+                log.Error("restart replication failed: %v", err)
+            }
+        }
+        if old.Scrub.Schedule != new.Scrub.Schedule {
+            select {
+            case ret.scrubber.reset <- struct{}{}:
+            default:
+            }
+        }
+    })
+    ret.scrubber.wait.Add(1)
+    go ret.scrubLoop()
+    ret.purgeStop = make(chan struct{})
+    ret.purgeWait.Add(1)
+    go ret.purgeLoop()
     return ret
 }
 
+//配置中的用户名密码作为初始管理员，与配置中的其他用户一样仅在该用户不存在时创建
+func (rest *restfulApi) initUsers(conf model.Config) {
+    users := conf.Users
+    if conf.Username != "" {
+        admin := model.UserInfo{Username: conf.Username, Password: conf.Password, Role: model.RoleAdmin}
+        users = append([]model.UserInfo{admin}, users...)
+    }
+    for _, info := range users {
+        if _, ok := rest.userMgr.Get(info.Username); ok {
+            continue
+        }
+        if err := rest.userMgr.Create(info); err != nil {
+            log.Error("create user %s failed: %v", info.Username, err)
+        }
+    }
+}
+
+//当前生效的配置
+func (rest *restfulApi) config() model.Config {
+    return rest.configMgr.Get()
+}
+
 func (rest *restfulApi) Close() {
+    close(rest.scrubber.stop)
+    rest.scrubber.wait.Wait()
+    close(rest.purgeStop)
+    rest.purgeWait.Wait()
+    rest.replicator.Close()
     rest.tokenMgr.Close()
+    rest.keyMgr.Close()
 }
 
 func (rest *restfulApi) Api(engine *gin.Engine) {
-    engine.Handle(http.MethodPost, "/meta", rest.CreateMeta)
-    engine.Handle(http.MethodPut, "/config", rest.Config)
     engine.Handle(http.MethodPost, "/login", rest.Login)
-    engine.Handle(http.MethodPost, "/file", rest.upload)
+    engine.Handle(http.MethodPost, "/token/refresh", rest.Refresh)
+
+    group := engine.Group("/", rest.Authorize)
+    group.Handle(http.MethodPost, "/logout", rest.Logout)
+    group.Handle(http.MethodGet, "/apikey", rest.ListApiKey)
+    group.Handle(http.MethodPost, "/apikey", rest.CreateApiKey)
+    group.Handle(http.MethodDelete, "/apikey/:id", rest.RevokeApiKey)
+    group.Handle(http.MethodPost, "/meta", rest.CreateMeta)
+    group.Handle(http.MethodGet, "/config", Require(auth.ActionConfig), rest.GetConfig)
+    group.Handle(http.MethodPut, "/config", Require(auth.ActionConfig), rest.Config)
+    group.Handle(http.MethodPost, "/encryption/rotate", Require(auth.ActionConfig), rest.RotateKeys)
+    group.Handle(http.MethodGet, "/scrub", Require(auth.ActionConfig), rest.LastScrub)
+    group.Handle(http.MethodPost, "/scrub", Require(auth.ActionConfig), rest.Scrub)
+    group.Handle(http.MethodGet, "/replication", Require(auth.ActionConfig), rest.ReplicaStatus)
+    group.Handle(http.MethodPost, "/replication/:name/verify", Require(auth.ActionConfig), rest.VerifyReplica)
+    group.Handle(http.MethodPost, "/file", rest.upload)
+    group.Handle(http.MethodGet, "/file", rest.Download)
+    group.Handle(http.MethodGet, "/stat", rest.Stat)
+    group.Handle(http.MethodGet, "/list", rest.List)
+    group.Handle(http.MethodGet, "/versions", rest.Versions)
+    group.Handle(http.MethodDelete, "/versions", rest.Prune)
+    group.Handle(http.MethodDelete, "/file", rest.Delete)
+    group.Handle(http.MethodGet, "/trash", rest.ListTrash)
+    group.Handle(http.MethodDelete, "/trash", rest.PurgeTrash)
+    group.Handle(http.MethodPost, "/trash/restore", rest.RestoreTrash)
+    group.Handle(http.MethodGet, "/snapshot", rest.ListSnapshot)
+    group.Handle(http.MethodPost, "/snapshot", rest.BeginSnapshot)
+    group.Handle(http.MethodGet, "/snapshot/:id", rest.ShowSnapshot)
+    group.Handle(http.MethodDelete, "/snapshot/:id", rest.DeleteSnapshot)
+    group.Handle(http.MethodPost, "/snapshot/:id/files", rest.AddSnapshot)
+    group.Handle(http.MethodPost, "/snapshot/:id/commit", rest.CommitSnapshot)
+    group.Handle(http.MethodPost, "/snapshot/:id/restore", rest.RestoreSnapshot)
+    group.Handle(http.MethodGet, "/snapshot/:id/diff", rest.DiffSnapshot)
+
+    admin := group.Group("/admin", Require(auth.ActionUser))
+    admin.Handle(http.MethodGet, "/user", rest.ListUser)
+    admin.Handle(http.MethodPost, "/user", rest.CreateUser)
+    admin.Handle(http.MethodPut, "/user/:username/disable", rest.DisableUser)
+    admin.Handle(http.MethodPut, "/user/:username/enable", rest.EnableUser)
+    admin.Handle(http.MethodPut, "/user/:username/password", rest.ResetPassword)
+    admin.Handle(http.MethodPut, "/user/:username/role", rest.SetRole)
+    admin.Handle(http.MethodGet, "/user/:username/token", rest.ListToken)
+    admin.Handle(http.MethodDelete, "/user/:username/token", rest.RevokeToken)
+    admin.Handle(http.MethodGet, "/user/:username/apikey", rest.ListUserApiKey)
+    admin.Handle(http.MethodDelete, "/user/:username/apikey/:id", rest.RevokeUserApiKey)
 }
 
 func (rest *restfulApi) Login(ctx *gin.Context) {
@@ -61,99 +242,220 @@ func (rest *restfulApi) Login(ctx *gin.Context) {
         return
     }
 
-    if rest.conf.Username == login.Username && rest.conf.Password == login.Password {
-        ctx.JSON(http.StatusOK, errcode.Ok(rest.tokenMgr.CreateToken(login.Username, LOGIN_EXPIRE_TIME)))
+    for _, scope := range login.Scopes {
+        if !auth.ValidScope(scope) {
+            ctx.JSON(http.StatusBadRequest, errcode.LoginError)
+            return
+        }
+    }
+
+    u, err := rest.userMgr.Authenticate(login.Username, login.Password)
+    if err != nil {
+        ctx.JSON(http.StatusUnauthorized, errcode.AuthError)
+        return
     }
 
-    ctx.JSON(http.StatusUnauthorized, errcode.AuthError)
-    return
+    claims := token.Claims{Type: token.LoginToken, User: u.Username, Scopes: login.Scopes}
+    if login.Path != "" {
+        claims.Path = auth.Resolve(u.Username, login.Path)
+    }
+    rest.createSession(ctx, claims)
 }
 
-func checkToken(ctx *gin.Context) bool {
-    token := ctx.GetHeader(CITRON_TOKEN)
-    if token == "" {
-        ctx.JSON(http.StatusUnauthorized, errcode.AuthError)
-        return false
+//gin中间件：校验header中的CITRON-TOKEN（登录token）或CITRON-API-KEY
+//token不存在、已过期或不是登录token时返回errcode.AuthError，校验通过则将用户保存在context中
+func (rest *restfulApi) Authorize(ctx *gin.Context) {
+    claims, ok := rest.verify(ctx.GetHeader(CITRON_TOKEN), ctx.GetHeader(CITRON_API_KEY))
+    if !ok {
+        ctx.AbortWithStatusJSON(http.StatusUnauthorized, errcode.AuthError)
+        return
+    }
+
+    //用户被删除或禁用后token立即失效
+    u, ok := rest.userMgr.Get(claims.User)
+    if !ok || u.Disabled {
+        ctx.AbortWithStatusJSON(http.StatusUnauthorized, errcode.AuthError)
+        return
+    }
+
+    ctx.Set(CONTEXT_USER, u)
+    ctx.Set(CONTEXT_CLAIMS, claims)
+    ctx.Next()
+}
+
+//校验登录token或api key，api key的信息转换为token.ApiKeyToken类型的Claims
+func (rest *restfulApi) verify(loginToken, key string) (token.Claims, bool) {
+    if loginToken != "" {
+        claims, ok := rest.tokenMgr.Get(loginToken)
+        if !ok || claims.Type != token.LoginToken {
+            return token.Claims{}, false
+        }
+        return claims, true
+    }
+    if key != "" {
+        k, err := rest.keyMgr.Verify(key)
+        if err != nil {
+            return token.Claims{}, false
+        }
+        return token.Claims{
+            Type:     token.ApiKeyToken,
+            User:     k.User,
+            Scopes:   k.Scopes,
+            Path:     k.Path,
+            ExpireAt: k.ExpireTime,
+        }, true
+    }
+    return token.Claims{}, false
+}
+
+//获得当前登录用户，必须在Authorize之后调用
+func CurrentUser(ctx *gin.Context) model.User {
+    u, _ := ctx.Get(CONTEXT_USER)
+    ret, _ := u.(model.User)
+    return ret
+}
+
+//获得当前登录token的信息，必须在Authorize之后调用
+func CurrentClaims(ctx *gin.Context) token.Claims {
+    c, _ := ctx.Get(CONTEXT_CLAIMS)
+    ret, _ := c.(token.Claims)
+    return ret
+}
+
+//检查当前用户及登录token是否允许在路径p上执行action，必须在Authorize之后调用
+func Check(ctx *gin.Context, action int, p string) error {
+    if err := auth.Check(CurrentUser(ctx), action, p); err != nil {
+        return err
+    }
+    claims := CurrentClaims(ctx)
+    return auth.CheckScope(claims.Scopes, claims.Path, action, p)
+}
+
+//gin中间件：检查当前用户在仓库根路径上是否有action权限，必须在Authorize之后使用
+func Require(action int) gin.HandlerFunc {
+    return func(ctx *gin.Context) {
+        if Check(ctx, action, "/") != nil {
+            ctx.AbortWithStatusJSON(http.StatusForbidden, errcode.PermissionDenied)
+            return
+        }
+        ctx.Next()
     }
-    return true
 }
 
 //header 包含CITRON-TOKEN（登录token）
 //header 包含CITRON-REL（相对目录)
 //header 包含CITRON-FILENAME（文件名称)
 func (rest *restfulApi) CreateMeta(ctx *gin.Context) {
-    if !checkToken(ctx) {
-        return
-    }
-
     rel := ctx.GetHeader(CITRON_REL)
     filename := ctx.GetHeader(CITRON_FILENAME)
     if filename == "" {
-        ctx.JSON(http.StatusBadRequest, errcode.FileTokenMissing)
+        ctx.JSON(http.StatusBadRequest, errcode.FilenamNotFound)
         return
     }
 
-    path := filepath.Join(rest.conf.BackupDir, rel, filename)
-    fileToken := rest.tokenMgr.CreateToken(path, FILE_EXPIRE_TIME)
-
-    ctx.JSON(http.StatusOK, errcode.Ok(fileToken))
-}
-
-func (rest *restfulApi) Config(ctx *gin.Context) {
-    if !checkToken(ctx) {
+    u := CurrentUser(ctx)
+    path, err := storage.Clean(auth.Resolve(u.Username, filepath.ToSlash(rel), filename))
+    if err != nil || path == "/" {
+        ctx.JSON(http.StatusBadRequest, errcode.FileParamError)
         return
     }
-
-    conf := model.Config{}
-    err := ctx.Bind(&conf)
+    if Check(ctx, auth.ActionWrite, path) != nil {
+        ctx.JSON(http.StatusForbidden, errcode.PermissionDenied)
+        return
+    }
+    claims := token.Claims{Type: token.FileToken, User: u.Username, Path: path}
+    fileToken, err := rest.tokenMgr.CreateToken(claims, rest.config().Token.FileExpire.Duration())
     if err != nil {
-        ctx.JSON(http.StatusBadRequest, errcode.ConfigError)
+        log.Error("create file token failed: %v", err)
+        ctx.JSON(http.StatusInternalServerError, errcode.TokenError)
         return
     }
 
-    rest.conf = conf
-    ctx.JSON(http.StatusOK, errcode.OK)
+    ctx.JSON(http.StatusOK, errcode.Ok(fileToken))
 }
 
 //header 包含CITRON-TOKEN（登录token）
-//header 包含CITRON-FILE-TOKEN（文件上传token)
+//header 包含CITRON-FILE-TOKEN（文件上传token），上传成功后失效
 func (rest *restfulApi) upload(ctx *gin.Context) {
-    if !checkToken(ctx) {
-        return
-    }
-
     fileToken := ctx.GetHeader(CITRON_FILE_TOKEN)
     if fileToken == "" {
         ctx.JSON(http.StatusUnauthorized, errcode.FileTokenMissing)
         return
     }
 
-    path := rest.tokenMgr.Get(fileToken)
-    if path == "" {
+    //读取body前取出token，同一token的并发上传只有一个能继续，上传失败时恢复以便重试
+    claims, ok := rest.tokenMgr.Take(fileToken, token.FileToken)
+    if !ok {
         ctx.JSON(http.StatusUnauthorized, errcode.FileTokenError)
         return
     }
-
-    file, _, err := ctx.Request.FormFile("file")
-    if err != nil {
-        ctx.JSON(http.StatusBadRequest, errcode.FileUploadFailed)
+    committed := false
+    defer func() {
+        if committed {
+            return
+        }
+        if err := rest.tokenMgr.Restore(fileToken, claims); err != nil {
+            log.Warn("restore file token of %s failed: %v", claims.Path, err)
+        }
+    }()
+    //文件token必须由当前登录用户创建
+    u := CurrentUser(ctx)
+    if claims.User != u.Username {
+        ctx.JSON(http.StatusUnauthorized, errcode.AuthError)
         return
     }
-    //写入文件
-    out, err := os.Create(path)
+    //创建token后权限可能已被修改
+    if Check(ctx, auth.ActionWrite, claims.Path) != nil {
+        ctx.JSON(http.StatusForbidden, errcode.PermissionDenied)
+        return
+    }
+    maxSize := rest.config().Limit.MaxUploadSize
+    if maxSize > 0 {
+        //multipart的boundary及header额外占用的空间
+        ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxSize+MULTIPART_OVERHEAD)
+    }
+    file, header, err := ctx.Request.FormFile("file")
     if err != nil {
-        log.Error("create file failed")
+        if maxSize > 0 && ctx.Request.ContentLength > maxSize {
+            ctx.JSON(http.StatusRequestEntityTooLarge, errcode.FileTooLarge)
+            return
+        }
         ctx.JSON(http.StatusBadRequest, errcode.FileUploadFailed)
         return
     }
+    defer file.Close()
+    if maxSize > 0 && header.Size > maxSize {
+        ctx.JSON(http.StatusRequestEntityTooLarge, errcode.FileTooLarge)
+        return
+    }
 
-    defer out.Close()
-    _, err = io.Copy(out, file)
+    info := model.FileInfo{
+        FilePath: claims.Path,
+        Checksum: ctx.GetHeader(CITRON_CHECKSUM),
+    }
+    if t := ctx.GetHeader(CITRON_MODTIME); t != "" {
+        info.ModTime, err = time.Parse(time.RFC3339Nano, t)
+        if err != nil {
+            ctx.JSON(http.StatusBadRequest, errcode.FileParamError)
+            return
+        }
+    }
+    if m := ctx.GetHeader(CITRON_MODE); m != "" {
+        mode, err := strconv.ParseUint(m, 8, 32)
+        if err != nil {
+            ctx.JSON(http.StatusBadRequest, errcode.FileParamError)
+            return
+        }
+        info.Mode = uint32(mode)
+    }
+    //写入文件
+    info, err = rest.storage.Put(info, file)
     if err != nil {
-        log.Error("copy file failed")
-        ctx.JSON(http.StatusBadRequest, errcode.FileUploadFailed)
+        log.Error("save file %s failed: %v", claims.Path, err)
+        writeFileError(ctx, err)
         return
     }
+    committed = true
 
-    ctx.JSON(http.StatusOK, errcode.OK)
+    ctx.JSON(http.StatusOK, errcode.Ok(info))
 }
@@ -0,0 +1,132 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package handler
+
+import (
+    "citron-repo/errcode"
+    "citron-repo/model"
+    "citron-repo/schedule/cron"
+    "errors"
+    "github.com/gin-gonic/gin"
+    "github.com/xfali/goutils/log"
+    "net/http"
+    "sync"
+    "time"
+)
+
+var errScrubRunning = errors.New("scrub already running")
+
+//完整性检查，同一时间只执行一次，保存最近一次的结果
+type scrubber struct {
+    lock    sync.Mutex
+    running bool
+    last    *model.ScrubReport
+
+    stop chan struct{}
+    //计划变化时重新计算下一次执行时间
+    reset chan struct{}
+    wait  sync.WaitGroup
+}
+
+func newScrubber() *scrubber {
+    return &scrubber{stop: make(chan struct{}), reset: make(chan struct{}, 1)}
+}
+
+//按配置的计划执行检查，直到Close
+func (rest *restfulApi) scrubLoop() {
+    defer rest.scrubber.wait.Done()
+    for {
+        var timer *time.Timer
+        var next <-chan time.Time
+        if s := rest.config().Scrub.Schedule; s != "" {
+            sch, err := cron.Parse(s)
+            if err != nil {
+                log.Error("invalid scrub schedule %q: %v", s, err)
+            } else if t := sch.Next(time.Now()); !t.IsZero() {
+                timer = time.NewTimer(time.Until(t))
+                next = timer.C
+            }
+        }
+        select {
+        case <-rest.scrubber.stop:
+            if timer != nil {
+                timer.Stop()
+            }
+            return
+        case <-rest.scrubber.reset:
+            if timer != nil {
+                timer.Stop()
+            }
+        case <-next:
+            if _, err := rest.scrub(); err != nil && err != errScrubRunning {
+                log.Error("scheduled scrub failed: %v", err)
+            }
+        }
+    }
+}
+
+func (rest *restfulApi) scrub() (model.ScrubReport, error) {
+    if !rest.beginScrub() {
+        return model.ScrubReport{}, errScrubRunning
+    }
+    return rest.runScrub()
+}
+
+//标记为正在检查，已经在检查时返回false
+func (rest *restfulApi) beginScrub() bool {
+    sc := rest.scrubber
+    sc.lock.Lock()
+    defer sc.lock.Unlock()
+    if sc.running {
+        return false
+    }
+    sc.running = true
+    return true
+}
+
+//执行检查，必须在beginScrub成功后调用
+func (rest *restfulApi) runScrub() (model.ScrubReport, error) {
+    sc := rest.scrubber
+    ret, err := rest.storage.Scrub(rest.config().Scrub, sc.stop)
+    if ret.Ok() {
+        log.Info("scrub finished: %d files, %d versions checked", ret.Files, ret.Versions)
+    } else {
+        log.Warn("scrub finished: %d missing, %d corrupted, %d orphaned %s",
+            len(ret.Missing), len(ret.Corrupted), len(ret.Orphaned), ret.Error)
+    }
+
+    sc.lock.Lock()
+    sc.running = false
+    sc.last = &ret
+    sc.lock.Unlock()
+    return ret, err
+}
+
+//在后台开始检查并立即返回，检查所有文件可能需要较长时间，结果通过LastScrub获取
+func (rest *restfulApi) Scrub(ctx *gin.Context) {
+    if !rest.beginScrub() {
+        ctx.JSON(http.StatusConflict, errcode.ScrubRunning)
+        return
+    }
+    rest.scrubber.wait.Add(1)
+    go func() {
+        defer rest.scrubber.wait.Done()
+        if _, err := rest.runScrub(); err != nil {
+            log.Error("scrub failed: %v", err)
+        }
+    }()
+    ctx.JSON(http.StatusAccepted, errcode.OK)
+}
+
+//最近一次完成的检查的结果，没有执行过时data为空；检查失败时结果中包含错误信息
+func (rest *restfulApi) LastScrub(ctx *gin.Context) {
+    sc := rest.scrubber
+    sc.lock.Lock()
+    last := sc.last
+    sc.lock.Unlock()
+    ctx.JSON(http.StatusOK, errcode.Ok(last))
+}
@@ -0,0 +1,110 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package handler
+
+import (
+    "citron-repo/errcode"
+    "citron-repo/model"
+    "citron-repo/token"
+    "github.com/gin-gonic/gin"
+    "github.com/xfali/goutils/log"
+    "net/http"
+    "time"
+)
+
+//创建登录token及刷新token，刷新token继承登录token的scope及绑定路径
+func (rest *restfulApi) createSession(ctx *gin.Context, claims token.Claims) {
+    conf := rest.config()
+    claims.Type = token.LoginToken
+    t, err := rest.tokenMgr.CreateToken(claims, conf.Token.LoginExpire.Duration())
+    if err != nil {
+        log.Error("create login token failed: %v", err)
+        ctx.JSON(http.StatusInternalServerError, errcode.TokenError)
+        return
+    }
+
+    claims.Type = token.RefreshToken
+    refresh, err := rest.tokenMgr.CreateToken(claims, conf.Token.RefreshExpire.Duration())
+    if err != nil {
+        rest.tokenMgr.Delete(t)
+        log.Error("create refresh token failed: %v", err)
+        ctx.JSON(http.StatusInternalServerError, errcode.TokenError)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, errcode.Ok(model.LoginResult{
+        Token:        t,
+        ExpireAt:     time.Now().Add(conf.Token.LoginExpire.Duration()),
+        RefreshToken: refresh,
+    }))
+}
+
+//使用刷新token换取新的登录token，原刷新token失效
+//body: {"refreshToken": ""}
+func (rest *restfulApi) Refresh(ctx *gin.Context) {
+    info := model.RefreshInfo{}
+    err := ctx.Bind(&info)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, errcode.AuthError)
+        return
+    }
+
+    claims, ok := rest.tokenMgr.Get(info.RefreshToken)
+    if !ok || claims.Type != token.RefreshToken {
+        ctx.JSON(http.StatusUnauthorized, errcode.AuthError)
+        return
+    }
+    u, ok := rest.userMgr.Get(claims.User)
+    if !ok || u.Disabled {
+        ctx.JSON(http.StatusUnauthorized, errcode.AuthError)
+        return
+    }
+
+    if err := rest.tokenMgr.Delete(info.RefreshToken); err != nil {
+        log.Error("delete refresh token failed: %v", err)
+        ctx.JSON(http.StatusInternalServerError, errcode.TokenError)
+        return
+    }
+    rest.createSession(ctx, claims)
+}
+
+//删除当前登录token，header中包含CITRON-REFRESH-TOKEN时同时删除刷新token
+func (rest *restfulApi) Logout(ctx *gin.Context) {
+    err := rest.tokenMgr.Delete(ctx.GetHeader(CITRON_TOKEN))
+    if err != nil {
+        log.Error("delete login token failed: %v", err)
+        ctx.JSON(http.StatusInternalServerError, errcode.TokenError)
+        return
+    }
+
+    refresh := ctx.GetHeader(CITRON_REFRESH_TOKEN)
+    if refresh != "" {
+        claims, ok := rest.tokenMgr.Get(refresh)
+        if ok && claims.Type == token.RefreshToken && claims.User == CurrentUser(ctx).Username {
+            if err := rest.tokenMgr.Delete(refresh); err != nil {
+                log.Error("delete refresh token failed: %v", err)
+            }
+        }
+    }
+    ctx.JSON(http.StatusOK, errcode.OK)
+}
+
+//列出用户所有服务端存储的有效token，签名的登录token不在其中
+func (rest *restfulApi) ListToken(ctx *gin.Context) {
+    ctx.JSON(http.StatusOK, errcode.Ok(rest.tokenMgr.Sessions(ctx.Param("username"))))
+}
+
+//吊销用户所有的token，包括已签发的签名token
+func (rest *restfulApi) RevokeToken(ctx *gin.Context) {
+    err := rest.tokenMgr.RevokeUser(ctx.Param("username"))
+    if err != nil {
+        log.Error("revoke tokens of user %s failed: %v", ctx.Param("username"), err)
+        ctx.JSON(http.StatusInternalServerError, errcode.TokenError)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.OK)
+}
@@ -0,0 +1,213 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package handler
+
+import (
+    "citron-repo/auth"
+    "citron-repo/errcode"
+    "citron-repo/diff"
+    "citron-repo/model"
+    "citron-repo/storage"
+    "encoding/json"
+    "github.com/gin-gonic/gin"
+    "github.com/xfali/goutils/log"
+    "net/http"
+)
+
+//读取路径参数中的快照并检查在快照根目录上的权限
+func (rest *restfulApi) snapshot(ctx *gin.Context, action int) (model.Snapshot, bool) {
+    snap, err := rest.storage.Snapshot(ctx.Param("id"))
+    if err != nil {
+        writeFileError(ctx, err)
+        return snap, false
+    }
+    if Check(ctx, action, snap.Path) != nil {
+        ctx.JSON(http.StatusForbidden, errcode.PermissionDenied)
+        return snap, false
+    }
+    return snap, true
+}
+
+//body: {"path": "", "from": "", "tags": []}
+func (rest *restfulApi) BeginSnapshot(ctx *gin.Context) {
+    req := model.SnapshotRequest{}
+    if err := ctx.Bind(&req); err != nil {
+        ctx.JSON(http.StatusBadRequest, errcode.SnapshotParamError)
+        return
+    }
+    p, ok := rest.checkPath(ctx, auth.ActionWrite, req.Path)
+    if !ok {
+        return
+    }
+    if req.From == "" {
+        req.From = ctx.ClientIP()
+    }
+    snap, err := rest.storage.BeginSnapshot(model.Snapshot{
+        Path:  p,
+        From:  req.From,
+        Tags:  req.Tags,
+        Owner: CurrentUser(ctx).Username,
+    })
+    if err != nil {
+        log.Warn("begin snapshot %s failed: %v", p, err)
+        writeFileError(ctx, err)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.Ok(snap))
+}
+
+//body: {"files": [{"path": "", "version": ""}]}
+func (rest *restfulApi) AddSnapshot(ctx *gin.Context) {
+    req := model.SnapshotRequest{}
+    if err := ctx.Bind(&req); err != nil {
+        ctx.JSON(http.StatusBadRequest, errcode.SnapshotParamError)
+        return
+    }
+    if _, ok := rest.snapshot(ctx, auth.ActionWrite); !ok {
+        return
+    }
+    for i := range req.Files {
+        p, ok := rest.checkPath(ctx, auth.ActionRead, req.Files[i].Path)
+        if !ok {
+            return
+        }
+        req.Files[i].Path = p
+    }
+    snap, err := rest.storage.AddSnapshot(ctx.Param("id"), req.Files)
+    if err != nil {
+        writeFileError(ctx, err)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.Ok(snap))
+}
+
+func (rest *restfulApi) CommitSnapshot(ctx *gin.Context) {
+    if _, ok := rest.snapshot(ctx, auth.ActionWrite); !ok {
+        return
+    }
+    snap, err := rest.storage.CommitSnapshot(ctx.Param("id"))
+    if err != nil {
+        writeFileError(ctx, err)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.Ok(snap))
+}
+
+//query: path
+func (rest *restfulApi) ListSnapshot(ctx *gin.Context) {
+    p, ok := rest.queryPath(ctx, auth.ActionList)
+    if !ok {
+        return
+    }
+    snaps, err := rest.storage.Snapshots(p)
+    if err != nil {
+        writeFileError(ctx, err)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.Ok(snaps))
+}
+
+func (rest *restfulApi) ShowSnapshot(ctx *gin.Context) {
+    snap, ok := rest.snapshot(ctx, auth.ActionList)
+    if !ok {
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.Ok(snap))
+}
+
+//body: {"target": ""}，target为空时恢复到原路径
+func (rest *restfulApi) RestoreSnapshot(ctx *gin.Context) {
+    req := model.SnapshotRequest{}
+    if ctx.Request.ContentLength != 0 {
+        if err := ctx.Bind(&req); err != nil {
+            ctx.JSON(http.StatusBadRequest, errcode.SnapshotParamError)
+            return
+        }
+    }
+    snap, ok := rest.snapshot(ctx, auth.ActionRead)
+    if !ok {
+        return
+    }
+    target := snap.Path
+    if req.Target != "" {
+        if target, ok = rest.checkPath(ctx, auth.ActionWrite, req.Target); !ok {
+            return
+        }
+    } else if Check(ctx, auth.ActionWrite, target) != nil {
+        ctx.JSON(http.StatusForbidden, errcode.PermissionDenied)
+        return
+    }
+    ret, err := rest.storage.RestoreSnapshot(snap.ID, target)
+    if err != nil {
+        log.Warn("restore snapshot %s failed: %v", snap.ID, err)
+        writeFileError(ctx, err)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.Ok(ret))
+}
+
+func (rest *restfulApi) DeleteSnapshot(ctx *gin.Context) {
+    snap, ok := rest.snapshot(ctx, auth.ActionDelete)
+    if !ok {
+        return
+    }
+    if err := rest.storage.DeleteSnapshot(snap.ID); err != nil {
+        writeFileError(ctx, err)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.OK)
+}
+
+//每输出DIFF_FLUSH_SIZE项差异刷新一次
+const DIFF_FLUSH_SIZE = 100
+
+//query: to（另一个快照id，为空时与快照根目录当前的文件比较）
+//响应为逐行的json（application/x-ndjson），每行一个model.DiffEntry
+func (rest *restfulApi) DiffSnapshot(ctx *gin.Context) {
+    from, ok := rest.snapshot(ctx, auth.ActionList)
+    if !ok {
+        return
+    }
+    to := model.Snapshot{Path: from.Path}
+    if id := ctx.Query("to"); id != "" {
+        snap, err := rest.storage.Snapshot(id)
+        if err != nil {
+            writeFileError(ctx, err)
+            return
+        }
+        if Check(ctx, auth.ActionList, snap.Path) != nil {
+            ctx.JSON(http.StatusForbidden, errcode.PermissionDenied)
+            return
+        }
+        to = snap
+    } else {
+        entries, err := rest.storage.Manifest(from.Path)
+        if err != nil && err != storage.ErrNotFound {
+            writeFileError(ctx, err)
+            return
+        }
+        to.Entries = entries
+    }
+
+    ctx.Header("Content-Type", "application/x-ndjson")
+    ctx.Status(http.StatusOK)
+    enc := json.NewEncoder(ctx.Writer)
+    n := 0
+    err := diff.Diff(from.Path, from.Entries, to.Path, to.Entries, func(e model.DiffEntry) error {
+        if err := enc.Encode(e); err != nil {
+            return err
+        }
+        if n++; n%DIFF_FLUSH_SIZE == 0 {
+            ctx.Writer.Flush()
+        }
+        return nil
+    })
+    if err != nil {
+        log.Warn("diff snapshot %s failed: %v", from.ID, err)
+        enc.Encode(model.DiffEntry{Type: model.DiffError, Error: err.Error()})
+    }
+}
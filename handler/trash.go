@@ -0,0 +1,123 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package handler
+
+import (
+    "citron-repo/auth"
+    "citron-repo/errcode"
+    "citron-repo/model"
+    "citron-repo/storage"
+    "github.com/gin-gonic/gin"
+    "github.com/xfali/goutils/log"
+    "net/http"
+    "time"
+)
+
+//检查回收站中超过保留时间的文件的间隔
+const TRASH_PURGE_INTERVAL = time.Hour
+
+//定期删除回收站中超过保留时间的文件，直到Close
+func (rest *restfulApi) purgeLoop() {
+    defer rest.purgeWait.Done()
+    ticker := time.NewTicker(TRASH_PURGE_INTERVAL)
+    defer ticker.Stop()
+    for {
+        before := time.Now().Add(-rest.config().Trash.Retention.Duration())
+        ret, err := rest.storage.PurgeTrash("/", before)
+        if err != nil {
+            log.Error("purge trash failed: %v", err)
+        } else if ret.Files > 0 {
+            log.Info("purged %d files (%d bytes) from trash", ret.Files, ret.Bytes)
+        }
+        select {
+        case <-rest.purgeStop:
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func writeTrashError(ctx *gin.Context, err error) {
+    switch err {
+    case storage.ErrNotFound, storage.ErrInvalidPath:
+        writeFileError(ctx, err)
+    default:
+        ctx.JSON(http.StatusInternalServerError, errcode.WithMsg(errcode.FileDeleteFailed, err.Error()))
+    }
+}
+
+//query: path（文件或目录），删除的文件移动到回收站
+func (rest *restfulApi) Delete(ctx *gin.Context) {
+    p, ok := rest.queryPath(ctx, auth.ActionDelete)
+    if !ok {
+        return
+    }
+    ret, err := rest.storage.Delete(p)
+    if err != nil {
+        log.Warn("delete %s failed: %v", p, err)
+        writeTrashError(ctx, err)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.Ok(ret))
+}
+
+//query: path（可选，默认为用户的备份根目录），列出回收站中该路径下的文件
+func (rest *restfulApi) ListTrash(ctx *gin.Context) {
+    p, ok := rest.queryPath(ctx, auth.ActionList)
+    if !ok {
+        return
+    }
+    entries, err := rest.trash(p)
+    if err != nil {
+        writeTrashError(ctx, err)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.Ok(entries))
+}
+
+//回收站中p下的文件，包括按当前保留时间计算的自动删除时间
+func (rest *restfulApi) trash(p string) ([]model.TrashEntry, error) {
+    entries, err := rest.storage.Trash(p)
+    if err != nil {
+        return nil, err
+    }
+    retention := rest.config().Trash.Retention.Duration()
+    for i := range entries {
+        entries[i].PurgeTime = entries[i].DeleteTime.Add(retention)
+    }
+    return entries, nil
+}
+
+//query: path（文件或目录），恢复回收站中该路径下的文件
+func (rest *restfulApi) RestoreTrash(ctx *gin.Context) {
+    p, ok := rest.queryPath(ctx, auth.ActionWrite)
+    if !ok {
+        return
+    }
+    ret, err := rest.storage.Restore(p)
+    if err != nil {
+        log.Warn("restore %s from trash failed: %v", p, err)
+        writeTrashError(ctx, err)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.Ok(ret))
+}
+
+//query: path（可选，默认为用户的备份根目录），立即删除回收站中该路径下的文件
+func (rest *restfulApi) PurgeTrash(ctx *gin.Context) {
+    p, ok := rest.queryPath(ctx, auth.ActionDelete)
+    if !ok {
+        return
+    }
+    ret, err := rest.storage.PurgeTrash(p, time.Now())
+    if err != nil {
+        log.Warn("purge trash %s failed: %v", p, err)
+        writeTrashError(ctx, err)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.Ok(ret))
+}
@@ -0,0 +1,129 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package handler
+
+import (
+    "citron-repo/auth"
+    "citron-repo/errcode"
+    "citron-repo/model"
+    "citron-repo/user"
+    "github.com/gin-gonic/gin"
+    "github.com/xfali/goutils/log"
+    "net/http"
+)
+
+func (rest *restfulApi) ListUser(ctx *gin.Context) {
+    ctx.JSON(http.StatusOK, errcode.Ok(rest.userMgr.List()))
+}
+
+//body: {"username": "", "password": "", "role": "writer", "permissions": [{"path": "", "role": ""}]}
+func (rest *restfulApi) CreateUser(ctx *gin.Context) {
+    info := model.UserInfo{}
+    err := ctx.Bind(&info)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, errcode.UserParamError)
+        return
+    }
+
+    err = rest.userMgr.Create(info)
+    if err != nil {
+        writeUserError(ctx, err)
+        return
+    }
+
+    //创建用户的备份根目录
+    err = rest.storage.Mkdir(auth.Home(info.Username))
+    if err != nil {
+        log.Error("create backup dir of user %s failed: %v", info.Username, err)
+    }
+
+    u, _ := rest.userMgr.Get(info.Username)
+    ctx.JSON(http.StatusOK, errcode.Ok(u))
+}
+
+func (rest *restfulApi) DisableUser(ctx *gin.Context) {
+    rest.setDisabled(ctx, true)
+}
+
+func (rest *restfulApi) EnableUser(ctx *gin.Context) {
+    rest.setDisabled(ctx, false)
+}
+
+func (rest *restfulApi) setDisabled(ctx *gin.Context, disabled bool) {
+    username := ctx.Param("username")
+    err := rest.userMgr.SetDisabled(username, disabled)
+    if err != nil {
+        writeUserError(ctx, err)
+        return
+    }
+    if disabled {
+        if err := rest.tokenMgr.RevokeUser(username); err != nil {
+            log.Error("revoke tokens of user %s failed: %v", username, err)
+        }
+    }
+    ctx.JSON(http.StatusOK, errcode.OK)
+}
+
+//重置密码后吊销用户所有的token及api key，已泄露的会话不能继续使用
+//body: {"password": ""}
+func (rest *restfulApi) ResetPassword(ctx *gin.Context) {
+    info := model.UserInfo{}
+    err := ctx.Bind(&info)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, errcode.UserParamError)
+        return
+    }
+
+    username := ctx.Param("username")
+    err = rest.userMgr.ResetPassword(username, info.Password)
+    if err != nil {
+        writeUserError(ctx, err)
+        return
+    }
+    if err := rest.tokenMgr.RevokeUser(username); err != nil {
+        log.Error("revoke tokens of user %s failed: %v", username, err)
+        ctx.JSON(http.StatusInternalServerError, errcode.TokenError)
+        return
+    }
+    if err := rest.keyMgr.RevokeUser(username); err != nil {
+        log.Error("revoke api keys of user %s failed: %v", username, err)
+        ctx.JSON(http.StatusInternalServerError, errcode.ApiKeyError)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.OK)
+}
+
+//body: {"role": "writer", "permissions": [{"path": "", "role": ""}]}
+func (rest *restfulApi) SetRole(ctx *gin.Context) {
+    info := model.UserInfo{}
+    err := ctx.Bind(&info)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, errcode.UserParamError)
+        return
+    }
+
+    err = rest.userMgr.SetRole(ctx.Param("username"), info.Role, info.Permissions)
+    if err != nil {
+        writeUserError(ctx, err)
+        return
+    }
+    ctx.JSON(http.StatusOK, errcode.OK)
+}
+
+func writeUserError(ctx *gin.Context, err error) {
+    switch err {
+    case user.ErrInvalidUsername, user.ErrInvalidPassword, user.ErrInvalidRole:
+        ctx.JSON(http.StatusBadRequest, errcode.UserParamError)
+    case user.ErrUserExists:
+        ctx.JSON(http.StatusConflict, errcode.UserExists)
+    case user.ErrUserNotFound:
+        ctx.JSON(http.StatusNotFound, errcode.UserNotFound)
+    default:
+        log.Error("save user failed: %v", err)
+        ctx.JSON(http.StatusInternalServerError, errcode.UserSaveFailed)
+    }
+}
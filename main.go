@@ -7,31 +7,119 @@
 package main
 
 import (
+    "citron-repo/apikey"
+    "citron-repo/config"
     "citron-repo/handler"
     "citron-repo/model"
-    "citron-repo/transport/binary"
+    "citron-repo/server"
+    "citron-repo/token"
+    "citron-repo/user"
     "flag"
-    "github.com/xfali/go-web-starter/config"
+    "github.com/xfali/goutils/log"
+    "os"
+    "path/filepath"
+    "reflect"
 )
 
+//命令行参数对应的环境变量，命令行参数与环境变量一样优先于配置文件，且不会写入配置文件
+var flagEnv = map[string]string{
+    "u": "CITRON_USERNAME",
+    "a": "CITRON_PASSWORD",
+    "p": "CITRON_HTTP_PORT",
+    "bp": "CITRON_BINARY_PORT",
+    "b": "CITRON_BACKUP_DIR",
+    "k": "CITRON_TOKEN_KEYS",
+}
+
 func main() {
-    username := flag.String("u", "", "username")
-    password := flag.String("a", "", "password")
-    port := flag.Int("p", 8080, "port")
-    backupDir := flag.String("b", "./backup", "dir to backup")
+    confFile := flag.String("c", config.DEFAULT_FILE, "config file(yaml)")
+    flag.String("u", "", "username")
+    flag.String("a", "", "password")
+    flag.Int("p", config.DEFAULT_HTTP_PORT, "port")
+    flag.Int("bp", config.DEFAULT_BINARY_PORT, "binary protocol port")
+    flag.String("b", config.DEFAULT_BACKUP_DIR, "dir to backup")
+    flag.String("k", "", "secret(at least 32 bytes) to sign stateless tokens")
+    flag.Parse()
+
+    flag.Visit(func(f *flag.Flag) {
+        env, ok := flagEnv[f.Name]
+        if !ok {
+            return
+        }
+        v := f.Value.String()
+        if f.Name == "k" {
+            v = "default:" + v
+        }
+        os.Setenv(env, v)
+    })
+
+    configMgr, err := config.Open(*confFile)
+    if err != nil {
+        log.Fatal("load config failed: %v", err)
+    }
+    stopWatch := configMgr.WatchSignal()
+    myconf := configMgr.Get()
 
-    conf := config.Default()
-    conf.ServerPort = *port
+    users, err := user.Open(filepath.Join(myconf.BackupDir, user.DEFAULT_FILE))
+    if err != nil {
+        log.Fatal("load users failed: %v", err)
+    }
 
-    myconf := model.Config{}
-    myconf.Username = *username
-    myconf.Password = *password
-    myconf.BackupDir = *backupDir
+    tokens, err := token.OpenFileStore(filepath.Join(myconf.BackupDir, token.DEFAULT_FILE))
+    if err != nil {
+        log.Fatal("load tokens failed: %v", err)
+    }
 
-    handler := handler.NewRestful(myconf)
-    defer handler.Close()
+    keys, err := apikey.Open(filepath.Join(myconf.BackupDir, apikey.DEFAULT_FILE))
+    if err != nil {
+        log.Fatal("load api keys failed: %v", err)
+    }
+
+    tokenOpts := []token.Opt{token.SetStore(tokens)}
+    if len(myconf.Token.Keys) > 0 {
+        signer, err := token.NewSigner(myconf.Token.Keys...)
+        if err != nil {
+            log.Fatal("load token keys failed: %v", err)
+        }
+        maxAge := myconf.Token.LoginExpire
+        if myconf.Token.FileExpire > maxAge {
+            maxAge = myconf.Token.FileExpire
+        }
+        tokenOpts = append(tokenOpts, token.SetSigner(signer), token.SetMaxAge(maxAge.Duration()))
+    }
+    tokenMgr := token.New(tokenOpts...)
+    configMgr.OnChange(func(old, new model.Config) {
+        onConfigChange(tokenMgr, old, new)
+    })
+
+    api := handler.NewRestful(myconf,
+        handler.SetConfigMgr(configMgr),
+        handler.SetUserMgr(users),
+        handler.SetApiKeyMgr(keys),
+        handler.SetTokenMgr(tokenMgr))
+
+    err = server.New(myconf, api).Run()
+    api.Close()
+    stopWatch()
+    if err != nil {
+        log.Fatal("server failed: %v", err)
+    }
+}
 
-    //web.StartupWithConf(conf, handler.Api)
-    s := binary.NewBinaryServer()
-    s.ListenAndServe()
+//token过期时间、上传限制等在使用时读取，立即生效；签名密钥在此更新；端口等需要重启
+func onConfigChange(tokenMgr *token.TokenMgr, old, new model.Config) {
+    if !reflect.DeepEqual(old.Token.Keys, new.Token.Keys) {
+        signer := tokenMgr.Signer()
+        if signer == nil || len(new.Token.Keys) == 0 {
+            log.Warn("enable or disable signed tokens requires restart")
+        } else if err := signer.SetKeys(new.Token.Keys...); err != nil {
+            log.Error("update token keys failed: %v", err)
+        }
+    }
+    if old.BackupDir != new.BackupDir {
+        log.Warn("files are saved to new backupDir, users, tokens and api keys move after restart")
+    }
+    if old.Http != new.Http || !reflect.DeepEqual(old.Binary, new.Binary) {
+        log.Warn("http and binary config changes take effect after restart")
+    }
 }
@@ -0,0 +1,41 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package model
+
+import "time"
+
+type ApiKey struct {
+    ID   string `json:"id"`
+    User string `json:"user"`
+    Name string `json:"name"`
+    //key的sha256，不返回给客户端
+    Hash string `json:"hash,omitempty"`
+
+    //允许的操作，为空时不限制
+    Scopes []string `json:"scopes,omitempty"`
+    //不为空时只能访问该路径及其子路径
+    Path string `json:"path,omitempty"`
+
+    CreateTime time.Time `json:"createTime"`
+    //为零值时永不过期
+    ExpireTime time.Time `json:"expireTime,omitempty"`
+    LastUsed   time.Time `json:"lastUsed,omitempty"`
+}
+
+type ApiKeyInfo struct {
+    Name   string   `json:"name"`
+    Scopes []string `json:"scopes"`
+    Path   string   `json:"path"`
+    //有效天数，为0时永不过期
+    ExpireDays int `json:"expireDays"`
+}
+
+type ApiKeyResult struct {
+    //完整的key，只在创建时返回一次
+    Key string `json:"key"`
+    ApiKey
+}
@@ -0,0 +1,15 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package model
+
+//二进制协议认证信息，使用用户名密码、REST /login获得的登录token或api key
+type AuthInfo struct {
+    Username string `json:"username,omitempty"`
+    Password string `json:"password,omitempty"`
+    Token    string `json:"token,omitempty"`
+    ApiKey   string `json:"apiKey,omitempty"`
+}
@@ -6,7 +6,178 @@
 
 package model
 
+import (
+    "encoding/json"
+    "time"
+)
+
 type Config struct {
-    LoginInfo
-    BackupDir string
+    //初始管理员，仅在该用户不存在时创建
+    Username  string `json:"username" yaml:"username"`
+    Password  string `json:"password,omitempty" yaml:"password"`
+    BackupDir string `json:"backupDir" yaml:"backupDir"`
+
+    Http   HttpConfig   `json:"http" yaml:"http"`
+    Binary BinaryConfig `json:"binary" yaml:"binary"`
+    Token  TokenConfig  `json:"token" yaml:"token"`
+    Limit  LimitConfig  `json:"limit" yaml:"limit"`
+
+    Compression CompressionConfig `json:"compression" yaml:"compression"`
+    Encryption  EncryptionConfig  `json:"encryption" yaml:"encryption"`
+    Scrub       ScrubConfig       `json:"scrub" yaml:"scrub"`
+    Replication ReplicationConfig `json:"replication" yaml:"replication"`
+    Trash       TrashConfig       `json:"trash" yaml:"trash"`
+
+    //启动时创建的用户，已存在的用户不会被修改
+    Users []UserInfo `json:"users,omitempty" yaml:"users"`
+}
+
+type HttpConfig struct {
+    Port int `json:"port" yaml:"port"`
+    //读取整个请求（包括body）及写入整个响应的超时，0为不限制
+    ReadTimeout  Duration `json:"readTimeout" yaml:"readTimeout"`
+    WriteTimeout Duration `json:"writeTimeout" yaml:"writeTimeout"`
+    //读取请求头的超时
+    ReadHeaderTimeout Duration `json:"readHeaderTimeout" yaml:"readHeaderTimeout"`
+    //keep-alive连接等待下一个请求的超时
+    IdleTimeout Duration `json:"idleTimeout" yaml:"idleTimeout"`
+}
+
+type BinaryConfig struct {
+    Port         int      `json:"port" yaml:"port"`
+    ReadBufSize  int      `json:"readBufSize" yaml:"readBufSize"`
+    WriteBufSize int      `json:"writeBufSize" yaml:"writeBufSize"`
+    ReadTimeout  Duration `json:"readTimeout" yaml:"readTimeout"`
+    WriteTimeout Duration `json:"writeTimeout" yaml:"writeTimeout"`
+    //允许客户端协商使用的body压缩算法（zstd、snappy、gzip），按优先级排序，为none时不压缩
+    Compression []string `json:"compression,omitempty" yaml:"compression"`
+    //小于该长度的body不压缩
+    CompressMinSize int64 `json:"compressMinSize" yaml:"compressMinSize"`
+}
+
+type TokenConfig struct {
+    LoginExpire   Duration `json:"loginExpire" yaml:"loginExpire"`
+    RefreshExpire Duration `json:"refreshExpire" yaml:"refreshExpire"`
+    FileExpire    Duration `json:"fileExpire" yaml:"fileExpire"`
+
+    //签名token的密钥，第一个用于签名，其余仅用于校验；为空时使用服务端存储的随机token
+    Keys []TokenKey `json:"keys,omitempty" yaml:"keys"`
+}
+
+type LimitConfig struct {
+    //单个文件（二进制协议单个请求）最大字节数，0为不限制
+    MaxUploadSize int64 `json:"maxUploadSize" yaml:"maxUploadSize"`
+    //二进制协议最大连接数，0为不限制
+    MaxConnections int `json:"maxConnections" yaml:"maxConnections"`
+}
+
+//存储压缩，压缩算法：none、gzip、zstd，为空时不压缩
+type CompressionConfig struct {
+    //默认压缩算法
+    Codec string `json:"codec,omitempty" yaml:"codec"`
+    //小于该大小的文件不压缩，0为默认值（1KB）
+    MinSize int64 `json:"minSize,omitempty" yaml:"minSize"`
+    //按路径前缀指定压缩算法，最长的前缀生效
+    Rules []CompressionRule `json:"rules,omitempty" yaml:"rules"`
+}
+
+type CompressionRule struct {
+    Prefix string `json:"prefix" yaml:"prefix"`
+    Codec  string `json:"codec" yaml:"codec"`
+}
+
+//存储加密，每个文件使用随机生成的数据密钥加密，数据密钥由主密钥加密后保存在元数据中
+//第一个主密钥用于加密新的数据密钥，其余仅用于解密；为空时不加密
+type EncryptionConfig struct {
+    Keys []MasterKey `json:"keys,omitempty" yaml:"keys"`
+}
+
+//主密钥为32字节，Secret为hex编码的密钥，或者从File读取（hex编码或32字节的原始密钥）
+type MasterKey struct {
+    ID     string `json:"id" yaml:"id"`
+    Secret string `json:"secret,omitempty" yaml:"secret"`
+    File   string `json:"file,omitempty" yaml:"file"`
+}
+
+//完整性检查，校验保存的所有版本并查找丢失、损坏及多余的文件
+type ScrubConfig struct {
+    //cron表达式，为空时只能手动执行
+    Schedule string `json:"schedule,omitempty" yaml:"schedule"`
+    //每秒最多读取的字节数，避免影响上传，0为不限制
+    RateLimit int64 `json:"rateLimit,omitempty" yaml:"rateLimit"`
+    //将损坏及多余的文件移动到隔离目录（.citron/quarantine）
+    Quarantine bool `json:"quarantine,omitempty" yaml:"quarantine"`
+}
+
+//复制，新版本及元数据异步推送到所有副本（二进制协议），副本不可用时在队列中等待，恢复后继续
+type ReplicationConfig struct {
+    Replicas []ReplicaConfig `json:"replicas,omitempty" yaml:"replicas"`
+    //复制失败后重试的最大间隔，0为默认值（1分钟）
+    MaxRetryInterval Duration `json:"maxRetryInterval,omitempty" yaml:"maxRetryInterval"`
+}
+
+//副本上的用户需要有所有路径的写权限（管理员），使用api key或者用户名密码认证
+type ReplicaConfig struct {
+    Name string `json:"name" yaml:"name"`
+    //副本的二进制协议地址，如backup2:20001
+    Addr     string `json:"addr" yaml:"addr"`
+    ApiKey   string `json:"apiKey,omitempty" yaml:"apiKey"`
+    Username string `json:"username,omitempty" yaml:"username"`
+    Password string `json:"password,omitempty" yaml:"password"`
+}
+
+//回收站，删除的文件保留一段时间，可以恢复
+type TrashConfig struct {
+    //超过该时间后自动删除（包括所有历史版本），0为默认值（30天）
+    Retention Duration `json:"retention,omitempty" yaml:"retention"`
+}
+
+//签名密钥，ID写入token header（kid），用于选择校验密钥
+type TokenKey struct {
+    ID     string `json:"id" yaml:"id"`
+    Secret string `json:"secret,omitempty" yaml:"secret"`
+}
+
+//配置文件及json中以字符串表示的时间间隔，如"15s"、"3h"
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+    return time.Duration(d)
+}
+
+func (d Duration) String() string {
+    return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+    return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+    var s string
+    if err := json.Unmarshal(data, &s); err != nil {
+        return err
+    }
+    return d.parse(s)
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+    return d.String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+    var s string
+    if err := unmarshal(&s); err != nil {
+        return err
+    }
+    return d.parse(s)
+}
+
+func (d *Duration) parse(s string) error {
+    v, err := time.ParseDuration(s)
+    if err != nil {
+        return err
+    }
+    *d = Duration(v)
+    return nil
 }
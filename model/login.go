@@ -6,7 +6,25 @@
 
 package model
 
+import "time"
+
 type LoginInfo struct {
     Username string `json:"username"`
     Password string `json:"password"`
+
+    //可选，限制token允许的操作及路径
+    Scopes []string `json:"scopes,omitempty"`
+    Path   string   `json:"path,omitempty"`
+}
+
+type LoginResult struct {
+    //登录token，放在header CITRON-TOKEN中
+    Token    string    `json:"token"`
+    ExpireAt time.Time `json:"expireAt"`
+    //用于在登录token过期前换取新的token
+    RefreshToken string `json:"refreshToken"`
+}
+
+type RefreshInfo struct {
+    RefreshToken string `json:"refreshToken"`
 }
@@ -8,6 +8,13 @@ package model
 
 import "time"
 
+//FileInfo.State
+const (
+    FileNormal = iota
+    //删除标记：已删除（在回收站中）的文件元数据中最后一个版本为删除标记，CreateTime为删除时间
+    FileDeleted
+)
+
 type FileInfo struct {
     FileName string `json:"filename"`
     FilePath string `json:"filepath"`
@@ -21,9 +28,80 @@ type FileInfo struct {
 
     IsDir bool  `json:"isDir"`
     Size  int64 `json:"size"`
+    //权限位（os.FileMode.Perm）
+    Mode uint32 `json:"mode,omitempty"`
 
     ModTime time.Time `json:"modTime"`
 
     Checksum     string `json:"checksum,omitempty"`
     ChecksumType string `json:"checksumType,omitempty"`
-}
\ No newline at end of file
+    //存储压缩算法及保存的大小（压缩、加密后），不压缩时Codec为空，Size及Checksum为原始内容的大小及checksum
+    Codec      string `json:"codec,omitempty"`
+    StoredSize int64  `json:"storedSize,omitempty"`
+    //是否加密保存，数据密钥保存在元数据中，不返回给客户端
+    Encrypted bool `json:"encrypted,omitempty"`
+
+    //版本号，每次上传内容变化时生成新版本
+    Version    string    `json:"version,omitempty"`
+    CreateTime time.Time `json:"createTime,omitempty"`
+}
+//文件请求，Path为相对路径时相对于用户的备份根目录
+type FileRequest struct {
+    Path    string `json:"path"`
+    Version string `json:"version,omitempty"`
+}
+
+//历史版本保留策略，最新版本总是保留
+type Retention struct {
+    //保留的历史版本数（包括最新版本），0为不限制
+    Versions int `json:"versions,omitempty" yaml:"versions"`
+    //被替换超过该时间的历史版本删除，0为不限制
+    MaxAge Duration `json:"maxAge,omitempty" yaml:"maxAge"`
+}
+
+func (r Retention) IsZero() bool {
+    return r.Versions <= 0 && r.MaxAge <= 0
+}
+
+//删除历史版本，Path为文件或目录（包括所有子目录）
+type PruneRequest struct {
+    Path      string    `json:"path"`
+    Retention Retention `json:"retention"`
+}
+
+type PruneResult struct {
+    //删除了历史版本的文件数
+    Files    int   `json:"files"`
+    Versions int   `json:"versions"`
+    Bytes    int64 `json:"bytes"`
+}
+
+//回收站中的文件
+type TrashEntry struct {
+    Path string `json:"path"`
+    //删除前的最新版本，恢复后成为最新版本
+    Version string    `json:"version"`
+    Size    int64     `json:"size"`
+    ModTime time.Time `json:"modTime"`
+    //保存的版本数（包括删除前的最新版本）
+    Versions   int       `json:"versions"`
+    DeleteTime time.Time `json:"deleteTime"`
+    //超过保留时间后自动删除的时间
+    PurgeTime time.Time `json:"purgeTime,omitempty"`
+}
+
+//删除、恢复及清空回收站的结果
+type TrashResult struct {
+    Files int   `json:"files"`
+    Bytes int64 `json:"bytes"`
+    //恢复时原路径已存在或内容已丢失的文件
+    Failed []string `json:"failed,omitempty"`
+}
+
+//主密钥轮换，Keys为使用当前主密钥重新加密的数据密钥数
+type RotateResult struct {
+    Files int `json:"files"`
+    Keys  int `json:"keys"`
+    //主密钥不在配置中，无法重新加密的文件
+    Failed []string `json:"failed,omitempty"`
+}
@@ -0,0 +1,48 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package model
+
+import "time"
+
+//副本的复制状态
+type ReplicaStatus struct {
+    Name      string `json:"name"`
+    Addr      string `json:"addr"`
+    Connected bool   `json:"connected"`
+    //已复制的最后一条记录及最新记录的序号
+    Acked uint64 `json:"acked"`
+    Head  uint64 `json:"head"`
+    //未复制的记录数
+    Pending uint64 `json:"pending"`
+    //最早未复制的记录产生到现在的时间，没有未复制的记录时为0
+    Lag Duration `json:"lag"`
+    //启动后复制的文件数及字节数
+    Files int64 `json:"files"`
+    Bytes int64 `json:"bytes"`
+
+    LastSync  time.Time `json:"lastSync,omitempty"`
+    LastError string    `json:"lastError,omitempty"`
+}
+
+//比较主仓库及副本中所有文件的最新版本
+type ReplicaVerify struct {
+    Name    string `json:"name"`
+    Files   int    `json:"files"`
+    Matched int    `json:"matched"`
+    //没有checksum的文件（直接写入备份目录的文件），不会被复制
+    Skipped int `json:"skipped"`
+    //副本中不存在
+    Missing []string `json:"missing,omitempty"`
+    //大小或checksum不同
+    Mismatched []string `json:"mismatched,omitempty"`
+    //主仓库中不存在（已删除或未保存）但副本中存在
+    Extra []string `json:"extra,omitempty"`
+}
+
+func (v ReplicaVerify) Ok() bool {
+    return len(v.Missing) == 0 && len(v.Mismatched) == 0 && len(v.Extra) == 0
+}
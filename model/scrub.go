@@ -0,0 +1,42 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package model
+
+import "time"
+
+//一次完整性检查的结果
+type ScrubReport struct {
+    Start time.Time `json:"start"`
+    End   time.Time `json:"end"`
+    //检查的文件数及版本数
+    Files    int   `json:"files"`
+    Versions int   `json:"versions"`
+    Bytes    int64 `json:"bytes"`
+    //没有checksum的版本（直接写入备份目录的文件），不校验内容
+    Skipped int `json:"skipped"`
+
+    //元数据中有记录但文件不存在
+    Missing []ScrubEntry `json:"missing,omitempty"`
+    //内容与checksum或大小不符，或者无法读取
+    Corrupted []ScrubEntry `json:"corrupted,omitempty"`
+    //历史版本目录中没有元数据记录的文件
+    Orphaned []ScrubEntry `json:"orphaned,omitempty"`
+    //未完成时的错误（如被停止）
+    Error string `json:"error,omitempty"`
+}
+
+type ScrubEntry struct {
+    Path    string `json:"path"`
+    Version string `json:"version,omitempty"`
+    Error   string `json:"error,omitempty"`
+    //已移动到隔离目录
+    Quarantined bool `json:"quarantined,omitempty"`
+}
+
+func (r ScrubReport) Ok() bool {
+    return r.Error == "" && len(r.Missing) == 0 && len(r.Corrupted) == 0 && len(r.Orphaned) == 0
+}
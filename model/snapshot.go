@@ -0,0 +1,80 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package model
+
+import "time"
+
+const (
+    //已创建，正在添加文件
+    SnapshotPending = iota
+    //已提交，不能再修改
+    SnapshotCommitted
+)
+
+//快照：某一时刻备份目录下所有文件的版本清单
+type Snapshot struct {
+    ID string `json:"id"`
+    //快照的根目录（仓库路径），文件都在该目录下
+    Path string `json:"path"`
+    //来源主机
+    From  string   `json:"from"`
+    Tags  []string `json:"tags,omitempty"`
+    Owner string   `json:"owner"`
+    State int      `json:"state"`
+
+    CreateTime time.Time `json:"createTime"`
+    CommitTime time.Time `json:"commitTime,omitempty"`
+
+    Files int   `json:"files"`
+    Size  int64 `json:"size"`
+    //文件清单，列表接口不返回
+    Entries []FileInfo `json:"entries,omitempty"`
+}
+
+//快照操作请求，路径为相对路径时相对于用户的备份根目录
+type SnapshotRequest struct {
+    ID string `json:"id,omitempty"`
+    //创建快照时为快照根目录，列出快照时为查询目录
+    Path string   `json:"path,omitempty"`
+    From string   `json:"from,omitempty"`
+    Tags []string `json:"tags,omitempty"`
+    //添加到快照的文件（路径及版本，版本为空时为最新版本）
+    Files []FileRequest `json:"files,omitempty"`
+    //恢复快照的目标目录，为空时恢复到原路径
+    Target string `json:"target,omitempty"`
+}
+
+//恢复快照的结果
+type RestoreResult struct {
+    Files int   `json:"files"`
+    Bytes int64 `json:"bytes"`
+}
+
+//差异类型
+const (
+    DiffAdded    = "added"
+    DiffRemoved  = "removed"
+    DiffModified = "modified"
+    //内容相同、路径不同
+    DiffRenamed = "renamed"
+    //比较过程中出错，Error为错误信息，之后不再有结果
+    DiffError = "error"
+)
+
+//两个文件清单之间的一项差异
+type DiffEntry struct {
+    Type string `json:"type"`
+    //相对于快照根目录的路径，重命名时为新路径
+    Path string `json:"path"`
+    //重命名前的路径
+    OldPath string `json:"oldPath,omitempty"`
+    //变化的属性：checksum、size、modTime、mode
+    Changes []string  `json:"changes,omitempty"`
+    Old     *FileInfo `json:"old,omitempty"`
+    New     *FileInfo `json:"new,omitempty"`
+    Error   string    `json:"error,omitempty"`
+}
@@ -0,0 +1,48 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package model
+
+import "time"
+
+const (
+    //管理员：所有路径的所有操作，修改配置及管理用户
+    RoleAdmin = "admin"
+    //备份：浏览、下载及上传
+    RoleWriter = "writer"
+    //恢复：浏览及下载
+    RoleRestoreOnly = "restore-only"
+    //只读：仅浏览元数据
+    RoleReader = "reader"
+)
+
+//路径权限，Path为仓库内的绝对路径（如/agent1/etc），对该路径及其子路径生效
+type Permission struct {
+    Path string `json:"path" yaml:"path"`
+    Role string `json:"role" yaml:"role"`
+}
+
+type User struct {
+    Username string `json:"username"`
+    //bcrypt密码hash，不返回给客户端
+    PasswordHash string `json:"passwordHash,omitempty"`
+
+    //用户在自己备份根目录(/username)下的角色，管理员对所有路径生效
+    Role string `json:"role"`
+    //额外授权的路径
+    Permissions []Permission `json:"permissions,omitempty"`
+    Disabled    bool         `json:"disabled"`
+
+    CreateTime time.Time `json:"createTime"`
+    UpdateTime time.Time `json:"updateTime"`
+}
+
+type UserInfo struct {
+    Username    string       `json:"username" yaml:"username"`
+    Password    string       `json:"password" yaml:"password"`
+    Role        string       `json:"role" yaml:"role"`
+    Permissions []Permission `json:"permissions" yaml:"permissions"`
+}
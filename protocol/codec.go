@@ -0,0 +1,333 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package protocol
+
+import (
+    "bytes"
+    "compress/gzip"
+    "encoding/binary"
+    "errors"
+    "github.com/klauspost/compress/snappy"
+    "github.com/klauspost/compress/zstd"
+    "io"
+    "sync"
+)
+
+//body压缩算法，保存在请求头及响应头Reserve字段的8-11位
+const (
+    CodecNone int16 = iota
+    CodecGzip
+    CodecZstd
+    CodecSnappy
+)
+
+const (
+    //Reserve字段低8位为命令ID（请求）或状态码（响应）
+    ReserveCodeMask   = 0x00FF
+    ReserveCodecMask  = 0x0F00
+    ReserveCodecShift = 8
+
+    //压缩的body由多个块组成，每块为4字节长度（大端，最高位为1时块未压缩）+ 块数据，
+    //每块解压后不超过BlockSize，header的Length为解压后的总长度
+    BlockSize       = 64 * 1024
+    BlockHeaderSize = 4
+    blockRawFlag    = 1 << 31
+
+    //小于该长度的body不压缩
+    DefaultCompressMinSize = 1024
+)
+
+//默认支持的压缩算法，按优先级排序
+var DefaultCodecs = []string{"zstd", "snappy", "gzip"}
+
+var codecNames = map[int16]string{
+    CodecNone:   "none",
+    CodecGzip:   "gzip",
+    CodecZstd:   "zstd",
+    CodecSnappy: "snappy",
+}
+
+var (
+    ErrCodec = errors.New("unknown codec")
+    ErrBlock = errors.New("invalid compressed block")
+)
+
+//压缩算法协商（NegotiateCommandID）的请求及响应
+type Negotiation struct {
+    //请求：客户端支持的算法
+    Codecs []string `json:"codecs,omitempty"`
+    //响应：选择的算法（不压缩时为none）及最小压缩长度
+    Codec   string `json:"codec,omitempty"`
+    MinSize int64  `json:"minSize,omitempty"`
+}
+
+func CodecName(codec int16) string {
+    return codecNames[codec]
+}
+
+func ParseCodec(name string) (int16, error) {
+    for k, v := range codecNames {
+        if v == name {
+            return k, nil
+        }
+    }
+    return CodecNone, ErrCodec
+}
+
+//拆分Reserve字段为命令ID（或状态码）及压缩算法
+func SplitReserve(reserve int16) (int16, int16) {
+    return reserve & ReserveCodeMask, (reserve & ReserveCodecMask) >> ReserveCodecShift
+}
+
+func JoinReserve(code, codec int16) int16 {
+    return code&ReserveCodeMask | codec<<ReserveCodecShift
+}
+
+var (
+    zstdOnce    sync.Once
+    zstdEncoder *zstd.Encoder
+    zstdDecoder *zstd.Decoder
+    zstdErr     error
+)
+
+//EncodeAll及DecodeAll可以并发调用，所有连接共用
+func initZstd() error {
+    zstdOnce.Do(func() {
+        zstdEncoder, zstdErr = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+        if zstdErr == nil {
+            zstdDecoder, zstdErr = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(4*BlockSize))
+        }
+    })
+    return zstdErr
+}
+
+func encodeBlock(codec int16, src []byte) ([]byte, error) {
+    switch codec {
+    case CodecGzip:
+        buf := bytes.NewBuffer(make([]byte, 0, len(src)))
+        w, _ := gzip.NewWriterLevel(buf, gzip.BestSpeed)
+        if _, err := w.Write(src); err != nil {
+            return nil, err
+        }
+        if err := w.Close(); err != nil {
+            return nil, err
+        }
+        return buf.Bytes(), nil
+    case CodecZstd:
+        if err := initZstd(); err != nil {
+            return nil, err
+        }
+        return zstdEncoder.EncodeAll(src, make([]byte, 0, len(src))), nil
+    case CodecSnappy:
+        return snappy.Encode(nil, src), nil
+    }
+    return nil, ErrCodec
+}
+
+//解压后超过BlockSize的块视为非法，防止解压炸弹
+func decodeBlock(codec int16, src []byte, raw bool) ([]byte, error) {
+    if raw {
+        return src, nil
+    }
+    var ret []byte
+    switch codec {
+    case CodecGzip:
+        r, err := gzip.NewReader(bytes.NewReader(src))
+        if err != nil {
+            return nil, err
+        }
+        buf := bytes.NewBuffer(make([]byte, 0, BlockSize))
+        if _, err := io.Copy(buf, io.LimitReader(r, BlockSize+1)); err != nil {
+            return nil, err
+        }
+        ret = buf.Bytes()
+    case CodecZstd:
+        if err := initZstd(); err != nil {
+            return nil, err
+        }
+        data, err := zstdDecoder.DecodeAll(src, nil)
+        if err != nil {
+            return nil, err
+        }
+        ret = data
+    case CodecSnappy:
+        n, err := snappy.DecodedLen(src)
+        if err != nil {
+            return nil, err
+        }
+        if n > BlockSize {
+            return nil, ErrBlock
+        }
+        if ret, err = snappy.Decode(nil, src); err != nil {
+            return nil, err
+        }
+    default:
+        return nil, ErrCodec
+    }
+    if len(ret) > BlockSize {
+        return nil, ErrBlock
+    }
+    return ret, nil
+}
+
+func parseBlockHeader(head []byte) (int, bool) {
+    v := binary.BigEndian.Uint32(head)
+    return int(v &^ blockRawFlag), v&blockRawFlag != 0
+}
+
+var blockPool = sync.Pool{New: func() interface{} {
+    return make([]byte, BlockHeaderSize+BlockSize)
+}}
+
+//读取r中的size字节，按块压缩后写入w，压缩后没有变小的块不压缩，每块调用一次w.Write
+func WriteBlocks(w io.Writer, codec int16, r io.Reader, size int64) error {
+    buf := blockPool.Get().([]byte)
+    defer blockPool.Put(buf)
+    for size > 0 {
+        n := int64(BlockSize)
+        if n > size {
+            n = size
+        }
+        raw := buf[BlockHeaderSize : BlockHeaderSize+n]
+        if _, err := io.ReadFull(r, raw); err != nil {
+            return err
+        }
+        data, err := encodeBlock(codec, raw)
+        if err != nil {
+            return err
+        }
+        var block []byte
+        if len(data) < len(raw) {
+            block = make([]byte, BlockHeaderSize, BlockHeaderSize+len(data))
+            binary.BigEndian.PutUint32(block, uint32(len(data)))
+            block = append(block, data...)
+        } else {
+            block = buf[:BlockHeaderSize+n]
+            binary.BigEndian.PutUint32(block, uint32(n)|blockRawFlag)
+        }
+        if _, err := w.Write(block); err != nil {
+            return err
+        }
+        size -= n
+    }
+    return nil
+}
+
+//从r读取压缩的body，返回解压后的数据，共size字节
+type BlockReader struct {
+    r     io.Reader
+    codec int16
+    left  int64
+    head  []byte
+    data  []byte
+    buf   []byte
+}
+
+func NewBlockReader(r io.Reader, codec int16, size int64) *BlockReader {
+    return &BlockReader{r: r, codec: codec, left: size, head: make([]byte, BlockHeaderSize)}
+}
+
+func (b *BlockReader) Read(p []byte) (int, error) {
+    if len(b.buf) == 0 {
+        if b.left <= 0 {
+            return 0, io.EOF
+        }
+        if err := b.next(); err != nil {
+            return 0, err
+        }
+    }
+    n := copy(p, b.buf)
+    b.buf = b.buf[n:]
+    return n, nil
+}
+
+func (b *BlockReader) next() error {
+    if _, err := io.ReadFull(b.r, b.head); err != nil {
+        return err
+    }
+    length, raw := parseBlockHeader(b.head)
+    if length <= 0 || length > BlockSize {
+        return ErrBlock
+    }
+    if cap(b.data) < length {
+        b.data = make([]byte, BlockSize)
+    }
+    b.data = b.data[:length]
+    if _, err := io.ReadFull(b.r, b.data); err != nil {
+        return err
+    }
+    out, err := decodeBlock(b.codec, b.data, raw)
+    if err != nil {
+        return err
+    }
+    if len(out) == 0 || int64(len(out)) > b.left {
+        return ErrBlock
+    }
+    b.left -= int64(len(out))
+    b.buf = out
+    return nil
+}
+
+//接收压缩的body（数据可以在任意位置分割），解压后写入w，共size字节
+type BlockDecoder struct {
+    w      io.Writer
+    codec  int16
+    left   int64
+    head   []byte
+    length int
+    raw    bool
+    data   []byte
+}
+
+func NewBlockDecoder(w io.Writer, codec int16, size int64) *BlockDecoder {
+    return &BlockDecoder{w: w, codec: codec, left: size, head: make([]byte, 0, BlockHeaderSize)}
+}
+
+//返回消耗的字节数，body接收完成后不再消耗数据
+func (d *BlockDecoder) Decode(p []byte) (int, error) {
+    n := 0
+    for n < len(p) && d.left > 0 {
+        if len(d.head) < BlockHeaderSize {
+            c := copy(d.head[len(d.head):BlockHeaderSize], p[n:])
+            d.head, n = d.head[:len(d.head)+c], n+c
+            if len(d.head) < BlockHeaderSize {
+                break
+            }
+            d.length, d.raw = parseBlockHeader(d.head)
+            if d.length <= 0 || d.length > BlockSize {
+                return n, ErrBlock
+            }
+            continue
+        }
+        c := d.length - len(d.data)
+        if c > len(p)-n {
+            c = len(p) - n
+        }
+        d.data, n = append(d.data, p[n:n+c]...), n+c
+        if len(d.data) < d.length {
+            break
+        }
+        out, err := decodeBlock(d.codec, d.data, d.raw)
+        if err != nil {
+            return n, err
+        }
+        if len(out) == 0 || int64(len(out)) > d.left {
+            return n, ErrBlock
+        }
+        d.left -= int64(len(out))
+        if _, err := d.w.Write(out); err != nil {
+            return n, err
+        }
+        d.head, d.data = d.head[:0], d.data[:0]
+    }
+    return n, nil
+}
+
+//body是否接收完成
+func (d *BlockDecoder) Done() bool {
+    return d.left <= 0
+}
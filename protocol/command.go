@@ -6,10 +6,64 @@
 
 package protocol
 
+import "fmt"
+
 type Command func(data []byte, writer chan<- []byte) error
 
+//请求头Reserve字段的低8位为命令ID（见codec.go）
 const (
     DebugCommandID = iota
+    //认证，body为json: {"username": "", "password": ""}、{"token": ""} 或 {"apiKey": ""}
+    AuthCommandID
+    //上传文件，body为meta(model.FileInfo，FilePath为仓库路径) + 文件内容，响应body为json: model.FileInfo
+    UploadCommandID
+    //下载文件，body为json: model.FileRequest，响应body为meta(model.FileInfo) + 文件内容
+    DownloadCommandID
+    //文件信息，body为json: model.FileRequest，响应body为json: model.FileInfo
+    StatCommandID
+    //列出目录，body为json: model.FileRequest，响应body为json: []model.FileInfo
+    ListCommandID
+    //文件的所有版本，body为json: model.FileRequest，响应body为json: []model.FileInfo
+    VersionsCommandID
+    //删除历史版本，body为json: model.PruneRequest，响应body为json: model.PruneResult
+    PruneCommandID
+    //快照操作，body为json: model.SnapshotRequest
+    //创建快照（Path、From、Tags），响应body为json: model.Snapshot
+    SnapshotBeginCommandID
+    //添加文件（ID、Files），响应body为json: model.Snapshot
+    SnapshotAddCommandID
+    //提交快照（ID），响应body为json: model.Snapshot
+    SnapshotCommitCommandID
+    //列出快照（Path），响应body为json: []model.Snapshot
+    SnapshotListCommandID
+    //快照信息及文件清单（ID），响应body为json: model.Snapshot
+    SnapshotShowCommandID
+    //恢复快照（ID、Target），响应body为json: model.RestoreResult
+    SnapshotRestoreCommandID
+    //删除快照（ID），响应body为空
+    SnapshotDeleteCommandID
+    //协商body压缩算法，认证前也可以发送，body为json: Negotiation，响应body为json: Negotiation
+    NegotiateCommandID
+    //删除文件或目录，删除的文件移动到回收站，body为json: model.FileRequest，响应body为json: model.TrashResult
+    DeleteCommandID
+    //列出回收站中的文件，body为json: model.FileRequest，响应body为json: []model.TrashEntry
+    TrashListCommandID
+    //恢复回收站中的文件，body为json: model.FileRequest，响应body为json: model.TrashResult
+    TrashRestoreCommandID
+    //立即删除回收站中的文件，body为json: model.FileRequest，响应body为json: model.TrashResult
+    TrashPurgeCommandID
+)
+
+//响应头Reserve字段的低8位为状态码，非StatusOK时body为错误信息
+const (
+    StatusOK = iota
+    StatusError
+    StatusAuthRequired
+    StatusAuthFailed
+    StatusPermissionDenied
+    StatusBadRequest
+    StatusNotFound
+    StatusTooLarge
 )
 
 var cmdMap = map[int16]Command{}
@@ -23,3 +77,17 @@ func DebugCommand(data []byte, writer chan<- []byte) error {
     writer <- []byte("debug: " + string(data))
     return nil
 }
+
+//命令执行失败，服务端以Status作为响应状态码返回给客户端
+type Error struct {
+    Status int16
+    Msg    string
+}
+
+func NewError(status int16, msg string) *Error {
+    return &Error{Status: status, Msg: msg}
+}
+
+func (e *Error) Error() string {
+    return fmt.Sprintf("status %d: %s", e.Status, e.Msg)
+}
@@ -0,0 +1,55 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package protocol
+
+import (
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "io"
+)
+
+const (
+    //meta长度字段占用的字节数
+    MetaLengthSize = 4
+    //meta最大长度
+    MaxMetaSize = 64 * 1024
+)
+
+var ErrMetaTooLarge = errors.New("meta too large")
+
+//编码meta：4字节长度（大端） + json，用于在body中的数据之前携带文件信息
+func EncodeMeta(v interface{}) ([]byte, error) {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return nil, err
+    }
+    if len(data) > MaxMetaSize {
+        return nil, ErrMetaTooLarge
+    }
+    ret := make([]byte, MetaLengthSize+len(data))
+    binary.BigEndian.PutUint32(ret, uint32(len(data)))
+    copy(ret[MetaLengthSize:], data)
+    return ret, nil
+}
+
+//读取EncodeMeta编码的meta，返回读取的字节数
+func DecodeMeta(r io.Reader, v interface{}) (int64, error) {
+    lenBuf := make([]byte, MetaLengthSize)
+    if _, err := io.ReadFull(r, lenBuf); err != nil {
+        return 0, err
+    }
+    size := binary.BigEndian.Uint32(lenBuf)
+    if size > MaxMetaSize {
+        return MetaLengthSize, ErrMetaTooLarge
+    }
+    data := make([]byte, size)
+    if _, err := io.ReadFull(r, data); err != nil {
+        return MetaLengthSize, err
+    }
+    return int64(MetaLengthSize + size), json.Unmarshal(data, v)
+}
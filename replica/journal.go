@@ -0,0 +1,293 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package replica
+
+import (
+    "bufio"
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+)
+
+const (
+    JOURNAL_FILE = "journal"
+    //已复制到所有副本的记录超过该数量时重写日志文件
+    COMPACT_SIZE = 1024
+)
+
+//复制日志中的一条记录：文件的一个版本（新版本、修改时间、权限变化或从回收站恢复）或删除标记
+type Entry struct {
+    Seq     uint64 `json:"seq"`
+    Path    string `json:"path"`
+    Version string `json:"version"`
+    //文件被删除（移动到回收站），Version为删除标记的版本
+    Deleted bool      `json:"deleted,omitempty"`
+    Time    time.Time `json:"time"`
+}
+
+//持久化的复制队列，所有副本共用，每个副本记录已复制的序号
+//记录追加到日志文件，已复制到所有副本的记录在Compact时删除
+//Path为空的记录表示序号不大于该记录的记录已删除或未记录，复制进度在此之前的副本无法从日志继续
+type Journal struct {
+    path string
+    lock sync.Mutex
+    file *os.File
+    head uint64
+    //序号大于base的记录完整
+    base uint64
+    //上次Skip之后没有追加记录，连续的Skip只写入一次
+    skipped bool
+    //未被所有副本复制的记录，按序号排序
+    entries []Entry
+    //Compact删除的记录数
+    dropped int
+    //追加记录时关闭并替换，通知等待的副本
+    changed chan struct{}
+}
+
+func OpenJournal(dir string) (*Journal, error) {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return nil, err
+    }
+    j := &Journal{path: filepath.Join(dir, JOURNAL_FILE), changed: make(chan struct{})}
+    f, err := os.Open(j.path)
+    if err != nil && !os.IsNotExist(err) {
+        return nil, err
+    }
+    if err == nil {
+        scanner := bufio.NewScanner(f)
+        for scanner.Scan() {
+            e := Entry{}
+            //最后一行可能只写入了一部分
+            if json.Unmarshal(scanner.Bytes(), &e) != nil || e.Seq <= j.head {
+                continue
+            }
+            j.head = e.Seq
+            if e.Path != "" {
+                j.entries = append(j.entries, e)
+            } else {
+                j.base, j.entries = e.Seq, nil
+            }
+        }
+        f.Close()
+        if err := scanner.Err(); err != nil {
+            return nil, err
+        }
+    }
+    if j.file, err = os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+        return nil, err
+    }
+    return j, nil
+}
+
+func (j *Journal) Close() error {
+    j.lock.Lock()
+    defer j.lock.Unlock()
+    return j.file.Close()
+}
+
+//追加记录，返回分配的序号
+func (j *Journal) Append(p, version string) (uint64, error) {
+    return j.append(Entry{Path: p, Version: version})
+}
+
+//追加删除记录，返回分配的序号
+func (j *Journal) AppendDelete(p, version string) (uint64, error) {
+    return j.append(Entry{Path: p, Version: version, Deleted: true})
+}
+
+func (j *Journal) append(e Entry) (uint64, error) {
+    j.lock.Lock()
+    defer j.lock.Unlock()
+
+    e.Seq, e.Time = j.head+1, time.Now()
+    if err := j.write(e); err != nil {
+        return 0, err
+    }
+    j.head, j.skipped = e.Seq, false
+    j.entries = append(j.entries, e)
+    close(j.changed)
+    j.changed = make(chan struct{})
+    return e.Seq, nil
+}
+
+//记录未写入日志的变化（没有副本时），之前的记录不再需要
+func (j *Journal) Skip() error {
+    j.lock.Lock()
+    defer j.lock.Unlock()
+
+    if j.skipped {
+        return nil
+    }
+    e := Entry{Seq: j.head + 1, Time: time.Now()}
+    if err := j.write(e); err != nil {
+        return err
+    }
+    j.head, j.base, j.skipped = e.Seq, e.Seq, true
+    j.dropped += len(j.entries)
+    j.entries = nil
+    return nil
+}
+
+func (j *Journal) write(e Entry) error {
+    data, err := json.Marshal(e)
+    if err != nil {
+        return err
+    }
+    _, err = j.file.Write(append(data, '\n'))
+    return err
+}
+
+//最新记录的序号
+func (j *Journal) Head() uint64 {
+    j.lock.Lock()
+    defer j.lock.Unlock()
+    return j.head
+}
+
+//序号不大于Base的记录已删除或未记录，复制进度小于Base的副本需要重新同步
+func (j *Journal) Base() uint64 {
+    j.lock.Lock()
+    defer j.lock.Unlock()
+    return j.base
+}
+
+//序号after之后的第一条记录，没有时返回false
+func (j *Journal) After(after uint64) (Entry, bool) {
+    j.lock.Lock()
+    defer j.lock.Unlock()
+    e, ok, _ := j.after(after)
+    return e, ok
+}
+
+func (j *Journal) after(after uint64) (Entry, bool, <-chan struct{}) {
+    if len(j.entries) == 0 || j.head <= after {
+        return Entry{}, false, j.changed
+    }
+    i := sort.Search(len(j.entries), func(i int) bool {
+        return j.entries[i].Seq > after
+    })
+    return j.entries[i], true, j.changed
+}
+
+//等待序号after之后的记录，stop关闭时返回false
+func (j *Journal) Next(after uint64, stop <-chan struct{}) (Entry, bool) {
+    for {
+        j.lock.Lock()
+        e, ok, changed := j.after(after)
+        j.lock.Unlock()
+        if ok {
+            return e, true
+        }
+        select {
+        case <-stop:
+            return Entry{}, false
+        case <-changed:
+        }
+    }
+}
+
+//删除序号不大于acked的记录（已复制到所有副本），删除的记录较多时重写日志文件
+func (j *Journal) Compact(acked uint64) error {
+    j.lock.Lock()
+    defer j.lock.Unlock()
+
+    n := 0
+    for n < len(j.entries) && j.entries[n].Seq <= acked {
+        n++
+    }
+    if n == 0 {
+        return nil
+    }
+    j.base = j.entries[n-1].Seq
+    j.entries = append([]Entry(nil), j.entries[n:]...)
+    j.dropped += n
+    if j.dropped < COMPACT_SIZE && len(j.entries) > 0 {
+        return nil
+    }
+    return j.rewrite()
+}
+
+func (j *Journal) rewrite() error {
+    tmp := j.path + ".tmp"
+    f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+    if err != nil {
+        return err
+    }
+    w := bufio.NewWriter(f)
+    //第一行记录已删除的序号，所有记录都被删除时为最新的序号，重启时序号继续增长
+    for _, e := range append([]Entry{{Seq: j.base}}, j.entries...) {
+        data, err := json.Marshal(e)
+        if err != nil {
+            f.Close()
+            return err
+        }
+        w.Write(append(data, '\n'))
+    }
+    err = w.Flush()
+    if err == nil {
+        err = f.Sync()
+    }
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+    if err == nil {
+        err = os.Rename(tmp, j.path)
+    }
+    if err != nil {
+        os.Remove(tmp)
+        return err
+    }
+    j.file.Close()
+    if j.file, err = os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+        return err
+    }
+    j.dropped = 0
+    return nil
+}
+
+//副本已复制的序号，保存在dir/<name>.cursor
+type cursor struct {
+    Acked uint64 `json:"acked"`
+}
+
+func cursorPath(dir, name string) string {
+    return filepath.Join(dir, name+".cursor")
+}
+
+//读取副本已复制的序号，文件不存在时返回false
+func loadCursor(dir, name string) (uint64, bool, error) {
+    data, err := ioutil.ReadFile(cursorPath(dir, name))
+    if err != nil {
+        if os.IsNotExist(err) {
+            return 0, false, nil
+        }
+        return 0, false, err
+    }
+    c := cursor{}
+    if err := json.Unmarshal(data, &c); err != nil {
+        return 0, false, err
+    }
+    return c.Acked, true, nil
+}
+
+func saveCursor(dir, name string, acked uint64) error {
+    data, err := json.Marshal(cursor{Acked: acked})
+    if err != nil {
+        return err
+    }
+    p := cursorPath(dir, name)
+    tmp := p + ".tmp"
+    if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+        return err
+    }
+    return os.Rename(tmp, p)
+}
@@ -0,0 +1,509 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package replica
+
+import (
+    "citron-repo/client"
+    "citron-repo/model"
+    "citron-repo/protocol"
+    "citron-repo/storage"
+    "errors"
+    "fmt"
+    "github.com/xfali/goutils/log"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+)
+
+const (
+    //复制日志及各副本的复制进度保存在该目录
+    REPLICATION_DIR = storage.INTERNAL_DIR + "/replication"
+
+    DEFAULT_MAX_RETRY_INTERVAL = time.Minute
+    MIN_RETRY_INTERVAL         = time.Second
+)
+
+var ErrReplicaNotFound = errors.New("replica not found")
+
+//将仓库中保存的新版本及删除、恢复异步推送到副本，每个副本一个连接，按复制日志的顺序推送
+//副本不可用时重试，复制进度持久化，重启后从上次的位置继续
+type Replicator struct {
+    storage *storage.Storage
+    dir     string
+
+    //串行执行Update及Close
+    updateLock sync.Mutex
+    lock       sync.Mutex
+    journal    *Journal
+    workers    []*worker
+}
+
+func New(s *storage.Storage) *Replicator {
+    r := &Replicator{storage: s, dir: filepath.Join(s.Dir(), filepath.FromSlash(REPLICATION_DIR))}
+    s.SetListener(r.onCommit)
+    return r
+}
+
+//在storage持有写锁时调用，只追加复制日志；没有副本时只记录存在未记录的变化
+func (r *Replicator) onCommit(info model.FileInfo) {
+    r.lock.Lock()
+    j, active := r.journal, len(r.workers) > 0
+    r.lock.Unlock()
+    if j == nil {
+        return
+    }
+    var err error
+    if !active {
+        err = j.Skip()
+    } else if info.State == model.FileDeleted {
+        _, err = j.AppendDelete(info.FilePath, info.Version)
+    } else {
+        _, err = j.Append(info.FilePath, info.Version)
+    }
+    if err != nil {
+        log.Error("append replication journal %s failed: %v", info.FilePath, err)
+    }
+}
+
+//按配置重新启动所有副本的复制，新增的副本从当前位置开始复制（之前保存的文件不复制）
+//复制进度早于日志中保留的记录的副本（如删除后重新添加）先推送所有文件的最新版本
+func (r *Replicator) Update(conf model.ReplicationConfig) error {
+    r.updateLock.Lock()
+    defer r.updateLock.Unlock()
+
+    r.lock.Lock()
+    old := r.workers
+    r.lock.Unlock()
+    //停止期间仍然记录复制日志
+    for _, w := range old {
+        w.close()
+    }
+
+    var workers []*worker
+    var err error
+    if len(conf.Replicas) > 0 {
+        workers, err = r.newWorkers(conf)
+    } else if _, serr := os.Stat(filepath.Join(r.dir, JOURNAL_FILE)); serr == nil {
+        //使用过复制时打开日志，记录没有副本期间的变化
+        err = r.openJournal()
+    }
+    r.lock.Lock()
+    r.workers = workers
+    r.lock.Unlock()
+    for _, w := range workers {
+        go w.run()
+    }
+    return err
+}
+
+func (r *Replicator) openJournal() error {
+    if r.journal != nil {
+        return nil
+    }
+    j, err := OpenJournal(r.dir)
+    if err != nil {
+        return err
+    }
+    r.lock.Lock()
+    r.journal = j
+    r.lock.Unlock()
+    return nil
+}
+
+func (r *Replicator) newWorkers(conf model.ReplicationConfig) ([]*worker, error) {
+    if err := r.openJournal(); err != nil {
+        return nil, err
+    }
+    maxRetry := conf.MaxRetryInterval.Duration()
+    if maxRetry <= 0 {
+        maxRetry = DEFAULT_MAX_RETRY_INTERVAL
+    }
+    workers := make([]*worker, 0, len(conf.Replicas))
+    for _, c := range conf.Replicas {
+        acked, ok, err := loadCursor(r.dir, c.Name)
+        if err != nil {
+            return nil, err
+        }
+        if !ok {
+            acked = r.journal.Head()
+            if err := saveCursor(r.dir, c.Name, acked); err != nil {
+                return nil, err
+            }
+        }
+        w := &worker{
+            r:        r,
+            conf:     c,
+            maxRetry: maxRetry,
+            stop:     make(chan struct{}),
+            done:     make(chan struct{}),
+        }
+        w.status.Acked = acked
+        if base := r.journal.Base(); acked < base {
+            log.Warn("replication journal of %s is incomplete after %d, resync all files", c.Name, acked)
+            w.resync = true
+            w.status.LastError = fmt.Sprintf("journal incomplete after %d, resyncing", acked)
+        }
+        workers = append(workers, w)
+    }
+    return workers, nil
+}
+
+func (r *Replicator) Close() error {
+    r.updateLock.Lock()
+    defer r.updateLock.Unlock()
+
+    r.lock.Lock()
+    workers, j := r.workers, r.journal
+    r.workers, r.journal = nil, nil
+    r.lock.Unlock()
+    for _, w := range workers {
+        w.close()
+    }
+    if j != nil {
+        return j.Close()
+    }
+    return nil
+}
+
+//所有副本的复制状态
+func (r *Replicator) Status() []model.ReplicaStatus {
+    r.lock.Lock()
+    workers, j := r.workers, r.journal
+    r.lock.Unlock()
+
+    ret := make([]model.ReplicaStatus, 0, len(workers))
+    now := time.Now()
+    for _, w := range workers {
+        status := w.getStatus()
+        status.Head = j.Head()
+        if status.Head > status.Acked {
+            status.Pending = status.Head - status.Acked
+            if e, ok := j.After(status.Acked); ok {
+                status.Lag = model.Duration(now.Sub(e.Time))
+            }
+        }
+        ret = append(ret, status)
+    }
+    return ret
+}
+
+//删除已复制到所有副本的记录
+func (r *Replicator) compact() {
+    r.lock.Lock()
+    workers, j := r.workers, r.journal
+    r.lock.Unlock()
+    if len(workers) == 0 {
+        return
+    }
+    min := workers[0].acked()
+    for _, w := range workers[1:] {
+        if acked := w.acked(); acked < min {
+            min = acked
+        }
+    }
+    if err := j.Compact(min); err != nil {
+        log.Warn("compact replication journal failed: %v", err)
+    }
+}
+
+//比较所有文件的最新版本与副本中的大小及checksum，并列出副本中多余的文件（如未复制的删除）
+func (r *Replicator) Verify(name string) (model.ReplicaVerify, error) {
+    ret := model.ReplicaVerify{Name: name}
+    var conf *model.ReplicaConfig
+    r.lock.Lock()
+    for _, w := range r.workers {
+        if w.conf.Name == name {
+            c := w.conf
+            conf = &c
+        }
+    }
+    r.lock.Unlock()
+    if conf == nil {
+        return ret, ErrReplicaNotFound
+    }
+
+    infos, err := r.storage.Manifest("/")
+    if err != nil {
+        return ret, err
+    }
+    c, err := connect(*conf)
+    if err != nil {
+        return ret, err
+    }
+    defer c.Close()
+    files := make(map[string]bool, len(infos))
+    for _, info := range infos {
+        files[info.FilePath] = true
+        ret.Files++
+        if info.Checksum == "" {
+            ret.Skipped++
+            continue
+        }
+        replica, err := c.Stat(info.FilePath)
+        if err != nil {
+            if perr, ok := err.(*protocol.Error); ok && perr.Status == protocol.StatusNotFound {
+                ret.Missing = append(ret.Missing, info.FilePath)
+                continue
+            }
+            return ret, err
+        }
+        if replica.Size != info.Size || replica.Checksum != info.Checksum {
+            ret.Mismatched = append(ret.Mismatched, info.FilePath)
+            continue
+        }
+        ret.Matched++
+    }
+    err = walkReplica(c, "/", func(info model.FileInfo) {
+        if !files[info.FilePath] {
+            ret.Extra = append(ret.Extra, info.FilePath)
+        }
+    })
+    return ret, err
+}
+
+//遍历副本中p下的所有文件
+func walkReplica(c *client.BinaryClient, p string, f func(info model.FileInfo)) error {
+    infos, err := c.List(p)
+    if err != nil {
+        return err
+    }
+    for _, info := range infos {
+        if !info.IsDir {
+            f(info)
+            continue
+        }
+        if err := walkReplica(c, info.FilePath, f); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+//连接副本并认证
+func connect(conf model.ReplicaConfig) (*client.BinaryClient, error) {
+    c, err := client.Dial(conf.Addr)
+    if err != nil {
+        return nil, err
+    }
+    //不支持协商的副本不压缩
+    if err := c.Negotiate(protocol.DefaultCodecs); err != nil {
+        if _, ok := err.(*protocol.Error); !ok {
+            c.Close()
+            return nil, err
+        }
+    }
+    if conf.ApiKey != "" {
+        err = c.LoginWithApiKey(conf.ApiKey)
+    } else {
+        err = c.Login(conf.Username, conf.Password)
+    }
+    if err != nil {
+        c.Close()
+        return nil, err
+    }
+    return c, nil
+}
+
+//复制到一个副本
+type worker struct {
+    r        *Replicator
+    conf     model.ReplicaConfig
+    maxRetry time.Duration
+    conn     *client.BinaryClient
+    //复制进度早于日志中保留的记录，需要先推送所有文件
+    resync bool
+
+    lock   sync.Mutex
+    status model.ReplicaStatus
+
+    stop chan struct{}
+    done chan struct{}
+}
+
+func (w *worker) run() {
+    defer close(w.done)
+    defer w.disconnect()
+
+    if w.resync && !w.pushAll() {
+        return
+    }
+    retry := time.Duration(0)
+    for {
+        e, ok := w.r.journal.Next(w.acked(), w.stop)
+        if !ok {
+            return
+        }
+        size, err := w.push(e)
+        if err != nil {
+            log.Warn("replicate %s to %s failed: %v", e.Path, w.conf.Name, err)
+            if retry, ok = w.fail(err, retry); !ok {
+                return
+            }
+            continue
+        }
+        retry = 0
+        if err := saveCursor(w.r.dir, w.conf.Name, e.Seq); err != nil {
+            log.Warn("save replication cursor of %s failed: %v", w.conf.Name, err)
+        }
+        w.lock.Lock()
+        w.status.Acked, w.status.LastSync, w.status.LastError = e.Seq, time.Now(), ""
+        if size >= 0 {
+            w.status.Files++
+            w.status.Bytes += size
+        }
+        w.lock.Unlock()
+        w.r.compact()
+    }
+}
+
+//推送所有文件的最新版本，之后从日志的当前位置继续；停止时返回false
+//复制进度之后的删除无法同步，副本中多余的文件由Verify列出
+func (w *worker) pushAll() bool {
+    retry := time.Duration(0)
+    for {
+        //之后的变化都记录在日志中
+        head := w.r.journal.Head()
+        infos, err := w.r.storage.Manifest("/")
+        files, bytes := int64(0), int64(0)
+        for i := 0; err == nil && i < len(infos); i++ {
+            var size int64
+            size, err = w.push(Entry{Path: infos[i].FilePath, Version: infos[i].Version})
+            if err == nil && size >= 0 {
+                files++
+                bytes += size
+            }
+        }
+        if err != nil {
+            log.Warn("resync to %s failed: %v", w.conf.Name, err)
+            var ok bool
+            if retry, ok = w.fail(err, retry); !ok {
+                return false
+            }
+            continue
+        }
+        if err := saveCursor(w.r.dir, w.conf.Name, head); err != nil {
+            log.Warn("save replication cursor of %s failed: %v", w.conf.Name, err)
+        }
+        w.lock.Lock()
+        w.status.Acked, w.status.LastSync, w.status.LastError = head, time.Now(), ""
+        w.status.Files += files
+        w.status.Bytes += bytes
+        w.lock.Unlock()
+        w.r.compact()
+        return true
+    }
+}
+
+//记录失败并等待重试，返回下次的重试间隔；停止时返回false
+func (w *worker) fail(err error, retry time.Duration) (time.Duration, bool) {
+    w.disconnect()
+    w.lock.Lock()
+    w.status.LastError = err.Error()
+    w.lock.Unlock()
+
+    retry *= 2
+    if retry < MIN_RETRY_INTERVAL {
+        retry = MIN_RETRY_INTERVAL
+    }
+    if retry > w.maxRetry {
+        retry = w.maxRetry
+    }
+    timer := time.NewTimer(retry)
+    select {
+    case <-w.stop:
+        timer.Stop()
+        return retry, false
+    case <-timer.C:
+    }
+    return retry, true
+}
+
+//推送记录对应的版本，返回推送的大小；版本已被清理时跳过（返回-1），之后的记录包含文件的新版本
+//删除记录在副本中删除文件（移动到副本的回收站），返回-1
+func (w *worker) push(e Entry) (int64, error) {
+    if e.Deleted {
+        return -1, w.delete(e.Path)
+    }
+    r, info, err := w.r.storage.Open(e.Path, e.Version)
+    if err == storage.ErrNotFound || err == storage.ErrIsDir {
+        return -1, nil
+    }
+    if err != nil {
+        return 0, err
+    }
+    defer r.Close()
+
+    if err := w.connect(); err != nil {
+        return 0, err
+    }
+    _, err = w.conn.Upload(model.FileInfo{
+        FilePath: e.Path,
+        Mode:     info.Mode,
+        ModTime:  info.ModTime,
+        Checksum: info.Checksum,
+    }, info.Size, r)
+    return info.Size, err
+}
+
+//副本中不存在（未复制或已删除）时忽略
+func (w *worker) delete(p string) error {
+    if err := w.connect(); err != nil {
+        return err
+    }
+    _, err := w.conn.Delete(p)
+    if perr, ok := err.(*protocol.Error); ok && perr.Status == protocol.StatusNotFound {
+        return nil
+    }
+    return err
+}
+
+func (w *worker) connect() error {
+    if w.conn != nil {
+        return nil
+    }
+    c, err := connect(w.conf)
+    if err != nil {
+        return err
+    }
+    w.conn = c
+    w.setConnected(true)
+    return nil
+}
+
+func (w *worker) disconnect() {
+    if w.conn != nil {
+        w.conn.Close()
+        w.conn = nil
+        w.setConnected(false)
+    }
+}
+
+func (w *worker) setConnected(connected bool) {
+    w.lock.Lock()
+    defer w.lock.Unlock()
+    w.status.Connected = connected
+}
+
+func (w *worker) acked() uint64 {
+    w.lock.Lock()
+    defer w.lock.Unlock()
+    return w.status.Acked
+}
+
+func (w *worker) getStatus() model.ReplicaStatus {
+    w.lock.Lock()
+    defer w.lock.Unlock()
+    status := w.status
+    status.Name, status.Addr = w.conf.Name, w.conf.Addr
+    return status
+}
+
+func (w *worker) close() {
+    close(w.stop)
+    <-w.done
+}
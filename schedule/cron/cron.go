@@ -0,0 +1,214 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package cron
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+//最多向后查找的年数，超过则认为表达式不会触发（如2月30日）
+const MAX_SEARCH_YEARS = 5
+
+type Schedule interface {
+    //t之后的下一次执行时间，不会触发时返回零值
+    Next(t time.Time) time.Time
+}
+
+//标准5段cron表达式：分 时 日 月 周
+type cronSchedule struct {
+    minute, hour, dom, month, dow uint64
+    //日和周都不为*时满足其一即可
+    domStar, dowStar bool
+}
+
+type field struct {
+    min, max int
+    names    map[string]int
+}
+
+var (
+    minuteField = field{0, 59, nil}
+    hourField   = field{0, 23, nil}
+    domField    = field{1, 31, nil}
+    monthField  = field{1, 12, map[string]int{
+        "jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+        "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+    }}
+    //0和7都表示周日
+    dowField = field{0, 7, map[string]int{
+        "sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+    }}
+)
+
+var descriptors = map[string]string{
+    "@yearly":   "0 0 1 1 *",
+    "@annually": "0 0 1 1 *",
+    "@monthly":  "0 0 1 * *",
+    "@weekly":   "0 0 * * 0",
+    "@daily":    "0 0 * * *",
+    "@midnight": "0 0 * * *",
+    "@hourly":   "0 * * * *",
+}
+
+//解析cron表达式，支持*、a-b、*/n、a-b/n、逗号分隔的列表、月及周的英文缩写，
+//以及@daily等预定义表达式和@every <duration>
+func Parse(expr string) (Schedule, error) {
+    expr = strings.TrimSpace(expr)
+    if strings.HasPrefix(expr, "@every ") {
+        d, err := time.ParseDuration(strings.TrimSpace(expr[len("@every "):]))
+        if err != nil {
+            return nil, fmt.Errorf("invalid schedule %q: %v", expr, err)
+        }
+        if d < time.Second {
+            return nil, fmt.Errorf("invalid schedule %q: interval must be at least 1s", expr)
+        }
+        return every(d), nil
+    }
+    if d, ok := descriptors[strings.ToLower(expr)]; ok {
+        expr = d
+    }
+
+    fields := strings.Fields(expr)
+    if len(fields) != 5 {
+        return nil, fmt.Errorf("invalid schedule %q: expect 5 fields", expr)
+    }
+    c := &cronSchedule{}
+    var err error
+    if c.minute, err = minuteField.parse(fields[0]); err != nil {
+        return nil, fmt.Errorf("invalid schedule %q: minute %v", expr, err)
+    }
+    if c.hour, err = hourField.parse(fields[1]); err != nil {
+        return nil, fmt.Errorf("invalid schedule %q: hour %v", expr, err)
+    }
+    if c.dom, err = domField.parse(fields[2]); err != nil {
+        return nil, fmt.Errorf("invalid schedule %q: day of month %v", expr, err)
+    }
+    if c.month, err = monthField.parse(fields[3]); err != nil {
+        return nil, fmt.Errorf("invalid schedule %q: month %v", expr, err)
+    }
+    if c.dow, err = dowField.parse(fields[4]); err != nil {
+        return nil, fmt.Errorf("invalid schedule %q: day of week %v", expr, err)
+    }
+    if c.dow&(1<<7) != 0 {
+        c.dow |= 1
+    }
+    c.domStar = fields[2] == "*" || fields[2] == "?"
+    c.dowStar = fields[4] == "*" || fields[4] == "?"
+    return c, nil
+}
+
+//解析一段表达式，返回匹配值的位集合
+func (f field) parse(s string) (uint64, error) {
+    var bits uint64
+    for _, part := range strings.Split(s, ",") {
+        step := 1
+        if i := strings.Index(part, "/"); i >= 0 {
+            n, err := strconv.Atoi(part[i+1:])
+            if err != nil || n <= 0 {
+                return 0, fmt.Errorf("invalid step %q", part)
+            }
+            step = n
+            part = part[:i]
+        }
+        lo, hi := f.min, f.max
+        switch {
+        case part == "*" || part == "?":
+        case strings.Contains(part, "-"):
+            i := strings.Index(part, "-")
+            var err error
+            if lo, err = f.value(part[:i]); err != nil {
+                return 0, err
+            }
+            if hi, err = f.value(part[i+1:]); err != nil {
+                return 0, err
+            }
+        default:
+            v, err := f.value(part)
+            if err != nil {
+                return 0, err
+            }
+            lo = v
+            //n/step表示从n开始到最大值
+            if step == 1 {
+                hi = v
+            }
+        }
+        if lo > hi {
+            return 0, fmt.Errorf("invalid range %q", part)
+        }
+        for v := lo; v <= hi; v += step {
+            bits |= 1 << uint(v)
+        }
+    }
+    return bits, nil
+}
+
+func (f field) value(s string) (int, error) {
+    if v, ok := f.names[strings.ToLower(s)]; ok {
+        return v, nil
+    }
+    v, err := strconv.Atoi(s)
+    if err != nil || v < f.min || v > f.max {
+        return 0, fmt.Errorf("value %q out of range [%d, %d]", s, f.min, f.max)
+    }
+    return v, nil
+}
+
+func (c *cronSchedule) Next(t time.Time) time.Time {
+    loc := t.Location()
+    t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+    limit := t.Year() + MAX_SEARCH_YEARS
+
+WRAP:
+    for t.Year() <= limit {
+        for c.month&(1<<uint(t.Month())) == 0 {
+            t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+            if t.Month() == time.January {
+                continue WRAP
+            }
+        }
+        for !c.dayMatch(t) {
+            t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+            if t.Day() == 1 {
+                continue WRAP
+            }
+        }
+        for c.hour&(1<<uint(t.Hour())) == 0 {
+            t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+            if t.Hour() == 0 {
+                continue WRAP
+            }
+        }
+        for c.minute&(1<<uint(t.Minute())) == 0 {
+            t = t.Add(time.Minute)
+            if t.Minute() == 0 {
+                continue WRAP
+            }
+        }
+        return t
+    }
+    return time.Time{}
+}
+
+func (c *cronSchedule) dayMatch(t time.Time) bool {
+    dom := c.dom&(1<<uint(t.Day())) != 0
+    dow := c.dow&(1<<uint(t.Weekday())) != 0
+    if c.domStar || c.dowStar {
+        return dom && dow
+    }
+    return dom || dow
+}
+
+//固定间隔
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+    return t.Add(time.Duration(e)).Truncate(time.Second)
+}
@@ -0,0 +1,170 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package schedule
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "net/url"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+)
+
+//每个任务保留的执行记录数
+const MAX_HISTORY = 50
+
+const (
+    StatusRunning = "running"
+    StatusOk      = "ok"
+    //部分文件失败或执行出错
+    StatusFailed = "failed"
+    //上次执行未结束，跳过本次执行
+    StatusSkipped = "skipped"
+)
+
+//一次任务执行记录
+type Run struct {
+    Job     string    `json:"job"`
+    Start   time.Time `json:"start"`
+    End     time.Time `json:"end,omitempty"`
+    Status  string    `json:"status"`
+    Added   int       `json:"added"`
+    Changed int       `json:"changed"`
+    Skipped int       `json:"skipped"`
+    Failed  int       `json:"failed"`
+    Bytes   int64     `json:"bytes"`
+    //保留策略删除的历史版本数
+    Pruned int `json:"pruned"`
+    //创建的快照
+    Snapshot string `json:"snapshot,omitempty"`
+    Error    string `json:"error,omitempty"`
+}
+
+//任务执行历史，保存在本地文件中供status命令查看
+//守护进程与schedule -run可能同时写入，保存时加文件锁并合并文件中其他进程的记录
+type History struct {
+    path string
+    lock sync.Mutex
+    Runs map[string][]Run `json:"runs"`
+}
+
+//读取执行历史，文件不存在时返回空历史
+func LoadHistory(path string) (*History, error) {
+    h := &History{path: path, Runs: map[string][]Run{}}
+    runs, err := h.load()
+    if err != nil {
+        return nil, err
+    }
+    h.Runs = runs
+    return h, nil
+}
+
+func (h *History) load() (map[string][]Run, error) {
+    data, err := ioutil.ReadFile(h.path)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return map[string][]Run{}, nil
+        }
+        return nil, err
+    }
+    v := struct {
+        Runs map[string][]Run `json:"runs"`
+    }{}
+    if err := json.Unmarshal(data, &v); err != nil {
+        return nil, err
+    }
+    if v.Runs == nil {
+        v.Runs = map[string][]Run{}
+    }
+    return v.Runs, nil
+}
+
+//执行历史的文件锁
+func (h *History) lockPath() string {
+    return h.path + ".lock"
+}
+
+//任务的文件锁，与执行历史保存在同一目录
+func (h *History) jobLockPath(job string) string {
+    return filepath.Join(filepath.Dir(h.path), "locks", url.PathEscape(job)+".lock")
+}
+
+//记录执行结果（Start相同的记录会被替换），并保存
+//保存前重新读取文件，其他进程写入的记录不会被覆盖
+func (h *History) Put(run Run) error {
+    h.lock.Lock()
+    defer h.lock.Unlock()
+
+    if h.path != "" {
+        l, err := lockFile(h.lockPath())
+        if err != nil {
+            return err
+        }
+        defer l.Unlock()
+        runs, err := h.load()
+        if err != nil {
+            return err
+        }
+        h.Runs = runs
+    }
+
+    runs := h.Runs[run.Job]
+    replaced := false
+    for i := len(runs) - 1; i >= 0; i-- {
+        if runs[i].Start.Equal(run.Start) {
+            runs[i] = run
+            replaced = true
+            break
+        }
+    }
+    if !replaced {
+        runs = append(runs, run)
+    }
+    if len(runs) > MAX_HISTORY {
+        runs = runs[len(runs)-MAX_HISTORY:]
+    }
+    h.Runs[run.Job] = runs
+    return h.save()
+}
+
+//任务的执行记录，最近的在最后
+func (h *History) Get(job string) []Run {
+    h.lock.Lock()
+    defer h.lock.Unlock()
+
+    return append([]Run(nil), h.Runs[job]...)
+}
+
+//最近一次执行记录
+func (h *History) Last(job string) (Run, bool) {
+    runs := h.Get(job)
+    if len(runs) == 0 {
+        return Run{}, false
+    }
+    return runs[len(runs)-1], true
+}
+
+func (h *History) save() error {
+    if h.path == "" {
+        return nil
+    }
+    data, err := json.Marshal(h)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(h.path), 0700); err != nil {
+        return err
+    }
+    tmp := h.path + ".tmp"
+    if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+        os.Remove(tmp)
+        return err
+    }
+    return os.Rename(tmp, h.path)
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package schedule
+
+import (
+    "os"
+    "path/filepath"
+)
+
+//进程间的文件锁，守护进程与schedule -run同时执行时避免同一任务重复执行、执行历史互相覆盖
+//进程退出时自动释放
+type fileLock struct {
+    f *os.File
+}
+
+func openLock(path string) (*os.File, error) {
+    if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+        return nil, err
+    }
+    return os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+}
+
+//加锁，已被其他进程锁定时等待
+func lockFile(path string) (*fileLock, error) {
+    f, err := openLock(path)
+    if err != nil {
+        return nil, err
+    }
+    if err := flock(f, true); err != nil {
+        f.Close()
+        return nil, err
+    }
+    return &fileLock{f: f}, nil
+}
+
+//尝试加锁，已被其他进程锁定时返回false
+func tryLockFile(path string) (*fileLock, bool, error) {
+    f, err := openLock(path)
+    if err != nil {
+        return nil, false, err
+    }
+    if err := flock(f, false); err != nil {
+        f.Close()
+        if err == errLocked {
+            return nil, false, nil
+        }
+        return nil, false, err
+    }
+    return &fileLock{f: f}, true, nil
+}
+
+//关闭文件即释放锁，锁文件保留
+func (l *fileLock) Unlock() error {
+    return l.f.Close()
+}
@@ -0,0 +1,36 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+// +build !windows
+
+package schedule
+
+import (
+    "errors"
+    "os"
+    "syscall"
+)
+
+var errLocked = errors.New("locked by another process")
+
+func flock(f *os.File, wait bool) error {
+    how := syscall.LOCK_EX
+    if !wait {
+        how |= syscall.LOCK_NB
+    }
+    for {
+        err := syscall.Flock(int(f.Fd()), how)
+        switch err {
+        case nil:
+            return nil
+        case syscall.EINTR:
+            continue
+        case syscall.EWOULDBLOCK:
+            return errLocked
+        }
+        return err
+    }
+}
@@ -0,0 +1,19 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package schedule
+
+import (
+    "errors"
+    "os"
+)
+
+var errLocked = errors.New("locked by another process")
+
+//windows不支持flock，只在进程内避免重复执行
+func flock(f *os.File, wait bool) error {
+    return nil
+}
@@ -0,0 +1,276 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package schedule
+
+import (
+    "citron-repo/client"
+    "citron-repo/model"
+    "citron-repo/schedule/cron"
+    "errors"
+    "fmt"
+    "github.com/xfali/goutils/log"
+    "os"
+    "path/filepath"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+var (
+    ErrJobNotFound = errors.New("job not found")
+    ErrJobRunning  = errors.New("previous run still running")
+)
+
+//备份任务定义
+type Job struct {
+    Name string `yaml:"name"`
+    //本地目录
+    Dir string `yaml:"dir"`
+    //仓库路径
+    Dest string `yaml:"dest"`
+    //cron表达式
+    Schedule  string          `yaml:"schedule"`
+    Retention model.Retention `yaml:"retention"`
+    //忽略规则，与全局规则合并
+    Exclude  []string `yaml:"exclude"`
+    Checksum bool     `yaml:"checksum"`
+    //每次执行创建快照，快照标签为任务名
+    Snapshot bool `yaml:"snapshot"`
+}
+
+type job struct {
+    Job
+    schedule cron.Schedule
+    running  int32
+}
+
+//连接仓库，每次执行任务时建立新连接，任务之间互不影响
+type Connector func() (client.Repo, error)
+
+//按计划执行备份任务，同一任务上次执行未结束时跳过本次执行
+//有执行历史文件时使用文件锁，其他进程（如schedule -run）正在执行的任务同样跳过
+type Scheduler struct {
+    jobs    []*job
+    connect Connector
+    history *History
+    //全局备份选项（如全局忽略规则）
+    exclude  []string
+    callback func(job string, e client.BackupEntry)
+    wait     sync.WaitGroup
+}
+
+type Opt func(s *Scheduler)
+
+//全局忽略规则
+func SetExclude(patterns []string) Opt {
+    return func(s *Scheduler) {
+        s.exclude = patterns
+    }
+}
+
+//每个文件处理完成后回调
+func SetCallback(callback func(job string, e client.BackupEntry)) Opt {
+    return func(s *Scheduler) {
+        s.callback = callback
+    }
+}
+
+//校验并解析任务定义
+func New(jobs []Job, connect Connector, history *History, opts ...Opt) (*Scheduler, error) {
+    s := &Scheduler{connect: connect, history: history}
+    names := map[string]bool{}
+    for _, j := range jobs {
+        if j.Name == "" || names[j.Name] {
+            return nil, fmt.Errorf("job name %q is empty or duplicated", j.Name)
+        }
+        names[j.Name] = true
+        if j.Dir == "" {
+            return nil, fmt.Errorf("job %s: dir is required", j.Name)
+        }
+        if j.Dest == "" {
+            j.Dest = filepath.Base(j.Dir)
+        }
+        if j.Retention.Versions < 0 || j.Retention.MaxAge < 0 {
+            return nil, fmt.Errorf("job %s: invalid retention", j.Name)
+        }
+        sch, err := cron.Parse(j.Schedule)
+        if err != nil {
+            return nil, fmt.Errorf("job %s: %v", j.Name, err)
+        }
+        s.jobs = append(s.jobs, &job{Job: j, schedule: sch})
+    }
+    for i := range opts {
+        opts[i](s)
+    }
+    return s, nil
+}
+
+//任务t之后的下一次执行时间
+func (s *Scheduler) Next(name string, t time.Time) (time.Time, error) {
+    j := s.find(name)
+    if j == nil {
+        return time.Time{}, ErrJobNotFound
+    }
+    return j.schedule.Next(t), nil
+}
+
+func (s *Scheduler) find(name string) *job {
+    for _, j := range s.jobs {
+        if j.Name == name {
+            return j
+        }
+    }
+    return nil
+}
+
+//按计划执行任务直到stop关闭，返回前等待正在执行的任务结束
+func (s *Scheduler) Run(stop <-chan struct{}) {
+    defer s.wait.Wait()
+
+    now := time.Now()
+    next := make([]time.Time, len(s.jobs))
+    for i, j := range s.jobs {
+        next[i] = j.schedule.Next(now)
+    }
+    for {
+        var earliest time.Time
+        for _, t := range next {
+            if !t.IsZero() && (earliest.IsZero() || t.Before(earliest)) {
+                earliest = t
+            }
+        }
+        if earliest.IsZero() {
+            <-stop
+            return
+        }
+
+        timer := time.NewTimer(time.Until(earliest))
+        select {
+        case <-stop:
+            timer.Stop()
+            return
+        case <-timer.C:
+        }
+
+        now = time.Now()
+        for i, j := range s.jobs {
+            if next[i].IsZero() || next[i].After(now) {
+                continue
+            }
+            s.wait.Add(1)
+            go func(j *job) {
+                defer s.wait.Done()
+                if _, err := s.run(j); err != nil {
+                    log.Warn("job %s failed: %v", j.Name, err)
+                }
+            }(j)
+            next[i] = j.schedule.Next(now)
+        }
+    }
+}
+
+//立即执行任务
+func (s *Scheduler) RunJob(name string) (Run, error) {
+    j := s.find(name)
+    if j == nil {
+        return Run{}, ErrJobNotFound
+    }
+    return s.run(j)
+}
+
+func (s *Scheduler) run(j *job) (Run, error) {
+    run := Run{Job: j.Name, Start: time.Now(), Status: StatusRunning}
+    if !atomic.CompareAndSwapInt32(&j.running, 0, 1) {
+        run.End, run.Status, run.Error = run.Start, StatusSkipped, ErrJobRunning.Error()
+        s.save(run)
+        return run, ErrJobRunning
+    }
+    defer atomic.StoreInt32(&j.running, 0)
+    l, ok, err := s.lockJob(j.Name)
+    if err != nil {
+        run.End, run.Status, run.Error = run.Start, StatusFailed, err.Error()
+        s.save(run)
+        return run, err
+    }
+    if !ok {
+        run.End, run.Status, run.Error = run.Start, StatusSkipped, ErrJobRunning.Error()
+        s.save(run)
+        return run, ErrJobRunning
+    }
+    if l != nil {
+        defer l.Unlock()
+    }
+    s.save(run)
+
+    err = s.backup(j, &run)
+    run.End = time.Now()
+    run.Status = StatusOk
+    if err != nil {
+        run.Status, run.Error = StatusFailed, err.Error()
+    } else if run.Failed > 0 {
+        run.Status = StatusFailed
+        err = fmt.Errorf("%d files failed", run.Failed)
+    }
+    s.save(run)
+    return run, err
+}
+
+//锁定任务，没有执行历史文件时只在进程内避免重复执行（返回nil）
+func (s *Scheduler) lockJob(name string) (*fileLock, bool, error) {
+    if s.history == nil || s.history.path == "" {
+        return nil, true, nil
+    }
+    return tryLockFile(s.history.jobLockPath(name))
+}
+
+func (s *Scheduler) backup(j *job, run *Run) error {
+    repo, err := s.connect()
+    if err != nil {
+        return err
+    }
+    defer repo.Close()
+
+    opts := []client.BackupOpt{
+        client.SetCompareChecksum(j.Checksum),
+        client.SetExclude(append(append([]string(nil), s.exclude...), j.Exclude...)),
+    }
+    if j.Snapshot {
+        host, _ := os.Hostname()
+        opts = append(opts, client.SetSnapshot(host, j.Name))
+    }
+    if s.callback != nil {
+        opts = append(opts, client.SetCallback(func(e client.BackupEntry) {
+            s.callback(j.Name, e)
+        }))
+    }
+    summary, err := client.NewBackup(repo, opts...).Dir(j.Dir, j.Dest)
+    run.Added, run.Changed, run.Skipped, run.Failed, run.Bytes =
+        summary.Added, summary.Changed, summary.Skipped, summary.Failed, summary.Bytes
+    run.Snapshot = summary.Snapshot.ID
+    if err != nil {
+        return err
+    }
+
+    if j.Retention.IsZero() {
+        return nil
+    }
+    ret, err := repo.Prune(model.PruneRequest{Path: j.Dest, Retention: j.Retention})
+    run.Pruned = ret.Versions
+    if err != nil {
+        return fmt.Errorf("prune: %v", err)
+    }
+    return nil
+}
+
+func (s *Scheduler) save(run Run) {
+    if s.history == nil {
+        return
+    }
+    if err := s.history.Put(run); err != nil {
+        log.Warn("save job history failed: %v", err)
+    }
+}
@@ -0,0 +1,180 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package server
+
+import (
+    "citron-repo/model"
+    "citron-repo/transport"
+    "context"
+    "fmt"
+    "github.com/gin-gonic/gin"
+    webconf "github.com/xfali/go-web-starter/config"
+    "github.com/xfali/go-web-starter/web/midware"
+    "github.com/xfali/goutils/log"
+    "net"
+    "net/http"
+    "os"
+    "os/signal"
+    "sync"
+    "syscall"
+    "time"
+)
+
+const (
+    SHUTDOWN_TIMEOUT = 10 * time.Second
+)
+
+//同时提供给REST及二进制协议，保证两者使用相同的认证及存储
+type Handler interface {
+    Api(engine *gin.Engine)
+    BinaryAuthenticator() transport.Authenticator
+    BinaryHandlerFactory() transport.RequestHandlerFactory
+}
+
+//同时运行REST及二进制协议服务
+type Server struct {
+    conf    model.Config
+    handler Handler
+    binOpts []transport.BinOpt
+
+    http     *http.Server
+    binary   *transport.BinaryServer
+    errChan  chan error
+    wait     sync.WaitGroup
+    stopOnce sync.Once
+}
+
+type Opt func(s *Server)
+
+//附加的二进制服务参数，如transport.SetMagicCode
+func SetBinaryOpts(opts ...transport.BinOpt) Opt {
+    return func(s *Server) {
+        s.binOpts = append(s.binOpts, opts...)
+    }
+}
+
+func New(conf model.Config, handler Handler, opts ...Opt) *Server {
+    ret := &Server{
+        conf:    conf,
+        handler: handler,
+        errChan: make(chan error, 2),
+    }
+    for i := range opts {
+        opts[i](ret)
+    }
+    return ret
+}
+
+//监听端口并在后台运行，http端口监听失败时直接返回错误，其他运行错误通过Err()返回
+func (s *Server) Start() error {
+    l, err := net.Listen("tcp", fmt.Sprintf(":%d", s.conf.Http.Port))
+    if err != nil {
+        return err
+    }
+    //默认只限制读取请求头及空闲连接的时间，请求body（上传、下载、快照比较）不限制
+    s.http = &http.Server{
+        Handler:           s.engine(),
+        ReadTimeout:       s.conf.Http.ReadTimeout.Duration(),
+        WriteTimeout:      s.conf.Http.WriteTimeout.Duration(),
+        ReadHeaderTimeout: s.conf.Http.ReadHeaderTimeout.Duration(),
+        IdleTimeout:       s.conf.Http.IdleTimeout.Duration(),
+        MaxHeaderBytes:    1 << 20,
+    }
+
+    tcp := transport.NewTcpTransport(
+        transport.SetPort(fmt.Sprintf(":%d", s.conf.Binary.Port)),
+        transport.SetReadTimeout(s.conf.Binary.ReadTimeout.Duration()),
+        transport.SetWriteTimeout(s.conf.Binary.WriteTimeout.Duration()),
+        transport.SetMaxConnections(s.conf.Limit.MaxConnections),
+    )
+    opts := []transport.BinOpt{
+        transport.SetTransport(tcp),
+        transport.SetReadBufSize(s.conf.Binary.ReadBufSize),
+        transport.SetWriteBufSize(s.conf.Binary.WriteBufSize),
+        transport.SetCompression(s.conf.Binary.Compression, s.conf.Binary.CompressMinSize),
+        transport.SetAuthenticator(s.handler.BinaryAuthenticator()),
+        transport.SetRequestHandlerFactory(s.handler.BinaryHandlerFactory()),
+    }
+    s.binary = transport.NewBinaryServer(append(opts, s.binOpts...)...)
+
+    s.wait.Add(2)
+    go func() {
+        defer s.wait.Done()
+        log.Info("http server listen on %s", l.Addr())
+        if err := s.http.Serve(l); err != nil && err != http.ErrServerClosed {
+            s.errChan <- fmt.Errorf("http server: %v", err)
+        }
+    }()
+    go func() {
+        defer s.wait.Done()
+        log.Info("binary server listen on :%d", s.conf.Binary.Port)
+        if err := s.binary.ListenAndServe(); err != nil {
+            s.errChan <- fmt.Errorf("binary server: %v", err)
+        }
+    }()
+    return nil
+}
+
+func (s *Server) engine() *gin.Engine {
+    //不记录响应内容，避免token写入日志
+    conf := webconf.Default()
+    conf.LogResponse = false
+    webconf.ResetConfig(conf)
+
+    engine := gin.New()
+    engine.Use(midware.Recovery())
+    engine.Use(midware.LogHttp())
+    s.handler.Api(engine)
+    return engine
+}
+
+//服务运行出错时返回错误
+func (s *Server) Err() <-chan error {
+    return s.errChan
+}
+
+//停止接收新请求，等待正在处理的http请求结束（最多等待至ctx超时），关闭所有二进制连接
+func (s *Server) Shutdown(ctx context.Context) error {
+    var err error
+    s.stopOnce.Do(func() {
+        if s.http == nil {
+            return
+        }
+        err = s.http.Shutdown(ctx)
+        if berr := s.binary.Close(); err == nil {
+            err = berr
+        }
+        s.wait.Wait()
+    })
+    return err
+}
+
+//启动服务，收到SIGINT/SIGTERM或服务出错时关闭服务后返回
+func (s *Server) Run() error {
+    if err := s.Start(); err != nil {
+        return err
+    }
+
+    ch := make(chan os.Signal, 1)
+    signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+    defer signal.Stop(ch)
+
+    var err error
+    select {
+    case si := <-ch:
+        log.Info("get a signal %s, stop the server", si.String())
+    case err = <-s.errChan:
+        log.Error("server stopped: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+    defer cancel()
+    if serr := s.Shutdown(ctx); serr != nil {
+        log.Error("shutdown server failed: %v", serr)
+    }
+    return err
+}
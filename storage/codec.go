@@ -0,0 +1,196 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package storage
+
+import (
+    "citron-repo/auth"
+    "citron-repo/model"
+    "compress/gzip"
+    "errors"
+    "github.com/klauspost/compress/zstd"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+)
+
+//存储压缩算法，文件元数据中记录使用的算法（不压缩时为空）
+const (
+    CODEC_NONE = "none"
+    CODEC_GZIP = "gzip"
+    CODEC_ZSTD = "zstd"
+
+    //小于该大小的文件不压缩（配置为0时）
+    DEFAULT_COMPRESS_MIN_SIZE = 1024
+    //先压缩文件开头的样本，样本压缩效果不好时不压缩整个文件
+    COMPRESS_SAMPLE_SIZE = 64 * 1024
+    //压缩后大小超过原大小的该比例时视为无法压缩，保存原始内容
+    COMPRESS_RATIO = 0.9
+)
+
+var ErrCodec = errors.New("unknown codec")
+
+func ValidCodec(codec string) bool {
+    switch codec {
+    case "", CODEC_NONE, CODEC_GZIP, CODEC_ZSTD:
+        return true
+    }
+    return false
+}
+
+//压缩数据写入w，Close时写入剩余数据，不关闭w
+func compressor(codec string, w io.Writer) (io.WriteCloser, error) {
+    switch codec {
+    case CODEC_GZIP:
+        return gzip.NewWriter(w), nil
+    case CODEC_ZSTD:
+        return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+    }
+    return nil, ErrCodec
+}
+
+//解压r中的数据，Close时同时关闭r
+func decompressor(codec string, r io.ReadCloser) (io.ReadCloser, error) {
+    switch codec {
+    case CODEC_GZIP:
+        gr, err := gzip.NewReader(r)
+        if err != nil {
+            return nil, err
+        }
+        return &codecReader{Reader: gr, close: func() { gr.Close() }, r: r}, nil
+    case CODEC_ZSTD:
+        zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+        if err != nil {
+            return nil, err
+        }
+        return &codecReader{Reader: zr, close: zr.Close, r: r}, nil
+    }
+    return nil, ErrCodec
+}
+
+type codecReader struct {
+    io.Reader
+    close func()
+    r     io.ReadCloser
+}
+
+func (c *codecReader) Close() error {
+    c.close()
+    return c.r.Close()
+}
+
+//计数写入的字节数
+type countWriter int64
+
+func (c *countWriter) Write(p []byte) (int, error) {
+    *c += countWriter(len(p))
+    return len(p), nil
+}
+
+//按路径选择压缩算法，最长的前缀规则生效，没有匹配的规则时使用默认算法
+func (s *Storage) SetCompression(conf model.CompressionConfig) {
+    s.compLock.Lock()
+    defer s.compLock.Unlock()
+    s.compression = conf
+}
+
+func (s *Storage) codec(p string) (string, int64) {
+    s.compLock.RLock()
+    defer s.compLock.RUnlock()
+
+    codec, prefix := s.compression.Codec, ""
+    for _, r := range s.compression.Rules {
+        rp, err := Clean(r.Prefix)
+        if err != nil || !auth.HasPrefix(p, rp) || len(rp) < len(prefix) {
+            continue
+        }
+        codec, prefix = r.Codec, rp
+    }
+    minSize := s.compression.MinSize
+    if minSize <= 0 {
+        minSize = DEFAULT_COMPRESS_MIN_SIZE
+    }
+    return codec, minSize
+}
+
+//按路径p的规则压缩上传的临时文件src，返回保存的文件、使用的算法及保存的大小
+//不压缩时返回src，压缩时删除src
+func (s *Storage) compress(src, p string, size int64) (string, string, int64, error) {
+    codec, minSize := s.codec(p)
+    if codec == "" || codec == CODEC_NONE || size < minSize {
+        return src, "", size, nil
+    }
+    in, err := os.Open(src)
+    if err != nil {
+        return src, "", size, err
+    }
+    defer in.Close()
+
+    sample := make([]byte, COMPRESS_SAMPLE_SIZE)
+    n, err := io.ReadFull(in, sample)
+    if err != nil && err != io.ErrUnexpectedEOF {
+        return src, "", size, err
+    }
+    if ok, err := compressible(codec, sample[:n]); err != nil || !ok {
+        return src, "", size, err
+    }
+    if _, err := in.Seek(0, io.SeekStart); err != nil {
+        return src, "", size, err
+    }
+
+    out, err := ioutil.TempFile(filepath.Dir(src), "compress")
+    if err != nil {
+        return src, "", size, err
+    }
+    stored, err := compressTo(codec, out, in)
+    if cerr := out.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil || float64(stored) > float64(size)*COMPRESS_RATIO {
+        os.Remove(out.Name())
+        return src, "", size, err
+    }
+    os.Remove(src)
+    return out.Name(), codec, stored, nil
+}
+
+func compressible(codec string, sample []byte) (bool, error) {
+    c := countWriter(0)
+    w, err := compressor(codec, &c)
+    if err != nil {
+        return false, err
+    }
+    if _, err := w.Write(sample); err != nil {
+        return false, err
+    }
+    if err := w.Close(); err != nil {
+        return false, err
+    }
+    return float64(c) <= float64(len(sample))*COMPRESS_RATIO, nil
+}
+
+func compressTo(codec string, w io.Writer, r io.Reader) (int64, error) {
+    c := countWriter(0)
+    cw, err := compressor(codec, io.MultiWriter(w, &c))
+    if err != nil {
+        return 0, err
+    }
+    if _, err := io.Copy(cw, r); err != nil {
+        cw.Close()
+        return 0, err
+    }
+    err = cw.Close()
+    return int64(c), err
+}
+
+//文件在存储中占用的大小
+func storedSize(info model.FileInfo) int64 {
+    if info.Codec != "" || info.Encrypted {
+        return info.StoredSize
+    }
+    return info.Size
+}
@@ -0,0 +1,339 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package storage
+
+import (
+    "citron-repo/model"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/binary"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+const (
+    //主密钥及数据密钥的长度（AES-256）
+    MASTER_KEY_SIZE = 32
+    //加密分块大小，每块使用AES-256-GCM单独加密，最后一块小于该大小（可以为空）
+    CRYPT_CHUNK_SIZE = 64 * 1024
+)
+
+var (
+    ErrMasterKey   = errors.New("invalid master key, expect 32 bytes")
+    ErrNoMasterKey = errors.New("no master key configured")
+    ErrKeyNotFound = errors.New("master key not found")
+    ErrDecrypt     = errors.New("decrypt stored file failed")
+)
+
+//使用主密钥加密的数据密钥，按版本保存在文件元数据中
+type dataKey struct {
+    KeyID string `json:"keyId"`
+    //nonce + 密文
+    Key []byte `json:"key"`
+}
+
+type masterKey struct {
+    id   string
+    aead cipher.AEAD
+}
+
+//读取主密钥，Secret及File只能设置一个
+func LoadMasterKey(k model.MasterKey) ([]byte, error) {
+    secret := k.Secret
+    if k.File != "" {
+        data, err := ioutil.ReadFile(k.File)
+        if err != nil {
+            return nil, err
+        }
+        if len(data) == MASTER_KEY_SIZE {
+            return data, nil
+        }
+        secret = strings.TrimSpace(string(data))
+    }
+    key, err := hex.DecodeString(secret)
+    if err != nil || len(key) != MASTER_KEY_SIZE {
+        return nil, ErrMasterKey
+    }
+    return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
+
+//设置主密钥，之后写入的文件使用第一个主密钥加密；已保存的文件不受影响，需要时调用RotateKeys
+//加载失败时拒绝写入，避免以明文保存
+func (s *Storage) SetEncryption(conf model.EncryptionConfig) error {
+    keys := make([]masterKey, 0, len(conf.Keys))
+    var err error
+    for _, k := range conf.Keys {
+        key, kerr := LoadMasterKey(k)
+        var aead cipher.AEAD
+        if kerr == nil {
+            aead, kerr = newAEAD(key)
+        }
+        if kerr != nil {
+            err = fmt.Errorf("load master key %s failed: %v", k.ID, kerr)
+            continue
+        }
+        keys = append(keys, masterKey{id: k.ID, aead: aead})
+    }
+
+    s.cryptLock.Lock()
+    defer s.cryptLock.Unlock()
+    s.masterKeys, s.cryptErr = keys, err
+    return err
+}
+
+func (s *Storage) keys() ([]masterKey, error) {
+    s.cryptLock.RLock()
+    defer s.cryptLock.RUnlock()
+    return s.masterKeys, s.cryptErr
+}
+
+//使用随机生成的数据密钥加密src，返回保存的文件、保存的大小及加密后的数据密钥
+//未配置主密钥时返回src，加密时删除src
+func (s *Storage) encrypt(src string, size int64) (string, int64, *dataKey, error) {
+    keys, err := s.keys()
+    if err != nil {
+        return src, size, nil, err
+    }
+    if len(keys) == 0 {
+        return src, size, nil, nil
+    }
+    key := make([]byte, MASTER_KEY_SIZE)
+    if _, err := rand.Read(key); err != nil {
+        return src, size, nil, err
+    }
+    dk, err := wrapKey(keys[0], key)
+    if err != nil {
+        return src, size, nil, err
+    }
+    aead, err := newAEAD(key)
+    if err != nil {
+        return src, size, nil, err
+    }
+
+    in, err := os.Open(src)
+    if err != nil {
+        return src, size, nil, err
+    }
+    defer in.Close()
+    out, err := ioutil.TempFile(filepath.Dir(src), "encrypt")
+    if err != nil {
+        return src, size, nil, err
+    }
+    stored, err := encryptTo(out, in, aead)
+    if cerr := out.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        os.Remove(out.Name())
+        return src, size, nil, err
+    }
+    os.Remove(src)
+    return out.Name(), stored, &dk, nil
+}
+
+//按块加密，nonce为块序号及是否为最后一块，防止块被重排或截断
+func encryptTo(w io.Writer, r io.Reader, aead cipher.AEAD) (int64, error) {
+    buf := make([]byte, CRYPT_CHUNK_SIZE, CRYPT_CHUNK_SIZE+aead.Overhead())
+    stored := int64(0)
+    for counter := uint64(0); ; counter++ {
+        n, err := io.ReadFull(r, buf[:CRYPT_CHUNK_SIZE])
+        if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+            return stored, err
+        }
+        last := err != nil
+        sealed := aead.Seal(buf[:0], chunkNonce(aead, counter, last), buf[:n], nil)
+        if _, err := w.Write(sealed); err != nil {
+            return stored, err
+        }
+        stored += int64(len(sealed))
+        if last {
+            return stored, nil
+        }
+    }
+}
+
+func chunkNonce(aead cipher.AEAD, counter uint64, last bool) []byte {
+    nonce := make([]byte, aead.NonceSize())
+    binary.BigEndian.PutUint64(nonce, counter)
+    if last {
+        nonce[len(nonce)-1] = 1
+    }
+    return nonce
+}
+
+func wrapKey(k masterKey, key []byte) (dataKey, error) {
+    nonce := make([]byte, k.aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return dataKey{}, err
+    }
+    return dataKey{KeyID: k.id, Key: k.aead.Seal(nonce, nonce, key, []byte(k.id))}, nil
+}
+
+func unwrapKey(keys []masterKey, dk dataKey) ([]byte, error) {
+    for _, k := range keys {
+        if k.id != dk.KeyID {
+            continue
+        }
+        ns := k.aead.NonceSize()
+        if len(dk.Key) < ns {
+            return nil, ErrDecrypt
+        }
+        key, err := k.aead.Open(nil, dk.Key[:ns], dk.Key[ns:], []byte(k.id))
+        if err != nil {
+            return nil, ErrDecrypt
+        }
+        return key, nil
+    }
+    return nil, ErrKeyNotFound
+}
+
+//解密文件p的版本info，读取f中保存的内容，Close时关闭f，必须在持有锁时调用
+func (s *Storage) decryptor(p string, info model.FileInfo, f io.ReadCloser) (io.ReadCloser, error) {
+    m, err := s.loadMeta(p)
+    if err != nil {
+        return nil, err
+    }
+    dk, ok := m.Keys[info.Version]
+    if !ok {
+        return nil, ErrKeyNotFound
+    }
+    keys, _ := s.keys()
+    key, err := unwrapKey(keys, dk)
+    if err != nil {
+        return nil, err
+    }
+    aead, err := newAEAD(key)
+    if err != nil {
+        return nil, err
+    }
+    if info.StoredSize < int64(aead.Overhead()) {
+        return nil, ErrDecrypt
+    }
+    return &decryptReader{
+        r:         f,
+        aead:      aead,
+        remaining: info.StoredSize,
+        buf:       make([]byte, CRYPT_CHUNK_SIZE+aead.Overhead()),
+    }, nil
+}
+
+type decryptReader struct {
+    r         io.ReadCloser
+    aead      cipher.AEAD
+    remaining int64
+    counter   uint64
+    buf       []byte
+    //已解密未读取的内容
+    plain []byte
+    err   error
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+    for len(d.plain) == 0 {
+        if d.err != nil {
+            return 0, d.err
+        }
+        d.err = d.next()
+    }
+    n := copy(p, d.plain)
+    d.plain = d.plain[n:]
+    return n, nil
+}
+
+func (d *decryptReader) next() error {
+    if d.remaining == 0 {
+        return io.EOF
+    }
+    n := int64(len(d.buf))
+    if n > d.remaining {
+        n = d.remaining
+    }
+    if _, err := io.ReadFull(d.r, d.buf[:n]); err != nil {
+        if err == io.EOF || err == io.ErrUnexpectedEOF {
+            return ErrDecrypt
+        }
+        return err
+    }
+    d.remaining -= n
+    plain, err := d.aead.Open(d.buf[:0], chunkNonce(d.aead, d.counter, d.remaining == 0), d.buf[:n], nil)
+    if err != nil {
+        return ErrDecrypt
+    }
+    d.counter++
+    d.plain = plain
+    return nil
+}
+
+func (d *decryptReader) Close() error {
+    return d.r.Close()
+}
+
+//使用当前主密钥（第一个）重新加密其他主密钥加密的数据密钥，文件内容不需要重新加密
+//完成后可以从配置中删除旧的主密钥；主密钥已不在配置中的文件记录在Failed中
+func (s *Storage) RotateKeys() (model.RotateResult, error) {
+    ret := model.RotateResult{}
+    keys, err := s.keys()
+    if err != nil {
+        return ret, err
+    }
+    if len(keys) == 0 {
+        return ret, ErrNoMasterKey
+    }
+    err = s.walkMeta("/", func(p string) error {
+        return s.rotateFile(p, keys, &ret)
+    })
+    return ret, err
+}
+
+func (s *Storage) rotateFile(p string, keys []masterKey, ret *model.RotateResult) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    m, err := s.loadMeta(p)
+    if err != nil {
+        return err
+    }
+    n, failed := 0, false
+    for version, dk := range m.Keys {
+        if dk.KeyID == keys[0].id {
+            continue
+        }
+        key, err := unwrapKey(keys, dk)
+        if err != nil {
+            failed = true
+            continue
+        }
+        if m.Keys[version], err = wrapKey(keys[0], key); err != nil {
+            return err
+        }
+        n++
+    }
+    if failed {
+        ret.Failed = append(ret.Failed, p)
+    }
+    if n == 0 {
+        return nil
+    }
+    ret.Files++
+    ret.Keys += n
+    return s.saveMeta(p, m)
+}
@@ -0,0 +1,279 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package storage
+
+import (
+    "citron-repo/model"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+const (
+    //隔离目录，损坏及多余的文件按原有的版本目录结构移动到该目录：.citron/quarantine/p/<version>
+    QUARANTINE_DIR = INTERNAL_DIR + "/quarantine"
+
+    //限速时每次读取的大小
+    SCRUB_READ_SIZE = 64 * 1024
+)
+
+var ErrScrubStopped = errors.New("scrub stopped")
+
+type scrubber struct {
+    s    *Storage
+    conf model.ScrubConfig
+    stop <-chan struct{}
+    ret  *model.ScrubReport
+    //限速的开始时间
+    start time.Time
+}
+
+//校验所有文件的所有版本（与元数据中的checksum及大小比较），并查找历史版本目录中没有元数据记录的文件
+//读取文件时不持有锁，不影响上传；stop关闭时停止检查并返回ErrScrubStopped，已检查的结果仍然返回
+func (s *Storage) Scrub(conf model.ScrubConfig, stop <-chan struct{}) (model.ScrubReport, error) {
+    ret := model.ScrubReport{Start: time.Now()}
+    sc := &scrubber{s: s, conf: conf, stop: stop, ret: &ret, start: ret.Start}
+    err := s.walkMeta("/", sc.checkFile)
+    if err == nil {
+        err = sc.findOrphans()
+    }
+    ret.End = time.Now()
+    if err != nil {
+        ret.Error = err.Error()
+    }
+    return ret, err
+}
+
+func (sc *scrubber) stopped() bool {
+    select {
+    case <-sc.stop:
+        return true
+    default:
+        return false
+    }
+}
+
+func (sc *scrubber) checkFile(p string) error {
+    sc.s.lock.RLock()
+    m, err := sc.s.loadMeta(p)
+    sc.s.lock.RUnlock()
+    if err != nil {
+        sc.ret.Corrupted = append(sc.ret.Corrupted, model.ScrubEntry{Path: p, Error: "meta: " + err.Error()})
+        return nil
+    }
+    if len(m.Versions) == 0 {
+        return nil
+    }
+    sc.ret.Files++
+    for _, v := range m.Versions {
+        if sc.stopped() {
+            return ErrScrubStopped
+        }
+        //删除标记没有内容
+        if v.State == model.FileDeleted {
+            continue
+        }
+        sc.ret.Versions++
+        if v.Checksum == "" || v.ChecksumType != CHECKSUM_SHA256 {
+            sc.ret.Skipped++
+            continue
+        }
+        err := sc.verify(p, v)
+        if err == nil {
+            continue
+        }
+        if err == ErrScrubStopped {
+            return err
+        }
+        entry := model.ScrubEntry{Path: p, Version: v.Version, Error: err.Error()}
+        if err == ErrNotFound {
+            //检查期间被删除的版本（如清理历史版本）不算丢失
+            if sc.s.hasVersion(p, v.Version) {
+                entry.Error = ""
+                sc.ret.Missing = append(sc.ret.Missing, entry)
+            }
+            continue
+        }
+        //缺少主密钥不是文件损坏，不隔离
+        if sc.conf.Quarantine && err != ErrKeyNotFound {
+            if qerr := sc.s.quarantine(p, v.Version, false); qerr != nil {
+                return qerr
+            }
+            entry.Quarantined = true
+        }
+        sc.ret.Corrupted = append(sc.ret.Corrupted, entry)
+    }
+    return nil
+}
+
+//读取版本v的内容并校验
+func (sc *scrubber) verify(p string, v model.FileInfo) error {
+    sc.s.lock.RLock()
+    local, ok := sc.s.location(p, v.Version)
+    var r io.ReadCloser
+    err := ErrNotFound
+    if ok {
+        r, err = sc.s.reader(p, v, local)
+    }
+    sc.s.lock.RUnlock()
+    if err != nil {
+        return err
+    }
+    defer r.Close()
+
+    h := sha256.New()
+    n, err := sc.copy(h, r)
+    if err != nil {
+        return err
+    }
+    if n != v.Size {
+        return fmt.Errorf("size mismatch, expect %d but get %d", v.Size, n)
+    }
+    if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, v.Checksum) {
+        return ErrChecksum
+    }
+    return nil
+}
+
+//按RateLimit限速复制
+func (sc *scrubber) copy(w io.Writer, r io.Reader) (int64, error) {
+    buf := make([]byte, SCRUB_READ_SIZE)
+    count := int64(0)
+    for {
+        if sc.stopped() {
+            return count, ErrScrubStopped
+        }
+        n, err := r.Read(buf)
+        if n > 0 {
+            w.Write(buf[:n])
+            count += int64(n)
+            sc.ret.Bytes += int64(n)
+            if werr := sc.wait(); werr != nil {
+                return count, werr
+            }
+        }
+        if err == io.EOF {
+            return count, nil
+        }
+        if err != nil {
+            return count, err
+        }
+    }
+}
+
+//读取速度超过限制时等待
+func (sc *scrubber) wait() error {
+    if sc.conf.RateLimit <= 0 {
+        return nil
+    }
+    expect := time.Duration(float64(sc.ret.Bytes) / float64(sc.conf.RateLimit) * float64(time.Second))
+    d := expect - time.Since(sc.start)
+    if d <= 0 {
+        return nil
+    }
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+    select {
+    case <-sc.stop:
+        return ErrScrubStopped
+    case <-timer.C:
+        return nil
+    }
+}
+
+//历史版本目录中没有元数据记录的文件
+func (sc *scrubber) findOrphans() error {
+    root := filepath.Join(sc.s.dir, filepath.FromSlash(VERSION_DIR))
+    return filepath.Walk(root, func(local string, fi os.FileInfo, err error) error {
+        if err != nil {
+            //检查期间被清理或提交移动的版本
+            if os.IsNotExist(err) {
+                return nil
+            }
+            return err
+        }
+        if sc.stopped() {
+            return ErrScrubStopped
+        }
+        if fi.IsDir() {
+            return nil
+        }
+        rel, err := filepath.Rel(root, filepath.Dir(local))
+        if err != nil {
+            return err
+        }
+        p, version := "/"+filepath.ToSlash(rel), fi.Name()
+        if sc.s.hasVersion(p, version) {
+            return nil
+        }
+        entry := model.ScrubEntry{Path: p, Version: version}
+        if sc.conf.Quarantine {
+            if err := sc.s.quarantine(p, version, true); err != nil {
+                return err
+            }
+            entry.Quarantined = true
+        }
+        sc.ret.Orphaned = append(sc.ret.Orphaned, entry)
+        return nil
+    })
+}
+
+func (s *Storage) hasVersion(p, version string) bool {
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    _, ok := s.location(p, version)
+    return ok
+}
+
+//版本保存的位置，元数据中没有该版本时返回false，必须在持有锁时调用
+func (s *Storage) location(p, version string) (string, bool) {
+    m, err := s.loadMeta(p)
+    if err != nil {
+        return "", false
+    }
+    for i, v := range m.Versions {
+        if v.Version != version {
+            continue
+        }
+        if i == len(m.Versions)-1 {
+            return s.local(p), true
+        }
+        return s.versionPath(p, version), true
+    }
+    return "", false
+}
+
+//将文件p的版本移动到隔离目录，orphan为true时移动历史版本目录中没有元数据记录的文件
+//元数据不变，隔离的版本在之后的检查中显示为丢失
+func (s *Storage) quarantine(p, version string, orphan bool) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    local, ok := s.location(p, version)
+    if orphan {
+        if ok {
+            return nil
+        }
+        local = s.versionPath(p, version)
+    } else if !ok {
+        return nil
+    }
+    dst := filepath.Join(s.dir, filepath.FromSlash(QUARANTINE_DIR+p), version)
+    if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+        return err
+    }
+    if err := os.Rename(local, dst); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    return nil
+}
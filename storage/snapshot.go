@@ -0,0 +1,355 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package storage
+
+import (
+    "citron-repo/auth"
+    "citron-repo/model"
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+//快照清单目录，快照id的清单保存在.citron/snapshots/<id>.json
+const SNAPSHOT_DIR = INTERNAL_DIR + "/snapshots"
+
+var (
+    ErrSnapshotNotFound  = errors.New("snapshot not found")
+    ErrSnapshotCommitted = errors.New("snapshot already committed")
+)
+
+func (s *Storage) snapshotPath(id string) string {
+    return filepath.Join(s.dir, filepath.FromSlash(SNAPSHOT_DIR), id+".json")
+}
+
+//创建快照，snap.Path为快照根目录
+func (s *Storage) BeginSnapshot(snap model.Snapshot) (model.Snapshot, error) {
+    p, err := Clean(snap.Path)
+    if err != nil {
+        return snap, err
+    }
+
+    s.snapLock.Lock()
+    defer s.snapLock.Unlock()
+
+    now := time.Now()
+    v := now.UnixNano()
+    for {
+        if _, err := os.Stat(s.snapshotPath(strconv.FormatInt(v, 10))); os.IsNotExist(err) {
+            break
+        }
+        v++
+    }
+    snap.ID = strconv.FormatInt(v, 10)
+    snap.Path = p
+    snap.State = model.SnapshotPending
+    snap.CreateTime = now
+    snap.CommitTime = time.Time{}
+    snap.Files, snap.Size, snap.Entries = 0, 0, nil
+    return snap, s.saveSnapshot(snap)
+}
+
+//向未提交的快照添加文件，同一路径重复添加时以最后一次为准
+func (s *Storage) AddSnapshot(id string, files []model.FileRequest) (model.Snapshot, error) {
+    s.snapLock.Lock()
+    defer s.snapLock.Unlock()
+
+    snap, err := s.loadSnapshot(id)
+    if err != nil {
+        return snap, err
+    }
+    if snap.State != model.SnapshotPending {
+        return snap, ErrSnapshotCommitted
+    }
+
+    index := make(map[string]int, len(snap.Entries))
+    for i, e := range snap.Entries {
+        index[e.FilePath] = i
+    }
+    s.lock.RLock()
+    for _, f := range files {
+        p, err := Clean(f.Path)
+        if err != nil || !auth.HasPrefix(p, snap.Path) {
+            s.lock.RUnlock()
+            return snap, ErrInvalidPath
+        }
+        info, err := s.version(p, f.Version)
+        if err != nil {
+            s.lock.RUnlock()
+            return snap, err
+        }
+        if i, ok := index[p]; ok {
+            snap.Size -= snap.Entries[i].Size
+            snap.Entries[i] = info
+        } else {
+            index[p] = len(snap.Entries)
+            snap.Entries = append(snap.Entries, info)
+        }
+        snap.Size += info.Size
+    }
+    s.lock.RUnlock()
+    snap.Files = len(snap.Entries)
+    return summary(snap), s.saveSnapshot(snap)
+}
+
+//提交快照，提交后不能再添加文件
+func (s *Storage) CommitSnapshot(id string) (model.Snapshot, error) {
+    s.snapLock.Lock()
+    defer s.snapLock.Unlock()
+
+    snap, err := s.loadSnapshot(id)
+    if err != nil {
+        return snap, err
+    }
+    if snap.State != model.SnapshotPending {
+        return snap, ErrSnapshotCommitted
+    }
+    sort.Slice(snap.Entries, func(i, j int) bool {
+        return snap.Entries[i].FilePath < snap.Entries[j].FilePath
+    })
+    snap.State = model.SnapshotCommitted
+    snap.CommitTime = time.Now()
+    return summary(snap), s.saveSnapshot(snap)
+}
+
+//快照信息及文件清单
+func (s *Storage) Snapshot(id string) (model.Snapshot, error) {
+    s.snapLock.Lock()
+    defer s.snapLock.Unlock()
+
+    return s.loadSnapshot(id)
+}
+
+//列出根目录在p下的快照，不包含文件清单，按创建时间排序
+//根目录为p上级目录的快照不列出，其中可能包含无权访问的其他路径
+func (s *Storage) Snapshots(p string) ([]model.Snapshot, error) {
+    p, err := Clean(p)
+    if err != nil {
+        return nil, err
+    }
+
+    s.snapLock.Lock()
+    defer s.snapLock.Unlock()
+
+    snaps, err := s.loadSnapshots()
+    if err != nil {
+        return nil, err
+    }
+    ret := make([]model.Snapshot, 0, len(snaps))
+    for _, snap := range snaps {
+        if auth.HasPrefix(snap.Path, p) {
+            ret = append(ret, summary(snap))
+        }
+    }
+    return ret, nil
+}
+
+//删除快照清单，文件的版本不删除，但不再受快照保护
+func (s *Storage) DeleteSnapshot(id string) error {
+    s.snapLock.Lock()
+    defer s.snapLock.Unlock()
+
+    if _, err := s.loadSnapshot(id); err != nil {
+        return err
+    }
+    return os.Remove(s.snapshotPath(id))
+}
+
+//将快照中的文件写入仓库成为最新版本，target为空时恢复到原路径，内容未变化的文件不生成新版本
+func (s *Storage) RestoreSnapshot(id, target string) (model.RestoreResult, error) {
+    ret := model.RestoreResult{}
+    snap, err := s.Snapshot(id)
+    if err != nil {
+        return ret, err
+    }
+    if snap.State != model.SnapshotCommitted {
+        return ret, ErrSnapshotNotFound
+    }
+    if target == "" {
+        target = snap.Path
+    }
+    if target, err = Clean(target); err != nil {
+        return ret, err
+    }
+
+    for _, e := range snap.Entries {
+        rel := strings.TrimPrefix(e.FilePath, snap.Path)
+        dst := path.Join(target, rel)
+        if err := s.restoreFile(e, dst); err != nil {
+            return ret, err
+        }
+        ret.Files++
+        ret.Bytes += e.Size
+    }
+    return ret, nil
+}
+
+func (s *Storage) restoreFile(e model.FileInfo, dst string) error {
+    r, info, err := s.Open(e.FilePath, e.Version)
+    if err != nil {
+        return err
+    }
+    defer r.Close()
+    _, err = s.Put(model.FileInfo{
+        FilePath: dst,
+        ModTime:  info.ModTime,
+        Mode:     info.Mode,
+        Checksum: info.Checksum,
+    }, r)
+    return err
+}
+
+//目录下所有文件当前的最新版本，p为文件时只包含该文件
+func (s *Storage) Manifest(p string) ([]model.FileInfo, error) {
+    p, err := Clean(p)
+    if err != nil {
+        return nil, err
+    }
+    var ret []model.FileInfo
+    internal := filepath.Join(s.dir, INTERNAL_DIR)
+    err = filepath.Walk(s.local(p), func(local string, fi os.FileInfo, err error) error {
+        if err != nil {
+            if os.IsNotExist(err) && local == s.local(p) {
+                return ErrNotFound
+            }
+            return err
+        }
+        if fi.IsDir() {
+            if local == internal {
+                return filepath.SkipDir
+            }
+            return nil
+        }
+        rel, err := filepath.Rel(s.dir, local)
+        if err != nil {
+            return err
+        }
+        info, err := s.Stat("/" + filepath.ToSlash(rel))
+        if err != nil {
+            //遍历期间被替换
+            if err == ErrNotFound {
+                return nil
+            }
+            return err
+        }
+        ret = append(ret, info)
+        return nil
+    })
+    return ret, err
+}
+
+//快照引用的文件版本，key为仓库路径，必须在持有snapLock时调用
+func (s *Storage) snapshotVersions() (map[string]map[string]bool, error) {
+    snaps, err := s.loadSnapshots()
+    if err != nil {
+        return nil, err
+    }
+    ret := map[string]map[string]bool{}
+    for _, snap := range snaps {
+        for _, e := range snap.Entries {
+            if ret[e.FilePath] == nil {
+                ret[e.FilePath] = map[string]bool{}
+            }
+            ret[e.FilePath][e.Version] = true
+        }
+    }
+    return ret, nil
+}
+
+//文件的指定版本，version为空时为最新版本，必须在持有读锁时调用
+func (s *Storage) version(p, version string) (model.FileInfo, error) {
+    info, err := s.stat(p)
+    if err != nil {
+        return info, err
+    }
+    if info.IsDir {
+        return info, ErrIsDir
+    }
+    if version == "" || version == info.Version {
+        return info, nil
+    }
+    m, err := s.loadMeta(p)
+    if err != nil {
+        return info, err
+    }
+    for _, v := range m.Versions {
+        if v.Version == version && v.State != model.FileDeleted {
+            return v, nil
+        }
+    }
+    return info, ErrNotFound
+}
+
+func (s *Storage) loadSnapshot(id string) (model.Snapshot, error) {
+    snap := model.Snapshot{}
+    //id只能为数字，避免访问其他文件
+    if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+        return snap, ErrSnapshotNotFound
+    }
+    data, err := ioutil.ReadFile(s.snapshotPath(id))
+    if err != nil {
+        if os.IsNotExist(err) {
+            return snap, ErrSnapshotNotFound
+        }
+        return snap, err
+    }
+    return snap, json.Unmarshal(data, &snap)
+}
+
+func (s *Storage) loadSnapshots() ([]model.Snapshot, error) {
+    fis, err := ioutil.ReadDir(filepath.Join(s.dir, filepath.FromSlash(SNAPSHOT_DIR)))
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, nil
+        }
+        return nil, err
+    }
+    ret := make([]model.Snapshot, 0, len(fis))
+    for _, fi := range fis {
+        if !strings.HasSuffix(fi.Name(), ".json") {
+            continue
+        }
+        snap, err := s.loadSnapshot(strings.TrimSuffix(fi.Name(), ".json"))
+        if err != nil {
+            return nil, err
+        }
+        ret = append(ret, snap)
+    }
+    sort.Slice(ret, func(i, j int) bool {
+        return ret[i].CreateTime.Before(ret[j].CreateTime)
+    })
+    return ret, nil
+}
+
+func (s *Storage) saveSnapshot(snap model.Snapshot) error {
+    data, err := json.Marshal(snap)
+    if err != nil {
+        return err
+    }
+    sp := s.snapshotPath(snap.ID)
+    if err := os.MkdirAll(filepath.Dir(sp), 0700); err != nil {
+        return err
+    }
+    tmp := sp + ".tmp"
+    if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+        return err
+    }
+    return os.Rename(tmp, sp)
+}
+
+//不包含文件清单的快照信息
+func summary(snap model.Snapshot) model.Snapshot {
+    snap.Entries = nil
+    return snap
+}
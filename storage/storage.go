@@ -0,0 +1,672 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package storage
+
+import (
+    "citron-repo/model"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "hash"
+    "io"
+    "io/ioutil"
+    "os"
+    "path"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    //仓库内部数据目录，不允许通过仓库路径访问
+    INTERNAL_DIR = ".citron"
+    META_DIR     = INTERNAL_DIR + "/meta"
+    VERSION_DIR  = INTERNAL_DIR + "/versions"
+    TMP_DIR      = INTERNAL_DIR + "/tmp"
+    //元数据文件名，文件p的元数据保存在.citron/meta/p/.meta
+    META_FILE = ".meta"
+
+    CHECKSUM_SHA256 = "sha256"
+)
+
+var (
+    ErrNotFound    = errors.New("file not found")
+    ErrIsDir       = errors.New("is a directory")
+    ErrInvalidPath = errors.New("invalid path")
+    ErrChecksum    = errors.New("checksum mismatch")
+)
+
+//仓库存储：最新内容保存在BackupDir下对应路径，历史版本保存在.citron/versions，
+//每个文件的元数据（所有版本的FileInfo）保存在.citron/meta
+type Storage struct {
+    dir  string
+    lock sync.RWMutex
+    //快照清单锁，与lock同时持有时先获取snapLock
+    snapLock sync.Mutex
+
+    compLock    sync.RWMutex
+    compression model.CompressionConfig
+
+    cryptLock  sync.RWMutex
+    masterKeys []masterKey
+    cryptErr   error
+
+    listener func(info model.FileInfo)
+}
+
+//文件的所有版本，最新版本在最后
+type meta struct {
+    Versions []model.FileInfo `json:"versions"`
+    //加密保存的版本的数据密钥
+    Keys map[string]dataKey `json:"keys,omitempty"`
+}
+
+func New(dir string) *Storage {
+    return &Storage{dir: dir}
+}
+
+func (s *Storage) Dir() string {
+    return s.dir
+}
+
+//清理仓库路径，返回以/开头的路径
+func Clean(p string) (string, error) {
+    p = path.Clean("/" + filepath.ToSlash(p))
+    if p == "/"+INTERNAL_DIR || strings.HasPrefix(p, "/"+INTERNAL_DIR+"/") {
+        return "", ErrInvalidPath
+    }
+    return p, nil
+}
+
+func (s *Storage) local(p string) string {
+    return filepath.Join(s.dir, filepath.FromSlash(p))
+}
+
+func (s *Storage) metaPath(p string) string {
+    return filepath.Join(s.dir, filepath.FromSlash(META_DIR+p), META_FILE)
+}
+
+func (s *Storage) versionPath(p, version string) string {
+    return filepath.Join(s.dir, filepath.FromSlash(VERSION_DIR+p), version)
+}
+
+func (s *Storage) Mkdir(p string) error {
+    p, err := Clean(p)
+    if err != nil {
+        return err
+    }
+    return os.MkdirAll(s.local(p), 0755)
+}
+
+//写入文件，内容写完后调用Commit生成新版本，失败时调用Abort
+type Writer struct {
+    s    *Storage
+    info model.FileInfo
+    file *os.File
+    hash hash.Hash
+    size int64
+}
+
+//info.FilePath为仓库路径，info.Checksum不为空时Commit会校验内容
+func (s *Storage) Create(info model.FileInfo) (*Writer, error) {
+    p, err := Clean(info.FilePath)
+    if err != nil {
+        return nil, err
+    }
+    if p == "/" {
+        return nil, ErrIsDir
+    }
+    info.FilePath = p
+    tmpDir := filepath.Join(s.dir, filepath.FromSlash(TMP_DIR))
+    if err := os.MkdirAll(tmpDir, 0700); err != nil {
+        return nil, err
+    }
+    f, err := ioutil.TempFile(tmpDir, "upload")
+    if err != nil {
+        return nil, err
+    }
+    return &Writer{s: s, info: info, file: f, hash: sha256.New()}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+    n, err := w.file.Write(p)
+    w.hash.Write(p[:n])
+    w.size += int64(n)
+    return n, err
+}
+
+func (w *Writer) Size() int64 {
+    return w.size
+}
+
+func (w *Writer) Abort() error {
+    w.file.Close()
+    return os.Remove(w.file.Name())
+}
+
+//校验并保存内容，内容与最新版本相同时不生成新版本
+func (w *Writer) Commit() (model.FileInfo, error) {
+    err := w.file.Sync()
+    if cerr := w.file.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        os.Remove(w.file.Name())
+        return model.FileInfo{}, err
+    }
+
+    sum := hex.EncodeToString(w.hash.Sum(nil))
+    if w.info.Checksum != "" && !strings.EqualFold(w.info.Checksum, sum) {
+        os.Remove(w.file.Name())
+        return model.FileInfo{}, ErrChecksum
+    }
+
+    info := w.info
+    p := info.FilePath
+    //在获取锁之前压缩及加密，避免处理大文件时阻塞其他操作
+    file, codec, stored, err := w.s.compress(w.file.Name(), p, w.size)
+    if err != nil {
+        os.Remove(file)
+        return model.FileInfo{}, err
+    }
+    file, stored, key, err := w.s.encrypt(file, stored)
+    if err != nil {
+        os.Remove(file)
+        return model.FileInfo{}, err
+    }
+    info.FileName = path.Base(p)
+    info.Parent = path.Dir(p)
+    info.IsDir = false
+    info.Size = w.size
+    info.Checksum = sum
+    info.ChecksumType = CHECKSUM_SHA256
+    info.Codec, info.StoredSize = codec, 0
+    info.Encrypted = key != nil
+    if codec != "" || key != nil {
+        info.StoredSize = stored
+    }
+    info.CreateTime = time.Now()
+    if info.ModTime.IsZero() {
+        info.ModTime = info.CreateTime
+    }
+
+    s := w.s
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    m, err := s.loadMeta(p)
+    if err != nil {
+        os.Remove(file)
+        return model.FileInfo{}, err
+    }
+    local := s.local(p)
+    if fi, err := os.Stat(local); err == nil && fi.IsDir() {
+        os.Remove(file)
+        return model.FileInfo{}, ErrIsDir
+    }
+
+    if n := len(m.Versions); n > 0 {
+        last := m.Versions[n-1]
+        //最新版本的文件丢失（如被隔离）时重新保存
+        if _, err := os.Stat(local); err == nil && last.Checksum == sum {
+            os.Remove(file)
+            //内容相同时只更新修改时间及权限（上传时指定的）
+            if w.info.ModTime.IsZero() {
+                info.ModTime = last.ModTime
+            }
+            if info.Mode == 0 {
+                info.Mode = last.Mode
+            }
+            if last.ModTime.Equal(info.ModTime) && last.Mode == info.Mode {
+                return last, nil
+            }
+            last.ModTime, last.Mode = info.ModTime, info.Mode
+            m.Versions[n-1] = last
+            os.Chtimes(local, last.ModTime, last.ModTime)
+            if err := s.saveMeta(p, m); err != nil {
+                return last, err
+            }
+            s.notify(last)
+            return last, nil
+        }
+    } else if fi, err := os.Stat(local); err == nil {
+        //没有元数据的文件（直接写入备份目录）作为第一个版本
+        m.Versions = append(m.Versions, legacyInfo(p, fi))
+    }
+
+    info.Version = nextVersion(m.Versions)
+    if n := len(m.Versions); n > 0 {
+        old := m.Versions[n-1]
+        if err := s.moveToVersion(p, old.Version); err != nil {
+            os.Remove(file)
+            return model.FileInfo{}, err
+        }
+    }
+
+    if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+        os.Remove(file)
+        return model.FileInfo{}, err
+    }
+    if err := os.Rename(file, local); err != nil {
+        os.Remove(file)
+        return model.FileInfo{}, err
+    }
+    if !info.ModTime.IsZero() {
+        os.Chtimes(local, info.ModTime, info.ModTime)
+    }
+
+    m.Versions = append(m.Versions, info)
+    if key != nil {
+        if m.Keys == nil {
+            m.Keys = map[string]dataKey{}
+        }
+        m.Keys[info.Version] = *key
+    }
+    if err := s.saveMeta(p, m); err != nil {
+        return info, err
+    }
+    s.notify(info)
+    return info, nil
+}
+
+//保存新版本、修改最新版本的修改时间及权限、删除文件（State为FileDeleted）或从回收站恢复后调用f
+//调用时持有写锁，f不能调用Storage的方法
+//必须在使用Storage之前设置
+func (s *Storage) SetListener(f func(info model.FileInfo)) {
+    s.listener = f
+}
+
+func (s *Storage) notify(info model.FileInfo) {
+    if s.listener != nil {
+        s.listener(info)
+    }
+}
+
+//将当前文件移动到历史版本目录
+func (s *Storage) moveToVersion(p, version string) error {
+    local := s.local(p)
+    if _, err := os.Stat(local); err != nil {
+        if os.IsNotExist(err) {
+            return nil
+        }
+        return err
+    }
+    dst := s.versionPath(p, version)
+    if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+        return err
+    }
+    return os.Rename(local, dst)
+}
+
+func nextVersion(versions []model.FileInfo) string {
+    v := time.Now().UnixNano()
+    if n := len(versions); n > 0 {
+        last, _ := strconv.ParseInt(versions[n-1].Version, 10, 64)
+        if v <= last {
+            v = last + 1
+        }
+    }
+    return strconv.FormatInt(v, 10)
+}
+
+func legacyInfo(p string, fi os.FileInfo) model.FileInfo {
+    return model.FileInfo{
+        FileName: path.Base(p),
+        FilePath: p,
+        Parent:   path.Dir(p),
+        IsDir:    fi.IsDir(),
+        Size:     fi.Size(),
+        Mode:     uint32(fi.Mode().Perm()),
+        ModTime:  fi.ModTime(),
+        Version:  strconv.FormatInt(fi.ModTime().UnixNano(), 10),
+    }
+}
+
+//写入文件
+func (s *Storage) Put(info model.FileInfo, r io.Reader) (model.FileInfo, error) {
+    w, err := s.Create(info)
+    if err != nil {
+        return model.FileInfo{}, err
+    }
+    if _, err := io.Copy(w, r); err != nil {
+        w.Abort()
+        return model.FileInfo{}, err
+    }
+    return w.Commit()
+}
+
+//读取文件，version为空时读取最新版本；回收站中的文件只能读取指定的版本（如恢复快照）
+func (s *Storage) Open(p, version string) (io.ReadCloser, model.FileInfo, error) {
+    p, err := Clean(p)
+    if err != nil {
+        return nil, model.FileInfo{}, err
+    }
+
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+
+    info, err := s.stat(p)
+    if err != nil && (err != ErrNotFound || version == "") {
+        return nil, info, err
+    }
+    if info.IsDir {
+        return nil, info, ErrIsDir
+    }
+    local := s.local(p)
+    if version != "" && version != info.Version {
+        m, err := s.loadMeta(p)
+        if err != nil {
+            return nil, info, err
+        }
+        found := false
+        for _, v := range m.Versions {
+            if v.Version == version && v.State != model.FileDeleted {
+                info, found = v, true
+                break
+            }
+        }
+        if !found {
+            return nil, info, ErrNotFound
+        }
+        local = s.versionPath(p, version)
+    }
+
+    r, err := s.reader(p, info, local)
+    return r, info, err
+}
+
+//打开保存在local的版本info，返回解密及解压后的内容，必须在持有锁时调用
+func (s *Storage) reader(p string, info model.FileInfo, local string) (io.ReadCloser, error) {
+    f, err := os.Open(local)
+    if err != nil {
+        if os.IsNotExist(err) {
+            err = ErrNotFound
+        }
+        return nil, err
+    }
+    var r io.ReadCloser = f
+    if info.Encrypted {
+        if r, err = s.decryptor(p, info, f); err != nil {
+            f.Close()
+            return nil, err
+        }
+    }
+    if info.Codec == "" {
+        return r, nil
+    }
+    dr, err := decompressor(info.Codec, r)
+    if err != nil {
+        r.Close()
+        return nil, err
+    }
+    return dr, nil
+}
+
+func (s *Storage) Stat(p string) (model.FileInfo, error) {
+    p, err := Clean(p)
+    if err != nil {
+        return model.FileInfo{}, err
+    }
+
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+
+    return s.stat(p)
+}
+
+func (s *Storage) stat(p string) (model.FileInfo, error) {
+    fi, err := os.Stat(s.local(p))
+    if err != nil {
+        if os.IsNotExist(err) {
+            err = ErrNotFound
+        }
+        return model.FileInfo{}, err
+    }
+    if fi.IsDir() {
+        return dirInfo(p, fi), nil
+    }
+    m, err := s.loadMeta(p)
+    if err != nil {
+        return model.FileInfo{}, err
+    }
+    if n := len(m.Versions); n > 0 {
+        return m.Versions[n-1], nil
+    }
+    return legacyInfo(p, fi), nil
+}
+
+func dirInfo(p string, fi os.FileInfo) model.FileInfo {
+    return model.FileInfo{
+        FileName: path.Base(p),
+        FilePath: p,
+        Parent:   path.Dir(p),
+        IsDir:    true,
+        ModTime:  fi.ModTime(),
+    }
+}
+
+//列出目录下的文件及子目录，按名称排序
+func (s *Storage) List(p string) ([]model.FileInfo, error) {
+    p, err := Clean(p)
+    if err != nil {
+        return nil, err
+    }
+
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+
+    fis, err := ioutil.ReadDir(s.local(p))
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, ErrNotFound
+        }
+        return nil, err
+    }
+    ret := make([]model.FileInfo, 0, len(fis))
+    for _, fi := range fis {
+        if p == "/" && fi.Name() == INTERNAL_DIR {
+            continue
+        }
+        child := path.Join(p, fi.Name())
+        if fi.IsDir() {
+            ret = append(ret, dirInfo(child, fi))
+            continue
+        }
+        info, err := s.stat(child)
+        if err != nil {
+            return nil, err
+        }
+        ret = append(ret, info)
+    }
+    return ret, nil
+}
+
+//文件的所有版本，最新版本在最前
+func (s *Storage) Versions(p string) ([]model.FileInfo, error) {
+    p, err := Clean(p)
+    if err != nil {
+        return nil, err
+    }
+
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+
+    info, err := s.stat(p)
+    if err != nil {
+        return nil, err
+    }
+    if info.IsDir {
+        return nil, ErrIsDir
+    }
+    m, err := s.loadMeta(p)
+    if err != nil {
+        return nil, err
+    }
+    if len(m.Versions) == 0 {
+        m.Versions = []model.FileInfo{info}
+    }
+    ret := make([]model.FileInfo, len(m.Versions))
+    for i := range m.Versions {
+        ret[i] = m.Versions[len(m.Versions)-1-i]
+    }
+    return ret, nil
+}
+
+func (s *Storage) loadMeta(p string) (meta, error) {
+    m := meta{}
+    data, err := ioutil.ReadFile(s.metaPath(p))
+    if err != nil {
+        if os.IsNotExist(err) {
+            return m, nil
+        }
+        return m, err
+    }
+    return m, json.Unmarshal(data, &m)
+}
+
+//遍历p（文件或目录）下所有有元数据的文件，f的参数为仓库路径
+//遍历期间被替换或删除的文件（如保存元数据时的临时文件）跳过
+func (s *Storage) walkMeta(p string, f func(p string) error) error {
+    base := filepath.Join(s.dir, filepath.FromSlash(META_DIR))
+    root := filepath.Join(base, filepath.FromSlash(p))
+    return filepath.Walk(root, func(local string, fi os.FileInfo, err error) error {
+        if err != nil {
+            if os.IsNotExist(err) {
+                return nil
+            }
+            return err
+        }
+        if fi.IsDir() || fi.Name() != META_FILE {
+            return nil
+        }
+        rel, err := filepath.Rel(base, filepath.Dir(local))
+        if err != nil {
+            return err
+        }
+        return f("/" + filepath.ToSlash(rel))
+    })
+}
+
+//先写临时文件再替换，必须在持有写锁时调用
+func (s *Storage) saveMeta(p string, m meta) error {
+    data, err := json.Marshal(m)
+    if err != nil {
+        return err
+    }
+    mp := s.metaPath(p)
+    if err := os.MkdirAll(filepath.Dir(mp), 0700); err != nil {
+        return err
+    }
+    tmp := mp + ".tmp"
+    if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+        return err
+    }
+    return os.Rename(tmp, mp)
+}
+
+//按保留策略删除文件或目录下所有文件的历史版本，最新版本及快照引用的版本总是保留
+func (s *Storage) Prune(p string, r model.Retention, now time.Time) (model.PruneResult, error) {
+    ret := model.PruneResult{}
+    p, err := Clean(p)
+    if err != nil {
+        return ret, err
+    }
+    fi, err := os.Stat(s.local(p))
+    if err != nil {
+        if os.IsNotExist(err) {
+            err = ErrNotFound
+        }
+        return ret, err
+    }
+    if r.IsZero() {
+        return ret, nil
+    }
+
+    //快照引用的版本不删除
+    s.snapLock.Lock()
+    defer s.snapLock.Unlock()
+    refs, err := s.snapshotVersions()
+    if err != nil {
+        return ret, err
+    }
+    if !fi.IsDir() {
+        return ret, s.pruneFile(p, r, now, refs[p], &ret)
+    }
+
+    internal := filepath.Join(s.dir, INTERNAL_DIR)
+    err = filepath.Walk(s.local(p), func(local string, fi os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        if fi.IsDir() {
+            if local == internal {
+                return filepath.SkipDir
+            }
+            return nil
+        }
+        rel, err := filepath.Rel(s.dir, local)
+        if err != nil {
+            return err
+        }
+        p := "/" + filepath.ToSlash(rel)
+        return s.pruneFile(p, r, now, refs[p], &ret)
+    })
+    return ret, err
+}
+
+func (s *Storage) pruneFile(p string, r model.Retention, now time.Time, refs map[string]bool, ret *model.PruneResult) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    m, err := s.loadMeta(p)
+    if err != nil {
+        return err
+    }
+    n := len(m.Versions)
+    if n < 2 {
+        return nil
+    }
+    keep := make([]model.FileInfo, 0, n)
+    removed := 0
+    var rerr error
+    for i, v := range m.Versions[:n-1] {
+        //被下一个版本替换的时间
+        replaced := m.Versions[i+1].CreateTime
+        if replaced.IsZero() {
+            replaced = m.Versions[i+1].ModTime
+        }
+        expired := r.Versions > 0 && n-i > r.Versions
+        if r.MaxAge > 0 && now.Sub(replaced) > r.MaxAge.Duration() {
+            expired = true
+        }
+        if !expired || refs[v.Version] {
+            keep = append(keep, v)
+            continue
+        }
+        if err := os.Remove(s.versionPath(p, v.Version)); err != nil && !os.IsNotExist(err) {
+            //已删除的版本仍需更新元数据
+            keep, rerr = append(keep, m.Versions[i:n-1]...), err
+            break
+        }
+        delete(m.Keys, v.Version)
+        removed++
+        ret.Versions++
+        ret.Bytes += storedSize(v)
+    }
+    if removed == 0 {
+        return rerr
+    }
+    ret.Files++
+    m.Versions = append(keep, m.Versions[n-1])
+    if err := s.saveMeta(p, m); err != nil {
+        return err
+    }
+    return rerr
+}
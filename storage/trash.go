@@ -0,0 +1,296 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package storage
+
+import (
+    "citron-repo/model"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+)
+
+//删除文件或目录（包括所有子目录），删除的文件移动到回收站：最新版本移动到历史版本目录，
+//元数据中追加删除标记（State为FileDeleted）。回收站中的文件不出现在列表中，可以通过Restore恢复，
+//上传同一路径时作为新版本保存，删除前的版本保留在历史版本中
+func (s *Storage) Delete(p string) (model.TrashResult, error) {
+    ret := model.TrashResult{}
+    p, err := Clean(p)
+    if err != nil {
+        return ret, err
+    }
+    if p == "/" {
+        return ret, ErrInvalidPath
+    }
+    root := s.local(p)
+    fi, err := os.Stat(root)
+    if err != nil {
+        if os.IsNotExist(err) {
+            err = ErrNotFound
+        }
+        return ret, err
+    }
+    now := time.Now()
+    if !fi.IsDir() {
+        return ret, s.deleteFile(p, now, &ret)
+    }
+
+    var dirs []string
+    err = filepath.Walk(root, func(local string, fi os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        if fi.IsDir() {
+            dirs = append(dirs, local)
+            return nil
+        }
+        rel, err := filepath.Rel(s.dir, local)
+        if err != nil {
+            return err
+        }
+        return s.deleteFile("/"+filepath.ToSlash(rel), now, &ret)
+    })
+    if err != nil {
+        return ret, err
+    }
+    //子目录在前，删除期间有新文件上传的目录保留
+    for i := len(dirs) - 1; i >= 0; i-- {
+        os.Remove(dirs[i])
+    }
+    return ret, nil
+}
+
+func (s *Storage) deleteFile(p string, now time.Time, ret *model.TrashResult) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    local := s.local(p)
+    fi, err := os.Stat(local)
+    if err != nil {
+        //遍历期间被删除
+        if os.IsNotExist(err) {
+            return nil
+        }
+        return err
+    }
+    if fi.IsDir() {
+        return nil
+    }
+    m, err := s.loadMeta(p)
+    if err != nil {
+        return err
+    }
+    //没有元数据的文件（直接写入备份目录）作为一个版本
+    if n := len(m.Versions); n == 0 || m.Versions[n-1].State == model.FileDeleted {
+        m.Versions = append(m.Versions, legacyInfo(p, fi))
+    }
+    last := m.Versions[len(m.Versions)-1]
+    if err := s.moveToVersion(p, last.Version); err != nil {
+        return err
+    }
+    mark := model.FileInfo{
+        FileName:   last.FileName,
+        FilePath:   p,
+        Parent:     last.Parent,
+        State:      model.FileDeleted,
+        ModTime:    last.ModTime,
+        Version:    nextVersion(m.Versions),
+        CreateTime: now,
+    }
+    m.Versions = append(m.Versions, mark)
+    if err := s.saveMeta(p, m); err != nil {
+        os.Rename(s.versionPath(p, last.Version), local)
+        return err
+    }
+    s.notify(mark)
+    ret.Files++
+    ret.Bytes += last.Size
+    return nil
+}
+
+//文件在回收站中时返回删除前的最新版本
+func trashEntry(p string, m meta) (model.TrashEntry, bool) {
+    n := len(m.Versions)
+    if n < 2 || m.Versions[n-1].State != model.FileDeleted {
+        return model.TrashEntry{}, false
+    }
+    last := m.Versions[n-2]
+    e := model.TrashEntry{
+        Path:       p,
+        Version:    last.Version,
+        Size:       last.Size,
+        ModTime:    last.ModTime,
+        DeleteTime: m.Versions[n-1].CreateTime,
+    }
+    for _, v := range m.Versions {
+        if v.State != model.FileDeleted {
+            e.Versions++
+        }
+    }
+    return e, true
+}
+
+//回收站中p（文件或目录）下的文件，按删除时间排序
+func (s *Storage) Trash(p string) ([]model.TrashEntry, error) {
+    p, err := Clean(p)
+    if err != nil {
+        return nil, err
+    }
+    var ret []model.TrashEntry
+    err = s.walkMeta(p, func(p string) error {
+        s.lock.RLock()
+        m, err := s.loadMeta(p)
+        s.lock.RUnlock()
+        if err != nil {
+            return err
+        }
+        if e, ok := trashEntry(p, m); ok {
+            ret = append(ret, e)
+        }
+        return nil
+    })
+    sort.SliceStable(ret, func(i, j int) bool {
+        return ret[i].DeleteTime.Before(ret[j].DeleteTime)
+    })
+    return ret, err
+}
+
+//恢复回收站中p（文件或目录）下的文件，删除前的最新版本重新成为最新版本
+//原路径已存在文件或目录、内容已丢失的文件不恢复，记录在Failed中；回收站中没有p下的文件时返回ErrNotFound
+func (s *Storage) Restore(p string) (model.TrashResult, error) {
+    ret := model.TrashResult{}
+    p, err := Clean(p)
+    if err != nil {
+        return ret, err
+    }
+    found := false
+    err = s.walkMeta(p, func(p string) error {
+        ok, err := s.restoreTrash(p, &ret)
+        found = found || ok
+        return err
+    })
+    if err == nil && !found {
+        err = ErrNotFound
+    }
+    return ret, err
+}
+
+func (s *Storage) restoreTrash(p string, ret *model.TrashResult) (bool, error) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    m, err := s.loadMeta(p)
+    if err != nil {
+        return false, err
+    }
+    e, ok := trashEntry(p, m)
+    if !ok {
+        return false, nil
+    }
+    local := s.local(p)
+    if _, err := os.Lstat(local); err == nil {
+        ret.Failed = append(ret.Failed, p)
+        return true, nil
+    }
+    //上级路径为文件时失败
+    if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+        ret.Failed = append(ret.Failed, p)
+        return true, nil
+    }
+    if err := os.Rename(s.versionPath(p, e.Version), local); err != nil {
+        if os.IsNotExist(err) {
+            ret.Failed = append(ret.Failed, p)
+            return true, nil
+        }
+        return true, err
+    }
+    m.Versions = m.Versions[:len(m.Versions)-1]
+    if err := s.saveMeta(p, m); err != nil {
+        os.Rename(local, s.versionPath(p, e.Version))
+        return true, err
+    }
+    s.notify(m.Versions[len(m.Versions)-1])
+    ret.Files++
+    ret.Bytes += e.Size
+    return true, nil
+}
+
+//彻底删除回收站中p（文件或目录）下删除时间早于before的文件，包括所有历史版本及元数据
+//快照引用的版本保留，文件仍在回收站中，快照删除后再次清理时删除
+func (s *Storage) PurgeTrash(p string, before time.Time) (model.TrashResult, error) {
+    ret := model.TrashResult{}
+    p, err := Clean(p)
+    if err != nil {
+        return ret, err
+    }
+
+    s.snapLock.Lock()
+    defer s.snapLock.Unlock()
+    refs, err := s.snapshotVersions()
+    if err != nil {
+        return ret, err
+    }
+    err = s.walkMeta(p, func(p string) error {
+        return s.purgeFile(p, before, refs[p], &ret)
+    })
+    return ret, err
+}
+
+func (s *Storage) purgeFile(p string, before time.Time, refs map[string]bool, ret *model.TrashResult) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    m, err := s.loadMeta(p)
+    if err != nil {
+        return err
+    }
+    e, ok := trashEntry(p, m)
+    if !ok || !e.DeleteTime.Before(before) {
+        return nil
+    }
+    n := len(m.Versions)
+    keep := make([]model.FileInfo, 0, n)
+    removed := 0
+    var rerr error
+    for i, v := range m.Versions[:n-1] {
+        //之前的删除标记没有内容
+        if v.State == model.FileDeleted {
+            continue
+        }
+        if refs[v.Version] {
+            keep = append(keep, v)
+            continue
+        }
+        if err := os.Remove(s.versionPath(p, v.Version)); err != nil && !os.IsNotExist(err) {
+            //已删除的版本仍需更新元数据
+            keep, rerr = append(keep, m.Versions[i:n-1]...), err
+            break
+        }
+        delete(m.Keys, v.Version)
+        removed++
+        ret.Bytes += storedSize(v)
+    }
+    if len(keep) > 0 {
+        if removed == 0 {
+            return rerr
+        }
+        m.Versions = append(keep, m.Versions[n-1])
+        if err := s.saveMeta(p, m); err != nil {
+            return err
+        }
+        return rerr
+    }
+    mp := s.metaPath(p)
+    if err := os.Remove(mp); err != nil {
+        return err
+    }
+    //其他文件的元数据及历史版本在子目录中时保留
+    os.Remove(filepath.Dir(mp))
+    os.Remove(filepath.Dir(s.versionPath(p, e.Version)))
+    ret.Files++
+    return nil
+}
@@ -0,0 +1,99 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "citron-repo/client"
+    "context"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sync"
+    "testing"
+    "time"
+)
+
+//等待仓库中的文件大小为size
+func waitRemote(t *testing.T, repo client.Repo, p string, size int64) {
+    deadline := time.Now().Add(5 * time.Second)
+    for time.Now().Before(deadline) {
+        if info, err := repo.Stat(p); err == nil && info.Size == size {
+            return
+        }
+        time.Sleep(50 * time.Millisecond)
+    }
+    t.Fatalf("wait %s timeout", p)
+}
+
+func TestAgent(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    src, _ := ioutil.TempDir("", "citron-src")
+    defer os.RemoveAll(src)
+    s, url, _, agentToken := startRepoServer(t, dir)
+    defer s.Shutdown(context.Background())
+
+    c := client.NewRestClient(url)
+    c.SetToken(agentToken)
+    statePath := filepath.Join(dir, "state", "agent.json")
+    writeFiles(t, src, map[string]string{"a.txt": "hello", ".citronignore": "*.swp\n"})
+
+    lock := sync.Mutex{}
+    var uploaded []string
+    start := func() (chan struct{}, chan error) {
+        state, err := client.LoadState(statePath)
+        if err != nil {
+            t.Fatal(err)
+        }
+        b := client.NewBackup(c, client.SetCallback(func(e client.BackupEntry) {
+            lock.Lock()
+            defer lock.Unlock()
+            uploaded = append(uploaded, e.Info.FilePath)
+        }))
+        a := client.NewAgent(b, state, []client.WatchDir{{Dir: src, Dest: "src"}}, client.SetDebounce(100*time.Millisecond))
+        stop, done := make(chan struct{}), make(chan error, 1)
+        go func() {
+            done <- a.Run(stop)
+        }()
+        return stop, done
+    }
+    count := func() int {
+        lock.Lock()
+        defer lock.Unlock()
+        n := len(uploaded)
+        uploaded = nil
+        return n
+    }
+
+    stop, done := start()
+    waitRemote(t, c, "src/a.txt", 5)
+
+    //新目录及文件，忽略的文件不上传
+    writeFiles(t, src, map[string]string{"sub/b.txt": "citron", "sub/b.txt.swp": "swap"})
+    waitRemote(t, c, "src/sub/b.txt", 6)
+    writeFiles(t, src, map[string]string{"a.txt": "changed"})
+    waitRemote(t, c, "src/a.txt", 7)
+    close(stop)
+    if err := <-done; err != nil {
+        t.Fatal(err)
+    }
+    if _, err := c.Stat("src/sub/b.txt.swp"); !client.IsNotFound(err) {
+        t.Fatalf("ignored file must not be uploaded, get %v", err)
+    }
+    count()
+
+    //停止期间的变化在启动后同步，未变化的文件不重新上传
+    writeFiles(t, src, map[string]string{"sub/b.txt": "offline change"})
+    stop, done = start()
+    waitRemote(t, c, "src/sub/b.txt", 14)
+    time.Sleep(200 * time.Millisecond)
+    close(stop)
+    <-done
+    if n := count(); n != 1 {
+        t.Fatalf("expect 1 upload but get %d", n)
+    }
+}
@@ -0,0 +1,163 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "citron-repo/apikey"
+    "citron-repo/client"
+    "citron-repo/handler"
+    "citron-repo/model"
+    "citron-repo/token"
+    "citron-repo/transport"
+    "citron-repo/user"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestApiKeyMgr(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, apikey.DEFAULT_FILE)
+
+    m, err := apikey.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    ret, err := m.Create("agent", model.ApiKeyInfo{Name: "cron", Scopes: []string{"write"}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !apikey.IsApiKey(ret.Key) || ret.Hash != "" {
+        t.Fatalf("invalid key result %v", ret)
+    }
+    data, _ := ioutil.ReadFile(path)
+    if strings.Contains(string(data), ret.Key) {
+        t.Fatal("api key must not be saved in plaintext")
+    }
+
+    k, err := m.Verify(ret.Key)
+    if err != nil || k.User != "agent" || k.LastUsed.IsZero() {
+        t.Fatalf("verify failed: %v %v", k, err)
+    }
+    if _, err := m.Verify(ret.Key + "0"); err != apikey.ErrInvalidKey {
+        t.Fatalf("expect invalid key but get %v", err)
+    }
+    m.Close()
+
+    m, err = apikey.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    keys := m.List("agent")
+    if len(keys) != 1 || keys[0].LastUsed.IsZero() {
+        t.Fatalf("api key must be reloaded with last used time: %v", keys)
+    }
+    if err := m.Revoke("other", keys[0].ID); err != apikey.ErrKeyNotFound {
+        t.Fatalf("expect not found but get %v", err)
+    }
+    if err := m.Revoke("agent", keys[0].ID); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := m.Verify(ret.Key); err != apikey.ErrInvalidKey {
+        t.Fatalf("expect invalid key but get %v", err)
+    }
+}
+
+func TestRestfulApiKey(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    tm := token.New()
+    defer tm.Close()
+    userMgr := user.New()
+    userMgr.Create(model.UserInfo{Username: "agent", Password: "agent123"})
+    api := handler.NewRestful(model.Config{BackupDir: dir},
+        handler.SetTokenMgr(tm), handler.SetUserMgr(userMgr))
+    engine := newEngine(api)
+
+    agentToken := login(t, engine, "agent", "agent123")
+    createKey := func(header, credential string, info model.ApiKeyInfo) (int, model.ApiKeyResult) {
+        req := jsonRequest(http.MethodPost, "/apikey", "", info)
+        req.Header.Set(header, credential)
+        code, ret := doRequest(engine, req)
+        result := model.ApiKeyResult{}
+        data, _ := json.Marshal(ret.Data)
+        json.Unmarshal(data, &result)
+        return code, result
+    }
+    metaRequest := func(key, rel string) int {
+        req := httptest.NewRequest(http.MethodPost, "/meta", nil)
+        req.Header.Set(handler.CITRON_API_KEY, key)
+        req.Header.Set(handler.CITRON_REL, rel)
+        req.Header.Set(handler.CITRON_FILENAME, "test.txt")
+        code, _ := doRequest(engine, req)
+        return code
+    }
+
+    code, key := createKey(handler.CITRON_TOKEN, agentToken, model.ApiKeyInfo{Name: "cron", Path: "etc"})
+    if code != http.StatusOK || key.Key == "" {
+        t.Fatalf("create api key failed: %d", code)
+    }
+
+    t.Run("header", func(t *testing.T) {
+        if code := metaRequest(key.Key, "etc"); code != http.StatusOK {
+            t.Fatalf("expect ok but get %d", code)
+        }
+        if code := metaRequest(key.Key, "var"); code != http.StatusForbidden {
+            t.Fatalf("expect forbidden but get %d", code)
+        }
+        if code := metaRequest("ck-0000000000000000-00", "etc"); code != http.StatusUnauthorized {
+            t.Fatalf("expect unauthorized but get %d", code)
+        }
+    })
+
+    t.Run("key can not create key", func(t *testing.T) {
+        if code, _ := createKey(handler.CITRON_API_KEY, key.Key, model.ApiKeyInfo{Name: "other"}); code != http.StatusForbidden {
+            t.Fatalf("expect forbidden but get %d", code)
+        }
+    })
+
+    t.Run("list", func(t *testing.T) {
+        code, ret := doRequest(engine, jsonRequest(http.MethodGet, "/apikey", agentToken, nil))
+        if code != http.StatusOK || len(ret.Data.([]interface{})) != 1 {
+            t.Fatalf("list api key failed: %d %v", code, ret)
+        }
+    })
+
+    t.Run("binary", func(t *testing.T) {
+        addr := freeAddr()
+        s := transport.NewBinaryServer(
+            transport.SetTransport(transport.NewTcpTransport(transport.SetPort(addr))),
+            transport.SetAuthenticator(api.BinaryAuthenticator()),
+        )
+        go s.ListenAndServe()
+        defer s.Close()
+        time.Sleep(100 * time.Millisecond)
+
+        c := client.NewBinaryClient(addr)
+        defer c.Close()
+        if err := c.LoginWithApiKey(key.Key); err != nil {
+            t.Fatal(err)
+        }
+    })
+
+    t.Run("revoke", func(t *testing.T) {
+        code, ret := doRequest(engine, jsonRequest(http.MethodDelete, "/apikey/"+key.ID, agentToken, nil))
+        if code != http.StatusOK {
+            t.Fatalf("revoke api key failed: %d %v", code, ret)
+        }
+        if code := metaRequest(key.Key, "etc"); code != http.StatusUnauthorized {
+            t.Fatalf("expect unauthorized but get %d", code)
+        }
+    })
+}
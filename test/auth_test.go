@@ -0,0 +1,242 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "citron-repo/auth"
+    "citron-repo/errcode"
+    "citron-repo/handler"
+    "citron-repo/model"
+    "citron-repo/token"
+    "citron-repo/user"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestAuthCheck(t *testing.T) {
+    agent := model.User{
+        Username: "agent",
+        Role:     model.RoleWriter,
+        Permissions: []model.Permission{
+            {Path: "/shared", Role: model.RoleRestoreOnly},
+            {Path: "/agent/readonly", Role: model.RoleReader},
+        },
+    }
+    admin := model.User{Username: "admin", Role: model.RoleAdmin}
+
+    cases := []struct {
+        user   model.User
+        action int
+        path   string
+        ok     bool
+    }{
+        {agent, auth.ActionWrite, "/agent/etc/hosts", true},
+        {agent, auth.ActionWrite, "/agent", true},
+        {agent, auth.ActionDelete, "/agent/etc/hosts", false},
+        {agent, auth.ActionWrite, "/agent2/etc/hosts", false},
+        {agent, auth.ActionList, "/agent2", false},
+        {agent, auth.ActionRead, "/shared/a", true},
+        {agent, auth.ActionWrite, "/shared/a", false},
+        {agent, auth.ActionList, "/agent/readonly/a", true},
+        {agent, auth.ActionRead, "/agent/readonly/a", false},
+        {agent, auth.ActionWrite, "/agent/../agent2/a", false},
+        {agent, auth.ActionConfig, "/", false},
+        {admin, auth.ActionWrite, "/agent/etc/hosts", true},
+        {admin, auth.ActionConfig, "/", true},
+        {admin, auth.ActionUser, "/", true},
+    }
+    for _, c := range cases {
+        err := auth.Check(c.user, c.action, c.path)
+        if (err == nil) != c.ok {
+            t.Fatalf("user %s action %d path %s expect %v but get %v", c.user.Username, c.action, c.path, c.ok, err)
+        }
+    }
+
+    if auth.Resolve("agent", "../../admin", "a.txt") != "/agent/admin/a.txt" {
+        t.Fatal("relative path must be resolved in user home")
+    }
+    if auth.Resolve("agent", "/shared", "a.txt") != "/shared/a.txt" {
+        t.Fatal("absolute path must be resolved in repository")
+    }
+}
+
+func TestRestfulRole(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    tm := token.New()
+    defer tm.Close()
+    userMgr := user.New()
+    engine := newTestRestful(tm, userMgr, dir)
+
+    userMgr.Create(model.UserInfo{Username: "agent", Password: "agent123"})
+    userMgr.Create(model.UserInfo{Username: "restore", Password: "restore123", Role: model.RoleRestoreOnly})
+    agentToken := login(t, engine, "agent", "agent123")
+    restoreToken := login(t, engine, "restore", "restore123")
+    adminToken := login(t, engine, "admin", "123456")
+
+    metaRequest := func(loginToken, rel string) (int, string) {
+        req := httptest.NewRequest(http.MethodPost, "/meta", nil)
+        req.Header.Set(handler.CITRON_TOKEN, loginToken)
+        req.Header.Set(handler.CITRON_REL, rel)
+        req.Header.Set(handler.CITRON_FILENAME, "test.txt")
+        code, ret := doRequest(engine, req)
+        return code, ret.Code
+    }
+
+    t.Run("config", func(t *testing.T) {
+        code, ret := doRequest(engine, jsonRequest(http.MethodPut, "/config", agentToken, model.Config{}))
+        if code != http.StatusForbidden || ret.Code != errcode.PermissionDenied.Code {
+            t.Fatalf("expect permission denied but get %d %v", code, ret)
+        }
+    })
+
+    t.Run("own subtree", func(t *testing.T) {
+        if code, _ := metaRequest(agentToken, "etc"); code != http.StatusOK {
+            t.Fatalf("expect ok but get %d", code)
+        }
+    })
+
+    t.Run("other subtree", func(t *testing.T) {
+        if code, c := metaRequest(agentToken, "/admin"); code != http.StatusForbidden || c != errcode.PermissionDenied.Code {
+            t.Fatalf("expect permission denied but get %d %s", code, c)
+        }
+        if code, _ := metaRequest(adminToken, "/agent"); code != http.StatusOK {
+            t.Fatalf("expect ok but get %d", code)
+        }
+    })
+
+    t.Run("restore only", func(t *testing.T) {
+        if code, c := metaRequest(restoreToken, "etc"); code != http.StatusForbidden || c != errcode.PermissionDenied.Code {
+            t.Fatalf("expect permission denied but get %d %s", code, c)
+        }
+    })
+
+    t.Run("grant", func(t *testing.T) {
+        code, ret := doRequest(engine, jsonRequest(http.MethodPut, "/admin/user/agent/role", adminToken, model.UserInfo{
+            Role:        model.RoleWriter,
+            Permissions: []model.Permission{{Path: "/shared", Role: model.RoleWriter}},
+        }))
+        if code != http.StatusOK {
+            t.Fatalf("set role failed: %d %v", code, ret)
+        }
+        if code, _ := metaRequest(agentToken, "/shared"); code != http.StatusOK {
+            t.Fatalf("expect ok but get %d", code)
+        }
+    })
+}
+
+func TestRestfulSignedToken(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    signer, _ := token.NewSigner(model.TokenKey{ID: "k1", Secret: strings.Repeat("1", 32)})
+    tm := token.New(token.SetSigner(signer))
+    defer tm.Close()
+    userMgr := user.New()
+    engine := newTestRestful(tm, userMgr, dir)
+    userMgr.Create(model.UserInfo{Username: "agent", Password: "agent123"})
+
+    loginScoped := func(info model.LoginInfo) string {
+        return loginResult(t, engine, info).Token
+    }
+    metaRequest := func(loginToken, rel string) int {
+        req := httptest.NewRequest(http.MethodPost, "/meta", nil)
+        req.Header.Set(handler.CITRON_TOKEN, loginToken)
+        req.Header.Set(handler.CITRON_REL, rel)
+        req.Header.Set(handler.CITRON_FILENAME, "test.txt")
+        code, _ := doRequest(engine, req)
+        return code
+    }
+
+    full := loginScoped(model.LoginInfo{Username: "agent", Password: "agent123"})
+    if !token.IsSigned(full) {
+        t.Fatal("expect signed token")
+    }
+    if code := metaRequest(full, "etc"); code != http.StatusOK {
+        t.Fatalf("expect ok but get %d", code)
+    }
+
+    t.Run("scope", func(t *testing.T) {
+        readOnly := loginScoped(model.LoginInfo{Username: "agent", Password: "agent123", Scopes: []string{"list", "read"}})
+        if code := metaRequest(readOnly, "etc"); code != http.StatusForbidden {
+            t.Fatalf("expect forbidden but get %d", code)
+        }
+    })
+
+    t.Run("path", func(t *testing.T) {
+        bound := loginScoped(model.LoginInfo{Username: "agent", Password: "agent123", Path: "etc"})
+        if code := metaRequest(bound, "etc/nginx"); code != http.StatusOK {
+            t.Fatalf("expect ok but get %d", code)
+        }
+        if code := metaRequest(bound, "var"); code != http.StatusForbidden {
+            t.Fatalf("expect forbidden but get %d", code)
+        }
+    })
+
+    t.Run("session", func(t *testing.T) {
+        refresh := func(refreshToken string) int {
+            code, _ := doRequest(engine, jsonRequest(http.MethodPost, "/token/refresh", "", model.RefreshInfo{RefreshToken: refreshToken}))
+            return code
+        }
+
+        //刷新token保存在服务端，使用后不能重放
+        result := loginResult(t, engine, model.LoginInfo{Username: "agent", Password: "agent123"})
+        if token.IsSigned(result.RefreshToken) {
+            t.Fatal("refresh token must be stored")
+        }
+        if code := refresh(result.RefreshToken); code != http.StatusOK {
+            t.Fatalf("refresh failed: %d", code)
+        }
+        if code := refresh(result.RefreshToken); code != http.StatusUnauthorized {
+            t.Fatalf("expect unauthorized but get %d", code)
+        }
+
+        //退出后签名token失效
+        result = loginResult(t, engine, model.LoginInfo{Username: "agent", Password: "agent123"})
+        req := jsonRequest(http.MethodPost, "/logout", result.Token, nil)
+        req.Header.Set(handler.CITRON_REFRESH_TOKEN, result.RefreshToken)
+        if code, ret := doRequest(engine, req); code != http.StatusOK {
+            t.Fatalf("logout failed: %d %v", code, ret)
+        }
+        if code := metaRequest(result.Token, "etc"); code != http.StatusUnauthorized {
+            t.Fatalf("expect unauthorized but get %d", code)
+        }
+        if code := refresh(result.RefreshToken); code != http.StatusUnauthorized {
+            t.Fatalf("expect unauthorized but get %d", code)
+        }
+
+        //吊销用户所有token，之前签发的签名token失效
+        adminToken := login(t, engine, "admin", "123456")
+        result = loginResult(t, engine, model.LoginInfo{Username: "agent", Password: "agent123"})
+        code, ret := doRequest(engine, jsonRequest(http.MethodDelete, "/admin/user/agent/token", adminToken, nil))
+        if code != http.StatusOK {
+            t.Fatalf("revoke failed: %d %v", code, ret)
+        }
+        if code := metaRequest(result.Token, "etc"); code != http.StatusUnauthorized {
+            t.Fatalf("expect unauthorized but get %d", code)
+        }
+        if code := refresh(result.RefreshToken); code != http.StatusUnauthorized {
+            t.Fatalf("expect unauthorized but get %d", code)
+        }
+        if code := metaRequest(adminToken, "etc"); code != http.StatusOK {
+            t.Fatalf("other users must not be revoked: %d", code)
+        }
+    })
+
+    t.Run("unknown key", func(t *testing.T) {
+        other, _ := token.NewSigner(model.TokenKey{ID: "k1", Secret: strings.Repeat("2", 32)})
+        forged, _ := other.Sign(token.Claims{Type: token.LoginToken, User: "admin", ExpireAt: time.Now().Add(time.Hour)})
+        code, ret := createMeta(engine, forged, "test.txt")
+        if code != http.StatusUnauthorized || ret.Code != errcode.AuthError.Code {
+            t.Fatalf("expect auth error but get %d %v", code, ret)
+        }
+    })
+}
@@ -0,0 +1,121 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "citron-repo/client"
+    "context"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+    for name, data := range files {
+        p := filepath.Join(dir, filepath.FromSlash(name))
+        os.MkdirAll(filepath.Dir(p), 0755)
+        if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func testBackup(t *testing.T, repo client.Repo, src string) {
+    writeFiles(t, src, map[string]string{
+        "a.txt":         "hello",
+        "sub/b.txt":     "citron",
+        "sub/deep/c.md": "backup",
+    })
+
+    expect := func(s client.BackupSummary, err error, added, changed, skipped int) {
+        if err != nil {
+            t.Fatal(err)
+        }
+        if s.Added != added || s.Changed != changed || s.Skipped != skipped || s.Failed != 0 {
+            t.Fatalf("expect %d/%d/%d but get %+v", added, changed, skipped, s)
+        }
+    }
+
+    b := client.NewBackup(repo)
+    s, err := b.Dir(src, "src")
+    expect(s, err, 3, 0, 0)
+    if s.Bytes != 17 {
+        t.Fatalf("expect 17 bytes but get %d", s.Bytes)
+    }
+    info, err := repo.Stat("src/sub/deep/c.md")
+    if err != nil || info.Size != 6 || info.Mode != 0644 {
+        t.Fatalf("unexpected info %+v %v", info, err)
+    }
+
+    s, err = b.Dir(src, "src")
+    expect(s, err, 0, 0, 3)
+
+    writeFiles(t, src, map[string]string{"sub/b.txt": "changed", "d.txt": "new"})
+    os.Chmod(filepath.Join(src, "a.txt"), 0600)
+    s, err = b.Dir(src, "src")
+    expect(s, err, 1, 2, 1)
+    if info, err := repo.Stat("src/a.txt"); err != nil || info.Mode != 0600 {
+        t.Fatalf("expect mode 0600 but get %+v %v", info, err)
+    }
+
+    //只修改时间时，比较checksum不会重新上传
+    later := time.Now().Add(time.Hour)
+    os.Chtimes(filepath.Join(src, "d.txt"), later, later)
+    s, err = client.NewBackup(repo, client.SetCompareChecksum(true)).Dir(src, "src")
+    expect(s, err, 0, 0, 4)
+    s, err = b.Dir(src, "src")
+    expect(s, err, 0, 1, 3)
+
+    //dry run只列出将要上传的文件
+    writeFiles(t, src, map[string]string{"e.txt": "dry", "e.swp": "swap"})
+    var entries []client.BackupEntry
+    s, err = client.NewBackup(repo, client.SetDryRun(true), client.SetExclude([]string{"*.swp"}),
+        client.SetCallback(func(e client.BackupEntry) {
+            entries = append(entries, e)
+        })).Dir(src, "src")
+    expect(s, err, 1, 0, 4)
+    if len(entries) != 5 || entries[2].Info.FilePath != "src/e.txt" || entries[2].Action != client.BackupAdded {
+        t.Fatalf("unexpected entries %v", entries)
+    }
+    if _, err := repo.Stat("src/e.txt"); !client.IsNotFound(err) {
+        t.Fatalf("dry run must not upload, get %v", err)
+    }
+}
+
+func TestBackupDir(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    s, url, addr, agentToken := startRepoServer(t, dir)
+    defer s.Shutdown(context.Background())
+
+    t.Run("rest", func(t *testing.T) {
+        src, _ := ioutil.TempDir("", "citron-src")
+        defer os.RemoveAll(src)
+        c := client.NewRestClient(url)
+        c.SetToken(agentToken)
+        testBackup(t, c, src)
+    })
+
+    os.RemoveAll(dir + "/agent")
+    os.RemoveAll(dir + "/.citron")
+
+    t.Run("binary", func(t *testing.T) {
+        src, _ := ioutil.TempDir("", "citron-src")
+        defer os.RemoveAll(src)
+        c, err := client.Dial(addr)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer c.Close()
+        if err := c.LoginWithToken(agentToken); err != nil {
+            t.Fatal(err)
+        }
+        testBackup(t, c, src)
+    })
+}
@@ -0,0 +1,233 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "citron-repo/client"
+    "citron-repo/handler"
+    "citron-repo/model"
+    "citron-repo/protocol"
+    "citron-repo/token"
+    "citron-repo/transport"
+    "citron-repo/user"
+    "encoding/json"
+    "io"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "os"
+    "strings"
+    "testing"
+    "time"
+)
+
+func freeAddr() string {
+    l, _ := net.Listen("tcp", "127.0.0.1:0")
+    defer l.Close()
+    return l.Addr().String()
+}
+
+func expectStatus(t *testing.T, err error, status int16) {
+    e, ok := err.(*protocol.Error)
+    if !ok || e.Status != status {
+        t.Fatalf("expect status %d but get %v", status, err)
+    }
+}
+
+func echo(t *testing.T, c *client.BinaryClient, msg string) (string, error) {
+    err := c.Send(int64(len(msg)), strings.NewReader(msg))
+    if err != nil {
+        t.Fatal(err)
+    }
+    r, err := c.Receive()
+    if err != nil {
+        return "", err
+    }
+    buf := bytes.NewBuffer(nil)
+    io.Copy(buf, r)
+    return buf.String(), nil
+}
+
+func TestBinaryAuth(t *testing.T) {
+    tm := token.New()
+    defer tm.Close()
+    userMgr := user.New()
+    userMgr.Create(model.UserInfo{Username: "agent", Password: "agent123"})
+    api := handler.NewRestful(model.Config{}, handler.SetTokenMgr(tm), handler.SetUserMgr(userMgr))
+
+    addr := freeAddr()
+    s := transport.NewBinaryServer(
+        transport.SetTransport(transport.NewTcpTransport(transport.SetPort(addr))),
+        transport.SetAuthenticator(api.BinaryAuthenticator()),
+        transport.SetAuthLimit(6, time.Minute, 10*time.Millisecond),
+    )
+    go s.ListenAndServe()
+    defer s.Close()
+    time.Sleep(100 * time.Millisecond)
+
+    t.Run("password", func(t *testing.T) {
+        c := client.NewBinaryClient(addr)
+        defer c.Close()
+
+        _, err := echo(t, c, "before auth")
+        expectStatus(t, err, protocol.StatusAuthRequired)
+
+        expectStatus(t, c.Login("agent", "wrong"), protocol.StatusAuthFailed)
+
+        if err := c.Login("agent", "agent123"); err != nil {
+            t.Fatal(err)
+        }
+        ret, err := echo(t, c, "after auth")
+        if err != nil || ret != "after auth" {
+            t.Fatalf("expect echo but get %s %v", ret, err)
+        }
+    })
+
+    t.Run("token", func(t *testing.T) {
+        c := client.NewBinaryClient(addr)
+        defer c.Close()
+
+        loginToken, _ := tm.CreateToken(token.Claims{Type: token.LoginToken, User: "agent"}, time.Minute)
+        fileToken, _ := tm.CreateToken(token.Claims{Type: token.FileToken, User: "agent"}, time.Minute)
+        expectStatus(t, c.LoginWithToken(fileToken), protocol.StatusAuthFailed)
+        if err := c.LoginWithToken(loginToken); err != nil {
+            t.Fatal(err)
+        }
+        ret, err := echo(t, c, "token")
+        if err != nil || ret != "token" {
+            t.Fatalf("expect echo but get %s %v", ret, err)
+        }
+    })
+
+    t.Run("empty body", func(t *testing.T) {
+        c := client.NewBinaryClient(addr)
+        defer c.Close()
+
+        if err := c.SendCommand(protocol.DebugCommandID, 0, nil); err != nil {
+            t.Fatal(err)
+        }
+        _, err := c.Receive()
+        expectStatus(t, err, protocol.StatusAuthRequired)
+    })
+
+    t.Run("too many failures", func(t *testing.T) {
+        c := client.NewBinaryClient(addr)
+        defer c.Close()
+
+        for i := 0; i < transport.MAX_AUTH_FAILURES-1; i++ {
+            expectStatus(t, c.Login("agent", "wrong"), protocol.StatusAuthFailed)
+        }
+        if err := c.Login("agent", "wrong"); err == nil {
+            t.Fatal("connection must be closed")
+        }
+    })
+
+    t.Run("address limit", func(t *testing.T) {
+        //之前的测试已失败5次
+        c := client.NewBinaryClient(addr)
+        defer c.Close()
+        start := time.Now()
+        expectStatus(t, c.Login("agent", "wrong"), protocol.StatusAuthFailed)
+        if time.Since(start) < 10*time.Millisecond {
+            t.Fatal("failed auth must be delayed")
+        }
+
+        //新连接使用正确的密码也被拒绝
+        c2 := client.NewBinaryClient(addr)
+        defer c2.Close()
+        expectStatus(t, c2.Login("agent", "agent123"), protocol.StatusAuthFailed)
+    })
+}
+
+func TestBinaryRevoke(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    tm := token.New()
+    defer tm.Close()
+    userMgr := user.New()
+    userMgr.Create(model.UserInfo{Username: "agent", Password: "agent123"})
+    api := handler.NewRestful(model.Config{BackupDir: dir}, handler.SetTokenMgr(tm), handler.SetUserMgr(userMgr))
+    engine := newEngine(api)
+
+    addr := freeAddr()
+    s := transport.NewBinaryServer(
+        transport.SetTransport(transport.NewTcpTransport(transport.SetPort(addr))),
+        transport.SetAuthenticator(api.BinaryAuthenticator()),
+        transport.SetRequestHandlerFactory(api.BinaryHandlerFactory()),
+        transport.SetAuthLimit(100, time.Minute, 0),
+    )
+    go s.ListenAndServe()
+    defer s.Close()
+    time.Sleep(100 * time.Millisecond)
+
+    //已认证的连接在token或api key失效后不能继续使用
+    t.Run("logout", func(t *testing.T) {
+        c := client.NewBinaryClient(addr)
+        defer c.Close()
+        agentToken := login(t, engine, "agent", "agent123")
+        if err := c.LoginWithToken(agentToken); err != nil {
+            t.Fatal(err)
+        }
+        if _, err := c.Upload(model.FileInfo{FilePath: "a.txt"}, 6, strings.NewReader("citron")); err != nil {
+            t.Fatal(err)
+        }
+        if code, _ := doRequest(engine, jsonRequest(http.MethodPost, "/logout", agentToken, nil)); code != http.StatusOK {
+            t.Fatalf("logout failed: %d", code)
+        }
+        _, err := c.List("")
+        expectStatus(t, err, protocol.StatusAuthFailed)
+    })
+
+    t.Run("expired", func(t *testing.T) {
+        c := client.NewBinaryClient(addr)
+        defer c.Close()
+        loginToken, _ := tm.CreateToken(token.Claims{Type: token.LoginToken, User: "agent"}, 500*time.Millisecond)
+        if err := c.LoginWithToken(loginToken); err != nil {
+            t.Fatal(err)
+        }
+        time.Sleep(time.Second)
+        _, err := c.Stat("a.txt")
+        expectStatus(t, err, protocol.StatusAuthFailed)
+    })
+
+    t.Run("api key", func(t *testing.T) {
+        c := client.NewBinaryClient(addr)
+        defer c.Close()
+        agentToken := login(t, engine, "agent", "agent123")
+        code, ret := doRequest(engine, jsonRequest(http.MethodPost, "/apikey", agentToken, model.ApiKeyInfo{Name: "cron"}))
+        key := model.ApiKeyResult{}
+        data, _ := json.Marshal(ret.Data)
+        json.Unmarshal(data, &key)
+        if code != http.StatusOK {
+            t.Fatalf("create api key failed: %d", code)
+        }
+        if err := c.LoginWithApiKey(key.Key); err != nil {
+            t.Fatal(err)
+        }
+        if _, err := c.Upload(model.FileInfo{FilePath: "a.txt"}, 6, strings.NewReader("citron")); err != nil {
+            t.Fatal(err)
+        }
+        if code, _ := doRequest(engine, jsonRequest(http.MethodDelete, "/apikey/"+key.ID, agentToken, nil)); code != http.StatusOK {
+            t.Fatalf("revoke api key failed: %d", code)
+        }
+        _, err := c.Upload(model.FileInfo{FilePath: "a.txt"}, 6, strings.NewReader("citron"))
+        expectStatus(t, err, protocol.StatusAuthFailed)
+    })
+
+    //重新认证失败后之前的身份失效
+    t.Run("failed re-auth", func(t *testing.T) {
+        c := client.NewBinaryClient(addr)
+        defer c.Close()
+        if err := c.Login("agent", "agent123"); err != nil {
+            t.Fatal(err)
+        }
+        expectStatus(t, c.Login("agent", "wrong"), protocol.StatusAuthFailed)
+        _, err := c.List("")
+        expectStatus(t, err, protocol.StatusAuthRequired)
+    })
+}
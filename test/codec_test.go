@@ -0,0 +1,72 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "citron-repo/protocol"
+    "io/ioutil"
+    "math/rand"
+    "strings"
+    "testing"
+)
+
+func TestCodec(t *testing.T) {
+    //可压缩数据与随机数据混合，且不是BlockSize的整数倍
+    random := make([]byte, protocol.BlockSize+100)
+    rand.Read(random)
+    data := append([]byte(strings.Repeat("citron block codec ", 8000)), random...)
+
+    for _, name := range protocol.DefaultCodecs {
+        t.Run(name, func(t *testing.T) {
+            codec, err := protocol.ParseCodec(name)
+            if err != nil {
+                t.Fatal(err)
+            }
+            body := bytes.NewBuffer(nil)
+            if err := protocol.WriteBlocks(body, codec, bytes.NewReader(data), int64(len(data))); err != nil {
+                t.Fatal(err)
+            }
+            if body.Len() >= len(data) {
+                t.Fatalf("expect compressed but get %d >= %d", body.Len(), len(data))
+            }
+
+            ret, err := ioutil.ReadAll(protocol.NewBlockReader(bytes.NewReader(body.Bytes()), codec, int64(len(data))))
+            if err != nil || !bytes.Equal(ret, data) {
+                t.Fatalf("read blocks failed %d %v", len(ret), err)
+            }
+
+            //分段推送，结尾是下一个请求的数据
+            out := bytes.NewBuffer(nil)
+            d := protocol.NewBlockDecoder(out, codec, int64(len(data)))
+            input := append(body.Bytes(), "next"...)
+            consumed := 0
+            for i := 0; i < len(input); i += 1000 {
+                end := i + 1000
+                if end > len(input) {
+                    end = len(input)
+                }
+                n, err := d.Decode(input[i:end])
+                if err != nil {
+                    t.Fatal(err)
+                }
+                consumed += n
+            }
+            if !d.Done() || consumed != body.Len() || !bytes.Equal(out.Bytes(), data) {
+                t.Fatalf("decode blocks failed %v %d %d", d.Done(), consumed, out.Len())
+            }
+        })
+    }
+
+    if _, err := protocol.ParseCodec("lz4"); err != protocol.ErrCodec {
+        t.Fatalf("expect ErrCodec but get %v", err)
+    }
+    code, codec := protocol.SplitReserve(protocol.JoinReserve(int16(protocol.DownloadCommandID), protocol.CodecZstd))
+    if code != int16(protocol.DownloadCommandID) || codec != protocol.CodecZstd {
+        t.Fatalf("unexpected reserve %d %d", code, codec)
+    }
+}
@@ -0,0 +1,369 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "citron-repo/config"
+    "citron-repo/errcode"
+    "citron-repo/handler"
+    "citron-repo/model"
+    "citron-repo/storage"
+    "citron-repo/token"
+    "citron-repo/user"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "syscall"
+    "testing"
+    "time"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func TestConfigLoad(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, config.DEFAULT_FILE)
+
+    t.Run("default", func(t *testing.T) {
+        conf, err := config.Load(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if conf.Http.Port != config.DEFAULT_HTTP_PORT || conf.Token.LoginExpire.Duration() != config.DEFAULT_LOGIN_EXPIRE {
+            t.Fatalf("expect default config but get %v", conf)
+        }
+    })
+
+    ioutil.WriteFile(path, []byte(`
+backupDir: /data/citron
+http:
+  port: 9090
+token:
+  loginExpire: 1h
+  keys:
+    - id: k1
+      secret: `+testSecret+`
+`), 0600)
+
+    t.Run("file and env", func(t *testing.T) {
+        os.Setenv("CITRON_HTTP_PORT", "9191")
+        os.Setenv("CITRON_TOKEN_FILE_EXPIRE", "30s")
+        defer os.Unsetenv("CITRON_HTTP_PORT")
+        defer os.Unsetenv("CITRON_TOKEN_FILE_EXPIRE")
+
+        conf, err := config.Load(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if conf.BackupDir != "/data/citron" || conf.Token.LoginExpire.Duration() != time.Hour || len(conf.Token.Keys) != 1 {
+            t.Fatalf("file not loaded: %v", conf)
+        }
+        if conf.Http.Port != 9191 || conf.Token.FileExpire.Duration() != 30*time.Second {
+            t.Fatalf("env not applied: %v", conf)
+        }
+        if conf.Binary.Port != config.DEFAULT_BINARY_PORT {
+            t.Fatalf("expect default binary port but get %d", conf.Binary.Port)
+        }
+    })
+
+    t.Run("invalid env", func(t *testing.T) {
+        os.Setenv("CITRON_HTTP_PORT", "abc")
+        defer os.Unsetenv("CITRON_HTTP_PORT")
+        _, err := config.Load(path)
+        if err == nil || !strings.Contains(err.Error(), "CITRON_HTTP_PORT") {
+            t.Fatalf("expect env error but get %v", err)
+        }
+    })
+
+    t.Run("unknown field", func(t *testing.T) {
+        bad := filepath.Join(dir, "bad.yaml")
+        ioutil.WriteFile(bad, []byte("htp:\n  port: 80\n"), 0600)
+        if _, err := config.Load(bad); err == nil {
+            t.Fatal("expect unknown field error")
+        }
+    })
+
+    t.Run("no http timeout", func(t *testing.T) {
+        //默认不限制http请求的读写时间，大文件上传下载不会被中断
+        conf := config.Default()
+        if conf.Http.ReadTimeout != 0 || conf.Http.WriteTimeout != 0 {
+            t.Fatalf("expect no timeout but get %v", conf.Http)
+        }
+        if err := config.Validate(conf); err != nil {
+            t.Fatal(err)
+        }
+        conf.Http.WriteTimeout = model.Duration(-time.Second)
+        if err := config.Validate(conf); err == nil || !strings.Contains(err.Error(), "http.writeTimeout") {
+            t.Fatalf("expect timeout error but get %v", err)
+        }
+    })
+
+    t.Run("validate", func(t *testing.T) {
+        conf := config.Default()
+        conf.Http.Port = 70000
+        conf.Binary.ReadBufSize = 1
+        conf.Token.Keys = []model.TokenKey{{ID: "k1", Secret: "short"}}
+        conf.Compression.Rules = []model.CompressionRule{{Prefix: "/logs", Codec: "lz4"}}
+        conf.Encryption.Keys = []model.MasterKey{{ID: "m1", Secret: "0123"}, {ID: "m1", Secret: "0123", File: "m1.key"}}
+        conf.Scrub.Schedule = "61 * * * *"
+        conf.Replication.Replicas = []model.ReplicaConfig{{Name: "r1", Addr: "replica"}}
+        conf.Trash.Retention = model.Duration(-time.Hour)
+        err := config.Validate(conf)
+        verr, ok := err.(config.ValidationError)
+        if !ok || len(verr) != 11 {
+            t.Fatalf("expect 11 errors but get %v", err)
+        }
+        if !strings.Contains(err.Error(), "http.port") || !strings.Contains(err.Error(), "token.keys[0].secret") ||
+            !strings.Contains(err.Error(), "compression.rules[0].codec") || !strings.Contains(err.Error(), "encryption.keys[1].id") ||
+            !strings.Contains(err.Error(), "replication.replicas[0].addr") || !strings.Contains(err.Error(), "trash.retention") {
+            t.Fatalf("expect clear errors but get %v", err)
+        }
+    })
+}
+
+func TestConfigManager(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, config.DEFAULT_FILE)
+
+    os.Setenv("CITRON_TOKEN_KEYS", "env:"+testSecret)
+    defer os.Unsetenv("CITRON_TOKEN_KEYS")
+
+    m, err := config.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    changed := make(chan model.Config, 4)
+    m.OnChange(func(old, new model.Config) {
+        changed <- new
+    })
+
+    conf := m.Get()
+    conf.BackupDir = dir
+    conf.Limit.MaxUploadSize = 1024
+    if err := m.Update(conf); err != nil {
+        t.Fatal(err)
+    }
+    if (<-changed).Limit.MaxUploadSize != 1024 {
+        t.Fatal("listener not notified")
+    }
+    data, _ := ioutil.ReadFile(path)
+    if !strings.Contains(string(data), "maxUploadSize: 1024") {
+        t.Fatalf("config not saved: %s", data)
+    }
+    if strings.Contains(string(data), testSecret) {
+        t.Fatal("secret from env must not be saved")
+    }
+
+    conf.Http.Port = 0
+    conf.Binary.Port = config.DEFAULT_HTTP_PORT
+    if err := m.Update(conf); err == nil {
+        t.Fatal("expect validation error")
+    }
+    if m.Get().Binary.Port != config.DEFAULT_BINARY_PORT {
+        t.Fatal("invalid config must not be applied")
+    }
+
+    ioutil.WriteFile(path, []byte("backupDir: "+dir+"\nlimit:\n  maxUploadSize: 2048\n"), 0600)
+    stop := m.WatchSignal()
+    defer stop()
+    syscall.Kill(os.Getpid(), syscall.SIGHUP)
+    select {
+    case c := <-changed:
+        if c.Limit.MaxUploadSize != 2048 || len(c.Token.Keys) != 1 {
+            t.Fatalf("reload failed: %v", c)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("config not reloaded on SIGHUP")
+    }
+
+    ioutil.WriteFile(path, []byte("binary:\n  readBufSize: 1\n"), 0600)
+    if err := m.Reload(); err == nil {
+        t.Fatal("expect validation error")
+    }
+    if m.Get().Limit.MaxUploadSize != 2048 {
+        t.Fatal("invalid config must not be applied")
+    }
+}
+
+func TestConfigConcurrentUpdate(t *testing.T) {
+    m := config.NewManager(model.Config{BackupDir: "."})
+    var last int64
+    m.OnChange(func(old, new model.Config) {
+        //通知期间其他更新不能替换配置
+        time.Sleep(time.Millisecond)
+        if m.Get().Limit.MaxUploadSize != new.Limit.MaxUploadSize {
+            t.Errorf("notified %d but current is %d", new.Limit.MaxUploadSize, m.Get().Limit.MaxUploadSize)
+        }
+        last = new.Limit.MaxUploadSize
+    })
+
+    wait := sync.WaitGroup{}
+    for i := 1; i <= 20; i++ {
+        wait.Add(1)
+        go func(size int64) {
+            defer wait.Done()
+            conf := m.Get()
+            conf.Limit.MaxUploadSize = size
+            m.Update(conf)
+        }(int64(i))
+    }
+    wait.Wait()
+    if last != m.Get().Limit.MaxUploadSize {
+        t.Fatalf("last notified %d but current is %d", last, m.Get().Limit.MaxUploadSize)
+    }
+}
+
+func TestRestfulConfig(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, config.DEFAULT_FILE)
+
+    conf := config.Default()
+    conf.Username = "admin"
+    conf.Password = "123456"
+    conf.BackupDir = dir
+    conf.Token.Keys = []model.TokenKey{{ID: "k1", Secret: testSecret}}
+    config.Save(path, conf)
+    m, err := config.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tm := token.New()
+    defer tm.Close()
+    api := handler.NewRestful(model.Config{}, handler.SetConfigMgr(m), handler.SetTokenMgr(tm), handler.SetUserMgr(user.New()))
+    engine := newEngine(api)
+    adminToken := login(t, engine, "admin", "123456")
+
+    t.Run("get", func(t *testing.T) {
+        code, ret := doRequest(engine, jsonRequest(http.MethodGet, "/config", adminToken, nil))
+        if code != http.StatusOK {
+            t.Fatalf("get config failed: %d %v", code, ret)
+        }
+        data, _ := json.Marshal(ret.Data)
+        if strings.Contains(string(data), testSecret) || strings.Contains(string(data), "123456") {
+            t.Fatalf("secrets must be masked: %s", data)
+        }
+    })
+
+    t.Run("update", func(t *testing.T) {
+        body := map[string]interface{}{
+            "token": map[string]interface{}{"loginExpire": "1h", "refreshExpire": "24h", "fileExpire": "15s", "keys": []interface{}{map[string]string{"id": "k1"}}},
+            "limit": map[string]interface{}{"maxUploadSize": 4},
+            "users": []interface{}{map[string]string{"username": "agent", "password": "agent123"}},
+        }
+        code, ret := doRequest(engine, jsonRequest(http.MethodPut, "/config", adminToken, body))
+        if code != http.StatusOK {
+            t.Fatalf("update config failed: %d %v", code, ret)
+        }
+        c := m.Get()
+        if c.Token.LoginExpire.Duration() != time.Hour || c.Token.Keys[0].Secret != testSecret || c.Password != "123456" {
+            t.Fatalf("unexpected config: %v", c)
+        }
+        saved, _ := config.ReadFile(path)
+        if saved.Limit.MaxUploadSize != 4 {
+            t.Fatal("config not saved")
+        }
+        //配置中新增的用户
+        login(t, engine, "agent", "agent123")
+
+        _, meta := createMeta(engine, adminToken, "large.txt")
+        code, ret = doRequest(engine, uploadRequest(adminToken, meta.Data.(string), []byte("too large")))
+        if code != http.StatusRequestEntityTooLarge || ret.Code != errcode.FileTooLarge.Code {
+            t.Fatalf("expect file too large but get %d %v", code, ret)
+        }
+        //上传失败后文件token仍然有效
+        code, ret = doRequest(engine, uploadRequest(adminToken, meta.Data.(string), []byte("ok")))
+        if code != http.StatusOK {
+            t.Fatalf("expect retry ok but get %d %v", code, ret)
+        }
+    })
+
+    t.Run("encryption", func(t *testing.T) {
+        k1 := strings.Repeat("11", storage.MASTER_KEY_SIZE)
+        body := map[string]interface{}{"encryption": map[string]interface{}{"keys": []interface{}{map[string]string{"id": "m1", "secret": k1}}}}
+        code, ret := doRequest(engine, jsonRequest(http.MethodPut, "/config", adminToken, body))
+        if code != http.StatusOK {
+            t.Fatalf("update config failed: %d %v", code, ret)
+        }
+        _, meta := createMeta(engine, adminToken, "enc.txt")
+        code, ret = doRequest(engine, uploadRequest(adminToken, meta.Data.(string), []byte("on")))
+        if code != http.StatusOK {
+            t.Fatalf("upload failed: %d %v", code, ret)
+        }
+
+        //新增主密钥，旧密钥的secret为空时保留
+        k2 := strings.Repeat("22", storage.MASTER_KEY_SIZE)
+        body = map[string]interface{}{"encryption": map[string]interface{}{"keys": []interface{}{
+            map[string]string{"id": "m2", "secret": k2}, map[string]string{"id": "m1"}}}}
+        code, ret = doRequest(engine, jsonRequest(http.MethodPut, "/config", adminToken, body))
+        if code != http.StatusOK || m.Get().Encryption.Keys[1].Secret != k1 {
+            t.Fatalf("update config failed: %d %v", code, ret)
+        }
+        code, ret = doRequest(engine, jsonRequest(http.MethodGet, "/config", adminToken, nil))
+        if data, _ := json.Marshal(ret.Data); code != http.StatusOK || strings.Contains(string(data), k2) {
+            t.Fatalf("master keys must be masked: %s", data)
+        }
+        code, ret = doRequest(engine, jsonRequest(http.MethodPost, "/encryption/rotate", adminToken, nil))
+        data, _ := json.Marshal(ret.Data)
+        rotated := model.RotateResult{}
+        json.Unmarshal(data, &rotated)
+        if code != http.StatusOK || rotated.Files != 1 || rotated.Keys != 1 {
+            t.Fatalf("rotate failed: %d %v", code, ret)
+        }
+    })
+
+    t.Run("scrub", func(t *testing.T) {
+        code, ret := doRequest(engine, jsonRequest(http.MethodGet, "/scrub", adminToken, nil))
+        if code != http.StatusOK || ret.Data != nil {
+            t.Fatalf("expect no scrub but get %d %v", code, ret)
+        }
+        //在后台执行，结果通过GET获取
+        code, ret = doRequest(engine, jsonRequest(http.MethodPost, "/scrub", adminToken, nil))
+        if code != http.StatusAccepted {
+            t.Fatalf("scrub failed: %d %v", code, ret)
+        }
+        for i := 0; i < 100 && ret.Data == nil; i++ {
+            time.Sleep(20 * time.Millisecond)
+            code, ret = doRequest(engine, jsonRequest(http.MethodGet, "/scrub", adminToken, nil))
+        }
+        data, _ := json.Marshal(ret.Data)
+        report := model.ScrubReport{}
+        json.Unmarshal(data, &report)
+        if code != http.StatusOK || !report.Ok() || report.Files == 0 {
+            t.Fatalf("expect last scrub but get %d %v", code, ret)
+        }
+    })
+
+    t.Run("replication", func(t *testing.T) {
+        code, ret := doRequest(engine, jsonRequest(http.MethodGet, "/replication", adminToken, nil))
+        if code != http.StatusOK {
+            t.Fatalf("expect replication status but get %d %v", code, ret)
+        }
+        code, ret = doRequest(engine, jsonRequest(http.MethodPost, "/replication/r1/verify", adminToken, nil))
+        if code != http.StatusNotFound || ret.Code != errcode.ReplicaNotFound.Code {
+            t.Fatalf("expect replica not found but get %d %v", code, ret)
+        }
+    })
+
+    t.Run("invalid", func(t *testing.T) {
+        body := map[string]interface{}{"http": map[string]interface{}{"port": 70000}}
+        code, ret := doRequest(engine, jsonRequest(http.MethodPut, "/config", adminToken, body))
+        if code != http.StatusBadRequest || ret.Code != errcode.ConfigError.Code || !strings.Contains(ret.Msg, "http.port") {
+            t.Fatalf("expect config error but get %d %v", code, ret)
+        }
+        if m.Get().Http.Port != config.DEFAULT_HTTP_PORT {
+            t.Fatal("invalid config must not be applied")
+        }
+    })
+}
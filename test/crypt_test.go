@@ -0,0 +1,184 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "citron-repo/client"
+    "citron-repo/model"
+    "context"
+    "io/ioutil"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestCryptSize(t *testing.T) {
+    for _, n := range []int64{0, 1, client.CRYPT_CHUNK_SIZE - 1, client.CRYPT_CHUNK_SIZE, client.CRYPT_CHUNK_SIZE + 1, 3*client.CRYPT_CHUNK_SIZE + 7} {
+        if ret := client.DecryptedSize(client.EncryptedSize(n)); ret != n {
+            t.Fatalf("expect %d but get %d", n, ret)
+        }
+    }
+    if client.DecryptedSize(10) != -1 {
+        t.Fatal("expect invalid size")
+    }
+}
+
+//服务端保存的文件名及内容中不能出现明文
+func checkCiphertext(t *testing.T, dir string, names []string, content string) {
+    filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, name := range names {
+            if fi.Name() == name {
+                t.Fatalf("plaintext name %s", p)
+            }
+        }
+        if !fi.IsDir() && fi.Name() != client.CRYPT_KEY_FILE && strings.Contains(readFile(t, p), content) {
+            t.Fatalf("plaintext content %s", p)
+        }
+        return nil
+    })
+}
+
+func testCrypt(t *testing.T, repo client.Repo, dir, dest string) {
+    //跨块边界的文件
+    data := make([]byte, 2*client.CRYPT_CHUNK_SIZE+100)
+    rand.Read(data)
+    for _, size := range []int{0, client.CRYPT_CHUNK_SIZE, len(data)} {
+        p := dest + "/chunk.bin"
+        info, err := repo.Upload(model.FileInfo{FilePath: p}, int64(size), bytes.NewReader(data[:size]))
+        if err != nil || info.Size != int64(size) || info.FilePath != "/agent/"+p {
+            t.Fatalf("unexpected upload %v %v", info, err)
+        }
+        stat, err := repo.Stat(p)
+        if err != nil || stat.Size != int64(size) || stat.FileName != "chunk.bin" {
+            t.Fatalf("unexpected stat %v %v", stat, err)
+        }
+        buf := bytes.NewBuffer(nil)
+        ret, err := repo.Download(model.FileRequest{Path: p}, buf)
+        if err != nil || !bytes.Equal(buf.Bytes(), data[:size]) || ret.Checksum != info.Checksum {
+            t.Fatalf("unexpected download %d %v", buf.Len(), err)
+        }
+    }
+
+    //篡改服务端保存的密文
+    var stored string
+    filepath.Walk(filepath.Join(dir, "agent"), func(p string, fi os.FileInfo, err error) error {
+        if err == nil && fi.Size() == client.EncryptedSize(int64(len(data))) {
+            stored = p
+        }
+        return nil
+    })
+    if stored == "" {
+        t.Fatal("stored file not found")
+    }
+    cipher, _ := ioutil.ReadFile(stored)
+    cipher[100] ^= 0xFF
+    ioutil.WriteFile(stored, cipher, 0644)
+    if _, err := repo.Download(model.FileRequest{Path: dest + "/chunk.bin"}, ioutil.Discard); err != client.ErrDecrypt {
+        t.Fatalf("expect decrypt error but get %v", err)
+    }
+    //出错后连接仍然可用
+    if _, err := repo.Upload(model.FileInfo{FilePath: dest + "/chunk.bin"}, 0, bytes.NewReader(nil)); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestCrypt(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    s, url, binaryAddr, agentToken := startRepoServer(t, dir)
+    defer s.Shutdown(context.Background())
+
+    names := []string{"rest", "binary", "a.txt", "bin", "run.sh", "sub", "b.txt", "deep", "c.md", "chunk.bin"}
+
+    t.Run("rest", func(t *testing.T) {
+        c := client.NewRestClient(url)
+        c.SetToken(agentToken)
+        //没有密钥时不自动创建
+        if _, err := client.LoadKey(c, "secret"); err != client.ErrNoKey {
+            t.Fatalf("expect no key but get %v", err)
+        }
+        key, err := client.InitKey(c, "secret", client.CIPHER_AES_GCM)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, err := client.InitKey(c, "secret", ""); err != client.ErrKeyExists {
+            t.Fatalf("expect key exists but get %v", err)
+        }
+        repo := client.NewCryptRepo(c, key)
+        testCrypt(t, repo, dir, "rest-chunk")
+        testRestore(t, repo, "rest", nil)
+        checkCiphertext(t, filepath.Join(dir, "agent"), names, "hello")
+
+        //密钥参数保存在仓库中，口令错误时无法打开
+        if _, err := client.LoadKey(c, "wrong"); err != client.ErrPassphrase {
+            t.Fatalf("expect wrong passphrase but get %v", err)
+        }
+        key, err = client.LoadKey(c, "secret")
+        if err != nil {
+            t.Fatal(err)
+        }
+        infos, err := client.NewCryptRepo(c, key).List("")
+        //密钥参数文件不返回
+        if err != nil || len(infos) != 2 {
+            t.Fatalf("unexpected list %v %v", infos, err)
+        }
+        for _, info := range infos {
+            if info.FileName != "rest" && info.FileName != "rest-chunk" {
+                t.Fatalf("unexpected file %v", info)
+            }
+        }
+
+        //快照清单中只有加密后的路径
+        snaps, err := c.Snapshots("")
+        if err != nil || len(snaps) != 1 || strings.Contains(snaps[0].Path, "rest") {
+            t.Fatalf("unexpected snapshots %v %v", snaps, err)
+        }
+        snaps, err = repo.Snapshots("rest")
+        if err != nil || len(snaps) != 1 || snaps[0].Path != "/agent/rest" {
+            t.Fatalf("unexpected snapshots %v %v", snaps, err)
+        }
+
+        //密钥参数丢失时不能创建新的密钥，否则已加密的文件无法解密
+        if err := os.Rename(filepath.Join(dir, "agent", client.CRYPT_KEY_FILE), filepath.Join(dir, "key.bak")); err != nil {
+            t.Fatal(err)
+        }
+        if _, err := client.LoadKey(c, "secret"); err != client.ErrKeyLost {
+            t.Fatalf("expect key lost but get %v", err)
+        }
+        if _, err := client.InitKey(c, "secret", ""); err != client.ErrKeyLost {
+            t.Fatalf("expect key lost but get %v", err)
+        }
+        os.Rename(filepath.Join(dir, "key.bak"), filepath.Join(dir, "agent", client.CRYPT_KEY_FILE))
+    })
+    t.Run("binary", func(t *testing.T) {
+        key, _, err := client.NewKey("another", client.CIPHER_XCHACHA)
+        if err != nil {
+            t.Fatal(err)
+        }
+        connect := func() (client.Repo, error) {
+            c, err := client.Dial(binaryAddr)
+            if err != nil {
+                return nil, err
+            }
+            return client.NewCryptRepo(c, key), c.LoginWithToken(agentToken)
+        }
+        repo, err := connect()
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer repo.Close()
+        testCrypt(t, repo, dir, "binary-chunk")
+        testRestore(t, repo, "binary", connect)
+        checkCiphertext(t, filepath.Join(dir, "agent"), names, "hello")
+    })
+}
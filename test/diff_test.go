@@ -0,0 +1,117 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "citron-repo/client"
+    "citron-repo/diff"
+    "citron-repo/model"
+    "context"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestDiff(t *testing.T) {
+    now := time.Now()
+    file := func(p, sum string, size int64) model.FileInfo {
+        return model.FileInfo{FilePath: p, Checksum: sum, Size: size, ModTime: now}
+    }
+    old := []model.FileInfo{
+        file("/a/same.txt", "s1", 1),
+        file("/a/changed.txt", "c1", 1),
+        file("/a/removed.txt", "r1", 1),
+        file("/a/old/name.txt", "n1", 1),
+        file("/a/touched.txt", "t1", 1),
+    }
+    touched := file("/b/touched.txt", "t1", 1)
+    touched.ModTime = now.Add(time.Second)
+    new := []model.FileInfo{
+        file("/b/same.txt", "s1", 1),
+        file("/b/changed.txt", "c2", 2),
+        file("/b/new/name.txt", "n1", 1),
+        file("/b/added.txt", "a1", 1),
+        touched,
+        {FilePath: "/b/dir", IsDir: true},
+    }
+
+    var ret []string
+    err := diff.Diff("/a", old, "/b", new, func(e model.DiffEntry) error {
+        ret = append(ret, fmt.Sprintf("%s %s %s %v", e.Type, e.OldPath, e.Path, e.Changes))
+        return nil
+    })
+    expect := []string{
+        "modified  changed.txt [checksum size]",
+        "modified  touched.txt [modTime]",
+        "added  added.txt []",
+        "renamed old/name.txt new/name.txt []",
+        "removed  removed.txt []",
+    }
+    if err != nil || !reflect.DeepEqual(ret, expect) {
+        t.Fatalf("expect %v but get %v %v", expect, ret, err)
+    }
+}
+
+func TestDiffSnapshot(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    src, _ := ioutil.TempDir("", "citron-src")
+    defer os.RemoveAll(src)
+    s, url, _, agentToken := startRepoServer(t, dir)
+    defer s.Shutdown(context.Background())
+
+    c := client.NewRestClient(url)
+    c.SetToken(agentToken)
+    snapshot := func() string {
+        s, err := client.NewBackup(c, client.SetSnapshot("host")).Dir(src, "src")
+        if err != nil {
+            t.Fatal(err)
+        }
+        return s.Snapshot.ID
+    }
+    files := map[string]string{}
+    for i := 0; i < 300; i++ {
+        files[fmt.Sprintf("f%03d.txt", i)] = fmt.Sprintf("file %d", i)
+    }
+    writeFiles(t, src, files)
+    first := snapshot()
+
+    later := time.Now().Add(time.Minute)
+    writeFiles(t, src, map[string]string{"f000.txt": "changed", "new.txt": "new"})
+    os.Chtimes(src+"/f000.txt", later, later)
+    os.Rename(src+"/f001.txt", src+"/renamed.txt")
+    os.Remove(src + "/f002.txt")
+    second := snapshot()
+
+    count := func(from, to string) map[string]int {
+        ret := map[string]int{}
+        if err := c.DiffSnapshot(from, to, func(e model.DiffEntry) error {
+            ret[e.Type]++
+            return nil
+        }); err != nil {
+            t.Fatal(err)
+        }
+        return ret
+    }
+    expect := map[string]int{model.DiffModified: 1, model.DiffAdded: 1, model.DiffRenamed: 1, model.DiffRemoved: 1}
+    if ret := count(first, second); !reflect.DeepEqual(ret, expect) {
+        t.Fatalf("expect %v but get %v", expect, ret)
+    }
+    if ret := count(second, first); ret[model.DiffRemoved] != 1 || ret[model.DiffAdded] != 1 || ret[model.DiffRenamed] != 1 {
+        t.Fatalf("unexpected reverse diff %v", ret)
+    }
+    //与当前文件比较，本地删除的文件仍在仓库中
+    if ret := count(first, ""); ret[model.DiffModified] != 1 || ret[model.DiffAdded] != 2 || ret[model.DiffRemoved] != 0 {
+        t.Fatalf("unexpected diff with current files %v", ret)
+    }
+    if err := c.DiffSnapshot("1", "", func(e model.DiffEntry) error { return nil }); !client.IsNotFound(err) {
+        t.Fatalf("expect not found but get %v", err)
+    }
+}
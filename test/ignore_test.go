@@ -0,0 +1,101 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "citron-repo/client"
+    "io/ioutil"
+    "os"
+    "reflect"
+    "sort"
+    "testing"
+)
+
+func TestIgnoreMatch(t *testing.T) {
+    ig := client.NewIgnore("", []string{
+        "# comment",
+        "",
+        "*.swp",
+        "node_modules/",
+        "/build",
+        "docs/**/*.tmp",
+        "*.log",
+        "!keep.log",
+        "\\#notes",
+        "file[0-9].txt",
+    })
+    cases := []struct {
+        path    string
+        isDir   bool
+        ignored bool
+    }{
+        {"a.swp", false, true},
+        {"src/.a.swp", false, true},
+        {"node_modules", true, true},
+        {"web/node_modules", true, true},
+        {"node_modules", false, false},
+        {"build", true, true},
+        {"src/build", true, false},
+        {"docs/a.tmp", false, true},
+        {"docs/x/y/a.tmp", false, true},
+        {"a.tmp", false, false},
+        {"err.log", false, true},
+        {"logs/keep.log", false, false},
+        {"#notes", false, true},
+        {"file1.txt", false, true},
+        {"filea.txt", false, false},
+        {"src/main.go", false, false},
+    }
+    for _, c := range cases {
+        if ret := (client.Ignores{ig}).Ignored(c.path, c.isDir); ret != c.ignored {
+            t.Fatalf("%s expect %v but get %v", c.path, c.ignored, ret)
+        }
+    }
+
+    //子目录规则只作用于子目录，且优先于父目录规则
+    sub := client.NewIgnore("web", []string{"!debug.log", "/dist"})
+    igs := client.Ignores{ig, sub}
+    if igs.Ignored("web/debug.log", false) || !igs.Ignored("debug.log", false) {
+        t.Fatal("sub directory rule must override parent rule")
+    }
+    if !igs.Ignored("web/dist", true) || igs.Ignored("dist", true) || igs.Ignored("web/src/dist", true) {
+        t.Fatal("anchored rule must be relative to its directory")
+    }
+}
+
+func TestIgnoreScan(t *testing.T) {
+    src, _ := ioutil.TempDir("", "citron-src")
+    defer os.RemoveAll(src)
+    writeFiles(t, src, map[string]string{
+        ".citronignore":              "*.o\n!keep.o\n",
+        "main.c":                     "",
+        "main.o":                     "",
+        "keep.o":                     "",
+        "node_modules/a/index.js":    "",
+        "web/.citronignore":          "dist/\n",
+        "web/dist/app.js":            "",
+        "web/src/app.js":             "",
+        "web/src/.app.js.swp":        "",
+        "web/node_modules/b/main.js": "",
+    })
+
+    infos, failed, err := client.Scan(src, client.NewIgnore("", []string{"node_modules/", "*.swp"}))
+    if err != nil || len(failed) != 0 {
+        t.Fatal(err, failed)
+    }
+    var files []string
+    for _, info := range infos {
+        if !info.IsDir {
+            files = append(files, info.FilePath)
+        }
+    }
+    sort.Strings(files)
+    expect := []string{".citronignore", "keep.o", "main.c", "web/.citronignore", "web/src/app.js"}
+    if !reflect.DeepEqual(files, expect) {
+        t.Fatalf("expect %v but get %v", expect, files)
+    }
+}
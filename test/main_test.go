@@ -0,0 +1,19 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "github.com/xfali/goutils/log"
+    "os"
+    "testing"
+)
+
+//日志级别只在启动测试前设置，测试中修改会与之前启动的服务的goroutine产生数据竞争
+func TestMain(m *testing.M) {
+    log.Level = log.WARN
+    os.Exit(m.Run())
+}
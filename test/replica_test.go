@@ -0,0 +1,207 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "citron-repo/client"
+    "citron-repo/model"
+    "citron-repo/replica"
+    "citron-repo/storage"
+    "context"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestReplicaJournal(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    j, err := replica.OpenJournal(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i := 0; i < 3; i++ {
+        j.Append("/agent/a.txt", "1")
+    }
+    if e, ok := j.After(1); !ok || e.Seq != 2 || j.Head() != 3 {
+        t.Fatalf("unexpected entry %+v %v", e, ok)
+    }
+    //全部删除后重写日志文件，重新打开时序号继续增长
+    j.Compact(3)
+    j.Close()
+    j, err = replica.OpenJournal(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := j.After(0); ok || j.Head() != 3 {
+        t.Fatalf("expect empty journal at 3 but get %d", j.Head())
+    }
+    if seq, _ := j.Append("/agent/b.txt", "2"); seq != 4 {
+        t.Fatalf("expect seq 4 but get %d", seq)
+    }
+
+    //未记录的变化，连续的Skip只增加一次序号
+    j.Skip()
+    j.Skip()
+    if _, ok := j.After(3); ok || j.Head() != 5 || j.Base() != 5 {
+        t.Fatalf("unexpected journal head %d base %d", j.Head(), j.Base())
+    }
+    j.Append("/agent/c.txt", "3")
+    j.Close()
+    j, err = replica.OpenJournal(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer j.Close()
+    if e, ok := j.After(0); !ok || e.Seq != 6 || j.Head() != 6 || j.Base() != 5 {
+        t.Fatalf("unexpected entry %+v %v base %d", e, ok, j.Base())
+    }
+
+    stop := make(chan struct{})
+    close(stop)
+    if _, ok := j.Next(6, stop); ok {
+        t.Fatal("expect stopped")
+    }
+}
+
+func waitReplica(t *testing.T, r *replica.Replicator, f func(status model.ReplicaStatus) bool) model.ReplicaStatus {
+    var status model.ReplicaStatus
+    for i := 0; i < 100; i++ {
+        status = r.Status()[0]
+        if f(status) {
+            return status
+        }
+        time.Sleep(50 * time.Millisecond)
+    }
+    t.Fatalf("unexpected status %+v", status)
+    return status
+}
+
+func TestReplica(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    primary, replicaDir := filepath.Join(dir, "primary"), filepath.Join(dir, "replica")
+    srv, _, addr, _ := startRepoServer(t, replicaDir)
+
+    s := storage.New(primary)
+    //添加副本之前保存的文件不复制
+    s.Put(model.FileInfo{FilePath: "/agent/old.txt"}, strings.NewReader("old"))
+    conf := model.ReplicationConfig{
+        Replicas:         []model.ReplicaConfig{{Name: "r1", Addr: addr, Username: "admin", Password: "123456"}},
+        MaxRetryInterval: model.Duration(time.Second),
+    }
+    r := replica.New(s)
+    if err := r.Update(conf); err != nil {
+        t.Fatal(err)
+    }
+    modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+    s.Put(model.FileInfo{FilePath: "/agent/a.txt", ModTime: modTime}, strings.NewReader("hello"))
+    s.Put(model.FileInfo{FilePath: "/agent/b/c.txt"}, strings.NewReader("citron"))
+    status := waitReplica(t, r, func(status model.ReplicaStatus) bool { return status.Acked == 2 })
+    if status.Pending != 0 || status.Files != 2 || status.Bytes != 11 || !status.Connected || status.LastError != "" {
+        t.Fatalf("unexpected status %+v", status)
+    }
+
+    c, err := client.Dial(addr)
+    if err != nil {
+        t.Fatal(err)
+    }
+    c.Login("admin", "123456")
+    buf := bytes.NewBuffer(nil)
+    info, err := c.Download(model.FileRequest{Path: "/agent/a.txt"}, buf)
+    c.Close()
+    if err != nil || buf.String() != "hello" || !info.ModTime.Equal(modTime) {
+        t.Fatalf("unexpected replica %s %+v %v", buf.String(), info, err)
+    }
+    ret, err := r.Verify("r1")
+    if err != nil || ret.Ok() || ret.Matched != 2 || len(ret.Missing) != 1 || ret.Missing[0] != "/agent/old.txt" {
+        t.Fatalf("unexpected verify %+v %v", ret, err)
+    }
+    if _, err := r.Verify("r2"); err != replica.ErrReplicaNotFound {
+        t.Fatalf("expect not found but get %v", err)
+    }
+
+    //副本不可用时记录保留在复制日志中，重启后从保存的位置继续
+    srv.Shutdown(context.Background())
+    r.Close()
+    r = replica.New(s)
+    defer r.Close()
+    if err := r.Update(conf); err != nil {
+        t.Fatal(err)
+    }
+    s.Put(model.FileInfo{FilePath: "/agent/a.txt"}, strings.NewReader("world"))
+    s.Put(model.FileInfo{FilePath: "/agent/old.txt"}, strings.NewReader("new"))
+    status = waitReplica(t, r, func(status model.ReplicaStatus) bool { return status.LastError != "" })
+    if status.Pending != 2 || status.Acked != 2 || status.Connected {
+        t.Fatalf("unexpected status %+v", status)
+    }
+
+    srv, _, addr, _ = startRepoServer(t, replicaDir)
+    defer srv.Shutdown(context.Background())
+    conf.Replicas[0].Addr = addr
+    r.Update(conf)
+    waitReplica(t, r, func(status model.ReplicaStatus) bool { return status.Pending == 0 && status.Acked == 4 })
+    ret, err = r.Verify("r1")
+    if err != nil || !ret.Ok() || ret.Files != 3 || ret.Matched != 3 {
+        t.Fatalf("unexpected verify %+v %v", ret, err)
+    }
+
+    //删除及从回收站恢复同步到副本
+    c, err = client.Dial(addr)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Close()
+    c.Login("admin", "123456")
+    if _, err := s.Delete("/agent/b"); err != nil {
+        t.Fatal(err)
+    }
+    waitReplica(t, r, func(status model.ReplicaStatus) bool { return status.Pending == 0 && status.Acked == 5 })
+    if _, err := c.Stat("/agent/b/c.txt"); err == nil {
+        t.Fatal("deleted file must be removed from replica")
+    }
+    if trash, err := c.Trash("/agent"); err != nil || len(trash) != 1 {
+        t.Fatalf("expect file in replica trash but get %v %v", trash, err)
+    }
+    ret, err = r.Verify("r1")
+    if err != nil || !ret.Ok() || ret.Files != 2 {
+        t.Fatalf("unexpected verify %+v %v", ret, err)
+    }
+
+    if _, err := s.Restore("/agent/b/c.txt"); err != nil {
+        t.Fatal(err)
+    }
+    waitReplica(t, r, func(status model.ReplicaStatus) bool { return status.Pending == 0 && status.Acked == 6 })
+    ret, err = r.Verify("r1")
+    if err != nil || !ret.Ok() || ret.Files != 3 || ret.Matched != 3 {
+        t.Fatalf("unexpected verify %+v %v", ret, err)
+    }
+
+    //副本中多余的文件
+    c.Upload(model.FileInfo{FilePath: "/agent/extra.txt"}, 5, strings.NewReader("extra"))
+    ret, err = r.Verify("r1")
+    if err != nil || ret.Ok() || len(ret.Extra) != 1 || ret.Extra[0] != "/agent/extra.txt" {
+        t.Fatalf("unexpected verify %+v %v", ret, err)
+    }
+
+    //删除副本期间的变化不在复制日志中，重新添加后推送所有文件
+    if err := r.Update(model.ReplicationConfig{}); err != nil {
+        t.Fatal(err)
+    }
+    s.Put(model.FileInfo{FilePath: "/agent/gap.txt"}, strings.NewReader("gap"))
+    if err := r.Update(conf); err != nil {
+        t.Fatal(err)
+    }
+    waitReplica(t, r, func(status model.ReplicaStatus) bool { return status.LastError == "" && status.Files == 4 })
+    if info, err := c.Stat("/agent/gap.txt"); err != nil || info.Size != 3 {
+        t.Fatalf("expect resynced file but get %+v %v", info, err)
+    }
+}
@@ -0,0 +1,253 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "citron-repo/client"
+    "citron-repo/config"
+    "citron-repo/handler"
+    "citron-repo/model"
+    "citron-repo/protocol"
+    "citron-repo/server"
+    "citron-repo/token"
+    "citron-repo/user"
+    "context"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "strings"
+    "testing"
+    "time"
+)
+
+//启动服务，返回REST地址、二进制协议地址及agent的登录token
+func startRepoServer(t *testing.T, dir string) (*server.Server, string, string, string) {
+    conf := config.Default()
+    conf.Username = "admin"
+    conf.Password = "123456"
+    conf.BackupDir = dir
+    conf.Http.Port = freePort()
+    conf.Binary.Port = freePort()
+
+    userMgr := user.New()
+    userMgr.Create(model.UserInfo{Username: "agent", Password: "agent123"})
+    api := handler.NewRestful(conf, handler.SetTokenMgr(token.New()), handler.SetUserMgr(userMgr))
+    s := server.New(conf, api)
+    if err := s.Start(); err != nil {
+        t.Fatal(err)
+    }
+    time.Sleep(100 * time.Millisecond)
+
+    url := fmt.Sprintf("http://127.0.0.1:%d", conf.Http.Port)
+    ret, err := client.NewRestClient(url).Login(model.LoginInfo{Username: "agent", Password: "agent123"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return s, url, fmt.Sprintf("127.0.0.1:%d", conf.Binary.Port), ret.Token
+}
+
+func testRepo(t *testing.T, repo client.Repo) {
+    data := "hello citron"
+    modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+    info, err := repo.Upload(model.FileInfo{FilePath: "etc/a.txt", ModTime: modTime}, int64(len(data)), strings.NewReader(data))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if info.FilePath != "/agent/etc/a.txt" || info.Size != int64(len(data)) || info.Checksum == "" {
+        t.Fatalf("unexpected info %v", info)
+    }
+
+    stat, err := repo.Stat("etc/a.txt")
+    if err != nil || stat.Checksum != info.Checksum || !stat.ModTime.Equal(modTime) {
+        t.Fatalf("unexpected stat %v %v", stat, err)
+    }
+
+    buf := bytes.NewBuffer(nil)
+    if _, err := repo.Download(model.FileRequest{Path: "/agent/etc/a.txt"}, buf); err != nil || buf.String() != data {
+        t.Fatalf("expect %s but get %s %v", data, buf.String(), err)
+    }
+
+    if _, err := repo.Upload(model.FileInfo{FilePath: "etc/a.txt"}, 3, strings.NewReader("new")); err != nil {
+        t.Fatal(err)
+    }
+    versions, err := repo.Versions("etc/a.txt")
+    if err != nil || len(versions) != 2 {
+        t.Fatalf("expect 2 versions but get %v %v", versions, err)
+    }
+    buf.Reset()
+    if _, err := repo.Download(model.FileRequest{Path: "etc/a.txt", Version: versions[1].Version}, buf); err != nil || buf.String() != data {
+        t.Fatalf("expect old version %s but get %s %v", data, buf.String(), err)
+    }
+
+    infos, err := repo.List("")
+    if err != nil || len(infos) != 1 || infos[0].FileName != "etc" || !infos[0].IsDir {
+        t.Fatalf("unexpected list %v %v", infos, err)
+    }
+
+    if _, err := repo.Stat("etc/none"); err == nil {
+        t.Fatal("expect not found")
+    }
+    if _, err := repo.List("/admin"); err == nil {
+        t.Fatal("expect permission denied")
+    }
+    //删除历史版本需要delete权限
+    if _, err := repo.Prune(model.PruneRequest{Path: "etc", Retention: model.Retention{Versions: 1}}); err == nil {
+        t.Fatal("expect permission denied")
+    }
+    if _, err := repo.Delete("etc/a.txt"); err == nil {
+        t.Fatal("expect permission denied")
+    }
+    if entries, err := repo.Trash(""); err != nil || len(entries) != 0 {
+        t.Fatalf("expect empty trash but get %v %v", entries, err)
+    }
+    //连接出错后仍然可用
+    if _, err := repo.Stat("etc/a.txt"); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestRepoClient(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    s, url, addr, agentToken := startRepoServer(t, dir)
+    defer s.Shutdown(context.Background())
+
+    t.Run("rest", func(t *testing.T) {
+        c := client.NewRestClient(url)
+        c.SetToken(agentToken)
+        testRepo(t, c)
+
+        _, err := c.Stat("/admin")
+        if e, ok := err.(*client.RestError); !ok || e.Status != http.StatusForbidden {
+            t.Fatalf("expect forbidden but get %v", err)
+        }
+    })
+
+    os.RemoveAll(dir + "/agent")
+    os.RemoveAll(dir + "/.citron")
+
+    t.Run("binary", func(t *testing.T) {
+        c, err := client.Dial(addr)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer c.Close()
+        if _, err := c.Stat("etc"); err == nil {
+            t.Fatal("expect auth required")
+        }
+        if err := c.LoginWithToken(agentToken); err != nil {
+            t.Fatal(err)
+        }
+        testRepo(t, c)
+
+        _, err = c.Stat("/.citron/meta")
+        expectStatus(t, err, protocol.StatusBadRequest)
+        _, err = c.Stat("/admin")
+        expectStatus(t, err, protocol.StatusPermissionDenied)
+    })
+
+    os.RemoveAll(dir + "/agent")
+    os.RemoveAll(dir + "/.citron")
+
+    t.Run("binary compressed", func(t *testing.T) {
+        c, err := client.Dial(addr)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer c.Close()
+        if err := c.Negotiate([]string{"lz4", "gzip"}); err != nil || c.Codec() != "gzip" {
+            t.Fatalf("expect gzip but get %s %v", c.Codec(), err)
+        }
+        if err := c.Negotiate(protocol.DefaultCodecs); err != nil || c.Codec() != "zstd" {
+            t.Fatalf("expect zstd but get %s %v", c.Codec(), err)
+        }
+        if err := c.LoginWithToken(agentToken); err != nil {
+            t.Fatal(err)
+        }
+        testRepo(t, c)
+
+        data := strings.Repeat("compressed citron frame\n", 20000)
+        if _, err := c.Upload(model.FileInfo{FilePath: "big.txt"}, int64(len(data)), strings.NewReader(data)); err != nil {
+            t.Fatal(err)
+        }
+        buf := bytes.NewBuffer(nil)
+        if _, err := c.Download(model.FileRequest{Path: "big.txt"}, buf); err != nil || buf.String() != data {
+            t.Fatalf("unexpected download %d %v", buf.Len(), err)
+        }
+    })
+}
+
+func testTrash(t *testing.T, repo client.Repo) {
+    for _, p := range []string{"docs/a.txt", "docs/sub/b.txt"} {
+        if _, err := repo.Upload(model.FileInfo{FilePath: p}, 6, strings.NewReader("citron")); err != nil {
+            t.Fatal(err)
+        }
+    }
+    ret, err := repo.Delete("docs")
+    if err != nil || ret.Files != 2 || ret.Bytes != 12 {
+        t.Fatalf("unexpected delete %+v %v", ret, err)
+    }
+    if _, err := repo.Stat("docs"); err == nil {
+        t.Fatal("expect not found")
+    }
+    entries, err := repo.Trash("")
+    if err != nil || len(entries) != 2 || entries[0].Path != "/admin/docs/a.txt" ||
+        !entries[0].PurgeTime.Equal(entries[0].DeleteTime.Add(config.DEFAULT_TRASH_RETAIN)) {
+        t.Fatalf("unexpected trash %+v %v", entries, err)
+    }
+    ret, err = repo.RestoreTrash("docs/sub")
+    if err != nil || ret.Files != 1 {
+        t.Fatalf("unexpected restore %+v %v", ret, err)
+    }
+    if _, err := repo.Stat("docs/sub/b.txt"); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := repo.RestoreTrash("none"); err == nil {
+        t.Fatal("expect not found")
+    }
+    ret, err = repo.PurgeTrash("docs")
+    if err != nil || ret.Files != 1 {
+        t.Fatalf("unexpected purge %+v %v", ret, err)
+    }
+    if entries, err := repo.Trash(""); err != nil || len(entries) != 0 {
+        t.Fatalf("expect empty trash but get %v %v", entries, err)
+    }
+    if _, err := repo.Delete("docs"); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestRepoTrash(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    s, url, addr, _ := startRepoServer(t, dir)
+    defer s.Shutdown(context.Background())
+
+    t.Run("rest", func(t *testing.T) {
+        c := client.NewRestClient(url)
+        ret, err := c.Login(model.LoginInfo{Username: "admin", Password: "123456"})
+        if err != nil {
+            t.Fatal(err)
+        }
+        c.SetToken(ret.Token)
+        testTrash(t, c)
+    })
+
+    t.Run("binary", func(t *testing.T) {
+        c, err := client.Dial(addr)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer c.Close()
+        if err := c.Login("admin", "123456"); err != nil {
+            t.Fatal(err)
+        }
+        testTrash(t, c)
+    })
+}
@@ -0,0 +1,257 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "citron-repo/errcode"
+    "citron-repo/handler"
+    "citron-repo/model"
+    "citron-repo/token"
+    "citron-repo/user"
+    "encoding/json"
+    "github.com/gin-gonic/gin"
+    webmodel "github.com/xfali/go-web-starter/web/model"
+    "io/ioutil"
+    "mime/multipart"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "testing"
+    "time"
+)
+
+func newTestRestful(tm *token.TokenMgr, userMgr *user.UserMgr, backupDir string) *gin.Engine {
+    gin.SetMode(gin.TestMode)
+    conf := model.Config{}
+    conf.Username = "admin"
+    conf.Password = "123456"
+    conf.BackupDir = backupDir
+
+    api := handler.NewRestful(conf, handler.SetTokenMgr(tm), handler.SetUserMgr(userMgr))
+    return newEngine(api)
+}
+
+func newEngine(api interface{ Api(engine *gin.Engine) }) *gin.Engine {
+    gin.SetMode(gin.TestMode)
+    engine := gin.New()
+    api.Api(engine)
+    return engine
+}
+
+func doRequest(engine *gin.Engine, req *http.Request) (int, webmodel.Result) {
+    w := httptest.NewRecorder()
+    engine.ServeHTTP(w, req)
+    ret := webmodel.Result{}
+    json.Unmarshal(w.Body.Bytes(), &ret)
+    return w.Code, ret
+}
+
+func loginResult(t *testing.T, engine *gin.Engine, info model.LoginInfo) model.LoginResult {
+    body, _ := json.Marshal(info)
+    req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body))
+    req.Header.Set("Content-Type", "application/json")
+    code, ret := doRequest(engine, req)
+    if code != http.StatusOK {
+        t.Fatalf("login failed: %d %v", code, ret)
+    }
+    result := model.LoginResult{}
+    data, _ := json.Marshal(ret.Data)
+    json.Unmarshal(data, &result)
+    return result
+}
+
+func login(t *testing.T, engine *gin.Engine, username, password string) string {
+    return loginResult(t, engine, model.LoginInfo{Username: username, Password: password}).Token
+}
+
+func createMeta(engine *gin.Engine, loginToken, filename string) (int, webmodel.Result) {
+    req := httptest.NewRequest(http.MethodPost, "/meta", nil)
+    req.Header.Set(handler.CITRON_TOKEN, loginToken)
+    req.Header.Set(handler.CITRON_FILENAME, filename)
+    return doRequest(engine, req)
+}
+
+func uploadRequest(loginToken, fileToken string, data []byte) *http.Request {
+    body := bytes.NewBuffer(nil)
+    w := multipart.NewWriter(body)
+    part, _ := w.CreateFormFile("file", "test.txt")
+    part.Write(data)
+    w.Close()
+
+    req := httptest.NewRequest(http.MethodPost, "/file", body)
+    req.Header.Set("Content-Type", w.FormDataContentType())
+    req.Header.Set(handler.CITRON_TOKEN, loginToken)
+    req.Header.Set(handler.CITRON_FILE_TOKEN, fileToken)
+    return req
+}
+
+func TestRestfulAuth(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    tm := token.New()
+    defer tm.Close()
+    userMgr := user.New()
+    engine := newTestRestful(tm, userMgr, dir)
+
+    t.Run("login", func(t *testing.T) {
+        loginToken := login(t, engine, "admin", "123456")
+        code, ret := createMeta(engine, loginToken, "test.txt")
+        if code != http.StatusOK {
+            t.Fatalf("expect ok but get %d %v", code, ret)
+        }
+        fileToken := ret.Data.(string)
+        code, ret = doRequest(engine, uploadRequest(loginToken, fileToken, []byte("hello")))
+        if code != http.StatusOK {
+            t.Fatalf("expect ok but get %d %v", code, ret)
+        }
+        //文件token上传成功后失效
+        code, ret = doRequest(engine, uploadRequest(loginToken, fileToken, []byte("world")))
+        if code != http.StatusUnauthorized || ret.Code != errcode.FileTokenError.Code {
+            t.Fatalf("expect file token error but get %d %v", code, ret)
+        }
+    })
+
+    t.Run("wrong password", func(t *testing.T) {
+        body, _ := json.Marshal(model.LoginInfo{Username: "admin", Password: "456"})
+        req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body))
+        req.Header.Set("Content-Type", "application/json")
+        code, ret := doRequest(engine, req)
+        if code != http.StatusUnauthorized || ret.Code != errcode.AuthError.Code {
+            t.Fatalf("expect auth error but get %d %v", code, ret)
+        }
+    })
+
+    t.Run("missing", func(t *testing.T) {
+        code, ret := createMeta(engine, "", "test.txt")
+        if code != http.StatusUnauthorized || ret.Code != errcode.AuthError.Code {
+            t.Fatalf("expect auth error but get %d %v", code, ret)
+        }
+    })
+
+    t.Run("unknown", func(t *testing.T) {
+        code, ret := createMeta(engine, "not-a-token", "test.txt")
+        if code != http.StatusUnauthorized || ret.Code != errcode.AuthError.Code {
+            t.Fatalf("expect auth error but get %d %v", code, ret)
+        }
+    })
+
+    t.Run("expired", func(t *testing.T) {
+        loginToken, _ := tm.CreateToken(token.Claims{Type: token.LoginToken, User: "admin"}, 10*time.Millisecond)
+        time.Sleep(50 * time.Millisecond)
+        code, ret := createMeta(engine, loginToken, "test.txt")
+        if code != http.StatusUnauthorized || ret.Code != errcode.AuthError.Code {
+            t.Fatalf("expect auth error but get %d %v", code, ret)
+        }
+    })
+
+    t.Run("file token as login token", func(t *testing.T) {
+        loginToken := login(t, engine, "admin", "123456")
+        _, ret := createMeta(engine, loginToken, "test.txt")
+        code, ret := createMeta(engine, ret.Data.(string), "test.txt")
+        if code != http.StatusUnauthorized || ret.Code != errcode.AuthError.Code {
+            t.Fatalf("expect auth error but get %d %v", code, ret)
+        }
+    })
+
+    t.Run("cross user", func(t *testing.T) {
+        adminToken := login(t, engine, "admin", "123456")
+        _, ret := createMeta(engine, adminToken, "test.txt")
+        fileToken := ret.Data.(string)
+
+        userMgr.Create(model.UserInfo{Username: "other", Password: "other123"})
+        otherToken := login(t, engine, "other", "other123")
+        code, ret := doRequest(engine, uploadRequest(otherToken, fileToken, []byte("hello")))
+        if code != http.StatusUnauthorized || ret.Code != errcode.AuthError.Code {
+            t.Fatalf("expect auth error but get %d %v", code, ret)
+        }
+    })
+}
+
+func TestRestfulSession(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    tm := token.New()
+    defer tm.Close()
+    userMgr := user.New()
+    engine := newTestRestful(tm, userMgr, dir)
+    userMgr.Create(model.UserInfo{Username: "agent", Password: "agent123"})
+
+    refresh := func(refreshToken string) (int, model.LoginResult) {
+        code, ret := doRequest(engine, jsonRequest(http.MethodPost, "/token/refresh", "", model.RefreshInfo{RefreshToken: refreshToken}))
+        result := model.LoginResult{}
+        data, _ := json.Marshal(ret.Data)
+        json.Unmarshal(data, &result)
+        return code, result
+    }
+
+    t.Run("refresh", func(t *testing.T) {
+        result := loginResult(t, engine, model.LoginInfo{Username: "agent", Password: "agent123"})
+        if result.RefreshToken == "" {
+            t.Fatal("expect refresh token")
+        }
+        //刷新token不能作为登录token使用
+        if code, _ := createMeta(engine, result.RefreshToken, "test.txt"); code != http.StatusUnauthorized {
+            t.Fatalf("expect unauthorized but get %d", code)
+        }
+
+        code, newResult := refresh(result.RefreshToken)
+        if code != http.StatusOK || newResult.Token == "" {
+            t.Fatalf("refresh failed: %d", code)
+        }
+        if code, _ := createMeta(engine, newResult.Token, "test.txt"); code != http.StatusOK {
+            t.Fatalf("expect ok but get %d", code)
+        }
+        //刷新token只能使用一次
+        if code, _ := refresh(result.RefreshToken); code != http.StatusUnauthorized {
+            t.Fatalf("expect unauthorized but get %d", code)
+        }
+        //登录token不能用于刷新
+        if code, _ := refresh(newResult.Token); code != http.StatusUnauthorized {
+            t.Fatalf("expect unauthorized but get %d", code)
+        }
+    })
+
+    t.Run("logout", func(t *testing.T) {
+        result := loginResult(t, engine, model.LoginInfo{Username: "agent", Password: "agent123"})
+        req := jsonRequest(http.MethodPost, "/logout", result.Token, nil)
+        req.Header.Set(handler.CITRON_REFRESH_TOKEN, result.RefreshToken)
+        if code, ret := doRequest(engine, req); code != http.StatusOK {
+            t.Fatalf("logout failed: %d %v", code, ret)
+        }
+        if code, _ := createMeta(engine, result.Token, "test.txt"); code != http.StatusUnauthorized {
+            t.Fatalf("expect unauthorized but get %d", code)
+        }
+        if code, _ := refresh(result.RefreshToken); code != http.StatusUnauthorized {
+            t.Fatalf("expect unauthorized but get %d", code)
+        }
+    })
+
+    t.Run("revoke", func(t *testing.T) {
+        adminToken := login(t, engine, "admin", "123456")
+        result := loginResult(t, engine, model.LoginInfo{Username: "agent", Password: "agent123"})
+
+        code, ret := doRequest(engine, jsonRequest(http.MethodGet, "/admin/user/agent/token", adminToken, nil))
+        if code != http.StatusOK || len(ret.Data.([]interface{})) == 0 {
+            t.Fatalf("list token failed: %d %v", code, ret)
+        }
+
+        code, ret = doRequest(engine, jsonRequest(http.MethodDelete, "/admin/user/agent/token", adminToken, nil))
+        if code != http.StatusOK {
+            t.Fatalf("revoke failed: %d %v", code, ret)
+        }
+        if code, _ := createMeta(engine, result.Token, "test.txt"); code != http.StatusUnauthorized {
+            t.Fatalf("expect unauthorized but get %d", code)
+        }
+        if code, _ := refresh(result.RefreshToken); code != http.StatusUnauthorized {
+            t.Fatalf("expect unauthorized but get %d", code)
+        }
+        if len(tm.Sessions("agent")) != 0 {
+            t.Fatal("all tokens must be revoked")
+        }
+    })
+}
@@ -0,0 +1,183 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "citron-repo/client"
+    "citron-repo/model"
+    "context"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func readFile(t *testing.T, p string) string {
+    data, err := ioutil.ReadFile(p)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return string(data)
+}
+
+func testRestore(t *testing.T, repo client.Repo, dest string, connect func() (client.Repo, error)) {
+    src, _ := ioutil.TempDir("", "citron-src")
+    defer os.RemoveAll(src)
+    dst, _ := ioutil.TempDir("", "citron-dst")
+    defer os.RemoveAll(dst)
+
+    writeFiles(t, src, map[string]string{
+        "a.txt":         "hello",
+        "bin/run.sh":    "#!/bin/sh",
+        "sub/b.txt":     "citron",
+        "sub/deep/c.md": "restore",
+    })
+    modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+    os.Chtimes(filepath.Join(src, "a.txt"), modTime, modTime)
+    os.Chmod(filepath.Join(src, "bin/run.sh"), 0755)
+    s, err := client.NewBackup(repo, client.SetSnapshot("host")).Dir(src, dest)
+    if err != nil || s.Failed > 0 {
+        t.Fatalf("backup failed %+v %v", s, err)
+    }
+    snapshot := s.Snapshot.ID
+
+    opts := []client.RestoreOpt{client.SetParallel(3, connect), client.SetVerify(true)}
+    restore := func(target string, opts ...client.RestoreOpt) client.RestoreSummary {
+        ret, err := client.NewRestore(repo, opts...).Dir(dest, target)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return ret
+    }
+
+    t.Run("dir", func(t *testing.T) {
+        target := filepath.Join(dst, "all")
+        ret := restore(target, opts...)
+        if ret.Restored != 4 || ret.Failed != 0 || ret.Bytes != 27 {
+            t.Fatalf("unexpected summary %+v", ret)
+        }
+        if readFile(t, filepath.Join(target, "sub/deep/c.md")) != "restore" {
+            t.Fatal("unexpected content")
+        }
+        fi, err := os.Stat(filepath.Join(target, "a.txt"))
+        if err != nil || !fi.ModTime().Equal(modTime) {
+            t.Fatalf("expect modTime %v but get %v %v", modTime, fi, err)
+        }
+        if fi, err := os.Stat(filepath.Join(target, "bin/run.sh")); err != nil || fi.Mode().Perm() != 0755 {
+            t.Fatalf("expect mode 0755 but get %v %v", fi, err)
+        }
+        //已恢复的文件不重复下载
+        if ret := restore(target, opts...); ret.Skipped != 4 || ret.Bytes != 0 {
+            t.Fatalf("expect all skipped but get %+v", ret)
+        }
+    })
+
+    t.Run("overwrite", func(t *testing.T) {
+        target := filepath.Join(dst, "overwrite")
+        writeFiles(t, target, map[string]string{"a.txt": "local"})
+        restore(target, append(opts, client.SetOverwrite(client.OVERWRITE_SKIP))...)
+        if readFile(t, filepath.Join(target, "a.txt")) != "local" {
+            t.Fatal("local file must be kept")
+        }
+        ret := restore(target, append(opts, client.SetOverwrite(client.OVERWRITE_RENAME))...)
+        if ret.Renamed != 1 || readFile(t, filepath.Join(target, "a.txt")) != "local" ||
+            readFile(t, filepath.Join(target, "a.restored.txt")) != "hello" {
+            t.Fatalf("expect renamed file but get %+v", ret)
+        }
+        restore(target, opts...)
+        if readFile(t, filepath.Join(target, "a.txt")) != "hello" {
+            t.Fatal("local file must be overwritten")
+        }
+        if _, err := client.NewRestore(repo, client.SetOverwrite("none")).Dir(dest, target); err != client.ErrOverwritePolicy {
+            t.Fatalf("expect policy error but get %v", err)
+        }
+    })
+
+    t.Run("include", func(t *testing.T) {
+        target := filepath.Join(dst, "include")
+        ret := restore(target, append(opts, client.SetInclude([]string{"/sub/", "!*.md", "*.sh"}))...)
+        if ret.Restored != 2 {
+            t.Fatalf("unexpected summary %+v", ret)
+        }
+        for name, exists := range map[string]bool{"sub/b.txt": true, "bin/run.sh": true, "a.txt": false, "sub/deep/c.md": false} {
+            if _, err := os.Stat(filepath.Join(target, name)); (err == nil) != exists {
+                t.Fatalf("%s expect exists %v but get %v", name, exists, err)
+            }
+        }
+    })
+
+    t.Run("file", func(t *testing.T) {
+        repo.Upload(model.FileInfo{FilePath: dest+"/a.txt"}, 3, strings.NewReader("new"))
+        versions, _ := repo.Versions(dest+"/a.txt")
+        if len(versions) != 2 {
+            t.Fatalf("expect 2 versions but get %v", versions)
+        }
+        target := filepath.Join(dst, "file.txt")
+        if _, err := client.NewRestore(repo).File(model.FileRequest{Path: dest+"/a.txt", Version: versions[1].Version}, target); err != nil {
+            t.Fatal(err)
+        }
+        if readFile(t, target) != "hello" {
+            t.Fatal("expect old version")
+        }
+        if _, err := client.NewRestore(repo).File(model.FileRequest{Path: dest+"/a.txt", Version: "1"}, target); err != client.ErrVersionNotFound {
+            t.Fatalf("expect version not found but get %v", err)
+        }
+    })
+
+    t.Run("snapshot", func(t *testing.T) {
+        target := filepath.Join(dst, "snapshot")
+        ret, err := client.NewRestore(repo, opts...).Snapshot(snapshot, target)
+        if err != nil || ret.Restored != 4 {
+            t.Fatalf("unexpected summary %+v %v", ret, err)
+        }
+        if readFile(t, filepath.Join(target, "a.txt")) != "hello" {
+            t.Fatal("expect content of the snapshot")
+        }
+    })
+}
+
+func TestRestore(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    s, url, binaryAddr, agentToken := startRepoServer(t, dir)
+    defer s.Shutdown(context.Background())
+
+    t.Run("rest", func(t *testing.T) {
+        c := client.NewRestClient(url)
+        c.SetToken(agentToken)
+        testRestore(t, c, "rest", nil)
+
+        //仓库中的空目录
+        os.MkdirAll(filepath.Join(dir, "agent", "rest", "empty"), 0755)
+        target, _ := ioutil.TempDir("", "citron-dst")
+        defer os.RemoveAll(target)
+        ret, err := client.NewRestore(c).Dir("rest", target)
+        if err != nil || ret.Dirs != 4 {
+            t.Fatalf("unexpected summary %+v %v", ret, err)
+        }
+        if fi, err := os.Stat(filepath.Join(target, "empty")); err != nil || !fi.IsDir() {
+            t.Fatalf("expect empty dir but get %v", err)
+        }
+    })
+    t.Run("binary", func(t *testing.T) {
+        connect := func() (client.Repo, error) {
+            c, err := client.Dial(binaryAddr)
+            if err != nil {
+                return nil, err
+            }
+            return c, c.LoginWithToken(agentToken)
+        }
+        c, err := connect()
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer c.Close()
+        testRestore(t, c, "binary", connect)
+    })
+}
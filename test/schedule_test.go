@@ -0,0 +1,199 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "citron-repo/client"
+    "citron-repo/model"
+    "citron-repo/schedule"
+    "citron-repo/schedule/cron"
+    "context"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestCronNext(t *testing.T) {
+    base := time.Date(2019, 3, 15, 10, 30, 20, 0, time.UTC)
+    cases := []struct {
+        expr string
+        next time.Time
+    }{
+        {"* * * * *", time.Date(2019, 3, 15, 10, 31, 0, 0, time.UTC)},
+        {"*/15 * * * *", time.Date(2019, 3, 15, 10, 45, 0, 0, time.UTC)},
+        {"0 2 * * *", time.Date(2019, 3, 16, 2, 0, 0, 0, time.UTC)},
+        {"30 9-17/4 * * mon-fri", time.Date(2019, 3, 15, 13, 30, 0, 0, time.UTC)},
+        {"0 0 * * sun", time.Date(2019, 3, 17, 0, 0, 0, 0, time.UTC)},
+        {"0 0 * * 7", time.Date(2019, 3, 17, 0, 0, 0, 0, time.UTC)},
+        {"0 0 1,15 * *", time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)},
+        //日和周同时指定时满足其一即可
+        {"0 0 1 * fri", time.Date(2019, 3, 22, 0, 0, 0, 0, time.UTC)},
+        {"0 0 29 feb *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+        {"@monthly", time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)},
+        {"@every 90m", time.Date(2019, 3, 15, 12, 0, 20, 0, time.UTC)},
+        {"0 0 30 2 *", time.Time{}},
+    }
+    for _, c := range cases {
+        s, err := cron.Parse(c.expr)
+        if err != nil {
+            t.Fatalf("%s: %v", c.expr, err)
+        }
+        if next := s.Next(base); !next.Equal(c.next) {
+            t.Fatalf("%s expect %v but get %v", c.expr, c.next, next)
+        }
+    }
+
+    for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "@every 1ms", "0 0 * * foo"} {
+        if _, err := cron.Parse(expr); err == nil {
+            t.Fatalf("%s expect error", expr)
+        }
+    }
+}
+
+func TestScheduler(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    src, _ := ioutil.TempDir("", "citron-src")
+    defer os.RemoveAll(src)
+    s, url, _, _ := startRepoServer(t, dir)
+    defer s.Shutdown(context.Background())
+
+    admin := client.NewRestClient(url)
+    ret, err := admin.Login(model.LoginInfo{Username: "admin", Password: "123456"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    admin.SetToken(ret.Token)
+    writeFiles(t, src, map[string]string{"a.txt": "v1", "a.tmp": "tmp"})
+
+    //执行中的任务阻塞在connect
+    block := make(chan struct{})
+    blocked := make(chan struct{}, 1)
+    connect := func() (client.Repo, error) {
+        select {
+        case blocked <- struct{}{}:
+            <-block
+        default:
+        }
+        return admin, nil
+    }
+    history, _ := schedule.LoadHistory(filepath.Join(dir, "history.json"))
+    sch, err := schedule.New([]schedule.Job{{
+        Name:      "src",
+        Dir:       src,
+        Dest:      "/admin/src",
+        Schedule:  "@every 1h",
+        Retention: model.Retention{Versions: 2},
+        Exclude:   []string{"*.tmp"},
+    }}, connect, history)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    t.Run("overlap", func(t *testing.T) {
+        done := make(chan schedule.Run)
+        go func() {
+            run, _ := sch.RunJob("src")
+            done <- run
+        }()
+        <-blocked
+        if run, err := sch.RunJob("src"); err != schedule.ErrJobRunning || run.Status != schedule.StatusSkipped {
+            t.Fatalf("expect skipped but get %+v %v", run, err)
+        }
+        if last, _ := history.Last("src"); last.Status != schedule.StatusSkipped {
+            t.Fatalf("expect skipped in history but get %+v", last)
+        }
+        close(block)
+        if run := <-done; run.Status != schedule.StatusOk || run.Added != 1 {
+            t.Fatalf("unexpected run %+v", run)
+        }
+    })
+
+    t.Run("retention", func(t *testing.T) {
+        for _, data := range []string{"v2", "v3", "v4"} {
+            writeFiles(t, src, map[string]string{"a.txt": data})
+            later := time.Now().Add(time.Minute)
+            os.Chtimes(filepath.Join(src, "a.txt"), later, later)
+            if _, err := sch.RunJob("src"); err != nil {
+                t.Fatal(err)
+            }
+        }
+        versions, err := admin.Versions("/admin/src/a.txt")
+        if err != nil || len(versions) != 2 {
+            t.Fatalf("expect 2 versions but get %v %v", versions, err)
+        }
+        if _, err := admin.Stat("/admin/src/a.tmp"); !client.IsNotFound(err) {
+            t.Fatalf("excluded file must not be uploaded, get %v", err)
+        }
+        last, _ := history.Last("src")
+        if last.Status != schedule.StatusOk || last.Changed != 1 || last.Pruned != 1 {
+            t.Fatalf("unexpected run %+v", last)
+        }
+    })
+
+    t.Run("history", func(t *testing.T) {
+        loaded, err := schedule.LoadHistory(filepath.Join(dir, "history.json"))
+        if err != nil || len(loaded.Get("src")) != 5 {
+            t.Fatalf("expect 5 runs but get %v %v", loaded.Get("src"), err)
+        }
+    })
+
+    t.Run("process", func(t *testing.T) {
+        //另一个进程（如schedule -run）使用独立的执行历史
+        other, _ := schedule.LoadHistory(filepath.Join(dir, "history.json"))
+        sch2, _ := schedule.New([]schedule.Job{{Name: "src", Dir: src, Dest: "/admin/src", Schedule: "@every 1h"}}, func() (client.Repo, error) {
+            return admin, nil
+        }, other)
+
+        //之前的执行已写入blocked
+        select {
+        case <-blocked:
+        default:
+        }
+        block = make(chan struct{})
+        done := make(chan schedule.Run)
+        go func() {
+            run, _ := sch.RunJob("src")
+            done <- run
+        }()
+        <-blocked
+        if run, err := sch2.RunJob("src"); err != schedule.ErrJobRunning || run.Status != schedule.StatusSkipped {
+            t.Fatalf("expect skipped but get %+v %v", run, err)
+        }
+        close(block)
+        if run := <-done; run.Status != schedule.StatusOk {
+            t.Fatalf("unexpected run %+v", run)
+        }
+
+        //两个进程的记录都被保存
+        loaded, err := schedule.LoadHistory(filepath.Join(dir, "history.json"))
+        runs := loaded.Get("src")
+        if err != nil || len(runs) != 7 || runs[5].Status != schedule.StatusOk || runs[6].Status != schedule.StatusSkipped {
+            t.Fatalf("unexpected runs %+v %v", runs, err)
+        }
+    })
+
+    t.Run("run", func(t *testing.T) {
+        s, err := schedule.New([]schedule.Job{{Name: "fast", Dir: src, Dest: "/admin/fast", Schedule: "@every 1s"}}, func() (client.Repo, error) {
+            return admin, nil
+        }, history)
+        if err != nil {
+            t.Fatal(err)
+        }
+        stop := make(chan struct{})
+        go func() {
+            time.Sleep(1500 * time.Millisecond)
+            close(stop)
+        }()
+        s.Run(stop)
+        if last, ok := history.Last("fast"); !ok || last.Status != schedule.StatusOk {
+            t.Fatalf("expect scheduled run but get %+v", last)
+        }
+    })
+}
@@ -0,0 +1,164 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "citron-repo/client"
+    "citron-repo/config"
+    "citron-repo/handler"
+    "citron-repo/model"
+    "citron-repo/protocol"
+    "citron-repo/server"
+    "citron-repo/token"
+    "citron-repo/user"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "os"
+    "testing"
+    "time"
+)
+
+func freePort() int {
+    l, _ := net.Listen("tcp", "127.0.0.1:0")
+    defer l.Close()
+    return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestServerRun(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+
+    conf := config.Default()
+    conf.Username = "admin"
+    conf.Password = "123456"
+    conf.BackupDir = dir
+    conf.Http.Port = freePort()
+    conf.Binary.Port = freePort()
+    conf.Limit.MaxConnections = 1
+
+    tm := token.New()
+    defer tm.Close()
+    api := handler.NewRestful(conf, handler.SetTokenMgr(tm), handler.SetUserMgr(user.New()))
+    s := server.New(conf, api)
+    if err := s.Start(); err != nil {
+        t.Fatal(err)
+    }
+    time.Sleep(100 * time.Millisecond)
+
+    body, _ := json.Marshal(model.LoginInfo{Username: "admin", Password: "123456"})
+    resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%d/login", conf.Http.Port), "application/json", bytes.NewReader(body))
+    if err != nil {
+        t.Fatal(err)
+    }
+    ret := struct {
+        Data model.LoginResult `json:"data"`
+    }{}
+    json.NewDecoder(resp.Body).Decode(&ret)
+    resp.Body.Close()
+    if ret.Data.Token == "" {
+        t.Fatal("http login failed")
+    }
+
+    //二进制协议与REST共用认证
+    addr := fmt.Sprintf("127.0.0.1:%d", conf.Binary.Port)
+    c := client.NewBinaryClient(addr)
+    _, err = echo(t, c, "before auth")
+    expectStatus(t, err, protocol.StatusAuthRequired)
+    if err := c.LoginWithToken(ret.Data.Token); err != nil {
+        t.Fatal(err)
+    }
+    if msg, err := echo(t, c, "hello"); err != nil || msg != "hello" {
+        t.Fatalf("expect echo but get %s %v", msg, err)
+    }
+
+    t.Run("max connections", func(t *testing.T) {
+        conn, err := net.Dial("tcp", addr)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer conn.Close()
+        conn.SetReadDeadline(time.Now().Add(time.Second))
+        if _, err := conn.Read(make([]byte, 1)); err == nil {
+            t.Fatal("expect connection closed")
+        }
+    })
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := s.Shutdown(ctx); err != nil {
+        t.Fatal(err)
+    }
+    c.Close()
+
+    for _, port := range []int{conf.Http.Port, conf.Binary.Port} {
+        if conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port)); err == nil {
+            conn.Close()
+            t.Fatalf("port %d still open after shutdown", port)
+        }
+    }
+    select {
+    case err := <-s.Err():
+        t.Fatalf("unexpected error %v", err)
+    default:
+    }
+}
+
+//每次读取前等待，模拟慢速上传
+type slowReader struct {
+    data  []byte
+    delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+    if len(r.data) == 0 {
+        return 0, io.EOF
+    }
+    time.Sleep(r.delay)
+    n := copy(p[:1], r.data)
+    r.data = r.data[n:]
+    return n, nil
+}
+
+func TestServerSlowUpload(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+
+    conf := config.Default()
+    conf.Username = "admin"
+    conf.Password = "123456"
+    conf.BackupDir = dir
+    conf.Http.Port = freePort()
+    conf.Binary.Port = freePort()
+    conf.Http.ReadHeaderTimeout = model.Duration(100 * time.Millisecond)
+
+    api := handler.NewRestful(conf, handler.SetTokenMgr(token.New()), handler.SetUserMgr(user.New()))
+    s := server.New(conf, api)
+    if err := s.Start(); err != nil {
+        t.Fatal(err)
+    }
+    defer s.Shutdown(context.Background())
+    time.Sleep(100 * time.Millisecond)
+
+    c := client.NewRestClient(fmt.Sprintf("http://127.0.0.1:%d", conf.Http.Port))
+    ret, err := c.Login(model.LoginInfo{Username: "admin", Password: "123456"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    c.SetToken(ret.Token)
+    //上传时间超过读取请求头的超时
+    data := "slow upload"
+    info, err := c.Upload(model.FileInfo{FilePath: "slow.txt"}, int64(len(data)), &slowReader{data: []byte(data), delay: 50 * time.Millisecond})
+    if err != nil || info.Size != int64(len(data)) {
+        t.Fatalf("slow upload failed: %+v %v", info, err)
+    }
+}
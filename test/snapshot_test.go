@@ -0,0 +1,169 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "citron-repo/client"
+    "citron-repo/model"
+    "citron-repo/storage"
+    "context"
+    "io/ioutil"
+    "os"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestStorageSnapshot(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    s := storage.New(dir)
+
+    v1, _ := s.Put(model.FileInfo{FilePath: "/agent/a.txt"}, strings.NewReader("v1"))
+    snap, err := s.BeginSnapshot(model.Snapshot{Path: "/agent", From: "host"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := s.AddSnapshot(snap.ID, []model.FileRequest{{Path: "/other/a.txt"}}); err != storage.ErrInvalidPath {
+        t.Fatalf("expect invalid path but get %v", err)
+    }
+    if _, err := s.AddSnapshot(snap.ID, []model.FileRequest{{Path: "/agent/a.txt", Version: v1.Version}}); err != nil {
+        t.Fatal(err)
+    }
+    if snap, err = s.CommitSnapshot(snap.ID); err != nil || snap.Files != 1 || snap.State != model.SnapshotCommitted {
+        t.Fatalf("unexpected snapshot %+v %v", snap, err)
+    }
+    if _, err := s.AddSnapshot(snap.ID, nil); err != storage.ErrSnapshotCommitted {
+        t.Fatalf("expect committed but get %v", err)
+    }
+
+    //快照引用的版本不会被删除
+    s.Put(model.FileInfo{FilePath: "/agent/a.txt"}, strings.NewReader("v2"))
+    s.Put(model.FileInfo{FilePath: "/agent/a.txt"}, strings.NewReader("v3"))
+    ret, err := s.Prune("/agent", model.Retention{Versions: 1}, time.Now())
+    if err != nil || ret.Versions != 1 {
+        t.Fatalf("unexpected prune result %+v %v", ret, err)
+    }
+    if readAll(t, s, "/agent/a.txt", v1.Version) != "v1" {
+        t.Fatal("version in snapshot must be kept")
+    }
+
+    //上级目录的快照不在子目录中列出
+    root, _ := s.BeginSnapshot(model.Snapshot{Path: "/", From: "admin"})
+    if snaps, err := s.Snapshots("/agent"); err != nil || len(snaps) != 1 || snaps[0].ID != snap.ID {
+        t.Fatalf("unexpected snapshots %+v %v", snaps, err)
+    }
+    if snaps, err := s.Snapshots("/"); err != nil || len(snaps) != 2 {
+        t.Fatalf("unexpected snapshots %+v %v", snaps, err)
+    }
+    s.DeleteSnapshot(root.ID)
+
+    if err := s.DeleteSnapshot(snap.ID); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := s.Snapshot(snap.ID); err != storage.ErrSnapshotNotFound {
+        t.Fatalf("expect not found but get %v", err)
+    }
+    if _, err := s.Snapshot("../../a"); err != storage.ErrSnapshotNotFound {
+        t.Fatalf("expect not found but get %v", err)
+    }
+}
+
+func testSnapshot(t *testing.T, repo client.Repo, src string) {
+    writeFiles(t, src, map[string]string{"a.txt": "v1", "sub/b.txt": "b"})
+    backup := func(tag string) model.Snapshot {
+        s, err := client.NewBackup(repo, client.SetSnapshot("host", tag)).Dir(src, "src")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if s.Snapshot.State != model.SnapshotCommitted || s.Snapshot.Files != 2 {
+            t.Fatalf("unexpected snapshot %+v", s.Snapshot)
+        }
+        return s.Snapshot
+    }
+
+    first := backup("first")
+    writeFiles(t, src, map[string]string{"a.txt": "v2"})
+    later := time.Now().Add(time.Minute)
+    os.Chtimes(src+"/a.txt", later, later)
+    second := backup("second")
+
+    snaps, err := repo.Snapshots("src")
+    if err != nil || len(snaps) != 2 || snaps[0].ID != first.ID || snaps[1].Tags[0] != "second" || len(snaps[0].Entries) != 0 {
+        t.Fatalf("unexpected snapshots %+v %v", snaps, err)
+    }
+    snap, err := repo.Snapshot(first.ID)
+    if err != nil || len(snap.Entries) != 2 || snap.From != "host" || snap.Entries[0].FilePath != "/agent/src/a.txt" {
+        t.Fatalf("unexpected snapshot %+v %v", snap, err)
+    }
+
+    buf := bytes.NewBuffer(nil)
+    download := func(p string) string {
+        buf.Reset()
+        if _, err := repo.Download(model.FileRequest{Path: p}, buf); err != nil {
+            t.Fatal(err)
+        }
+        return buf.String()
+    }
+    ret, err := repo.RestoreSnapshot(first.ID, "restored")
+    if err != nil || ret.Files != 2 {
+        t.Fatalf("unexpected restore result %+v %v", ret, err)
+    }
+    if download("restored/a.txt") != "v1" || download("restored/sub/b.txt") != "b" {
+        t.Fatal("restored content mismatch")
+    }
+    //恢复到原路径生成新版本
+    if _, err := repo.RestoreSnapshot(first.ID, ""); err != nil {
+        t.Fatal(err)
+    }
+    if download("src/a.txt") != "v1" {
+        t.Fatal("restored content mismatch")
+    }
+    if _, err := repo.CommitSnapshot(second.ID); err == nil {
+        t.Fatal("expect committed error")
+    }
+    //删除快照需要delete权限
+    if err := repo.DeleteSnapshot(second.ID); err == nil {
+        t.Fatal("expect permission denied")
+    }
+    if _, err := repo.Snapshot("1"); !client.IsNotFound(err) {
+        t.Fatalf("expect not found but get %v", err)
+    }
+}
+
+func TestSnapshot(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    s, url, addr, agentToken := startRepoServer(t, dir)
+    defer s.Shutdown(context.Background())
+
+    t.Run("rest", func(t *testing.T) {
+        src, _ := ioutil.TempDir("", "citron-src")
+        defer os.RemoveAll(src)
+        c := client.NewRestClient(url)
+        c.SetToken(agentToken)
+        testSnapshot(t, c, src)
+    })
+
+    os.RemoveAll(dir + "/agent")
+    os.RemoveAll(dir + "/.citron")
+
+    t.Run("binary", func(t *testing.T) {
+        src, _ := ioutil.TempDir("", "citron-src")
+        defer os.RemoveAll(src)
+        c, err := client.Dial(addr)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer c.Close()
+        if err := c.LoginWithToken(agentToken); err != nil {
+            t.Fatal(err)
+        }
+        testSnapshot(t, c, src)
+    })
+}
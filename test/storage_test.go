@@ -0,0 +1,512 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "citron-repo/model"
+    "crypto/rand"
+    "fmt"
+    "citron-repo/storage"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func readAll(t *testing.T, s *storage.Storage, p, version string) string {
+    r, _, err := s.Open(p, version)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer r.Close()
+    data, _ := ioutil.ReadAll(r)
+    return string(data)
+}
+
+func TestStorage(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    s := storage.New(dir)
+
+    modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+    v1, err := s.Put(model.FileInfo{FilePath: "/agent/etc/a.txt", ModTime: modTime}, strings.NewReader("hello"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if v1.FileName != "a.txt" || v1.Parent != "/agent/etc" || v1.Size != 5 || v1.ChecksumType != storage.CHECKSUM_SHA256 || !v1.ModTime.Equal(modTime) {
+        t.Fatalf("unexpected info %v", v1)
+    }
+    if data, _ := ioutil.ReadFile(filepath.Join(dir, "agent", "etc", "a.txt")); string(data) != "hello" {
+        t.Fatalf("expect latest content in backup dir but get %s", data)
+    }
+
+    t.Run("same content", func(t *testing.T) {
+        info, err := s.Put(model.FileInfo{FilePath: "/agent/etc/a.txt"}, strings.NewReader("hello"))
+        if err != nil || info.Version != v1.Version {
+            t.Fatalf("expect no new version but get %v %v", info, err)
+        }
+    })
+
+    t.Run("versions", func(t *testing.T) {
+        v2, err := s.Put(model.FileInfo{FilePath: "/agent/etc/a.txt"}, strings.NewReader("world!"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        versions, err := s.Versions("/agent/etc/a.txt")
+        if err != nil || len(versions) != 2 || versions[0].Version != v2.Version || versions[1].Version != v1.Version {
+            t.Fatalf("unexpected versions %v %v", versions, err)
+        }
+        if readAll(t, s, "/agent/etc/a.txt", "") != "world!" || readAll(t, s, "/agent/etc/a.txt", v1.Version) != "hello" {
+            t.Fatal("unexpected content")
+        }
+        if _, _, err := s.Open("/agent/etc/a.txt", "1"); err != storage.ErrNotFound {
+            t.Fatalf("expect not found but get %v", err)
+        }
+    })
+
+    t.Run("checksum", func(t *testing.T) {
+        _, err := s.Put(model.FileInfo{FilePath: "/agent/b.txt", Checksum: "00"}, strings.NewReader("data"))
+        if err != storage.ErrChecksum {
+            t.Fatalf("expect checksum error but get %v", err)
+        }
+        if _, err := s.Stat("/agent/b.txt"); err != storage.ErrNotFound {
+            t.Fatalf("expect not found but get %v", err)
+        }
+    })
+
+    t.Run("list", func(t *testing.T) {
+        infos, err := s.List("/agent")
+        if err != nil || len(infos) != 1 || !infos[0].IsDir || infos[0].FilePath != "/agent/etc" {
+            t.Fatalf("unexpected list %v %v", infos, err)
+        }
+        root, err := s.List("/")
+        if err != nil || len(root) != 1 {
+            t.Fatalf("internal dir must be hidden: %v %v", root, err)
+        }
+    })
+
+    t.Run("internal path", func(t *testing.T) {
+        if _, err := s.Stat("/.citron/meta"); err != storage.ErrInvalidPath {
+            t.Fatalf("expect invalid path but get %v", err)
+        }
+        if _, err := s.Put(model.FileInfo{FilePath: "/agent/../.citron/x"}, bytes.NewReader(nil)); err != storage.ErrInvalidPath {
+            t.Fatalf("expect invalid path but get %v", err)
+        }
+    })
+
+    t.Run("legacy file", func(t *testing.T) {
+        os.MkdirAll(filepath.Join(dir, "old"), 0755)
+        ioutil.WriteFile(filepath.Join(dir, "old", "c.txt"), []byte("old"), 0644)
+        if info, err := s.Stat("/old/c.txt"); err != nil || info.Size != 3 {
+            t.Fatalf("unexpected info %v %v", info, err)
+        }
+        s.Put(model.FileInfo{FilePath: "/old/c.txt"}, strings.NewReader("new"))
+        versions, _ := s.Versions("/old/c.txt")
+        if len(versions) != 2 || readAll(t, s, "/old/c.txt", versions[1].Version) != "old" {
+            t.Fatalf("expect old file kept as version but get %v", versions)
+        }
+    })
+}
+
+func TestStoragePrune(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    s := storage.New(dir)
+
+    for _, data := range []string{"v1", "v2", "v3", "v4"} {
+        if _, err := s.Put(model.FileInfo{FilePath: "/agent/a.txt"}, strings.NewReader(data)); err != nil {
+            t.Fatal(err)
+        }
+        if _, err := s.Put(model.FileInfo{FilePath: "/agent/sub/b.txt"}, strings.NewReader(data)); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    ret, err := s.Prune("/agent", model.Retention{Versions: 2}, time.Now())
+    if err != nil || ret.Files != 2 || ret.Versions != 4 || ret.Bytes != 8 {
+        t.Fatalf("unexpected prune result %+v %v", ret, err)
+    }
+    versions, _ := s.Versions("/agent/sub/b.txt")
+    if len(versions) != 2 || readAll(t, s, "/agent/sub/b.txt", versions[1].Version) != "v3" {
+        t.Fatalf("expect 2 versions but get %v", versions)
+    }
+    if _, _, err := s.Open("/agent/a.txt", "1"); err == nil {
+        t.Fatal("pruned version must not be readable")
+    }
+
+    //最新版本总是保留
+    ret, err = s.Prune("/agent/a.txt", model.Retention{MaxAge: model.Duration(time.Millisecond)}, time.Now().Add(time.Hour))
+    if err != nil || ret.Versions != 1 {
+        t.Fatalf("unexpected prune result %+v %v", ret, err)
+    }
+    if readAll(t, s, "/agent/a.txt", "") != "v4" {
+        t.Fatal("latest version must be kept")
+    }
+    if _, err := s.Prune("/agent/none", model.Retention{Versions: 1}, time.Now()); err != storage.ErrNotFound {
+        t.Fatalf("expect not found but get %v", err)
+    }
+}
+
+func TestStorageCompression(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    s := storage.New(dir)
+    s.SetCompression(model.CompressionConfig{
+        Codec: storage.CODEC_ZSTD,
+        Rules: []model.CompressionRule{
+            {Prefix: "/agent/gz", Codec: storage.CODEC_GZIP},
+            {Prefix: "/agent/raw", Codec: storage.CODEC_NONE},
+        },
+    })
+    text := strings.Repeat("2019-01-01 12:00:00 INFO citron backup finished\n", 1000)
+    random := make([]byte, 100*1024)
+    rand.Read(random)
+
+    cases := []struct {
+        path  string
+        data  string
+        codec string
+    }{
+        {"/agent/logs/a.log", text, storage.CODEC_ZSTD},
+        {"/agent/gz/b.log", text, storage.CODEC_GZIP},
+        {"/agent/raw/c.log", text, ""},
+        {"/agent/logs/random.bin", string(random), ""},
+        {"/agent/logs/small.txt", "small", ""},
+    }
+    for _, c := range cases {
+        info, err := s.Put(model.FileInfo{FilePath: c.path}, strings.NewReader(c.data))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if info.Codec != c.codec || info.Size != int64(len(c.data)) {
+            t.Fatalf("%s expect codec %q but get %+v", c.path, c.codec, info)
+        }
+        fi, _ := os.Stat(filepath.Join(dir, filepath.FromSlash(c.path)))
+        if c.codec != "" && (fi.Size() != info.StoredSize || info.StoredSize*10 > info.Size) {
+            t.Fatalf("%s expect compressed but stored %d of %d", c.path, fi.Size(), info.Size)
+        }
+        if readAll(t, s, c.path, "") != c.data {
+            t.Fatalf("%s unexpected content", c.path)
+        }
+    }
+
+    //历史版本保持原有的压缩算法
+    s.SetCompression(model.CompressionConfig{})
+    v2, _ := s.Put(model.FileInfo{FilePath: "/agent/logs/a.log"}, strings.NewReader("new"))
+    versions, _ := s.Versions("/agent/logs/a.log")
+    if v2.Codec != "" || len(versions) != 2 || readAll(t, s, "/agent/logs/a.log", versions[1].Version) != text {
+        t.Fatalf("unexpected versions %v", versions)
+    }
+    ret, err := s.Prune("/agent/logs/a.log", model.Retention{Versions: 1}, time.Now())
+    if err != nil || ret.Bytes != versions[1].StoredSize {
+        t.Fatalf("expect stored size pruned but get %+v %v", ret, err)
+    }
+}
+
+func TestStorageEncryption(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    s := storage.New(dir)
+    plain, _ := s.Put(model.FileInfo{FilePath: "/agent/plain.txt"}, strings.NewReader("plain text"))
+
+    k1 := model.MasterKey{ID: "k1", Secret: strings.Repeat("01", storage.MASTER_KEY_SIZE)}
+    keyFile := filepath.Join(dir, "k2.key")
+    ioutil.WriteFile(keyFile, []byte(strings.Repeat("ab", storage.MASTER_KEY_SIZE)+"\n"), 0600)
+    k2 := model.MasterKey{ID: "k2", File: keyFile}
+    if err := s.SetEncryption(model.EncryptionConfig{Keys: []model.MasterKey{k1}}); err != nil {
+        t.Fatal(err)
+    }
+    s.SetCompression(model.CompressionConfig{Codec: storage.CODEC_GZIP})
+
+    text := strings.Repeat("citron encrypted at rest\n", 10000)
+    random := make([]byte, 2*storage.CRYPT_CHUNK_SIZE)
+    rand.Read(random)
+    files := map[string]string{
+        "/agent/a.log": text,
+        "/agent/b.bin": string(random),
+        "/agent/empty": "",
+        "/agent/c.log": "old version",
+    }
+    for p, data := range files {
+        info, err := s.Put(model.FileInfo{FilePath: p}, strings.NewReader(data))
+        if err != nil || !info.Encrypted || info.Size != int64(len(data)) {
+            t.Fatalf("unexpected put %s %+v %v", p, info, err)
+        }
+        stored, _ := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(p)))
+        if int64(len(stored)) != info.StoredSize || (data != "" && bytes.Contains(stored, []byte(data[:10]))) {
+            t.Fatalf("%s expect encrypted but stored %d", p, len(stored))
+        }
+    }
+    v2, _ := s.Put(model.FileInfo{FilePath: "/agent/c.log"}, strings.NewReader("new version"))
+    versions, _ := s.Versions("/agent/c.log")
+    files["/agent/c.log"] = "new version"
+    check := func() {
+        for p, data := range files {
+            if readAll(t, s, p, "") != data {
+                t.Fatalf("%s unexpected content", p)
+            }
+        }
+        if readAll(t, s, "/agent/c.log", versions[1].Version) != "old version" || readAll(t, s, "/agent/plain.txt", "") != "plain text" {
+            t.Fatal("unexpected old version")
+        }
+    }
+    check()
+    if !versions[0].Encrypted || v2.Codec != "" || plain.Encrypted {
+        t.Fatalf("unexpected versions %v", versions)
+    }
+
+    //轮换主密钥只重新加密数据密钥，文件内容不变
+    before, _ := ioutil.ReadFile(filepath.Join(dir, "agent", "b.bin"))
+    s.SetEncryption(model.EncryptionConfig{Keys: []model.MasterKey{k2, k1}})
+    ret, err := s.RotateKeys()
+    if err != nil || ret.Files != 4 || ret.Keys != 5 || len(ret.Failed) != 0 {
+        t.Fatalf("unexpected rotate %+v %v", ret, err)
+    }
+    after, _ := ioutil.ReadFile(filepath.Join(dir, "agent", "b.bin"))
+    if !bytes.Equal(before, after) {
+        t.Fatal("content must not be re-encrypted")
+    }
+    if ret, _ := s.RotateKeys(); ret.Keys != 0 {
+        t.Fatalf("expect nothing to rotate but get %+v", ret)
+    }
+    //删除旧的主密钥后仍然可以读取
+    s.SetEncryption(model.EncryptionConfig{Keys: []model.MasterKey{k2}})
+    check()
+
+    //主密钥不在配置中时无法读取
+    s.SetEncryption(model.EncryptionConfig{Keys: []model.MasterKey{k1}})
+    if _, _, err := s.Open("/agent/a.log", ""); err != storage.ErrKeyNotFound {
+        t.Fatalf("expect key not found but get %v", err)
+    }
+    if ret, err := s.RotateKeys(); err != nil || len(ret.Failed) != 4 {
+        t.Fatalf("expect failed files but get %+v %v", ret, err)
+    }
+
+    //篡改密文
+    s.SetEncryption(model.EncryptionConfig{Keys: []model.MasterKey{k2}})
+    after[100] ^= 0xFF
+    ioutil.WriteFile(filepath.Join(dir, "agent", "b.bin"), after, 0644)
+    r, _, err := s.Open("/agent/b.bin", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := ioutil.ReadAll(r); err != storage.ErrDecrypt {
+        t.Fatalf("expect decrypt error but get %v", err)
+    }
+    r.Close()
+
+    //主密钥加载失败时拒绝写入
+    if err := s.SetEncryption(model.EncryptionConfig{Keys: []model.MasterKey{{ID: "bad", File: filepath.Join(dir, "none")}}}); err == nil {
+        t.Fatal("expect load error")
+    }
+    if _, err := s.Put(model.FileInfo{FilePath: "/agent/d.txt"}, strings.NewReader("d")); err == nil {
+        t.Fatal("expect put rejected")
+    }
+    s.SetEncryption(model.EncryptionConfig{})
+    if _, err := s.RotateKeys(); err != storage.ErrNoMasterKey {
+        t.Fatalf("expect no master key but get %v", err)
+    }
+}
+
+func TestStorageScrub(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    s := storage.New(dir)
+    s.SetCompression(model.CompressionConfig{Codec: storage.CODEC_ZSTD})
+    text := strings.Repeat("citron scrub\n", 10000)
+    s.Put(model.FileInfo{FilePath: "/agent/a.txt"}, strings.NewReader("a1"))
+    a2, _ := s.Put(model.FileInfo{FilePath: "/agent/a.txt"}, strings.NewReader("a2"))
+    b1, _ := s.Put(model.FileInfo{FilePath: "/agent/b.log"}, strings.NewReader(text))
+    s.Put(model.FileInfo{FilePath: "/agent/b.log"}, strings.NewReader("b2"))
+    //直接写入备份目录的文件作为没有checksum的第一个版本
+    os.MkdirAll(filepath.Join(dir, "agent"), 0755)
+    ioutil.WriteFile(filepath.Join(dir, "agent", "legacy.txt"), []byte("legacy"), 0644)
+    s.Put(model.FileInfo{FilePath: "/agent/legacy.txt"}, strings.NewReader("new"))
+
+    ret, err := s.Scrub(model.ScrubConfig{}, nil)
+    if err != nil || !ret.Ok() || ret.Files != 3 || ret.Versions != 6 || ret.Skipped != 1 {
+        t.Fatalf("unexpected scrub %+v %v", ret, err)
+    }
+
+    //最新版本损坏，历史版本丢失，历史版本目录中多余的文件
+    latest := filepath.Join(dir, "agent", "a.txt")
+    ioutil.WriteFile(latest, []byte("a3"), 0644)
+    os.Remove(filepath.Join(dir, storage.VERSION_DIR, "agent", "b.log", b1.Version))
+    orphan := filepath.Join(dir, storage.VERSION_DIR, "agent", "c.txt", "1")
+    os.MkdirAll(filepath.Dir(orphan), 0700)
+    ioutil.WriteFile(orphan, []byte("orphan"), 0644)
+
+    ret, _ = s.Scrub(model.ScrubConfig{}, nil)
+    if len(ret.Corrupted) != 1 || ret.Corrupted[0].Path != "/agent/a.txt" || ret.Corrupted[0].Version != a2.Version ||
+        len(ret.Missing) != 1 || ret.Missing[0].Version != b1.Version ||
+        len(ret.Orphaned) != 1 || ret.Orphaned[0].Path != "/agent/c.txt" || ret.Corrupted[0].Quarantined {
+        t.Fatalf("unexpected scrub %+v", ret)
+    }
+
+    ret, _ = s.Scrub(model.ScrubConfig{Quarantine: true}, nil)
+    if len(ret.Corrupted) != 1 || !ret.Corrupted[0].Quarantined || len(ret.Orphaned) != 1 || !ret.Orphaned[0].Quarantined {
+        t.Fatalf("unexpected scrub %+v", ret)
+    }
+    if _, err := os.Stat(latest); !os.IsNotExist(err) {
+        t.Fatal("corrupted file must be quarantined")
+    }
+    if data, _ := ioutil.ReadFile(filepath.Join(dir, storage.QUARANTINE_DIR, "agent", "c.txt", "1")); string(data) != "orphan" {
+        t.Fatal("orphaned file must be quarantined")
+    }
+    //隔离的版本显示为丢失，重新上传相同内容后恢复
+    ret, _ = s.Scrub(model.ScrubConfig{}, nil)
+    if len(ret.Missing) != 2 || len(ret.Corrupted) != 0 || len(ret.Orphaned) != 0 {
+        t.Fatalf("unexpected scrub %+v", ret)
+    }
+    s.Put(model.FileInfo{FilePath: "/agent/a.txt"}, strings.NewReader("a2"))
+    if readAll(t, s, "/agent/a.txt", "") != "a2" {
+        t.Fatal("expect restored")
+    }
+
+    //限速及停止
+    start := time.Now()
+    ret, _ = s.Scrub(model.ScrubConfig{RateLimit: 20}, nil)
+    if time.Since(start) < 300*time.Millisecond || ret.Bytes != 9 {
+        t.Fatalf("expect throttled but get %v %d", time.Since(start), ret.Bytes)
+    }
+    stop := make(chan struct{})
+    close(stop)
+    if _, err := s.Scrub(model.ScrubConfig{}, stop); err != storage.ErrScrubStopped {
+        t.Fatalf("expect stopped but get %v", err)
+    }
+
+    //检查期间上传及删除文件
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        for i := 0; i < 200; i++ {
+            p := fmt.Sprintf("/busy/%d/f.txt", i%5)
+            s.Put(model.FileInfo{FilePath: p}, strings.NewReader(fmt.Sprint(i)))
+            if i%3 == 0 {
+                s.Delete(p)
+            }
+        }
+    }()
+    for busy := true; busy; {
+        select {
+        case <-done:
+            busy = false
+        default:
+        }
+        if _, err := s.Scrub(model.ScrubConfig{}, nil); err != nil {
+            t.Fatalf("scrub must not fail while files change: %v", err)
+        }
+        if _, err := s.Trash("/"); err != nil {
+            t.Fatalf("trash must not fail while files change: %v", err)
+        }
+    }
+}
+
+func TestStorageTrash(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    s := storage.New(dir)
+    s.Put(model.FileInfo{FilePath: "/agent/a.txt"}, strings.NewReader("a1"))
+    a2, _ := s.Put(model.FileInfo{FilePath: "/agent/a.txt"}, strings.NewReader("a2"))
+    s.Put(model.FileInfo{FilePath: "/agent/sub/b.txt"}, strings.NewReader("b1"))
+    //没有元数据的文件
+    ioutil.WriteFile(filepath.Join(dir, "agent", "sub", "legacy.txt"), []byte("legacy"), 0644)
+
+    if _, err := s.Delete("/"); err != storage.ErrInvalidPath {
+        t.Fatalf("expect invalid path but get %v", err)
+    }
+    ret, err := s.Delete("/agent")
+    if err != nil || ret.Files != 3 || ret.Bytes != 10 {
+        t.Fatalf("unexpected delete %+v %v", ret, err)
+    }
+    if _, err := s.Stat("/agent"); err != storage.ErrNotFound {
+        t.Fatalf("expect not found but get %v", err)
+    }
+    if infos, err := s.List("/"); err != nil || len(infos) != 0 {
+        t.Fatalf("expect empty list but get %v %v", infos, err)
+    }
+    //删除的文件仍然可以读取指定的版本（如恢复快照）
+    if readAll(t, s, "/agent/a.txt", a2.Version) != "a2" {
+        t.Fatal("deleted version must be readable")
+    }
+    if _, _, err := s.Open("/agent/a.txt", ""); err != storage.ErrNotFound {
+        t.Fatalf("expect not found but get %v", err)
+    }
+    entries, err := s.Trash("/agent/sub")
+    if err != nil || len(entries) != 2 || entries[0].Path != "/agent/sub/b.txt" || entries[1].Path != "/agent/sub/legacy.txt" {
+        t.Fatalf("unexpected trash %+v %v", entries, err)
+    }
+    entries, _ = s.Trash("/")
+    if len(entries) != 3 || entries[0].Path != "/agent/a.txt" || entries[0].Version != a2.Version || entries[0].Versions != 2 {
+        t.Fatalf("unexpected trash %+v", entries)
+    }
+    if scrub, err := s.Scrub(model.ScrubConfig{}, nil); err != nil || !scrub.Ok() || scrub.Versions != 4 {
+        t.Fatalf("unexpected scrub %+v %v", scrub, err)
+    }
+
+    //原路径已有文件时不恢复
+    s.Put(model.FileInfo{FilePath: "/agent/sub/b.txt/c.txt"}, strings.NewReader("c"))
+    ret, err = s.Restore("/agent")
+    if err != nil || ret.Files != 2 || len(ret.Failed) != 1 || ret.Failed[0] != "/agent/sub/b.txt" {
+        t.Fatalf("unexpected restore %+v %v", ret, err)
+    }
+    if readAll(t, s, "/agent/a.txt", "") != "a2" || readAll(t, s, "/agent/sub/legacy.txt", "") != "legacy" {
+        t.Fatal("restored file must be latest")
+    }
+    if versions, _ := s.Versions("/agent/a.txt"); len(versions) != 2 || versions[0].Version != a2.Version {
+        t.Fatalf("unexpected versions %v", versions)
+    }
+    if _, err := s.Restore("/agent/a.txt"); err != storage.ErrNotFound {
+        t.Fatalf("expect not found but get %v", err)
+    }
+
+    //删除后上传作为新版本，删除标记保留在历史版本中
+    s.Delete("/agent/a.txt")
+    s.Put(model.FileInfo{FilePath: "/agent/a.txt"}, strings.NewReader("a3"))
+    versions, _ := s.Versions("/agent/a.txt")
+    if len(versions) != 4 || versions[1].State != model.FileDeleted {
+        t.Fatalf("unexpected versions %v", versions)
+    }
+    if entries, _ := s.Trash("/agent/a.txt"); len(entries) != 0 {
+        t.Fatalf("expect empty trash but get %+v", entries)
+    }
+
+    //超过保留时间后删除，快照引用的版本保留
+    s.Delete("/agent/sub/legacy.txt")
+    snap, _ := s.BeginSnapshot(model.Snapshot{Path: "/agent"})
+    s.AddSnapshot(snap.ID, []model.FileRequest{{Path: "/agent/a.txt"}})
+    s.CommitSnapshot(snap.ID)
+    s.Delete("/agent/a.txt")
+    if ret, _ := s.PurgeTrash("/", time.Now().Add(-time.Hour)); ret.Files != 0 {
+        t.Fatalf("expect nothing purged but get %+v", ret)
+    }
+    ret, err = s.PurgeTrash("/", time.Now())
+    if err != nil || ret.Files != 2 || ret.Bytes != 12 {
+        t.Fatalf("unexpected purge %+v %v", ret, err)
+    }
+    entries, _ = s.Trash("/")
+    if len(entries) != 1 || entries[0].Path != "/agent/a.txt" || entries[0].Versions != 1 {
+        t.Fatalf("unexpected trash %+v", entries)
+    }
+    if _, err := s.RestoreSnapshot(snap.ID, ""); err != nil || readAll(t, s, "/agent/a.txt", "") != "a3" {
+        t.Fatalf("restore snapshot failed: %v", err)
+    }
+    s.DeleteSnapshot(snap.ID)
+    s.Delete("/agent/a.txt")
+    if ret, _ := s.PurgeTrash("/agent", time.Now()); ret.Files != 1 {
+        t.Fatalf("unexpected purge %+v", ret)
+    }
+    if _, err := s.Versions("/agent/a.txt"); err != storage.ErrNotFound {
+        t.Fatalf("expect not found but get %v", err)
+    }
+    if _, err := os.Stat(filepath.Join(dir, storage.META_DIR, "agent", "a.txt")); !os.IsNotExist(err) {
+        t.Fatalf("expect meta removed but get %v", err)
+    }
+}
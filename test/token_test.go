@@ -0,0 +1,243 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "citron-repo/model"
+    "citron-repo/token"
+    "encoding/base64"
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func testTokenStore(t *testing.T, tm *token.TokenMgr) {
+    t1, err := tm.CreateToken(token.Claims{Type: token.LoginToken, User: "agent"}, time.Minute)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t2, _ := tm.CreateToken(token.Claims{Type: token.LoginToken, User: "agent"}, time.Minute)
+    t3, _ := tm.CreateToken(token.Claims{Type: token.FileToken, User: "other", Path: "/other/a"}, time.Minute)
+    expired, _ := tm.CreateToken(token.Claims{Type: token.LoginToken, User: "agent"}, 10*time.Millisecond)
+    time.Sleep(20 * time.Millisecond)
+
+    if c, ok := tm.Get(t1); !ok || c.User != "agent" {
+        t.Fatalf("token not found: %v", c)
+    }
+    if c, ok := tm.Get(t3); !ok || c.Path != "/other/a" {
+        t.Fatalf("token not found: %v", c)
+    }
+    if _, ok := tm.Get(expired); ok {
+        t.Fatal("token must be expired")
+    }
+    if _, ok := tm.Get("unknown"); ok {
+        t.Fatal("unknown token must not be found")
+    }
+    if n := len(tm.Sessions("agent")); n != 2 {
+        t.Fatalf("expect 2 sessions but get %d", n)
+    }
+
+    tm.Delete(t2)
+    if _, ok := tm.Get(t2); ok {
+        t.Fatal("token must be deleted")
+    }
+    tm.RevokeUser("other")
+    if _, ok := tm.Get(t3); ok {
+        t.Fatal("token must be revoked")
+    }
+}
+
+func TestTokenTake(t *testing.T) {
+    signer, _ := token.NewSigner(model.TokenKey{ID: "k1", Secret: strings.Repeat("1", 32)})
+    for name, tm := range map[string]*token.TokenMgr{"store": token.New(), "signed": token.New(token.SetSigner(signer))} {
+        t.Run(name, func(t *testing.T) {
+            defer tm.Close()
+            ft, _ := tm.CreateToken(token.Claims{Type: token.FileToken, User: "agent", Path: "/agent/a"}, time.Minute)
+            if _, ok := tm.Take(ft, token.LoginToken); ok {
+                t.Fatal("token type must match")
+            }
+
+            //并发取出只有一个成功
+            var n int32
+            wait := sync.WaitGroup{}
+            for i := 0; i < 10; i++ {
+                wait.Add(1)
+                go func() {
+                    defer wait.Done()
+                    if _, ok := tm.Take(ft, token.FileToken); ok {
+                        atomic.AddInt32(&n, 1)
+                    }
+                }()
+            }
+            wait.Wait()
+            if n != 1 {
+                t.Fatalf("expect taken once but get %d", n)
+            }
+            if _, ok := tm.Get(ft); ok {
+                t.Fatal("taken token must be deleted")
+            }
+
+            tm.Restore(ft, token.Claims{Type: token.FileToken, User: "agent", Path: "/agent/a", ExpireAt: time.Now().Add(time.Minute)})
+            if c, ok := tm.Take(ft, token.FileToken); !ok || c.Path != "/agent/a" {
+                t.Fatalf("restored token must be valid: %v", c)
+            }
+        })
+    }
+}
+
+func TestMemoryTokenStore(t *testing.T) {
+    tm := token.New()
+    defer tm.Close()
+    testTokenStore(t, tm)
+}
+
+func TestFileTokenStore(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, token.DEFAULT_FILE)
+
+    store, err := token.OpenFileStore(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tm := token.New(token.SetStore(store))
+    testTokenStore(t, tm)
+
+    t1, _ := tm.CreateToken(token.Claims{Type: token.LoginToken, User: "restart"}, time.Minute)
+    t2, _ := tm.CreateToken(token.Claims{Type: token.LoginToken, User: "restart"}, time.Minute)
+    sessions := tm.Sessions("restart")
+    tm.Revoke(sessions[0].ID)
+    tm.Close()
+
+    data, _ := ioutil.ReadFile(path)
+    if strings.Contains(string(data), t1) || strings.Contains(string(data), t2) {
+        t.Fatal("token must not be saved in plaintext")
+    }
+
+    t.Run("restart", func(t *testing.T) {
+        store, err := token.OpenFileStore(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        tm := token.New(token.SetStore(store))
+        defer tm.Close()
+
+        sessions := tm.Sessions("restart")
+        if len(sessions) != 1 {
+            t.Fatalf("expect 1 session but get %d", len(sessions))
+        }
+        _, ok1 := tm.Get(t1)
+        _, ok2 := tm.Get(t2)
+        if ok1 == ok2 {
+            t.Fatal("only the token not revoked must survive restart")
+        }
+        if len(tm.Sessions("agent")) != 1 {
+            t.Fatal("token of agent must survive restart")
+        }
+    })
+
+    t.Run("broken record", func(t *testing.T) {
+        f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+        f.Write([]byte(`{"op":"set","id":"x`))
+        f.Close()
+
+        store, err := token.OpenFileStore(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        tm := token.New(token.SetStore(store))
+        defer tm.Close()
+        if len(tm.Sessions("restart")) != 1 {
+            t.Fatal("broken record must be skipped")
+        }
+    })
+}
+
+func TestSignedToken(t *testing.T) {
+    key1 := model.TokenKey{ID: "k1", Secret: strings.Repeat("1", 32)}
+    key2 := model.TokenKey{ID: "k2", Secret: strings.Repeat("2", 32)}
+
+    if _, err := token.NewSigner(model.TokenKey{ID: "short", Secret: "123"}); err != token.ErrNoKey {
+        t.Fatalf("expect key error but get %v", err)
+    }
+
+    signer, err := token.NewSigner(key1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tm := token.New(token.SetSigner(signer))
+    defer tm.Close()
+
+    t1, err := tm.CreateToken(token.Claims{
+        Type:   token.LoginToken,
+        User:   "agent",
+        Path:   "/agent/etc",
+        Scopes: []string{"list", "write"},
+    }, time.Minute)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !token.IsSigned(t1) {
+        t.Fatal("expect signed token")
+    }
+    c, ok := tm.Get(t1)
+    if !ok || c.User != "agent" || c.Path != "/agent/etc" || len(c.Scopes) != 2 || c.Type != token.LoginToken {
+        t.Fatalf("verify failed: %v", c)
+    }
+
+    t.Run("tampered", func(t *testing.T) {
+        parts := strings.Split(t1, ".")
+        payload, _ := json.Marshal(map[string]interface{}{"sub": "admin", "typ": token.LoginToken, "exp": time.Now().Add(time.Hour).Unix()})
+        forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+        if _, ok := tm.Get(forged); ok {
+            t.Fatal("tampered token must be rejected")
+        }
+
+        header, _ := json.Marshal(map[string]string{"alg": "none", "typ": "JWT", "kid": "k1"})
+        none := base64.RawURLEncoding.EncodeToString(header) + "." + parts[1] + "."
+        if _, ok := tm.Get(none); ok {
+            t.Fatal("alg none must be rejected")
+        }
+    })
+
+    t.Run("expired", func(t *testing.T) {
+        expired, _ := tm.CreateToken(token.Claims{Type: token.LoginToken, User: "agent"}, -time.Second)
+        if _, err := signer.Verify(expired); err != token.ErrTokenExpired {
+            t.Fatalf("expect expired but get %v", err)
+        }
+    })
+
+    t.Run("rotate", func(t *testing.T) {
+        //新密钥签名，旧密钥仍可校验
+        signer.SetKeys(key2, key1)
+        t2, _ := tm.CreateToken(token.Claims{Type: token.LoginToken, User: "agent"}, time.Minute)
+        if _, ok := tm.Get(t1); !ok {
+            t.Fatal("token signed by old key must be valid")
+        }
+        if _, ok := tm.Get(t2); !ok {
+            t.Fatal("token signed by new key must be valid")
+        }
+
+        //移除旧密钥
+        signer.SetKeys(key2)
+        if _, err := signer.Verify(t1); err != token.ErrUnknownKey {
+            t.Fatalf("expect unknown key but get %v", err)
+        }
+
+        //其他实例使用相同密钥校验
+        other, _ := token.NewSigner(key2)
+        if _, err := other.Verify(t2); err != nil {
+            t.Fatal(err)
+        }
+    })
+}
@@ -0,0 +1,167 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "citron-repo/errcode"
+    "citron-repo/handler"
+    "citron-repo/model"
+    "citron-repo/token"
+    "citron-repo/user"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestUserMgr(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, user.DEFAULT_FILE)
+
+    m, err := user.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := m.Create(model.UserInfo{Username: "agent", Password: "agent123"}); err != nil {
+        t.Fatal(err)
+    }
+    if err := m.Create(model.UserInfo{Username: "agent", Password: "agent123"}); err != user.ErrUserExists {
+        t.Fatalf("expect user exists but get %v", err)
+    }
+    if err := m.Create(model.UserInfo{Username: ".citron", Password: "agent123"}); err != user.ErrInvalidUsername {
+        t.Fatalf("expect invalid username but get %v", err)
+    }
+    if err := m.Create(model.UserInfo{Username: "short", Password: "123"}); err != user.ErrInvalidPassword {
+        t.Fatalf("expect invalid password but get %v", err)
+    }
+
+    data, _ := ioutil.ReadFile(path)
+    if strings.Contains(string(data), "agent123") {
+        t.Fatal("password must not be saved in plaintext")
+    }
+
+    m, err = user.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := m.Authenticate("agent", "agent123"); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := m.Authenticate("agent", "wrong"); err != user.ErrAuthFailed {
+        t.Fatalf("expect auth failed but get %v", err)
+    }
+    if _, err := m.Authenticate("nobody", "agent123"); err != user.ErrAuthFailed {
+        t.Fatalf("expect auth failed but get %v", err)
+    }
+
+    m.SetDisabled("agent", true)
+    if _, err := m.Authenticate("agent", "agent123"); err != user.ErrUserDisabled {
+        t.Fatalf("expect disabled but get %v", err)
+    }
+    m.SetDisabled("agent", false)
+
+    m.ResetPassword("agent", "newpass")
+    if _, err := m.Authenticate("agent", "agent123"); err != user.ErrAuthFailed {
+        t.Fatalf("expect auth failed but get %v", err)
+    }
+    if _, err := m.Authenticate("agent", "newpass"); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func jsonRequest(method, url, loginToken string, v interface{}) *http.Request {
+    var body []byte
+    if v != nil {
+        body, _ = json.Marshal(v)
+    }
+    req := httptest.NewRequest(method, url, bytes.NewReader(body))
+    req.Header.Set("Content-Type", "application/json")
+    if loginToken != "" {
+        req.Header.Set(handler.CITRON_TOKEN, loginToken)
+    }
+    return req
+}
+
+func TestRestfulUser(t *testing.T) {
+    dir, _ := ioutil.TempDir("", "citron")
+    defer os.RemoveAll(dir)
+    tm := token.New()
+    defer tm.Close()
+    engine := newTestRestful(tm, user.New(), dir)
+
+    adminToken := login(t, engine, "admin", "123456")
+
+    code, ret := doRequest(engine, jsonRequest(http.MethodPost, "/admin/user", adminToken,
+        model.UserInfo{Username: "agent", Password: "agent123"}))
+    if code != http.StatusOK {
+        t.Fatalf("create user failed: %d %v", code, ret)
+    }
+    if _, err := os.Stat(filepath.Join(dir, "agent")); err != nil {
+        t.Fatal("user backup dir not created")
+    }
+
+    agentToken := login(t, engine, "agent", "agent123")
+
+    t.Run("not admin", func(t *testing.T) {
+        code, ret := doRequest(engine, jsonRequest(http.MethodGet, "/admin/user", agentToken, nil))
+        if code != http.StatusForbidden || ret.Code != errcode.PermissionDenied.Code {
+            t.Fatalf("expect permission denied but get %d %v", code, ret)
+        }
+    })
+
+    t.Run("upload into user root", func(t *testing.T) {
+        req := httptest.NewRequest(http.MethodPost, "/meta", nil)
+        req.Header.Set(handler.CITRON_TOKEN, agentToken)
+        req.Header.Set(handler.CITRON_REL, "../../admin")
+        req.Header.Set(handler.CITRON_FILENAME, "test.txt")
+        _, ret := doRequest(engine, req)
+        code, ret := doRequest(engine, uploadRequest(agentToken, ret.Data.(string), []byte("hello")))
+        if code != http.StatusOK {
+            t.Fatalf("upload failed: %d %v", code, ret)
+        }
+        if _, err := os.Stat(filepath.Join(dir, "agent", "admin", "test.txt")); err != nil {
+            t.Fatal("file must be saved in user root")
+        }
+    })
+
+    t.Run("reset password", func(t *testing.T) {
+        code, ret := doRequest(engine, jsonRequest(http.MethodPut, "/admin/user/agent/password", adminToken,
+            model.UserInfo{Password: "agent456"}))
+        if code != http.StatusOK {
+            t.Fatalf("reset password failed: %d %v", code, ret)
+        }
+        //重置密码前的token失效
+        if code, _ := createMeta(engine, agentToken, "test.txt"); code != http.StatusUnauthorized {
+            t.Fatalf("expect unauthorized but get %d", code)
+        }
+        login(t, engine, "agent", "agent456")
+    })
+
+    t.Run("disable", func(t *testing.T) {
+        code, ret := doRequest(engine, jsonRequest(http.MethodPut, "/admin/user/agent/disable", adminToken, nil))
+        if code != http.StatusOK {
+            t.Fatalf("disable user failed: %d %v", code, ret)
+        }
+        code, ret = createMeta(engine, agentToken, "test.txt")
+        if code != http.StatusUnauthorized || ret.Code != errcode.AuthError.Code {
+            t.Fatalf("expect auth error but get %d %v", code, ret)
+        }
+    })
+
+    t.Run("not found", func(t *testing.T) {
+        code, ret := doRequest(engine, jsonRequest(http.MethodPut, "/admin/user/nobody/enable", adminToken, nil))
+        if code != http.StatusNotFound || ret.Code != errcode.UserNotFound.Code {
+            t.Fatalf("expect user not found but get %d %v", code, ret)
+        }
+    })
+}
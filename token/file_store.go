@@ -0,0 +1,227 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package token
+
+import (
+    "bufio"
+    "encoding/json"
+    "github.com/xfali/goutils/log"
+    "os"
+    "path/filepath"
+    "sync"
+)
+
+const (
+    //token文件默认保存在备份目录下
+    DEFAULT_FILE = ".citron/tokens.log"
+
+    opSet    = "set"
+    opDelete = "del"
+
+    //日志记录数超过有效token数的COMPACT_RATIO倍且超过COMPACT_MIN时重写文件
+    COMPACT_RATIO = 2
+    COMPACT_MIN   = 1024
+)
+
+type record struct {
+    Op     string  `json:"op"`
+    ID     string  `json:"id"`
+    Claims *Claims `json:"claims,omitempty"`
+}
+
+//文件存储：修改以追加日志的方式写入文件，启动时重放日志恢复token，服务重启后token仍然有效
+type FileStore struct {
+    *MemoryStore
+
+    path     string
+    fileLock sync.Mutex
+    file     *os.File
+    records  int
+}
+
+func OpenFileStore(path string) (*FileStore, error) {
+    s := &FileStore{
+        MemoryStore: newMemoryStore(),
+        path:        path,
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+        return nil, err
+    }
+    if err := s.load(); err != nil {
+        return nil, err
+    }
+    //启动时丢弃过期及已删除的记录
+    s.fileLock.Lock()
+    err := s.compact()
+    s.fileLock.Unlock()
+    if err != nil {
+        return nil, err
+    }
+
+    go s.purgeLoop(PURGE_INTERVAL, func(n int) {
+        if n > 0 {
+            s.tryCompact()
+        }
+    })
+    return s, nil
+}
+
+func (s *FileStore) load() error {
+    f, err := os.Open(s.path)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil
+        }
+        return err
+    }
+    defer f.Close()
+
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+    for scanner.Scan() {
+        r := record{}
+        //服务异常退出时最后一条记录可能不完整
+        if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+            log.Warn("skip broken token record: %v", err)
+            continue
+        }
+        switch r.Op {
+        case opSet:
+            if r.Claims != nil && !r.Claims.Expired() {
+                s.db[r.ID] = *r.Claims
+            }
+        case opDelete:
+            delete(s.db, r.ID)
+        }
+    }
+    return scanner.Err()
+}
+
+func (s *FileStore) Set(id string, claims Claims) error {
+    s.fileLock.Lock()
+    defer s.fileLock.Unlock()
+
+    if err := s.append(record{Op: opSet, ID: id, Claims: &claims}); err != nil {
+        return err
+    }
+    return s.MemoryStore.Set(id, claims)
+}
+
+func (s *FileStore) Delete(id string) error {
+    s.fileLock.Lock()
+    defer s.fileLock.Unlock()
+
+    if err := s.append(record{Op: opDelete, ID: id}); err != nil {
+        return err
+    }
+    return s.MemoryStore.Delete(id)
+}
+
+func (s *FileStore) Close() error {
+    s.MemoryStore.Close()
+
+    s.fileLock.Lock()
+    defer s.fileLock.Unlock()
+
+    if s.file == nil {
+        return nil
+    }
+    err := s.file.Close()
+    s.file = nil
+    return err
+}
+
+//必须在持有fileLock时调用
+func (s *FileStore) append(r record) error {
+    if s.file == nil {
+        return os.ErrClosed
+    }
+    data, err := json.Marshal(r)
+    if err != nil {
+        return err
+    }
+    _, err = s.file.Write(append(data, '\n'))
+    if err != nil {
+        return err
+    }
+    s.records++
+    return nil
+}
+
+func (s *FileStore) tryCompact() {
+    s.fileLock.Lock()
+    defer s.fileLock.Unlock()
+
+    if s.file == nil {
+        return
+    }
+
+    s.lock.RLock()
+    size := len(s.db)
+    s.lock.RUnlock()
+    if s.records < COMPACT_MIN || s.records < COMPACT_RATIO*size {
+        return
+    }
+    if err := s.compact(); err != nil {
+        log.Error("compact token file failed: %v", err)
+    }
+}
+
+//只保留有效token重写文件，必须在持有fileLock时调用
+func (s *FileStore) compact() error {
+    tmp := s.path + ".tmp"
+    f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+    if err != nil {
+        return err
+    }
+
+    w := bufio.NewWriter(f)
+    count := 0
+    s.lock.RLock()
+    for id, claims := range s.db {
+        if claims.Expired() {
+            continue
+        }
+        c := claims
+        data, err := json.Marshal(record{Op: opSet, ID: id, Claims: &c})
+        if err != nil {
+            s.lock.RUnlock()
+            f.Close()
+            return err
+        }
+        w.Write(data)
+        w.WriteByte('\n')
+        count++
+    }
+    s.lock.RUnlock()
+
+    if err := w.Flush(); err != nil {
+        f.Close()
+        return err
+    }
+    if err := f.Sync(); err != nil {
+        f.Close()
+        return err
+    }
+    if err := f.Close(); err != nil {
+        return err
+    }
+    if err := os.Rename(tmp, s.path); err != nil {
+        return err
+    }
+
+    file, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0600)
+    if err != nil {
+        return err
+    }
+    if s.file != nil {
+        s.file.Close()
+    }
+    s.file = file
+    s.records = count
+    return nil
+}
@@ -0,0 +1,183 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package token
+
+import (
+    "citron-repo/model"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    //签名算法HMAC-SHA256
+    ALG_HS256 = "HS256"
+    //签名密钥最小长度
+    MIN_KEY_SIZE = 32
+)
+
+var (
+    ErrInvalidToken = errors.New("invalid token")
+    ErrTokenExpired = errors.New("token expired")
+    ErrUnknownKey   = errors.New("unknown token key")
+    ErrNoKey        = errors.New("no token key, at least 32 bytes")
+)
+
+type jwtHeader struct {
+    Alg string `json:"alg"`
+    Typ string `json:"typ"`
+    Kid string `json:"kid,omitempty"`
+}
+
+type jwtPayload struct {
+    Sub   string `json:"sub"`
+    Type  int    `json:"typ"`
+    Path  string `json:"path,omitempty"`
+    Scope string `json:"scope,omitempty"`
+    Exp   int64  `json:"exp"`
+    Iat   int64  `json:"iat"`
+}
+
+//无状态token签名器（JWT HS256），服务端不保存token，多个实例配置相同密钥即可互相校验
+//第一个密钥用于签名，所有密钥均可用于校验，轮换密钥时将新密钥放在第一个并保留旧密钥直到旧token过期
+type Signer struct {
+    lock sync.RWMutex
+    sign model.TokenKey
+    keys map[string][]byte
+}
+
+func NewSigner(keys ...model.TokenKey) (*Signer, error) {
+    s := &Signer{}
+    if err := s.SetKeys(keys...); err != nil {
+        return nil, err
+    }
+    return s, nil
+}
+
+//替换密钥
+func (s *Signer) SetKeys(keys ...model.TokenKey) error {
+    if len(keys) == 0 {
+        return ErrNoKey
+    }
+    m := make(map[string][]byte, len(keys))
+    for _, k := range keys {
+        if len(k.Secret) < MIN_KEY_SIZE {
+            return ErrNoKey
+        }
+        m[k.ID] = []byte(k.Secret)
+    }
+
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    s.sign = keys[0]
+    s.keys = m
+    return nil
+}
+
+func (s *Signer) Sign(claims Claims) (string, error) {
+    s.lock.RLock()
+    key := s.sign
+    s.lock.RUnlock()
+
+    if claims.IssuedAt.IsZero() {
+        claims.IssuedAt = time.Now()
+    }
+    header, err := json.Marshal(jwtHeader{Alg: ALG_HS256, Typ: "JWT", Kid: key.ID})
+    if err != nil {
+        return "", err
+    }
+    payload, err := json.Marshal(jwtPayload{
+        Sub:   claims.User,
+        Type:  claims.Type,
+        Path:  claims.Path,
+        Scope: strings.Join(claims.Scopes, " "),
+        Exp:   claims.ExpireAt.Unix(),
+        Iat:   claims.IssuedAt.Unix(),
+    })
+    if err != nil {
+        return "", err
+    }
+
+    enc := base64.RawURLEncoding
+    signing := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+    return signing + "." + enc.EncodeToString(sum([]byte(key.Secret), signing)), nil
+}
+
+func (s *Signer) Verify(token string) (Claims, error) {
+    parts := strings.Split(token, ".")
+    if len(parts) != 3 {
+        return Claims{}, ErrInvalidToken
+    }
+
+    enc := base64.RawURLEncoding
+    header := jwtHeader{}
+    if err := decode(parts[0], &header); err != nil {
+        return Claims{}, ErrInvalidToken
+    }
+    //只接受HS256，拒绝"none"等算法
+    if header.Alg != ALG_HS256 {
+        return Claims{}, ErrInvalidToken
+    }
+    sig, err := enc.DecodeString(parts[2])
+    if err != nil {
+        return Claims{}, ErrInvalidToken
+    }
+
+    s.lock.RLock()
+    secret, ok := s.keys[header.Kid]
+    s.lock.RUnlock()
+    if !ok {
+        return Claims{}, ErrUnknownKey
+    }
+    if !hmac.Equal(sig, sum(secret, parts[0]+"."+parts[1])) {
+        return Claims{}, ErrInvalidToken
+    }
+
+    payload := jwtPayload{}
+    if err := decode(parts[1], &payload); err != nil {
+        return Claims{}, ErrInvalidToken
+    }
+    claims := Claims{
+        Type:     payload.Type,
+        User:     payload.Sub,
+        Path:     payload.Path,
+        IssuedAt: time.Unix(payload.Iat, 0),
+        ExpireAt: time.Unix(payload.Exp, 0),
+    }
+    if payload.Scope != "" {
+        claims.Scopes = strings.Split(payload.Scope, " ")
+    }
+    if claims.Expired() {
+        return Claims{}, ErrTokenExpired
+    }
+    return claims, nil
+}
+
+//是否为签名token
+func IsSigned(token string) bool {
+    return strings.Count(token, ".") == 2
+}
+
+func sum(secret []byte, data string) []byte {
+    h := hmac.New(sha256.New, secret)
+    h.Write([]byte(data))
+    return h.Sum(nil)
+}
+
+func decode(s string, v interface{}) error {
+    data, err := base64.RawURLEncoding.DecodeString(s)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(data, v)
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package token
+
+import (
+    "citron-repo/util"
+    "sort"
+    "sync"
+    "time"
+)
+
+const (
+    PURGE_INTERVAL = time.Second
+)
+
+//token存储，id为token的hash值，存储中不保存token原文
+type TokenStore interface {
+    Set(id string, claims Claims) error
+    //获得id对应的信息，不存在或已过期返回false
+    Get(id string) (Claims, bool)
+    Delete(id string) error
+    //列出用户所有未过期的token
+    List(user string) []Session
+    Close() error
+}
+
+type Session struct {
+    ID string `json:"id"`
+    Claims
+}
+
+//内存存储，服务重启后所有token失效
+type MemoryStore struct {
+    lock     sync.RWMutex
+    db       map[string]Claims
+    stopChan util.Closable
+}
+
+func NewMemoryStore() *MemoryStore {
+    ret := newMemoryStore()
+    go ret.purgeLoop(PURGE_INTERVAL, nil)
+    return ret
+}
+
+func newMemoryStore() *MemoryStore {
+    return &MemoryStore{
+        db:       map[string]Claims{},
+        stopChan: util.NewSafeCloseChan(),
+    }
+}
+
+func (s *MemoryStore) Set(id string, claims Claims) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    s.db[id] = claims
+    return nil
+}
+
+func (s *MemoryStore) Get(id string) (Claims, bool) {
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+
+    claims, ok := s.db[id]
+    if !ok || claims.Expired() {
+        return Claims{}, false
+    }
+    return claims, true
+}
+
+func (s *MemoryStore) Delete(id string) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    delete(s.db, id)
+    return nil
+}
+
+func (s *MemoryStore) List(user string) []Session {
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+
+    var ret []Session
+    for id, claims := range s.db {
+        if claims.User == user && !claims.Expired() {
+            ret = append(ret, Session{ID: id, Claims: claims})
+        }
+    }
+    sort.Slice(ret, func(i, j int) bool {
+        return ret[i].ExpireAt.Before(ret[j].ExpireAt)
+    })
+    return ret
+}
+
+func (s *MemoryStore) Close() error {
+    return s.stopChan.Close()
+}
+
+//删除过期token，返回删除数量
+func (s *MemoryStore) purge() int {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    n := 0
+    for id, claims := range s.db {
+        if claims.Expired() {
+            delete(s.db, id)
+            n++
+        }
+    }
+    return n
+}
+
+func (s *MemoryStore) purgeLoop(interval time.Duration, after func(n int)) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-s.stopChan.C():
+            return
+        case <-ticker.C:
+            n := s.purge()
+            if after != nil {
+                after(n)
+            }
+        }
+    }
+}
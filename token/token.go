@@ -7,34 +7,237 @@
 package token
 
 import (
-    "github.com/xfali/goutils/container/recycleMap"
+    "crypto/sha256"
+    "encoding/hex"
     "github.com/xfali/goutils/idUtil"
+    "sync"
     "time"
 )
 
+const (
+    //登录token
+    LoginToken = iota
+    //文件上传token
+    FileToken
+    //刷新token，用于换取新的登录token
+    RefreshToken
+    //使用api key认证，不由TokenMgr创建
+    ApiKeyToken
+    //签名token的吊销记录，保存在存储中，不能用于认证
+    RevokedToken
+)
+
+//token携带的信息
+type Claims struct {
+    Type int    `json:"type"`
+    User string `json:"user"`
+    //文件token为上传路径，登录token不为空时只能访问该路径及其子路径
+    Path string `json:"path,omitempty"`
+    //允许的操作，为空时不限制
+    Scopes   []string  `json:"scopes,omitempty"`
+    IssuedAt time.Time `json:"issuedAt"`
+    ExpireAt time.Time `json:"expireAt"`
+}
+
+func (c *Claims) Expired() bool {
+    return !time.Now().Before(c.ExpireAt)
+}
+
 type TokenMgr struct {
-    rmap *recycleMap.RecycleMap
+    store  TokenStore
+    signer *Signer
+
+    //签名token的最长有效期，用户吊销记录保存到该时间之后
+    ageLock sync.Mutex
+    maxAge  time.Duration
+    //串行执行Take，同一token只能被取出一次
+    takeLock sync.Mutex
+}
+
+type Opt func(tm *TokenMgr)
+
+func SetStore(store TokenStore) Opt {
+    return func(tm *TokenMgr) {
+        tm.store = store
+    }
+}
+
+//设置签名器后登录token及文件token为无状态的签名token，刷新token仍保存在服务端存储中
+//签名token删除或吊销后在存储中记录，直到token过期
+func SetSigner(signer *Signer) Opt {
+    return func(tm *TokenMgr) {
+        tm.signer = signer
+    }
 }
 
-func New() *TokenMgr {
-    ret := TokenMgr{
-        rmap: recycleMap.New(),
+//签名token的最长有效期，重启前签发的token按此保留吊销记录，运行时签发更长的token自动延长
+func SetMaxAge(d time.Duration) Opt {
+    return func(tm *TokenMgr) {
+        tm.maxAge = d
+    }
+}
+
+//默认使用内存存储
+func New(opts ...Opt) *TokenMgr {
+    ret := TokenMgr{}
+    for i := range opts {
+        opts[i](&ret)
+    }
+    if ret.store == nil {
+        ret.store = NewMemoryStore()
     }
-    ret.rmap.PurgeInterval = 10 * time.Millisecond
-    ret.rmap.Run()
     return &ret
 }
 
-func (tm *TokenMgr) CreateToken(key string, duration time.Duration) string {
+//token在存储中的id
+func ID(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
+//用户吊销记录在存储中的id
+func revokeID(user string) string {
+    return "revoked:" + user
+}
+
+func (tm *TokenMgr) CreateToken(claims Claims, duration time.Duration) (string, error) {
+    claims.IssuedAt = time.Now()
+    claims.ExpireAt = claims.IssuedAt.Add(duration)
+    //刷新token需要在使用后失效，始终保存在服务端
+    if tm.signer != nil && claims.Type != RefreshToken {
+        tm.ageLock.Lock()
+        if duration > tm.maxAge {
+            tm.maxAge = duration
+        }
+        tm.ageLock.Unlock()
+        return tm.signer.Sign(claims)
+    }
+
     token := idUtil.RandomId(32)
-    tm.rmap.Set(token, key, duration)
-    return token
+    if err := tm.store.Set(ID(token), claims); err != nil {
+        return "", err
+    }
+    return token, nil
 }
 
-func (tm *TokenMgr) Get(token string) string {
-    return tm.rmap.Get(token).(string)
+//获得token对应的信息，token不存在或已过期返回false
+func (tm *TokenMgr) Get(token string) (Claims, bool) {
+    if token == "" {
+        return Claims{}, false
+    }
+    if IsSigned(token) {
+        if tm.signer == nil {
+            return Claims{}, false
+        }
+        claims, err := tm.signer.Verify(token)
+        if err != nil || tm.revoked(token, claims) {
+            return Claims{}, false
+        }
+        return claims, true
+    }
+    claims, ok := tm.store.Get(ID(token))
+    if !ok || claims.Type == RevokedToken {
+        return Claims{}, false
+    }
+    return claims, true
+}
+
+//签名token已删除，或签发时间不晚于用户的吊销时间
+//签发时间精确到秒，吊销的同一秒内签发的token也失效
+func (tm *TokenMgr) revoked(token string, claims Claims) bool {
+    if _, ok := tm.store.Get(ID(token)); ok {
+        return true
+    }
+    mark, ok := tm.store.Get(revokeID(claims.User))
+    return ok && !claims.IssuedAt.After(mark.IssuedAt)
+}
+
+func (tm *TokenMgr) Signer() *Signer {
+    return tm.signer
+}
+
+//删除token，签名token在存储中记录为已吊销直到过期
+func (tm *TokenMgr) Delete(token string) error {
+    if IsSigned(token) {
+        if tm.signer == nil {
+            return nil
+        }
+        claims, err := tm.signer.Verify(token)
+        //无效或已过期的token无需记录
+        if err != nil {
+            return nil
+        }
+        return tm.store.Set(ID(token), Claims{
+            Type:     RevokedToken,
+            User:     claims.User,
+            IssuedAt: time.Now(),
+            ExpireAt: claims.ExpireAt,
+        })
+    }
+    return tm.store.Delete(ID(token))
+}
+
+//获得类型为typ的token对应的信息并删除token，用于只能使用一次的token（如文件token），并发调用时只有一个成功
+func (tm *TokenMgr) Take(token string, typ int) (Claims, bool) {
+    tm.takeLock.Lock()
+    defer tm.takeLock.Unlock()
+
+    claims, ok := tm.Get(token)
+    if !ok || claims.Type != typ {
+        return Claims{}, false
+    }
+    if err := tm.Delete(token); err != nil {
+        return Claims{}, false
+    }
+    return claims, true
+}
+
+//恢复Take取出的token（如上传失败后允许重试）
+func (tm *TokenMgr) Restore(token string, claims Claims) error {
+    if IsSigned(token) {
+        return tm.store.Delete(ID(token))
+    }
+    return tm.store.Set(ID(token), claims)
+}
+
+//列出用户所有服务端存储的有效token，签名的登录token及文件token不在其中
+func (tm *TokenMgr) Sessions(user string) []Session {
+    var ret []Session
+    for _, s := range tm.store.List(user) {
+        if s.Type != RevokedToken {
+            ret = append(ret, s)
+        }
+    }
+    return ret
+}
+
+//根据Session.ID吊销token
+func (tm *TokenMgr) Revoke(id string) error {
+    return tm.store.Delete(id)
+}
+
+//吊销用户所有的token，使用签名器时同时记录吊销时间，之前签发的签名token失效
+func (tm *TokenMgr) RevokeUser(user string) error {
+    for _, s := range tm.Sessions(user) {
+        if err := tm.store.Delete(s.ID); err != nil {
+            return err
+        }
+    }
+    if tm.signer == nil {
+        return nil
+    }
+    tm.ageLock.Lock()
+    maxAge := tm.maxAge
+    tm.ageLock.Unlock()
+    now := time.Now()
+    return tm.store.Set(revokeID(user), Claims{
+        Type:     RevokedToken,
+        User:     user,
+        IssuedAt: now,
+        ExpireAt: now.Add(maxAge),
+    })
 }
 
 func (tm *TokenMgr) Close() {
-    tm.rmap.Close()
+    tm.store.Close()
 }
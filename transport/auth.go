@@ -0,0 +1,189 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package transport
+
+import (
+    "bytes"
+    "citron-repo/protocol"
+    "errors"
+    "net"
+    "sync"
+    "time"
+)
+
+const (
+    //认证请求body最大长度
+    MAX_AUTH_SIZE = 4 * 1024
+    //同一连接认证失败次数超过后关闭连接
+    MAX_AUTH_FAILURES = 3
+    //同一远程地址在AUTH_FAILURE_WINDOW内认证失败次数超过后拒绝该地址的认证请求
+    MAX_ADDR_AUTH_FAILURES = 10
+    AUTH_FAILURE_WINDOW    = 15 * time.Minute
+    //认证失败后延迟返回，降低暴力破解的速度
+    AUTH_FAILURE_DELAY = time.Second
+)
+
+//认证成功后连接的身份
+type Identity struct {
+    Username string
+    //允许的操作，为空时不限制
+    Scopes []string
+    //不为空时只能访问该路径及其子路径
+    Path string
+    //认证使用的凭证（如token），RequestHandler每次请求时重新校验，为nil时不校验
+    Credential interface{}
+}
+
+type Authenticator interface {
+    //校验认证请求body，成功返回连接身份
+    Authenticate(data []byte) (*Identity, error)
+}
+
+//连接信息，RequestHandler通过它获得连接身份
+type ConnContext interface {
+    //认证成功后的身份，未认证返回nil
+    Identity() *Identity
+}
+
+type RequestHandlerFactory func(ctx ConnContext) RequestHandler
+
+var errTooManyAuthFailures = errors.New("too many auth failures")
+
+//按远程地址记录认证失败次数，多个连接共用
+type authLimiter struct {
+    maxFailures int
+    window      time.Duration
+    delay       time.Duration
+
+    lock     sync.Mutex
+    failures map[string]*authFailure
+}
+
+type authFailure struct {
+    count int
+    //第一次失败的时间，超过window后重新计数
+    since time.Time
+}
+
+func newAuthLimiter(maxFailures int, window, delay time.Duration) *authLimiter {
+    return &authLimiter{
+        maxFailures: maxFailures,
+        window:      window,
+        delay:       delay,
+        failures:    map[string]*authFailure{},
+    }
+}
+
+//地址的认证失败次数已达到上限
+func (l *authLimiter) blocked(host string) bool {
+    if l.maxFailures <= 0 {
+        return false
+    }
+    l.lock.Lock()
+    defer l.lock.Unlock()
+
+    f, ok := l.failures[host]
+    return ok && time.Since(f.since) < l.window && f.count >= l.maxFailures
+}
+
+//记录一次失败，同时清理过期的记录
+func (l *authLimiter) fail(host string) {
+    if l.maxFailures > 0 {
+        l.lock.Lock()
+        now := time.Now()
+        for k, f := range l.failures {
+            if now.Sub(f.since) >= l.window {
+                delete(l.failures, k)
+            }
+        }
+        f, ok := l.failures[host]
+        if !ok {
+            f = &authFailure{since: now}
+            l.failures[host] = f
+        }
+        f.count++
+        l.lock.Unlock()
+    }
+    if l.delay > 0 {
+        time.Sleep(l.delay)
+    }
+}
+
+//连接的远程地址，去掉端口；无法解析时使用完整地址
+func remoteHost(addr net.Addr) string {
+    if addr == nil {
+        return ""
+    }
+    host, _, err := net.SplitHostPort(addr.String())
+    if err != nil {
+        return addr.String()
+    }
+    return host
+}
+
+//处理认证请求
+type authHandler struct {
+    buf           bytes.Buffer
+    conn          *binaryConn
+    authenticator Authenticator
+    limiter       *authLimiter
+    failures      int
+}
+
+func (h *authHandler) Write(p []byte) (n int, err error) {
+    if h.buf.Len()+len(p) > MAX_AUTH_SIZE {
+        return 0, errors.New("auth request too large")
+    }
+    return h.buf.Write(p)
+}
+
+func (h *authHandler) Reset() {
+    h.buf.Reset()
+}
+
+func (h *authHandler) OnePackage(w PackageWriter) error {
+    //未配置认证时直接返回成功
+    if h.authenticator == nil {
+        return w(0, nil)
+    }
+
+    var identity *Identity
+    var err error
+    //地址被限制时不再校验，直到失败记录过期
+    if h.limiter != nil && h.limiter.blocked(h.conn.host) {
+        err = errTooManyAuthFailures
+    } else if identity, err = h.authenticator.Authenticate(h.buf.Bytes()); err != nil && h.limiter != nil {
+        h.limiter.fail(h.conn.host)
+    }
+    if err != nil {
+        //重新认证失败后之前的身份失效
+        h.conn.setIdentity(nil)
+        h.failures++
+        if h.failures >= MAX_AUTH_FAILURES {
+            return errTooManyAuthFailures
+        }
+        return protocol.NewError(protocol.StatusAuthFailed, err.Error())
+    }
+
+    h.failures = 0
+    h.conn.setIdentity(identity)
+    return w(int64(len(identity.Username)), bytes.NewReader([]byte(identity.Username)))
+}
+
+//未认证时拒绝所有命令请求，丢弃请求body
+type rejectHandler struct{}
+
+func (h rejectHandler) Write(p []byte) (n int, err error) {
+    return len(p), nil
+}
+
+func (h rejectHandler) Reset() {
+}
+
+func (h rejectHandler) OnePackage(w PackageWriter) error {
+    return protocol.NewError(protocol.StatusAuthRequired, "authentication required")
+}
@@ -16,8 +16,10 @@ import (
     "fmt"
     "github.com/xfali/goutils/log"
     "io"
+    "net"
     "sync"
     "sync/atomic"
+    "time"
 )
 
 const (
@@ -30,9 +32,15 @@ const (
 type connConf struct {
     magicCode      uint16
     version        uint16
-    requestHandler RequestHandler
+    handlerFactory RequestHandlerFactory
+    authenticator  Authenticator
+    //所有连接共用，按远程地址限制认证失败次数
+    authLimiter *authLimiter
     readBufSize    int
     writeBufSize   int
+    //允许的body压缩算法（按优先级排序）及最小压缩长度，为空时不压缩
+    codecs          []int16
+    compressMinSize int64
 }
 
 type BinaryServer struct {
@@ -50,8 +58,11 @@ type binaryConn struct {
     readBufPool  sync.Pool
     writeBufPool sync.Pool
 
-    conf connConf
-    o    Observer
+    conf     connConf
+    o        Observer
+    identity atomic.Value
+    //远程地址（不含端口），用于限制认证失败次数
+    host string
 }
 
 type PackageWriter func(size int64, reader io.Reader) error
@@ -62,6 +73,12 @@ type RequestHandler interface {
     OnePackage(PackageWriter) error
 }
 
+//需要根据命令处理请求的handler，在接收body之前调用Begin
+type CommandHandler interface {
+    RequestHandler
+    Begin(header protocol.RequestHeader)
+}
+
 type BinOpt func(s *BinaryServer)
 
 func SetReadBufSize(size int) BinOpt {
@@ -88,9 +105,47 @@ func SetVersion(version uint16) BinOpt {
     }
 }
 
+//允许客户端协商使用的body压缩算法，按优先级排序，不支持的算法忽略
+func SetCompression(codecs []string, minSize int64) BinOpt {
+    return func(s *BinaryServer) {
+        s.conf.codecs = nil
+        for _, name := range codecs {
+            if c, err := protocol.ParseCodec(name); err == nil && c != protocol.CodecNone {
+                s.conf.codecs = append(s.conf.codecs, c)
+            }
+        }
+        s.conf.compressMinSize = minSize
+    }
+}
+
+//所有连接共用同一个handler，handler必须是线程安全的
 func SetRequestHandler(handler RequestHandler) BinOpt {
     return func(s *BinaryServer) {
-        s.conf.requestHandler = handler
+        s.conf.handlerFactory = func(ctx ConnContext) RequestHandler {
+            return handler
+        }
+    }
+}
+
+//每个连接创建一个handler
+func SetRequestHandlerFactory(factory RequestHandlerFactory) BinOpt {
+    return func(s *BinaryServer) {
+        s.conf.handlerFactory = factory
+    }
+}
+
+//设置后连接必须先发送认证请求(protocol.AuthCommandID)，认证成功前拒绝所有命令请求
+func SetAuthenticator(authenticator Authenticator) BinOpt {
+    return func(s *BinaryServer) {
+        s.conf.authenticator = authenticator
+    }
+}
+
+//同一远程地址在window内认证失败maxFailures次后拒绝该地址的认证请求直到window结束
+//每次认证失败后延迟delay返回，maxFailures为0时不限制
+func SetAuthLimit(maxFailures int, window, delay time.Duration) BinOpt {
+    return func(s *BinaryServer) {
+        s.conf.authLimiter = newAuthLimiter(maxFailures, window, delay)
     }
 }
 
@@ -104,11 +159,15 @@ func NewBinaryServer(opts ...BinOpt) *BinaryServer {
     s := BinaryServer{
     }
 
-    s.conf.requestHandler = newDummyHandler()
+    s.conf.handlerFactory = func(ctx ConnContext) RequestHandler {
+        return newDummyHandler()
+    }
     s.conf.readBufSize = PkgReadBufSize
     s.conf.writeBufSize = PkgWriteBufSize
     s.conf.magicCode = MagicCode
     s.conf.version = Version
+    s.conf.compressMinSize = protocol.DefaultCompressMinSize
+    s.conf.authLimiter = newAuthLimiter(MAX_ADDR_AUTH_FAILURES, AUTH_FAILURE_WINDOW, AUTH_FAILURE_DELAY)
 
     for i := range opts {
         opts[i](&s)
@@ -131,11 +190,11 @@ func (s *BinaryServer) NotifyClosed(closer io.Closer) {
     s.connMap.Delete(closer)
 }
 
-func (s *BinaryServer) ListenAndServe() {
-    s.transport.ListenAndServe()
+func (s *BinaryServer) ListenAndServe() error {
+    return s.transport.ListenAndServe()
 }
 
-func (s *BinaryServer) createListener() Processor {
+func (s *BinaryServer) createListener(addr net.Addr) Processor {
     c := &binaryConn{
         readChan:  make(chan []byte),
         writeChan: make(chan []byte),
@@ -149,6 +208,7 @@ func (s *BinaryServer) createListener() Processor {
         }},
         conf: s.conf,
         o:    s,
+        host: remoteHost(addr),
     }
     s.connMap.Store(c, c)
     go c.process(s.conf)
@@ -182,6 +242,22 @@ func (c *binaryConn) Closer() util.Closable {
     return c.stopChan
 }
 
+func (c *binaryConn) Identity() *Identity {
+    if v, ok := c.identity.Load().(*Identity); ok {
+        return v
+    }
+    return nil
+}
+
+func (c *binaryConn) setIdentity(identity *Identity) {
+    c.identity.Store(identity)
+}
+
+//连接是否可以执行命令请求
+func (c *binaryConn) authenticated() bool {
+    return c.conf.authenticator == nil || c.Identity() != nil
+}
+
 var readCount int32 = 0
 var writeCount int32 = 0
 
@@ -219,8 +295,10 @@ func (c *binaryConn) process(conf connConf) {
         ready:          false,
         headerBuf:      make([]byte, PkgReadBufSize),
         conn:           c,
-        requestHandler: conf.requestHandler,
+        requestHandler: conf.handlerFactory(c),
+        auth:           &authHandler{conn: c, authenticator: conf.authenticator, limiter: conf.authLimiter},
     }
+    pkg.negotiate = &negotiateHandler{pkg: &pkg, codecs: conf.codecs, minSize: conf.compressMinSize}
 
     defer c.o.NotifyClosed(c)
     for {
@@ -250,6 +328,15 @@ type pkgHandler struct {
     header         protocol.RequestHeader
     bodyOffset     int64
     requestHandler RequestHandler
+    auth           *authHandler
+    negotiate      *negotiateHandler
+    //处理当前请求的handler
+    handler RequestHandler
+    //请求body压缩时解压后写入handler
+    decoder *protocol.BlockDecoder
+    //协商的响应body压缩算法及最小压缩长度
+    codec   int16
+    minSize int64
 }
 
 func (pkg *pkgHandler) reset() {
@@ -257,6 +344,8 @@ func (pkg *pkgHandler) reset() {
     pkg.headerOffset = 0
     pkg.bodyOffset = 0
     pkg.header = protocol.RequestHeader{}
+    pkg.handler = nil
+    pkg.decoder = nil
 }
 
 func (pkg *pkgHandler) toHeader() error {
@@ -270,10 +359,37 @@ func (pkg *pkgHandler) toHeader() error {
         return errC
     }
 
+    //后续处理只使用命令ID，header.Length为解压后的长度
+    cmd, codec := protocol.SplitReserve(pkg.header.Reserve)
+    if protocol.CodecName(codec) == "" {
+        return protocol.ErrCodec
+    }
+    pkg.header.Reserve = cmd
+
     log.Debug("header is %v", pkg.header)
+    pkg.selectHandler()
+    if codec != protocol.CodecNone {
+        pkg.decoder = protocol.NewBlockDecoder(pkg.handler, codec, pkg.header.Length)
+    }
     return nil
 }
 
+//根据命令及连接认证状态选择处理当前请求的handler
+func (pkg *pkgHandler) selectHandler() {
+    if pkg.header.Reserve == protocol.AuthCommandID {
+        pkg.handler = pkg.auth
+    } else if pkg.header.Reserve == protocol.NegotiateCommandID {
+        pkg.handler = pkg.negotiate
+    } else if !pkg.conn.authenticated() {
+        pkg.handler = rejectHandler{}
+    } else {
+        pkg.handler = pkg.requestHandler
+        if h, ok := pkg.handler.(CommandHandler); ok {
+            h.Begin(pkg.header)
+        }
+    }
+}
+
 func (pkg *pkgHandler) checkHeader() error {
     if pkg.header.MagicCode != pkg.magicCode {
         return errors.New("Magic code not match ")
@@ -314,6 +430,17 @@ func (pkg *pkgHandler) processHeader(data []byte) error {
     }
     pkg.headerOffset += copyLen
 
+    //没有body的请求
+    if pkg.ready && pkg.header.Length == 0 {
+        if err := pkg.finish(); err != nil {
+            return err
+        }
+        if copyLen < dataLen {
+            return pkg.next(data[copyLen:])
+        }
+        return nil
+    }
+
     if copyLen < dataLen {
         return pkg.processbody(data[copyLen:])
     }
@@ -324,34 +451,33 @@ func (pkg *pkgHandler) processbody(data []byte) error {
     if !pkg.ready {
         return errors.New("package not ready")
     }
-    if pkg.requestHandler == nil {
+    if pkg.handler == nil {
         panic("body handler is nil")
     }
+    if pkg.decoder != nil {
+        return pkg.processBlocks(data)
+    }
 
     length := int64(len(data))
     left := pkg.header.Length - pkg.bodyOffset
     if length <= left {
         pkg.bodyOffset += length
-        _, err := pkg.requestHandler.Write(data)
+        _, err := pkg.handler.Write(data)
         if err != nil {
             return err
         }
     } else if length > left {
         pkg.bodyOffset += left
-        _, err := pkg.requestHandler.Write(data[:left])
+        _, err := pkg.handler.Write(data[:left])
         if err != nil {
             return err
         }
     }
 
     if pkg.header.Length == pkg.bodyOffset {
-        err := pkg.requestHandler.OnePackage(pkg.write)
-        if err != nil {
+        if err := pkg.finish(); err != nil {
             return err
         }
-        //prepare for next package
-        pkg.requestHandler.Reset()
-        pkg.reset()
     }
 
     if length > left {
@@ -360,24 +486,77 @@ func (pkg *pkgHandler) processbody(data []byte) error {
     return nil
 }
 
-func (pkg *pkgHandler) createHeader(size int64) protocol.ResponseHeader {
+//压缩的body，接收完所有块后完成请求
+func (pkg *pkgHandler) processBlocks(data []byte) error {
+    n, err := pkg.decoder.Decode(data)
+    if err != nil {
+        return err
+    }
+    if !pkg.decoder.Done() {
+        return nil
+    }
+    if err := pkg.finish(); err != nil {
+        return err
+    }
+    if n < len(data) {
+        return pkg.next(data[n:])
+    }
+    return nil
+}
+
+//请求接收完成，handler返回protocol.Error时向客户端返回错误状态，其他错误关闭连接
+func (pkg *pkgHandler) finish() error {
+    err := pkg.handler.OnePackage(pkg.write)
+    if err != nil {
+        if e, ok := err.(*protocol.Error); ok {
+            err = pkg.writeError(e)
+        }
+        if err != nil {
+            return err
+        }
+    }
+    //prepare for next package
+    pkg.handler.Reset()
+    pkg.reset()
+    return nil
+}
+
+func (pkg *pkgHandler) createHeader(status int16, size int64) protocol.ResponseHeader {
     return protocol.ResponseHeader{
         MagicCode: pkg.magicCode,
         Version:   pkg.version,
+        Reserve:   status,
         Length:    size,
     }
 }
 
+//响应body是否压缩
+func (pkg *pkgHandler) compress(size int64, reader io.Reader) bool {
+    return pkg.codec != protocol.CodecNone && reader != nil && size > 0 && size >= pkg.minSize
+}
+
 func (pkg *pkgHandler) Write(d []byte) (n int, err error) {
     pkg.conn.writeChan <- d
     return len(d), nil
 }
 
 func (pkg *pkgHandler) write(size int64, reader io.Reader) (err error) {
+    return pkg.writeResponse(protocol.StatusOK, size, reader)
+}
+
+func (pkg *pkgHandler) writeError(e *protocol.Error) error {
+    return pkg.writeResponse(e.Status, int64(len(e.Msg)), bytes.NewReader([]byte(e.Msg)))
+}
+
+func (pkg *pkgHandler) writeResponse(status int16, size int64, reader io.Reader) (err error) {
     buf := pkg.conn.AcquireWriteBuf()
     //write header
     writer := ioutil.ByteWrapper{B: buf}
-    header := pkg.createHeader(size)
+    header := pkg.createHeader(status, size)
+    compress := pkg.compress(size, reader)
+    if compress {
+        header.Reserve = protocol.JoinReserve(status, pkg.codec)
+    }
     err = binary.Write(&writer, binary.BigEndian, header)
     if err != nil {
         return err
@@ -392,6 +571,9 @@ func (pkg *pkgHandler) write(size int64, reader io.Reader) (err error) {
     }
 
     //write body
+    if compress {
+        return protocol.WriteBlocks(copyWriter{pkg}, pkg.codec, reader, size)
+    }
     if reader != nil {
         var count int64 = 0
         for count < size {
@@ -400,17 +582,38 @@ func (pkg *pkgHandler) write(size int64, reader io.Reader) (err error) {
             if readSize > size-count {
                 readSize = size - count
             }
-            n, err := ioutil.CopyNWithBuffer(pkg, reader, readSize, buf)
+            //每个写缓冲只发送一次（发送后由WriteLoop释放），reader分多次返回时先填满缓冲
+            n, err := io.ReadFull(reader, buf[:readSize])
             if err != nil {
                 return err
             }
-            count += n
+            if _, err := pkg.Write(buf[:n]); err != nil {
+                return err
+            }
+            count += int64(n)
         }
     }
 
     return nil
 }
 
+//复制到写缓冲后发送，用于发送不是从写缓冲池获取的数据
+type copyWriter struct {
+    pkg *pkgHandler
+}
+
+func (w copyWriter) Write(p []byte) (int, error) {
+    for off := 0; off < len(p); {
+        buf := w.pkg.conn.AcquireWriteBuf()
+        n := copy(buf, p[off:])
+        if _, err := w.pkg.Write(buf[:n]); err != nil {
+            return off, err
+        }
+        off += n
+    }
+    return len(p), nil
+}
+
 type DummyHandler bytes.Buffer
 
 const (
@@ -38,7 +38,7 @@ type Connect struct {
 }
 
 func NewConnect(conf ConnConfig, conn net.Conn) *Connect {
-    p := conf.factory()
+    p := conf.factory(conn.RemoteAddr())
     ret := Connect{
         conn:     conn,
         stopChan: util.NewSafeCloseChan(),
@@ -112,6 +112,8 @@ func (c *Connect) WriteLoop() {
         case <-c.stopChan.C():
             return
         case <-c.p.Closer().C():
+            //processor关闭时关闭连接，使ReadLoop退出
+            c.conn.Close()
             return
         case d = <-c.p.WriteChan():
             break
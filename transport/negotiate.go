@@ -0,0 +1,64 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package transport
+
+import (
+    "bytes"
+    "citron-repo/protocol"
+    "encoding/json"
+    "errors"
+)
+
+//协商请求body最大长度
+const MAX_NEGOTIATE_SIZE = 4 * 1024
+
+//处理压缩算法协商请求，按服务端的优先级选择双方都支持的算法，响应之后的body按选择的算法压缩
+type negotiateHandler struct {
+    buf     bytes.Buffer
+    pkg     *pkgHandler
+    codecs  []int16
+    minSize int64
+}
+
+func (h *negotiateHandler) Write(p []byte) (n int, err error) {
+    if h.buf.Len()+len(p) > MAX_NEGOTIATE_SIZE {
+        return 0, errors.New("negotiate request too large")
+    }
+    return h.buf.Write(p)
+}
+
+func (h *negotiateHandler) Reset() {
+    h.buf.Reset()
+}
+
+func (h *negotiateHandler) OnePackage(w PackageWriter) error {
+    req := protocol.Negotiation{}
+    if err := json.Unmarshal(h.buf.Bytes(), &req); err != nil {
+        return protocol.NewError(protocol.StatusBadRequest, err.Error())
+    }
+    supported := map[string]bool{}
+    for _, name := range req.Codecs {
+        supported[name] = true
+    }
+    codec := protocol.CodecNone
+    for _, c := range h.codecs {
+        if supported[protocol.CodecName(c)] {
+            codec = c
+            break
+        }
+    }
+
+    data, err := json.Marshal(protocol.Negotiation{Codec: protocol.CodecName(codec), MinSize: h.minSize})
+    if err != nil {
+        return err
+    }
+    if err := w(int64(len(data)), bytes.NewReader(data)); err != nil {
+        return err
+    }
+    h.pkg.codec, h.pkg.minSize = codec, h.minSize
+    return nil
+}
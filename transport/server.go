@@ -13,6 +13,7 @@ import (
     "io"
     "net"
     "sync"
+    "sync/atomic"
     "time"
 )
 
@@ -24,8 +25,12 @@ const (
 type TcpTransport struct {
     port     string
     listener net.Listener
-    stop     bool
+    lock     sync.Mutex
+    stopChan util.Closable
     connConf ConnConfig
+    //最大连接数，0为不限制
+    maxConn int32
+    connNum int32
 
     connMap sync.Map
 }
@@ -52,7 +57,8 @@ type Processor interface {
     ReleaseWriteBuf([]byte)
 }
 
-type ProcessorFactory func() Processor
+//addr为连接的远程地址
+type ProcessorFactory func(addr net.Addr) Processor
 
 type Opt func(*TcpTransport)
 
@@ -86,29 +92,46 @@ func SetListenerFactory(factory ProcessorFactory) Opt {
     }
 }
 
+//超过最大连接数时直接关闭新连接
+func SetMaxConnections(max int) Opt {
+    return func(t *TcpTransport) {
+        t.maxConn = int32(max)
+    }
+}
+
 func NewTcpTransport(opts ...Opt) *TcpTransport {
-    ret := &TcpTransport{}
+    ret := &TcpTransport{
+        stopChan: util.NewSafeCloseChan(),
+    }
     for i := range opts {
         opts[i](ret)
     }
     return ret
 }
 
+//Close后返回nil
 func (t *TcpTransport) ListenAndServe() error {
     l, err := net.Listen("tcp", t.port)
     if err != nil {
         return err
     }
+    t.lock.Lock()
+    if t.stopChan.IsClosed() {
+        t.lock.Unlock()
+        l.Close()
+        return nil
+    }
     t.listener = l
+    t.lock.Unlock()
 
     ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
     for {
-        if t.stop {
-            return nil
-        }
         c, err := l.Accept()
         if err != nil {
-            cancel()
+            if t.stopChan.IsClosed() {
+                return nil
+            }
             return err
         }
         t.handleConnect(ctx, c)
@@ -116,8 +139,14 @@ func (t *TcpTransport) ListenAndServe() error {
 }
 
 func (t *TcpTransport) Close() error {
-    t.stop = true
-    err := t.listener.Close()
+    t.lock.Lock()
+    t.stopChan.Close()
+    var err error
+    if t.listener != nil {
+        err = t.listener.Close()
+    }
+    t.lock.Unlock()
+
     t.connMap.Range(func(key, value interface{}) bool {
         key.(*Connect).Close()
         t.connMap.Delete(key)
@@ -127,6 +156,12 @@ func (t *TcpTransport) Close() error {
 }
 
 func (t *TcpTransport) handleConnect(ctx context.Context, c net.Conn) {
+    if n := atomic.AddInt32(&t.connNum, 1); t.maxConn > 0 && n > t.maxConn {
+        atomic.AddInt32(&t.connNum, -1)
+        log.Warn("too many connections, reject %v", c.RemoteAddr())
+        c.Close()
+        return
+    }
     conn := NewConnect(t.connConf, c)
     //observer
     conn.RegisterObserver(t)
@@ -137,4 +172,5 @@ func (t *TcpTransport) handleConnect(ctx context.Context, c net.Conn) {
 
 func (t *TcpTransport) NotifyClosed(closer io.Closer) {
     t.connMap.Delete(closer)
+    atomic.AddInt32(&t.connNum, -1)
 }
@@ -0,0 +1,288 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package user
+
+import (
+    "citron-repo/auth"
+    "citron-repo/model"
+    "encoding/json"
+    "errors"
+    "golang.org/x/crypto/bcrypt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "sync"
+    "time"
+)
+
+const (
+    //用户文件默认保存在备份目录下
+    DEFAULT_FILE = ".citron/users.json"
+    MIN_PASSWORD = 6
+)
+
+var (
+    ErrUserExists      = errors.New("user already exists")
+    ErrUserNotFound    = errors.New("user not found")
+    ErrUserDisabled    = errors.New("user disabled")
+    ErrInvalidUsername = errors.New("invalid username")
+    ErrInvalidPassword = errors.New("invalid password")
+    ErrInvalidRole     = errors.New("invalid role")
+    ErrAuthFailed      = errors.New("username or password not match")
+)
+
+//用户名同时作为备份子目录名，不允许以"."开头
+var usernameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+
+//用户不存在时也做一次hash比较，避免通过响应时间判断用户是否存在
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("citron-dummy-password"), bcrypt.DefaultCost)
+
+type UserMgr struct {
+    //持久化文件路径，为空时只保存在内存中
+    path  string
+    lock  sync.RWMutex
+    users map[string]*model.User
+}
+
+//创建只保存在内存中的用户管理器
+func New() *UserMgr {
+    return &UserMgr{
+        users: map[string]*model.User{},
+    }
+}
+
+//从文件加载用户，文件不存在时创建空的用户管理器
+func Open(path string) (*UserMgr, error) {
+    ret := New()
+    ret.path = path
+
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return ret, nil
+        }
+        return nil, err
+    }
+
+    var users []*model.User
+    if err := json.Unmarshal(data, &users); err != nil {
+        return nil, err
+    }
+    for _, u := range users {
+        ret.users[u.Username] = u
+    }
+    return ret, nil
+}
+
+func ValidUsername(username string) bool {
+    return usernameRegexp.MatchString(username)
+}
+
+//校验角色及路径权限，角色为空时默认为model.RoleWriter
+func checkRole(info *model.UserInfo) error {
+    if info.Role == "" {
+        info.Role = model.RoleWriter
+    }
+    if !auth.ValidRole(info.Role) {
+        return ErrInvalidRole
+    }
+    for _, perm := range info.Permissions {
+        if !auth.ValidRole(perm.Role) || perm.Role == model.RoleAdmin {
+            return ErrInvalidRole
+        }
+    }
+    return nil
+}
+
+func hash(password string) (string, error) {
+    if len(password) < MIN_PASSWORD {
+        return "", ErrInvalidPassword
+    }
+    b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+    if err != nil {
+        return "", err
+    }
+    return string(b), nil
+}
+
+func (m *UserMgr) Create(info model.UserInfo) error {
+    if !ValidUsername(info.Username) {
+        return ErrInvalidUsername
+    }
+    if err := checkRole(&info); err != nil {
+        return err
+    }
+    h, err := hash(info.Password)
+    if err != nil {
+        return err
+    }
+
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    if _, ok := m.users[info.Username]; ok {
+        return ErrUserExists
+    }
+    now := time.Now()
+    m.users[info.Username] = &model.User{
+        Username:     info.Username,
+        PasswordHash: h,
+        Role:         info.Role,
+        Permissions:  info.Permissions,
+        CreateTime:   now,
+        UpdateTime:   now,
+    }
+    if err := m.save(); err != nil {
+        delete(m.users, info.Username)
+        return err
+    }
+    return nil
+}
+
+//校验用户名密码，成功返回用户信息（不包含密码hash）
+func (m *UserMgr) Authenticate(username, password string) (model.User, error) {
+    m.lock.RLock()
+    u, ok := m.users[username]
+    var h string
+    if ok {
+        h = u.PasswordHash
+    } else {
+        h = string(dummyHash)
+    }
+    m.lock.RUnlock()
+
+    //bcrypt内部使用constant time比较
+    err := bcrypt.CompareHashAndPassword([]byte(h), []byte(password))
+    if !ok || err != nil {
+        return model.User{}, ErrAuthFailed
+    }
+    if u.Disabled {
+        return model.User{}, ErrUserDisabled
+    }
+    return strip(u), nil
+}
+
+func (m *UserMgr) Get(username string) (model.User, bool) {
+    m.lock.RLock()
+    defer m.lock.RUnlock()
+
+    u, ok := m.users[username]
+    if !ok {
+        return model.User{}, false
+    }
+    return strip(u), true
+}
+
+func (m *UserMgr) List() []model.User {
+    m.lock.RLock()
+    defer m.lock.RUnlock()
+
+    ret := make([]model.User, 0, len(m.users))
+    for _, u := range m.users {
+        ret = append(ret, strip(u))
+    }
+    sort.Slice(ret, func(i, j int) bool {
+        return ret[i].Username < ret[j].Username
+    })
+    return ret
+}
+
+func (m *UserMgr) Size() int {
+    m.lock.RLock()
+    defer m.lock.RUnlock()
+
+    return len(m.users)
+}
+
+func (m *UserMgr) SetDisabled(username string, disabled bool) error {
+    return m.update(username, func(u *model.User) error {
+        u.Disabled = disabled
+        return nil
+    })
+}
+
+func (m *UserMgr) ResetPassword(username, password string) error {
+    h, err := hash(password)
+    if err != nil {
+        return err
+    }
+    return m.update(username, func(u *model.User) error {
+        u.PasswordHash = h
+        return nil
+    })
+}
+
+//修改用户角色及路径权限
+func (m *UserMgr) SetRole(username, role string, permissions []model.Permission) error {
+    info := model.UserInfo{Role: role, Permissions: permissions}
+    if err := checkRole(&info); err != nil {
+        return err
+    }
+    return m.update(username, func(u *model.User) error {
+        u.Role = info.Role
+        u.Permissions = info.Permissions
+        return nil
+    })
+}
+
+func (m *UserMgr) update(username string, f func(u *model.User) error) error {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    u, ok := m.users[username]
+    if !ok {
+        return ErrUserNotFound
+    }
+    //修改失败时不影响原有数据
+    nu := *u
+    if err := f(&nu); err != nil {
+        return err
+    }
+    nu.UpdateTime = time.Now()
+    m.users[username] = &nu
+    if err := m.save(); err != nil {
+        m.users[username] = u
+        return err
+    }
+    return nil
+}
+
+//持久化用户信息，先写临时文件再替换，必须在持有写锁时调用
+func (m *UserMgr) save() error {
+    if m.path == "" {
+        return nil
+    }
+
+    users := make([]*model.User, 0, len(m.users))
+    for _, u := range m.users {
+        users = append(users, u)
+    }
+    sort.Slice(users, func(i, j int) bool {
+        return users[i].Username < users[j].Username
+    })
+    data, err := json.MarshalIndent(users, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    if err := os.MkdirAll(filepath.Dir(m.path), 0700); err != nil {
+        return err
+    }
+    tmp := m.path + ".tmp"
+    if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+        return err
+    }
+    return os.Rename(tmp, m.path)
+}
+
+func strip(u *model.User) model.User {
+    ret := *u
+    ret.PasswordHash = ""
+    return ret
+}